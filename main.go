@@ -1,11 +1,27 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"main/src/analyze"
 	"main/src/client"
+	"main/src/config"
+	"main/src/crosstraffic"
+	"main/src/experiment"
+	"main/src/loadgen"
+	"main/src/matrix"
+	"main/src/runinfo"
 	"main/src/server"
+	"main/src/sim"
 	"main/src/test_client"
 	"os"
-	"strconv"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -17,26 +33,305 @@ func main() {
 		client := client.NewClient(url, port)
 		client.Start()
 	} else if arg == "server" {
-		// Uso: main server <wfq|sp|fifo>
+		// Uso: main server [wfq|sp|fifo] [-config arquivo.json] [-server.port 8000 ...]
 
-		queuePolicy := os.Args[2]
-		server := server.NewServer("0.0.0.0", port, queuePolicy)
-		server.Start()
+		// garanta logs no stdout
+		log.SetOutput(os.Stdout)
+
+		cfg := parseConfig("server", os.Args[2:], "server.policy")
+		cfg.Server.OutputDir = runinfo.NewDir(cfg.Server.OutputDir, "server-"+cfg.Server.Policy)
+		run := startRun("server", cfg.Server.OutputDir, cfg)
+		err := runServer(cfg)
+		finishRun(run, err)
+		if err != nil {
+			log.Fatal(err)
+		}
 	} else if arg == "test-client" {
-		// Uso: main test-client [ip] parallelism baseLatency
+		// Uso: main test-client [ip] [parallelism] [baseLatency] [-config arquivo.json] [-client.pipeline ...]
+
+		cfg := parseConfig("test-client", os.Args[2:],
+			"client.server_url", "client.parallelism", "client.base_latency_ms")
+		cfg.Client.OutputDir = runinfo.NewDir(cfg.Client.OutputDir, "client")
+		run := startRun("test-client", cfg.Client.OutputDir, cfg)
+		err := test_client.RunTestClient(test_client.TestClientOptions{
+			ClientConfig: cfg.Client,
+			ServerPort:   cfg.Server.Port,
+			Network:      cfg.Network,
+		})
+		finishRun(run, err)
+		if err != nil {
+			log.Println(err)
+		}
+	} else if arg == "replay" {
+		// Uso: main replay <requests.jsonl> [-config arquivo.json] [-client.server_url ip ...]
+		// Reenvia uma trace gravada com client.record_requests
+
+		if len(os.Args) < 3 || strings.HasPrefix(os.Args[2], "-") {
+			log.Fatal("usage: main replay <requests.jsonl> [-config file] [overrides]")
+		}
+		cfg := parseConfig("replay", os.Args[3:])
+		cfg.Client.OutputDir = runinfo.NewDir(cfg.Client.OutputDir, "replay")
+		run := startRun("replay", cfg.Client.OutputDir, cfg)
+		err := test_client.RunReplay(test_client.TestClientOptions{
+			ClientConfig: cfg.Client,
+			ServerPort:   cfg.Server.Port,
+			Network:      cfg.Network,
+		}, os.Args[2])
+		finishRun(run, err)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if arg == "loadgen" {
+		// Uso: main loadgen [-config arquivo.json] [-loadgen.arrival poisson] [-loadgen.rate_per_sec 200] [-client.server_url ip ...]
+		// Carga em malha aberta: chegadas independentes das respostas
+
+		cfg := parseConfig("loadgen", os.Args[2:])
+		cfg.Client.OutputDir = runinfo.NewDir(cfg.Client.OutputDir, "loadgen")
+		run := startRun("loadgen", cfg.Client.OutputDir, cfg)
+		_, err := loadgen.Run(loadgen.Options{Config: cfg})
+		finishRun(run, err)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if arg == "cross-traffic" {
+		// Uso: main cross-traffic sink [-cross_traffic.mode udp ...] [-out dir]
+		//      main cross-traffic send <ip> [-cross_traffic.mode udp] [-cross_traffic.rate_mbps 20 ...] [-out dir]
+		// Tráfego de fundo em enlaces reais (substitui o iperf)
+
+		log.SetOutput(os.Stdout)
+
+		if len(os.Args) < 3 || (os.Args[2] == "send" && (len(os.Args) < 4 || strings.HasPrefix(os.Args[3], "-"))) ||
+			(os.Args[2] != "send" && os.Args[2] != "sink") {
+			log.Fatal("usage: main cross-traffic sink|send <ip> [-config file] [overrides]")
+		}
+		role := os.Args[2]
+		args := os.Args[3:]
+		target := ""
+		if role == "send" {
+			target, args = os.Args[3], os.Args[4:]
+		}
+		cfg := parseConfig("cross-traffic", args)
+		runDir := cfg.Experiment.OutputDir
+		if runDir == "" {
+			runDir = runinfo.NewDir("runs", "cross-traffic-"+role)
+		}
+		cfg.Experiment.OutputDir = runDir
+		run := startRun("cross-traffic", runDir, cfg)
+		err := runCrossTraffic(cfg, role, target)
+		finishRun(run, err)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if arg == "experiment" {
+		// Uso: main experiment [-config arquivo.json] [-policy wfq] [-clients 1] [-parallelism 128] [-base-latency 250] [-port 8000] [-out dir]
+
+		log.SetOutput(os.Stdout)
+
+		cfg := parseConfig("experiment", os.Args[2:])
+		if _, err := experiment.Run(cfg); err != nil {
+			log.Fatal(err)
+		}
+	} else if arg == "sim" {
+		// Uso: main sim [-config arquivo.json] [overrides]
+		// Simula a política em tempo virtual; saídas em <out>/server como no experiment
+
+		log.SetOutput(os.Stdout)
+
+		cfg := parseConfig("sim", os.Args[2:])
+		runDir := cfg.Experiment.OutputDir
+		if runDir == "" {
+			runDir = runinfo.NewDir("runs", "sim-"+cfg.Server.Policy)
+		}
+		cfg.Experiment.OutputDir = runDir
+		run := startRun("sim", runDir, cfg)
+		if err := cfg.WriteFile(filepath.Join(runDir, "config.json")); err != nil {
+			finishRun(run, err)
+			log.Fatal(err)
+		}
+		result, err := sim.Run(sim.Options{Config: cfg, OutputDir: filepath.Join(runDir, "server")})
+		finishRun(run, err)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Simulated %v: requests=%d skipped=%d on_time=%.2f%% fov_on_time=%.2f%% server_drops=%d bytes=%d, outputs in %s",
+			result.Duration, result.Requests, result.Skipped, result.OnTimeRate(), result.FOVOnTimeRate(),
+			result.ServerDrops, result.BytesSent, runDir)
+	} else if arg == "matrix" {
+		// Uso: main matrix <matrix.json> [-config base.json] [-out dir] [overrides]
+		// Cada célula × repetição roda como um "main experiment" separado
+
+		log.SetOutput(os.Stdout)
+
+		if len(os.Args) < 3 || strings.HasPrefix(os.Args[2], "-") {
+			log.Fatal("usage: main matrix <matrix.json> [-config base.json] [-out dir]")
+		}
+		spec, err := matrix.LoadSpec(os.Args[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg := parseConfig("matrix", os.Args[3:])
+		outDir := cfg.Experiment.OutputDir
+		if outDir == "" {
+			outDir = filepath.Join("runs", "matrix-"+time.Now().Format("20060102-150405"))
+		}
+		cfg.Experiment.OutputDir = ""
+		if err := matrix.Run(spec, cfg, outDir); err != nil {
+			log.Fatal(err)
+		}
+	} else if arg == "analyze" {
+		// Uso: main analyze [-format text|csv] [-out dir] <diretório de execução...>
+		// Compara execuções: CDFs por classe, deadlines perdidos, fairness e FoV
+
+		flags := flag.NewFlagSet("analyze", flag.ExitOnError)
+		format := flags.String("format", "text", "comparison printed to stdout: text or csv")
+		out := flags.String("out", "", "directory for comparison.csv, cdf.csv and fairness_over_time.csv")
+		_ = flags.Parse(os.Args[2:])
+		err := analyze.Analyze(flags.Args(), analyze.Options{Format: *format, OutputDir: *out})
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if arg == "join" {
+		// Uso: main join [-out arquivo.csv] <diretório de execução>
+		// Junta os logs do cliente e do servidor por requisição e divide a
+		// latência em uplink, fila, serviço e downlink
 
-		if len(os.Args) > 1 {
-			url = os.Args[2]
+		flags := flag.NewFlagSet("join", flag.ExitOnError)
+		out := flags.String("out", "", "joined CSV (default <run dir>/joined.csv)")
+		_ = flags.Parse(os.Args[2:])
+		if flags.NArg() != 1 {
+			log.Fatal("usage: main join [-out file.csv] <run dir>")
 		}
-		parallelism := 128
-		if len(os.Args) > 2 {
-			parallelism, _ = strconv.Atoi(os.Args[3])
+		if err := analyze.JoinRun(flags.Arg(0), *out, os.Stdout); err != nil {
+			log.Fatal(err)
 		}
-		baseLatency := 250
-		if len(os.Args) > 3 {
-			baseLatency, _ = strconv.Atoi(os.Args[4])
+	} else if arg == "config" {
+		// Uso: main config [-config arquivo.json] [overrides] > arquivo.json
+		// Mostra a configuração efetiva (padrões, ambiente, arquivo e flags)
+
+		cfg := parseConfig("config", os.Args[2:])
+		if err := cfg.WriteFile("/dev/stdout"); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// parseConfig carrega a configuração de um comando. Os argumentos posicionais
+// antigos (antes das flags) preenchem os campos de positional, na ordem.
+func parseConfig(command string, args []string, positional ...string) config.Config {
+	var flagArgs []string
+	for _, path := range positional {
+		if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+			break
 		}
+		flagArgs = append(flagArgs, "-"+path, args[0])
+		args = args[1:]
+	}
+	// Flags explícitas vêm depois e têm precedência
+	flagArgs = append(flagArgs, args...)
+
+	flags := config.NewFlags(command)
+	flags.Alias("policy", "server.policy")
+	flags.Alias("port", "server.port")
+	flags.Alias("clients", "experiment.clients")
+	flags.Alias("parallelism", "client.parallelism")
+	flags.Alias("base-latency", "client.base_latency_ms")
+	flags.Alias("out", "experiment.output_dir")
+	cfg, err := flags.Parse(flagArgs)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("%s: %v", command, err)
+	}
+	if len(flags.Args()) > 0 {
+		log.Fatalf("%s: unexpected arguments %v", command, flags.Args())
+	}
+	return cfg
+}
+
+// startRun cria o diretório da execução em dir e escreve o manifest.json.
+func startRun(command string, dir string, cfg config.Config) *runinfo.Run {
+	run, err := runinfo.Start(dir, command, cfg)
+	if err != nil {
+		log.Fatalf("%s: %v", command, err)
+	}
+	log.Printf("Run %s, outputs in %s", run.ID(), dir)
+	return run
+}
 
-		test_client.StartTestClient(url, port, parallelism, baseLatency)
+// finishRun completa o manifest.json com o fim, o resultado e os arquivos.
+func finishRun(run *runinfo.Run, err error) {
+	if finishErr := run.Finish(err); finishErr != nil {
+		log.Printf("manifest: %v", finishErr)
+	}
+}
+
+// runServer serve até receber SIGINT ou SIGTERM e então para o servidor,
+// que fecha os CSVs.
+func runServer(cfg config.Config) error {
+	srv, err := server.NewServerFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if err := srv.Listen(); err != nil {
+		return err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	served := make(chan struct{})
+	go func() {
+		srv.Serve()
+		close(served)
+	}()
+	select {
+	case <-signals:
+	case <-served:
+	}
+	srv.Stop()
+	return nil
+}
+
+// runCrossTraffic roda um lado do tráfego de fundo até cross_traffic.duration_s
+// ou até ser interrompido. As taxas vão para <out>/cross_traffic-<role>.csv;
+// sem -out, <out> é um diretório novo em runs/, com o manifest.json.
+func runCrossTraffic(cfg config.Config, role string, target string) error {
+	ct := cfg.CrossTraffic
+	if ct.Mode == "" {
+		return errors.New("cross-traffic: set -cross_traffic.mode (udp or quic)")
+	}
+
+	var sender *crosstraffic.Sender
+	var sink *crosstraffic.Sink
+	if role == "send" {
+		sender = crosstraffic.NewSender(ct, fmt.Sprintf("%s:%d", target, ct.Port), nil)
+		if err := sender.Start(); err != nil {
+			return err
+		}
+		defer sender.Stop()
+	} else {
+		sink = crosstraffic.NewSink(ct.Mode, fmt.Sprintf(":%d", ct.Port))
+		if err := sink.Start(); err != nil {
+			return err
+		}
+		defer sink.Stop()
+	}
+
+	// o diretório da execução já existe (startRun)
+	reporter, err := crosstraffic.StartReporter(filepath.Join(cfg.Experiment.OutputDir, "cross_traffic-"+role+".csv"), time.Second, sender, sink)
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	var timeout <-chan time.Time
+	if ct.DurationS > 0 {
+		timeout = time.After(time.Duration(ct.DurationS * float64(time.Second)))
+	}
+	select {
+	case <-signals:
+	case <-timeout:
 	}
+	return reporter.Stop()
 }
@@ -5,7 +5,7 @@
 from mininet.net import Mininet, Host
 from mininet.log import setLogLevel
 from mininet.util import pmonitor
-import os, signal, subprocess
+import os, signal, subprocess, threading, time
 from subprocess import Popen
 from utils import HostParams, NetParams, createMininet
 
@@ -17,6 +17,8 @@ PARALELLISM = int(os.environ['PARALELLISM'])
 DELAY = '%fms' % float(os.environ['DELAY'])
 LOAD = float(os.environ['LOAD'])
 BASE_LATENCY = int(os.environ['BASE_LATENCY'])
+BW_TRACE = os.environ.get('BW_TRACE', '')
+BW_TRACE_LOOP = os.environ.get('BW_TRACE_LOOP', '') == '1'
 
 print('SERVER_MODE=', SERVER_MODE)
 print('SERVER_BW=', SERVER_BW)
@@ -26,6 +28,66 @@ print('PARALELLISM=', PARALELLISM)
 print('DELAY=', DELAY)
 print('LOAD=', LOAD)
 print('BASE_LATENCY=', BASE_LATENCY)
+print('BW_TRACE=', BW_TRACE, '(loop)' if BW_TRACE_LOOP else '')
+
+# Lowest bandwidth applied from a trace (outages), as in src/netem
+MIN_TRACE_MBPS = 0.01
+
+def load_bandwidth_trace(path: str) -> 'list[tuple[float, float]]':
+    '''Reads "timestamp, Mbps" lines; returns (seconds from the first, Mbps).'''
+    samples = []
+    with open(path) as f:
+        for line in f:
+            fields = line.replace(',', ' ').replace(';', ' ').split()
+            if len(fields) < 2 or line.startswith('#'):
+                continue
+            try:
+                t, mbps = float(fields[0]), float(fields[1])
+            except ValueError:
+                continue  # header
+            samples.append((t, mbps))
+    if not samples:
+        raise RuntimeError('empty bandwidth trace: ' + path)
+    t0 = samples[0][0]
+    return [(t - t0, mbps) for t, mbps in samples]
+
+class BandwidthTracePlayer(threading.Thread):
+    '''Changes the rate of the htb class Mininet's TCLink creates on the
+    interface (handle 5:, class 5:1) as the trace goes, and writes the trace
+    position to bandwidth-trace.csv, like the bandwidth-*.csv of the
+    in-process emulator.'''
+
+    def __init__(self, host: Host, samples, loop: bool, csv_path: str):
+        super().__init__(daemon=True)
+        self.host = host
+        self.samples = samples
+        self.loop = loop
+        self.csv_path = csv_path
+        self.stopped = threading.Event()
+        last = samples[-1][0]
+        self.duration = last + (last - samples[-2][0]) if len(samples) > 1 else 0
+
+    def run(self):
+        intf = self.host.intf().name
+        start = time.time()
+        with open(self.csv_path, 'w') as csv:
+            csv.write('time_ns,trace_time_s,sample,mbps\n')
+            offset = 0.0
+            while not self.stopped.is_set():
+                for i, (at, mbps) in enumerate(self.samples):
+                    if self.stopped.wait(max(0.0, start + offset + at - time.time())):
+                        return
+                    mbps = max(mbps, MIN_TRACE_MBPS)
+                    self.host.cmd('tc class change dev %s parent 5:0 classid 5:1 '
+                                  'htb rate %fMbit burst 15k' % (intf, mbps))
+                    csv.write('%d,%.3f,%d,%.3f\n' % (int((time.time() - start) * 1e9), at, i, mbps))
+                    csv.flush()
+                if not self.loop or self.duration <= 0:
+                    return
+                offset += self.duration
+
+    def stop(self):
+        self.stopped.set()
 
 class Test():
     def __init__(self):
@@ -37,8 +99,9 @@ class Test():
         self.server: Host = server
         self.client: Host = clients[0]
         self.processes: 'dict[Host, Popen]' = {}
-        self.iperf_server: 'Popen | None' = None
-        self.iperf_client: 'Popen | None' = None
+        self.load_sink: 'Popen | None' = None
+        self.load_sender: 'Popen | None' = None
+        self.bw_trace_player: 'BandwidthTracePlayer | None' = None
 
     def run(self):
         try:
@@ -53,29 +116,45 @@ class Test():
             raise RuntimeError('Failed to connect switches')
         setLogLevel('info')
 
+        dir = os.path.dirname(os.path.realpath(__file__))
+
         if LOAD != 0.0:
-            load = '%dM' % (SERVER_BW * LOAD / 100)
-            print('Starting load: ' + load)
-            self.iperf_server = self.server.popen(
-                ['iperf', '-u', '-s', '-p', '5001'])
-            self.iperf_client = self.client.popen(
-                ['iperf', '-u', '-c', self.server.IP(), '-p', '5001',
-                 '-b', load, '-t', '99999'])
+            # Constant-rate UDP, as iperf -u did; rates in cross_traffic-*.csv
+            load = SERVER_BW * LOAD / 100
+            print('Starting load: %.1f Mbps' % load)
+            self.load_sink = self.server.popen(
+                [dir + '/main', 'cross-traffic', 'sink',
+                 '-cross_traffic.mode', 'udp', '-cross_traffic.port', '5001'],
+                cwd=dir, stderr=subprocess.STDOUT)
+            self.load_sender = self.client.popen(
+                [dir + '/main', 'cross-traffic', 'send', self.server.IP(),
+                 '-cross_traffic.mode', 'udp', '-cross_traffic.port', '5001',
+                 '-cross_traffic.rate_mbps', str(load)],
+                cwd=dir, stderr=subprocess.STDOUT)
 
         print('Running test')
-        dir = os.path.dirname(os.path.realpath(__file__))
 
-        # Start server
+        # Start server; server and client write to their own run
+        # directories in runs/, each with a manifest.json
         self.processes[self.server] = self.server.popen(
-            [dir + '/main', 'server', self.server_policy],
+            [dir + '/main', 'server', self.server_policy,
+             '-server.output_dir', 'runs'],
             cwd=dir,
             stderr=subprocess.STDOUT)
         # Start client
         self.processes[self.client] = self.client.popen(
             [dir + '/main', 'test-client', self.server.IP(), str(PARALELLISM),
-             str(BASE_LATENCY)],
+             str(BASE_LATENCY), '-client.output_dir', 'runs'],
             cwd=dir,
             stderr=subprocess.STDOUT)
+
+        # Starts with the client, the time base of its statistics
+        if BW_TRACE != '':
+            print('Playing bandwidth trace: ' + BW_TRACE)
+            self.bw_trace_player = BandwidthTracePlayer(
+                self.server, load_bandwidth_trace(BW_TRACE), BW_TRACE_LOOP,
+                os.path.join(dir, 'bandwidth-trace.csv'))
+            self.bw_trace_player.start()
         
         for host, line in pmonitor(self.processes):
             if line:
@@ -87,14 +166,17 @@ class Test():
 
     def __finalize(self):
         setLogLevel('warning')
+        if self.bw_trace_player != None:
+            self.bw_trace_player.stop()
         for process in self.processes.values():
             process.terminate()
-        if self.iperf_client != None:
-            self.iperf_client.terminate()
-        if self.iperf_server != None:
-            self.iperf_server.terminate()
-            print('iperf server results:')
-            result, _ = self.iperf_server.communicate()
+        if self.load_sender != None:
+            self.load_sender.terminate()
+            self.load_sender.wait()
+        if self.load_sink != None:
+            self.load_sink.terminate()
+            print('Load results:')
+            result, _ = self.load_sink.communicate()
             print(str(result, 'utf8'))
         self.net.stop()
 
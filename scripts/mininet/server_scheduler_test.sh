@@ -12,6 +12,8 @@ showUsage() {
     echo "-p N                    Select paralellism"
     echo "--delay N               Select delay"
     echo "--load N                Select load %"
+    echo "--bwtrace FILE          Drive the server bandwidth from a \"timestamp, Mbps\" trace"
+    echo "--bwtraceloop           Loop the bandwidth trace"
     echo "-o DIR                  Select output directory"
 }
 
@@ -23,6 +25,8 @@ PARALELLISM="128"
 DELAY="0"
 LOAD="0"
 BASE_LATENCY="250"
+BW_TRACE=
+BW_TRACE_LOOP=
 IP=
 LOG_DIR=
 
@@ -38,6 +42,8 @@ while [[ "$#" > 0 ]]; do
     -p)     PARALELLISM="$2"                ; shift 2 ;;
     --delay) DELAY="$2"                     ; shift 2 ;;
     --load) LOAD="$2"                       ; shift 2 ;;
+    --bwtrace) BW_TRACE="$2"                ; shift 2 ;;
+    --bwtraceloop) BW_TRACE_LOOP="1"        ; shift   ;;
     -o)     LOG_DIR="$2"                    ; shift 2 ;;
     -*)     showUsage ; exit 1              ; shift   ;;
     *)      IP="$1"                         ; shift   ;;
@@ -49,6 +55,10 @@ if [[ -z $IP ]]; then
     exit 1
 fi
 
+if [[ -n "$BW_TRACE" ]]; then
+    BW_TRACE=$(realpath "$BW_TRACE")
+fi
+
 ############################################################################
 
 cd -- "$( dirname -- "${BASH_SOURCE[0]}" )"
@@ -130,6 +140,10 @@ upload "../../main" "$REMOTE_DIR"
 upload "../../data" "$REMOTE_DIR"
 upload "resources/server_scheduler_test.py" "$REMOTE_DIR"
 upload "resources/utils.py" "$REMOTE_DIR"
+if [[ -n "$BW_TRACE" ]]; then
+    upload "$BW_TRACE" "$REMOTE_DIR/bandwidth-trace.txt"
+    BW_TRACE="bandwidth-trace.txt"
+fi
 
 echo -e "${PURPLE}Executing...${NC}"
 
@@ -139,13 +153,21 @@ withSSH "cd $REMOTE_DIR && \
         sudo env SERVER_MODE='$SERVER_MODE' SERVER_BW='$SERVER_BW' \
             CLIENT_BW='$CLIENT_BW' LOSS='$LOSS' PARALELLISM='$PARALELLISM' \
             DELAY='$DELAY' LOAD='$LOAD' BASE_LATENCY='$BASE_LATENCY' \
+            BW_TRACE='$BW_TRACE' BW_TRACE_LOOP='$BW_TRACE_LOOP' \
             ./server_scheduler_test.py" 2>&1 | tee "$LOG_DIR/stdout"
 EXIT_CODE=$?
 echo -e "${PURPLE}Exit code: $EXIT_CODE${NC}"
 
-download "$REMOTE_DIR/*.csv" "$LOG_DIR"
+# Server and client run directories, plus the bandwidth trace and load CSVs
+download "$REMOTE_DIR/runs/" "$LOG_DIR/runs/"
+if withSSH "ls $REMOTE_DIR/*.csv" > /dev/null 2>&1; then
+    download "$REMOTE_DIR/*.csv" "$LOG_DIR"
+fi
+
+# CDFs, deadline misses, fairness and FoV hit rates, no Python needed
+../../main analyze -out "$LOG_DIR/analysis" "$LOG_DIR" | tee "$LOG_DIR/analysis.txt"
 
-resources/plot_server_scheduler_test_results.py "$LOG_DIR"/*.csv \
-    "$LOG_DIR"
+# Per-request latency split and miss causes (Mininet hosts share one clock)
+../../main join -out "$LOG_DIR/analysis/joined.csv" "$LOG_DIR/runs" | tee "$LOG_DIR/join.txt"
 
 echo -e "${PURPLE}Logs: $(cd "$LOG_DIR" && pwd)${NC}"
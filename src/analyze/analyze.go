@@ -0,0 +1,453 @@
+// Package analyze reads the outputs of runs and compares them: per-class
+// latency CDFs, deadline miss rates, fairness over time and FoV hit rates. It
+// needs nothing but the binary, unlike the Python plotting scripts.
+//
+// A run directory is searched recursively, so an experiment run directory,
+// a server or client run directory or a downloaded Mininet log directory all
+// work. The files read are reqlog.csv, server_summary.csv and fairness.csv of
+// the server (aggregate only, not the -conn<ID> files), statistics-*.csv and
+// fov-delivery-*.csv of the clients.
+package analyze
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/fs"
+	"main/src/model"
+	"main/src/runinfo"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const classCount = model.PRIORITY_LEVEL_COUNT
+
+// Classes in the order of the reports, with their names.
+var classes = []struct {
+	class model.Priority
+	name  string
+}{{model.HIGH_PRIORITY, "high"}, {model.MEDIUM_PRIORITY, "medium"}, {model.LOW_PRIORITY, "low"}}
+
+// Run holds what was read from one run directory.
+type Run struct {
+	Name string
+	Dir  string
+	// Requests of each class seen by the server (reqlog.csv) and by the
+	// clients (statistics-*.csv).
+	Server [classCount]ClassStats
+	Client [classCount]ClassStats
+	// Last row of server_summary.csv, nil without the file.
+	Summary map[string]float64
+	// Windows of fairness.csv.
+	Fairness []Window
+	// FoV tiles and those delivered on time, over the segments of
+	// fov-delivery-*.csv, and the segments with every FoV tile on time.
+	FOVTiles, FOVOnTime          int64
+	FOVSegments, FOVFullSegments int
+
+	files int
+}
+
+// ClassStats are the requests of one class.
+type ClassStats struct {
+	Requests int
+	// Not delivered before the deadline.
+	Missed int
+	// Dropped by the server (reqlog only).
+	Dropped int
+	// Response time of the completed requests, in ms.
+	Response []float64
+	// Queue delay of the requests served, in ms (reqlog only).
+	QueueDelay []float64
+}
+
+// Window is one row of fairness.csv.
+type Window struct {
+	T     float64 // seconds since the first window
+	Share [classCount]float64
+	Jain  float64
+	// Jain over share/weight of the backlogged classes, NaN when fewer than
+	// two were backlogged
+	WeightedJain float64
+}
+
+// Load reads the outputs found under dir. The run is named after the run ID
+// of its manifest.json, or else the directory.
+func Load(dir string) (*Run, error) {
+	r := &Run{Name: filepath.Base(filepath.Clean(dir)), Dir: dir}
+	if manifest, err := runinfo.ReadManifest(dir); err == nil && manifest.RunID != "" {
+		r.Name = manifest.RunID
+	}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		name := entry.Name()
+		var read func(string) error
+		switch {
+		case name == "reqlog.csv":
+			read = r.readReqlog
+		case name == "server_summary.csv":
+			read = r.readSummary
+		case name == "fairness.csv":
+			read = r.readFairness
+		case strings.HasPrefix(name, "statistics-summary-"):
+		case strings.HasPrefix(name, "statistics-") && strings.HasSuffix(name, ".csv"):
+			read = r.readStatistics
+		case strings.HasPrefix(name, "fov-delivery-") && strings.HasSuffix(name, ".csv"):
+			read = r.readFOVDelivery
+		}
+		if read == nil {
+			return nil
+		}
+		r.files++
+		if err := read(path); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if r.files == 0 {
+		return nil, fmt.Errorf("%s: no run outputs (reqlog.csv, statistics-*.csv...)", dir)
+	}
+	return r, nil
+}
+
+// table is a CSV file read with its header.
+type table struct {
+	columns map[string]int
+	rows    [][]string
+}
+
+func readTable(path string) (*table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return &table{columns: map[string]int{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := &table{columns: map[string]int{}}
+	for i, name := range header {
+		t.columns[name] = i
+	}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.rows = append(t.rows, row)
+	}
+}
+
+// get returns the column name of row, "" if missing.
+func (t *table) get(row []string, name string) string {
+	i, ok := t.columns[name]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return row[i]
+}
+
+func (t *table) float(row []string, name string) (float64, bool) {
+	v, err := strconv.ParseFloat(t.get(row, name), 64)
+	return v, err == nil
+}
+
+func (t *table) bool(row []string, name string) bool {
+	return t.get(row, name) == "true"
+}
+
+func (t *table) class(row []string, name string) (model.Priority, bool) {
+	c, err := strconv.Atoi(t.get(row, name))
+	return model.Priority(c), err == nil && c >= 0 && c < classCount
+}
+
+func (r *Run) readReqlog(path string) error {
+	t, err := readTable(path)
+	if err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		c, ok := t.class(row, "class")
+		if !ok {
+			continue
+		}
+		stats := &r.Server[c]
+		stats.Requests++
+		if !t.bool(row, "ontime") {
+			stats.Missed++
+		}
+		if t.bool(row, "drop") {
+			stats.Dropped++
+			continue
+		}
+		if event := t.get(row, "event"); event == "cancel" || event == "reject" {
+			continue // never served, no queue delay
+		}
+		if qd, ok := t.float(row, "qd_ms"); ok {
+			stats.QueueDelay = append(stats.QueueDelay, qd)
+		}
+		if rsp, ok := t.float(row, "rsp_ms"); ok && t.get(row, "event") == "complete" {
+			stats.Response = append(stats.Response, rsp)
+		}
+	}
+	return nil
+}
+
+func (r *Run) readSummary(path string) error {
+	t, err := readTable(path)
+	if err != nil || len(t.rows) == 0 {
+		return err
+	}
+	last := t.rows[len(t.rows)-1]
+	r.Summary = map[string]float64{}
+	for name := range t.columns {
+		if v, ok := t.float(last, name); ok {
+			r.Summary[name] = v
+		}
+	}
+	return nil
+}
+
+func (r *Run) readFairness(path string) error {
+	t, err := readTable(path)
+	if err != nil {
+		return err
+	}
+	var start time.Time
+	for _, row := range t.rows {
+		ts, err := time.Parse(time.RFC3339Nano, t.get(row, "ts"))
+		if err != nil {
+			continue
+		}
+		if start.IsZero() {
+			start = ts
+		}
+		w := Window{T: ts.Sub(start).Seconds()}
+		for _, c := range classes {
+			w.Share[c.class], _ = t.float(row, "share_"+c.name)
+		}
+		w.Jain, _ = t.float(row, "jain")
+		if v, ok := t.float(row, "weighted_jain"); ok {
+			w.WeightedJain = v
+		} else {
+			w.WeightedJain = math.NaN()
+		}
+		r.Fairness = append(r.Fairness, w)
+	}
+	return nil
+}
+
+// Tiles skipped by the client are never requested and are not counted.
+func (r *Run) readStatistics(path string) error {
+	t, err := readTable(path)
+	if err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		c, ok := t.class(row, "priority")
+		if !ok || t.bool(row, "skipped") {
+			continue
+		}
+		stats := &r.Client[c]
+		stats.Requests++
+		if !t.bool(row, "on_time") {
+			stats.Missed++
+		}
+		if latency, ok := t.float(row, "latency_ns"); ok && t.bool(row, "ok") {
+			stats.Response = append(stats.Response, latency/1e6)
+		}
+	}
+	return nil
+}
+
+func (r *Run) readFOVDelivery(path string) error {
+	t, err := readTable(path)
+	if err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		tiles, ok1 := t.float(row, "fov_tiles")
+		onTime, ok2 := t.float(row, "fov_on_time")
+		if !ok1 || !ok2 || tiles == 0 {
+			continue
+		}
+		r.FOVTiles += int64(tiles)
+		r.FOVOnTime += int64(onTime)
+		r.FOVSegments++
+		if onTime >= tiles {
+			r.FOVFullSegments++
+		}
+	}
+	return nil
+}
+
+// Quantile returns the value below which a fraction q of the sorted values
+// lie (nearest rank), NaN if there are none.
+func Quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	rank := int(math.Ceil(q * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+func sorted(values []float64) []float64 {
+	out := append([]float64(nil), values...)
+	sort.Float64s(out)
+	return out
+}
+
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return math.NaN()
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// Metric is one row of the comparison table. NaN means not available.
+type Metric struct {
+	Name  string
+	Value float64
+}
+
+// Metrics returns the comparison metrics of the run, always the same names
+// in the same order.
+func (r *Run) Metrics() []Metric {
+	var metrics []Metric
+	add := func(name string, value float64) {
+		metrics = append(metrics, Metric{name, value})
+	}
+	latency := func(prefix string, stats ClassStats, class string) {
+		response := sorted(stats.Response)
+		add(fmt.Sprintf("%s_requests_%s", prefix, class), float64(stats.Requests))
+		add(fmt.Sprintf("%s_deadline_miss_rate_%s_pct", prefix, class), percent(int64(stats.Missed), int64(stats.Requests)))
+		add(fmt.Sprintf("%s_response_p50_%s_ms", prefix, class), Quantile(response, 0.50))
+		add(fmt.Sprintf("%s_response_p90_%s_ms", prefix, class), Quantile(response, 0.90))
+		add(fmt.Sprintf("%s_response_p99_%s_ms", prefix, class), Quantile(response, 0.99))
+	}
+
+	for _, c := range classes {
+		stats := r.Server[c.class]
+		latency("server", stats, c.name)
+		add("server_drop_rate_"+c.name+"_pct", percent(int64(stats.Dropped), int64(stats.Requests)))
+		add("server_queue_delay_p90_"+c.name+"_ms", Quantile(sorted(stats.QueueDelay), 0.90))
+	}
+	for _, c := range classes {
+		latency("client", r.Client[c.class], c.name)
+	}
+
+	summary := func(name string) float64 {
+		if v, ok := r.Summary[name]; ok {
+			return v
+		}
+		return math.NaN()
+	}
+	for _, name := range []string{"throughput_high_kbps", "throughput_med_kbps", "throughput_low_kbps",
+		"jain_fairness", "weighted_jain_fairness", "min_max_service_ratio"} {
+		add(name, summary(name))
+	}
+	for _, o := range model.ServerOutcomes {
+		add("server_outcome_"+o.String(), summary("outcome_"+o.String()))
+	}
+
+	var jain, weighted []float64
+	for _, w := range r.Fairness {
+		jain = append(jain, w.Jain)
+		if !math.IsNaN(w.WeightedJain) {
+			weighted = append(weighted, w.WeightedJain)
+		}
+	}
+	add("fairness_windows", float64(len(r.Fairness)))
+	add("fairness_jain_mean", mean(jain))
+	add("fairness_jain_min", minimum(jain))
+	add("fairness_backlogged_windows", float64(len(weighted)))
+	add("fairness_weighted_jain_mean", mean(weighted))
+	add("fairness_weighted_jain_min", minimum(weighted))
+
+	add("fov_hit_rate_pct", percent(r.FOVOnTime, r.FOVTiles))
+	add("fov_full_segments_pct", percent(int64(r.FOVFullSegments), int64(r.FOVSegments)))
+	return metrics
+}
+
+// minimum of values, NaN if empty.
+func minimum(values []float64) float64 {
+	min := math.NaN()
+	for _, v := range values {
+		if math.IsNaN(min) || v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// CDFPercentiles are the points of the CDFs: every 5% plus p99.
+var CDFPercentiles = []float64{0, 5, 10, 15, 20, 25, 30, 35, 40, 45, 50, 55, 60, 65, 70, 75, 80, 85, 90, 95, 99, 100}
+
+// CDFPoint is the value of one latency at one percentile.
+type CDFPoint struct {
+	// server or client
+	Side string
+	// response_ms (both sides) or queue_delay_ms (server)
+	Metric     string
+	Class      string
+	Percentile float64
+	Value      float64
+}
+
+// CDF returns the per-class CDFs of the run, empty classes left out.
+func (r *Run) CDF() []CDFPoint {
+	var points []CDFPoint
+	add := func(side, metric, class string, values []float64) {
+		values = sorted(values)
+		if len(values) == 0 {
+			return
+		}
+		for _, p := range CDFPercentiles {
+			points = append(points, CDFPoint{side, metric, class, p, Quantile(values, p/100)})
+		}
+	}
+	for _, c := range classes {
+		add("server", "response_ms", c.name, r.Server[c.class].Response)
+		add("server", "queue_delay_ms", c.name, r.Server[c.class].QueueDelay)
+	}
+	for _, c := range classes {
+		add("client", "response_ms", c.name, r.Client[c.class].Response)
+	}
+	return points
+}
@@ -0,0 +1,125 @@
+package analyze_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"main/src/analyze"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, path string, lines ...string) {
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	assert.Nil(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644))
+}
+
+// An experiment run directory with two high and two low priority requests.
+func writeRun(t *testing.T, dir string) {
+	writeFile(t, filepath.Join(dir, "server", "reqlog.csv"),
+		"time_ns,event,class,segment,tile,bytes,ontime,drop,qd_ms,svc_ms,rsp_ms,fault,pred_ms,feasible",
+		"1,complete,0,1,100,1000,true,false,2,3,5,,,",
+		"2,complete,0,1,101,1000,true,false,4,6,10,,,",
+		"3,complete,2,1,102,1000,false,false,50,30,80,,,",
+		"4,drop,2,1,103,0,false,true,900,0,900,,,")
+	// only the aggregate counts
+	writeFile(t, filepath.Join(dir, "server", "reqlog-conn1.csv"),
+		"time_ns,event,class,segment,tile,bytes,ontime,drop,qd_ms,svc_ms,rsp_ms,fault,pred_ms,feasible",
+		"1,complete,0,1,100,1000,true,false,2,3,5,,,")
+	writeFile(t, filepath.Join(dir, "server", "server_summary.csv"),
+		"ts_start,ts_end,jain_fairness,throughput_high_kbps",
+		"a,b,0.900,120.000")
+	writeFile(t, filepath.Join(dir, "server", "fairness.csv"),
+		"ts,bytes_low,bytes_medium,bytes_high,share_low,share_medium,share_high,jain,weighted_jain",
+		"2024-01-01T00:00:01Z,1,0,1,0.5,0,0.5,0.666667,",
+		"2024-01-01T00:00:02Z,1,1,1,0.333333,0.333333,0.333333,1.000000,0.900000")
+	writeFile(t, filepath.Join(dir, "client", "statistics-client0.csv"),
+		"time_ns,segment,tile,priority,latency_ns,timedout,skipped,ok,tp,buffer_s,tile_missing_ratio,in_fov,on_time,delivery",
+		"1,100,1,0,20000000,false,false,true,1,0,-1,true,true,stream",
+		"2,101,1,2,0,false,true,false,0,0,-1,false,false,stream",
+		"3,102,1,2,0,true,false,false,0,0,-1,false,false,stream")
+	writeFile(t, filepath.Join(dir, "client", "statistics-summary-client0.csv"),
+		"join_latency_ms", "10")
+	writeFile(t, filepath.Join(dir, "client", "fov-delivery-client0.csv"),
+		"segment,fov_tiles,fov_on_time,fov_hit_rate_percent",
+		"1,4,4,100.00",
+		"2,4,2,50.00")
+}
+
+func metric(r *analyze.Run, name string) float64 {
+	for _, m := range r.Metrics() {
+		if m.Name == name {
+			return m.Value
+		}
+	}
+	return math.Inf(1)
+}
+
+// Tests if the metrics of a run directory are read from its files
+func TestLoad(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "run1")
+	writeRun(t, dir)
+	r, err := analyze.Load(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, "run1", r.Name)
+
+	assert.Equal(t, 2.0, metric(r, "server_requests_high"))
+	assert.Equal(t, 0.0, metric(r, "server_deadline_miss_rate_high_pct"))
+	assert.Equal(t, 5.0, metric(r, "server_response_p50_high_ms"))
+	assert.Equal(t, 10.0, metric(r, "server_response_p99_high_ms"))
+	assert.Equal(t, 100.0, metric(r, "server_deadline_miss_rate_low_pct"))
+	assert.Equal(t, 50.0, metric(r, "server_drop_rate_low_pct"))
+	assert.Equal(t, 50.0, metric(r, "server_queue_delay_p90_low_ms"))
+	assert.True(t, math.IsNaN(metric(r, "server_deadline_miss_rate_medium_pct")))
+
+	assert.Equal(t, 1.0, metric(r, "client_requests_high"))
+	assert.Equal(t, 20.0, metric(r, "client_response_p90_high_ms"))
+	assert.Equal(t, 1.0, metric(r, "client_requests_low"))
+	assert.Equal(t, 100.0, metric(r, "client_deadline_miss_rate_low_pct"))
+
+	assert.Equal(t, 0.9, metric(r, "jain_fairness"))
+	assert.Equal(t, 2.0, metric(r, "fairness_windows"))
+	assert.InDelta(t, 0.666667, metric(r, "fairness_jain_min"), 1e-9)
+	assert.Equal(t, 1.0, metric(r, "fairness_backlogged_windows"))
+	assert.InDelta(t, 0.9, metric(r, "fairness_weighted_jain_mean"), 1e-9)
+	assert.True(t, math.IsNaN(metric(r, "weighted_jain_fairness")))
+	assert.Equal(t, 1.0, r.Fairness[1].T)
+	assert.Equal(t, 75.0, metric(r, "fov_hit_rate_pct"))
+	assert.Equal(t, 50.0, metric(r, "fov_full_segments_pct"))
+}
+
+// Tests if a directory without outputs is an error
+func TestLoad_Empty(t *testing.T) {
+	_, err := analyze.Load(t.TempDir())
+	assert.NotNil(t, err)
+}
+
+// Tests if the comparison has a column per run and the CSV files are written
+func TestAnalyze(t *testing.T) {
+	base := t.TempDir()
+	writeRun(t, filepath.Join(base, "a"))
+	writeRun(t, filepath.Join(base, "b"))
+	out := filepath.Join(base, "analysis")
+
+	var stdout bytes.Buffer
+	err := analyze.Analyze([]string{filepath.Join(base, "a"), filepath.Join(base, "b")},
+		analyze.Options{Format: "csv", OutputDir: out, Out: &stdout})
+	assert.Nil(t, err)
+	rows, err := csv.NewReader(&stdout).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"metric", "a", "b"}, rows[0])
+	assert.Equal(t, []string{"server_requests_high", "2.000", "2.000"}, rows[1])
+
+	for _, name := range []string{"comparison.csv", "cdf.csv", "fairness_over_time.csv"} {
+		_, err := os.Stat(filepath.Join(out, name))
+		assert.Nil(t, err)
+	}
+
+	err = analyze.Analyze([]string{filepath.Join(base, "a")}, analyze.Options{Format: "xml", Out: &stdout})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,430 @@
+package analyze
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"main/src/model"
+)
+
+// JoinedHeader are the columns of the joined per-request records.
+var JoinedHeader = []string{
+	"request_id", "match", "client", "class", "segment", "tile",
+	"sent_unix_ns", "client_latency_ms", "client_on_time", "client_outcome",
+	"server_event", "server_drop", "server_outcome",
+	"uplink_ms", "queue_ms", "service_ms", "downlink_ms",
+	"miss_cause",
+}
+
+// How a record was matched.
+const (
+	MatchID         = "id"          // same request ID
+	MatchTime       = "time"        // same class, segment and tile, closest in time
+	MatchClientOnly = "client_only" // no server row
+	MatchServerOnly = "server_only" // no client row
+)
+
+// Causes of a deadline miss.
+const (
+	CauseScheduler = "scheduler" // dropped, or queue + service took longer than the network
+	CauseNetwork   = "network"   // uplink + downlink took longer than queue + service
+	CauseFailure   = "failure"   // the server failed to send it, see server_outcome
+	CauseUnknown   = "unknown"   // no server row to tell
+)
+
+// Server outcomes that are failures rather than slow service.
+var failureOutcomes = map[string]bool{
+	model.NOT_FOUND_OUTCOME.String():       true,
+	model.WRITE_ERROR_OUTCOME.String():     true,
+	model.CANCELLED_OUTCOME.String():       true,
+	model.TRANSPORT_ERROR_OUTCOME.String(): true,
+}
+
+// Joined is one request seen by the client, the server or both. Durations
+// are in ms, NaN when unknown.
+type Joined struct {
+	RequestID string
+	Match     string
+	// Statistics file suffix, e.g. client0.
+	Client                string
+	Class, Segment, Tile  int
+	SentUnixNs            int64
+	ClientLatency         float64
+	ClientOnTime          bool
+	ClientOutcome         string // empty in logs without the column
+	ServerEvent           string
+	ServerDrop            bool
+	ServerOutcome         string
+	Uplink, Queue         float64
+	Service, Downlink     float64
+	MissCause             string
+	hasClient, hasServer  bool
+	serverEnd, serverSpan int64 // time_ns and rsp of the reqlog, in ns
+}
+
+type clientRow struct {
+	client               string
+	id                   string
+	class, segment, tile int
+	sent                 int64
+	latency              float64
+	ok, onTime           bool
+	outcome              string
+}
+
+type serverRow struct {
+	id                   string
+	class, segment, tile int
+	end                  int64 // time_ns
+	qd, svc, rsp         float64
+	event, outcome       string
+	drop                 bool
+	used                 bool
+}
+
+func (r *serverRow) arrival() int64 {
+	return r.end - int64(r.rsp*1e6)
+}
+
+type joinKey struct{ class, segment, tile int }
+
+// Join reads the aggregate reqlog.csv and the statistics-*.csv found under
+// dir and joins them per request: on the request ID, else on class, segment
+// and tile with the server arrival closest to the send time.
+//
+// The end-to-end latency is split with the clocks of both sides, which are
+// the same on one host (experiment, Mininet): uplink from the send to the
+// server arrival (time_ns - rsp_ms), queue and service from the reqlog, and
+// downlink from the end of service to the response at the client. The server
+// times are whole ms, so a split can be off by 1 ms.
+func Join(dir string) ([]Joined, error) {
+	var clients []clientRow
+	var servers []*serverRow
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		name := entry.Name()
+		switch {
+		case name == "reqlog.csv":
+			rows, err := readServerRows(path)
+			servers = append(servers, rows...)
+			return err
+		case strings.HasPrefix(name, "statistics-summary-"):
+		case strings.HasPrefix(name, "statistics-") && strings.HasSuffix(name, ".csv"):
+			client := strings.TrimSuffix(strings.TrimPrefix(name, "statistics-"), ".csv")
+			rows, err := readClientRows(path, client)
+			clients = append(clients, rows...)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(clients) == 0 && len(servers) == 0 {
+		return nil, fmt.Errorf("%s: no reqlog.csv or statistics-*.csv", dir)
+	}
+
+	byID := map[string]*serverRow{}
+	byKey := map[joinKey][]*serverRow{}
+	for _, s := range servers {
+		if s.id != "" {
+			byID[s.id] = s
+		}
+		key := joinKey{s.class, s.segment, s.tile}
+		byKey[key] = append(byKey[key], s)
+	}
+
+	joined := make([]Joined, 0, len(clients))
+	var unmatched []int
+	for i, c := range clients {
+		if s, ok := byID[c.id]; ok && c.id != "" && !s.used {
+			s.used = true
+			joined = append(joined, join(&c, s, MatchID))
+			continue
+		}
+		unmatched = append(unmatched, i)
+		joined = append(joined, Joined{})
+	}
+	// fallback after every ID is taken, so it never steals a row with one
+	for _, i := range unmatched {
+		c := &clients[i]
+		var best *serverRow
+		for _, s := range byKey[joinKey{c.class, c.segment, c.tile}] {
+			if s.used || (s.id != "" && c.id != "" && s.id != c.id) {
+				continue
+			}
+			if best == nil || abs(s.arrival()-c.sent) < abs(best.arrival()-c.sent) {
+				best = s
+			}
+		}
+		if best == nil {
+			joined[i] = join(c, nil, MatchClientOnly)
+			continue
+		}
+		best.used = true
+		joined[i] = join(c, best, MatchTime)
+	}
+	for _, s := range servers {
+		if !s.used {
+			joined = append(joined, join(nil, s, MatchServerOnly))
+		}
+	}
+	sortJoined(joined)
+	return joined, nil
+}
+
+// JoinRun joins the logs under dir, writes the records to path
+// (<dir>/joined.csv when empty) and prints the summary to w.
+func JoinRun(dir, path string, w io.Writer) error {
+	joined, err := Join(dir)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		path = filepath.Join(dir, "joined.csv")
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteJoinedCSV(f, joined); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return WriteJoinSummary(w, joined)
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func join(c *clientRow, s *serverRow, match string) Joined {
+	nan := math.NaN()
+	j := Joined{Match: match, ClientLatency: nan, Uplink: nan, Queue: nan, Service: nan, Downlink: nan}
+	if c != nil {
+		j.hasClient = true
+		j.RequestID, j.Client = c.id, c.client
+		j.Class, j.Segment, j.Tile = c.class, c.segment, c.tile
+		j.SentUnixNs = c.sent
+		j.ClientLatency = c.latency
+		j.ClientOnTime = c.onTime
+		j.ClientOutcome = c.outcome
+	}
+	if s != nil {
+		j.hasServer = true
+		if j.RequestID == "" {
+			j.RequestID = s.id
+		}
+		j.Class, j.Segment, j.Tile = s.class, s.segment, s.tile
+		j.ServerEvent, j.ServerDrop, j.ServerOutcome = s.event, s.drop, s.outcome
+		j.serverEnd, j.serverSpan = s.end, int64(s.rsp*1e6)
+		if s.drop {
+			j.Queue = s.rsp // waited until dropped
+		} else {
+			j.Queue, j.Service = s.qd, s.svc
+		}
+	}
+	if c != nil && s != nil && c.sent > 0 {
+		j.Uplink = float64(s.arrival()-c.sent) / 1e6
+		if c.ok && !s.drop {
+			received := c.sent + int64(c.latency*1e6)
+			j.Downlink = float64(received-s.end) / 1e6
+		}
+	}
+	if c != nil && !c.onTime {
+		j.MissCause = missCause(j)
+	}
+	return j
+}
+
+// missCause tells whether the scheduler or the network took most of the time
+// of a missed request.
+func missCause(j Joined) string {
+	if !j.hasServer {
+		return CauseUnknown
+	}
+	if j.ServerDrop {
+		return CauseScheduler
+	}
+	if failureOutcomes[j.ServerOutcome] {
+		return CauseFailure
+	}
+	server := j.Queue + nanToZero(j.Service)
+	network := nanToZero(j.Uplink) + nanToZero(j.Downlink)
+	if math.IsNaN(j.Downlink) && !math.IsNaN(j.ClientLatency) && !math.IsNaN(j.Uplink) {
+		// no response in time: what the server did not take was the network
+		network = j.ClientLatency - server
+	}
+	if server >= network {
+		return CauseScheduler
+	}
+	return CauseNetwork
+}
+
+func nanToZero(v float64) float64 {
+	if math.IsNaN(v) {
+		return 0
+	}
+	return v
+}
+
+func readServerRows(path string) ([]*serverRow, error) {
+	t, err := readTable(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	rows := make([]*serverRow, 0, len(t.rows))
+	for _, row := range t.rows {
+		c, ok := t.class(row, "class")
+		end, err := strconv.ParseInt(t.get(row, "time_ns"), 10, 64)
+		if !ok || err != nil {
+			continue
+		}
+		s := &serverRow{id: t.get(row, "request_id"), class: int(c), end: end,
+			event: t.get(row, "event"), drop: t.bool(row, "drop"), outcome: t.get(row, "outcome")}
+		s.segment, _ = strconv.Atoi(t.get(row, "segment"))
+		s.tile, _ = strconv.Atoi(t.get(row, "tile"))
+		s.qd, _ = t.float(row, "qd_ms")
+		s.svc, _ = t.float(row, "svc_ms")
+		s.rsp, _ = t.float(row, "rsp_ms")
+		rows = append(rows, s)
+	}
+	return rows, nil
+}
+
+// Tiles skipped by the client were never requested and are left out.
+func readClientRows(path, client string) ([]clientRow, error) {
+	t, err := readTable(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	rows := make([]clientRow, 0, len(t.rows))
+	for _, row := range t.rows {
+		c, ok := t.class(row, "priority")
+		if !ok || t.bool(row, "skipped") {
+			continue
+		}
+		r := clientRow{client: client, id: t.get(row, "request_id"), class: int(c),
+			ok: t.bool(row, "ok"), onTime: t.bool(row, "on_time"), outcome: t.get(row, "outcome")}
+		r.segment, _ = strconv.Atoi(t.get(row, "segment"))
+		r.tile, _ = strconv.Atoi(t.get(row, "tile"))
+		r.sent, _ = strconv.ParseInt(t.get(row, "sent_unix_ns"), 10, 64)
+		latency, _ := t.float(row, "latency_ns")
+		r.latency = latency / 1e6
+		rows = append(rows, r)
+	}
+	return rows, nil
+}
+
+func msValue(v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', 3, 64)
+}
+
+// WriteJoinedCSV writes the records with the columns of JoinedHeader.
+func WriteJoinedCSV(w io.Writer, joined []Joined) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write(JoinedHeader)
+	for _, j := range joined {
+		onTime, sent := "", ""
+		if j.hasClient {
+			onTime = strconv.FormatBool(j.ClientOnTime)
+			if j.SentUnixNs > 0 {
+				sent = strconv.FormatInt(j.SentUnixNs, 10)
+			}
+		}
+		drop := ""
+		if j.hasServer {
+			drop = strconv.FormatBool(j.ServerDrop)
+		}
+		_ = cw.Write([]string{
+			j.RequestID, j.Match, j.Client,
+			strconv.Itoa(j.Class), strconv.Itoa(j.Segment), strconv.Itoa(j.Tile),
+			sent, msValue(j.ClientLatency), onTime, j.ClientOutcome,
+			j.ServerEvent, drop, j.ServerOutcome,
+			msValue(j.Uplink), msValue(j.Queue), msValue(j.Service), msValue(j.Downlink),
+			j.MissCause,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJoinSummary prints, per class, how the records were matched, the
+// mean latency split of the requests seen by both sides and the causes of
+// the deadline misses.
+func WriteJoinSummary(w io.Writer, joined []Joined) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	columns := []string{"class", "by_id", "by_time", "client_only", "server_only",
+		"uplink_ms", "queue_ms", "service_ms", "downlink_ms",
+		"misses", "scheduler", "network", "failure", "unknown"}
+	fmt.Fprintln(tw, strings.Join(columns, "\t")+"\t")
+	for _, c := range classes {
+		matches := map[string]int{}
+		causes := map[string]int{}
+		var split [4][]float64
+		for _, j := range joined {
+			if j.Class != int(c.class) {
+				continue
+			}
+			matches[j.Match]++
+			if j.MissCause != "" {
+				causes[j.MissCause]++
+			}
+			for i, v := range []float64{j.Uplink, j.Queue, j.Service, j.Downlink} {
+				if j.hasClient && j.hasServer && !math.IsNaN(v) {
+					split[i] = append(split[i], v)
+				}
+			}
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		cells := []string{c.name,
+			strconv.Itoa(matches[MatchID]), strconv.Itoa(matches[MatchTime]),
+			strconv.Itoa(matches[MatchClientOnly]), strconv.Itoa(matches[MatchServerOnly])}
+		for _, values := range split {
+			if v := mean(values); math.IsNaN(v) {
+				cells = append(cells, "-")
+			} else {
+				cells = append(cells, strconv.FormatFloat(v, 'f', 2, 64))
+			}
+		}
+		misses := causes[CauseScheduler] + causes[CauseNetwork] + causes[CauseFailure] + causes[CauseUnknown]
+		cells = append(cells, strconv.Itoa(misses),
+			strconv.Itoa(causes[CauseScheduler]), strconv.Itoa(causes[CauseNetwork]),
+			strconv.Itoa(causes[CauseFailure]), strconv.Itoa(causes[CauseUnknown]))
+		fmt.Fprintln(tw, strings.Join(cells, "\t")+"\t")
+	}
+	return tw.Flush()
+}
+
+// sortJoined orders the records by send time, server-only ones by arrival.
+func sortJoined(joined []Joined) {
+	at := func(j Joined) int64 {
+		if j.SentUnixNs > 0 {
+			return j.SentUnixNs
+		}
+		return j.serverEnd - j.serverSpan
+	}
+	sort.SliceStable(joined, func(a, b int) bool { return at(joined[a]) < at(joined[b]) })
+}
@@ -0,0 +1,94 @@
+package analyze_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"main/src/analyze"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A run where requests match by ID, by time, not at all, and where the
+// scheduler, the network or a server failure caused the misses. The client
+// log has no outcome column, as before it existed.
+func writeJoinRun(t *testing.T, dir string) {
+	writeFile(t, filepath.Join(dir, "server", "reqlog.csv"),
+		"time_ns,event,class,segment,tile,bytes,ontime,drop,qd_ms,svc_ms,rsp_ms,fault,pred_ms,feasible,request_id,outcome",
+		"1100000000,complete,0,1,1,1000,true,false,2,3,5,,,,a,on_time",
+		"2000000000,complete,2,1,2,1000,false,false,100,50,150,,,,,late",
+		"3000000000,drop,1,2,1,0,false,true,900,0,900,,,,c,expired_before_service",
+		"5000000000,complete,2,1,2,1000,true,false,5,5,10,,,,,on_time",
+		"6000000000,complete,0,4,4,0,false,false,1,1,2,,,,e,not_found")
+	writeFile(t, filepath.Join(dir, "client", "statistics-client0.csv"),
+		"time_ns,segment,tile,priority,latency_ns,timedout,skipped,ok,tp,buffer_s,tile_missing_ratio,in_fov,on_time,delivery,request_id,sent_unix_ns",
+		"1,1,1,0,200000000,false,false,true,1,0,-1,true,false,stream,a,1000000000",
+		"2,1,2,2,250000000,false,false,true,1,0,-1,true,false,stream,,1800000000",
+		"3,2,1,1,1000000000,true,false,false,0,0,-1,true,false,stream,c,2000000000",
+		"4,9,9,0,0,true,false,false,0,0,-1,true,false,stream,d,2500000000",
+		"5,3,3,0,0,false,true,false,0,0,-1,true,false,stream,,0",
+		"6,4,4,0,1000000000,true,false,false,0,0,-1,true,false,stream,e,5990000000")
+}
+
+// Tests if requests are joined on the ID first, then on class, segment and
+// tile closest in time, and the rest is kept unmatched
+func TestJoinMatches(t *testing.T) {
+	dir := t.TempDir()
+	writeJoinRun(t, dir)
+	joined, err := analyze.Join(dir)
+	assert.Nil(t, err)
+	assert.Len(t, joined, 6) // the skipped tile is left out
+
+	matches := map[string]string{}
+	for _, j := range joined {
+		matches[j.RequestID+"/"+j.Match] = j.MissCause
+	}
+	assert.Equal(t, map[string]string{
+		"a/id":          analyze.CauseNetwork,
+		"/time":         analyze.CauseScheduler,
+		"c/id":          analyze.CauseScheduler,
+		"d/client_only": analyze.CauseUnknown,
+		"e/id":          analyze.CauseFailure,
+		"/server_only":  "",
+	}, matches)
+}
+
+// Tests if the latency is split into uplink, queue, service and downlink
+func TestJoinSplit(t *testing.T) {
+	dir := t.TempDir()
+	writeJoinRun(t, dir)
+	joined, err := analyze.Join(dir)
+	assert.Nil(t, err)
+
+	// sorted by send time
+	a, b := joined[0], joined[1]
+	assert.Equal(t, "a", a.RequestID)
+	assert.InDelta(t, 95, a.Uplink, 1e-9)
+	assert.InDelta(t, 2, a.Queue, 1e-9)
+	assert.InDelta(t, 3, a.Service, 1e-9)
+	assert.InDelta(t, 100, a.Downlink, 1e-9)
+
+	assert.Equal(t, analyze.MatchTime, b.Match)
+	assert.InDelta(t, 50, b.Uplink, 1e-9)
+	assert.InDelta(t, 100, b.Queue, 1e-9)
+	assert.InDelta(t, 50, b.Service, 1e-9)
+	assert.InDelta(t, 50, b.Downlink, 1e-9)
+}
+
+// Tests if JoinRun writes joined.csv in the run directory and the summary
+func TestJoinRun(t *testing.T) {
+	dir := t.TempDir()
+	writeJoinRun(t, dir)
+	var out bytes.Buffer
+	assert.Nil(t, analyze.JoinRun(dir, "", &out))
+	assert.Contains(t, out.String(), "scheduler")
+
+	data, err := os.ReadFile(filepath.Join(dir, "joined.csv"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), "a,id,client0,0,1,1,1000000000,200.000,false,,complete,false,on_time,95.000,2.000,3.000,100.000,network")
+
+	_, err = analyze.Join(t.TempDir())
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,201 @@
+package analyze
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+)
+
+// Options of Analyze.
+type Options struct {
+	// text (aligned table) or csv, for the comparison printed to Out.
+	Format string
+	// Directory for comparison.csv, cdf.csv and fairness_over_time.csv.
+	// Empty writes no files.
+	OutputDir string
+	Out       io.Writer
+}
+
+// Analyze loads every run directory and prints the comparison table, one
+// column per run. With an output directory it also writes the tables as CSV.
+func Analyze(dirs []string, opts Options) error {
+	if len(dirs) == 0 {
+		return fmt.Errorf("no run directory")
+	}
+	if opts.Out == nil {
+		opts.Out = os.Stdout
+	}
+	runs := make([]*Run, 0, len(dirs))
+	names := map[string]bool{}
+	duplicate := false
+	for _, dir := range dirs {
+		r, err := Load(dir)
+		if err != nil {
+			return err
+		}
+		duplicate = duplicate || names[r.Name]
+		names[r.Name] = true
+		runs = append(runs, r)
+	}
+	// rep1 of several matrix cells...: the paths tell them apart
+	if duplicate {
+		for _, r := range runs {
+			r.Name = r.Dir
+		}
+	}
+
+	switch opts.Format {
+	case "", "text":
+		if err := WriteText(opts.Out, runs); err != nil {
+			return err
+		}
+	case "csv":
+		if err := WriteComparisonCSV(opts.Out, runs); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %q (text or csv)", opts.Format)
+	}
+
+	if opts.OutputDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(opts.OutputDir, 0o755); err != nil {
+		return err
+	}
+	files := []struct {
+		name  string
+		write func(io.Writer, []*Run) error
+	}{
+		{"comparison.csv", WriteComparisonCSV},
+		{"cdf.csv", WriteCDFCSV},
+		{"fairness_over_time.csv", WriteFairnessCSV},
+	}
+	for _, file := range files {
+		if err := writeFile(filepath.Join(opts.OutputDir, file.name), runs, file.write); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFile(path string, runs []*Run, write func(io.Writer, []*Run) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f, runs); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Rows of the comparison: metric, then the value of each run.
+func comparison(runs []*Run) (header []string, rows [][]float64, names []string) {
+	header = []string{"metric"}
+	for _, r := range runs {
+		header = append(header, r.Name)
+	}
+	for i, r := range runs {
+		for j, m := range r.Metrics() {
+			if i == 0 {
+				names = append(names, m.Name)
+				rows = append(rows, make([]float64, len(runs)))
+			}
+			rows[j][i] = m.Value
+		}
+	}
+	return header, rows, names
+}
+
+// WriteText prints the comparison as an aligned table; "-" marks values not
+// available in a run.
+func WriteText(w io.Writer, runs []*Run) error {
+	header, rows, names := comparison(runs)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	line := func(cells []string) {
+		for _, cell := range cells {
+			fmt.Fprintf(tw, "%s\t", cell)
+		}
+		fmt.Fprintln(tw)
+	}
+	line(header)
+	for i, name := range names {
+		cells := []string{name}
+		for _, v := range rows[i] {
+			if math.IsNaN(v) {
+				cells = append(cells, "-")
+			} else {
+				cells = append(cells, strconv.FormatFloat(v, 'f', 2, 64))
+			}
+		}
+		line(cells)
+	}
+	return tw.Flush()
+}
+
+// Numbers in CSV, empty when not available.
+func csvValue(v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', 3, 64)
+}
+
+// WriteComparisonCSV writes the comparison: metric, then one column per run.
+func WriteComparisonCSV(w io.Writer, runs []*Run) error {
+	header, rows, names := comparison(runs)
+	cw := csv.NewWriter(w)
+	_ = cw.Write(header)
+	for i, name := range names {
+		row := []string{name}
+		for _, v := range rows[i] {
+			row = append(row, csvValue(v))
+		}
+		_ = cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteCDFCSV writes the per-class CDFs of every run in long format.
+func WriteCDFCSV(w io.Writer, runs []*Run) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"run", "side", "metric", "class", "percentile", "value_ms"})
+	for _, r := range runs {
+		for _, p := range r.CDF() {
+			_ = cw.Write([]string{r.Name, p.Side, p.Metric, p.Class,
+				strconv.FormatFloat(p.Percentile, 'f', -1, 64), csvValue(p.Value)})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteFairnessCSV writes the fairness windows of every run, with the time
+// since the first window of the run.
+func WriteFairnessCSV(w io.Writer, runs []*Run) error {
+	cw := csv.NewWriter(w)
+	header := []string{"run", "t_s"}
+	for _, c := range classes {
+		header = append(header, "share_"+c.name)
+	}
+	_ = cw.Write(append(header, "jain", "weighted_jain"))
+	for _, r := range runs {
+		for _, window := range r.Fairness {
+			row := []string{r.Name, csvValue(window.T)}
+			for _, c := range classes {
+				row = append(row, csvValue(window.Share[c.class]))
+			}
+			_ = cw.Write(append(row, csvValue(window.Jain), csvValue(window.WeightedJain)))
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
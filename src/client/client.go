@@ -204,7 +204,7 @@ func (c *Client) handleStream(connection quic.Connection, priority model.Priorit
 				c.sendRequest(stream, req)
 				tempReqTime := time.Now()
 
-				str := fmt.Sprintf("%f", priority)
+				str := fmt.Sprintf("%d", priority)
 				//fmt.Printf(str)
 				//stream.CancelRead(404)
 
@@ -0,0 +1,461 @@
+// Package config holds the settings of the server, the test client, the
+// emulated network and the experiment runner, loaded from a JSON file.
+//
+// Values are resolved in this order, later ones winning: defaults, the
+// environment variables read by the commands (NETEM_*, FOV_TRACE_*,
+// DATAGRAM_CLASSES), the config file, and command-line flags.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"main/src/model"
+	"main/src/netem"
+	"os"
+	"strconv"
+	"time"
+)
+
+type Config struct {
+	Server     ServerConfig     `json:"server"`
+	Client     ClientConfig     `json:"client"`
+	Network    NetworkConfig    `json:"network"`
+	Experiment ExperimentConfig `json:"experiment"`
+	Loadgen    LoadgenConfig    `json:"loadgen"`
+	// Background traffic sharing the link with the tiles.
+	CrossTraffic CrossTrafficConfig `json:"cross_traffic"`
+}
+
+type ServerConfig struct {
+	// Queue policy: wfq, sp or fifo.
+	Policy string `json:"policy"`
+	// Listen address of the server command.
+	Address string `json:"address"`
+	// UDP port, also used by the clients.
+	Port int `json:"port"`
+	// Directory of the server CSVs. The server command creates a run
+	// directory for each run inside it.
+	OutputDir  string     `json:"output_dir"`
+	WFQWeights WFQWeights `json:"wfq_weights"`
+	// Faults injected in the service path, and their random seed (0 uses
+	// the current time).
+	Faults    []FaultConfig `json:"faults"`
+	FaultSeed int64         `json:"fault_seed"`
+	// Encoding of the metrics files: csv or jsonl for all of them, optionally
+	// followed by per-series choices, e.g. "csv,reqlog=jsonl". The series are
+	// reqlog, class_agg, queue_len, server_summary, fairness,
+	// work_conserving and wfq_utilization.
+	MetricsFormat string `json:"metrics_format"`
+	// Listen address of the Prometheus /metrics endpoint, e.g. ":9100".
+	// Empty disables it.
+	MetricsAddr string `json:"metrics_addr"`
+	// Listen address of the admin HTTP API, e.g. "127.0.0.1:9200". Empty
+	// disables it. Not authenticated: keep it local.
+	AdminAddr string `json:"admin_addr"`
+	// Write the qlog of every connection to conn<id>-<odcid>.qlog in
+	// OutputDir.
+	Qlog bool `json:"qlog"`
+	// Interval of the transport state samples (RTT, congestion window,
+	// bytes in flight, losses) written to transport.csv. 0 disables them.
+	TransportIntervalMs int `json:"transport_interval_ms"`
+	// Deadline feasibility prediction from the delivery rate of each
+	// connection: off, log (predict and record only) or skip (drop the tiles
+	// predicted to miss their deadline).
+	Feasibility string `json:"feasibility"`
+}
+
+// A fault injected by the server into the requests it matches.
+type FaultConfig struct {
+	// storage_delay, read_error, write_delay, write_error or worker_stall.
+	Kind string `json:"kind"`
+	// Chance of hitting a matching request, in [0, 1].
+	Probability float64 `json:"probability"`
+	// Added latency of storage_delay, write_delay and worker_stall.
+	DelayMs float64 `json:"delay_ms"`
+	// Classes affected, e.g. "high" or "medium,low". Empty means all.
+	Classes string `json:"classes"`
+	// Connection affected, numbered from 1 in order of arrival. 0 means all.
+	Connection int `json:"connection"`
+	// Active from start_s to end_s (0: no end) after the server starts,
+	// repeating every period_s if set.
+	StartS  float64 `json:"start_s"`
+	EndS    float64 `json:"end_s"`
+	PeriodS float64 `json:"period_s"`
+}
+
+type WFQWeights struct {
+	High   int `json:"high"`
+	Medium int `json:"medium"`
+	Low    int `json:"low"`
+}
+
+type ClientConfig struct {
+	ServerURL     string `json:"server_url"`
+	Parallelism   int    `json:"parallelism"`
+	BaseLatencyMs int    `json:"base_latency_ms"`
+	// Use the same stream for all requests.
+	Pipeline bool `json:"pipeline"`
+	// Proportion of high and medium priority tiles when no FoV trace is
+	// loaded. The remaining tiles are low priority (all of them by default).
+	HighPriorityRatio   float64 `json:"high_priority_ratio"`
+	MediumPriorityRatio float64 `json:"medium_priority_ratio"`
+	// Segments 1..TotalTimeSegments and tiles FirstTile..LastTile are
+	// requested.
+	TotalTimeSegments int `json:"total_time_segments"`
+	FirstTile         int `json:"first_tile"`
+	LastTile          int `json:"last_tile"`
+	// Segments that can be downloaded ahead of the playback.
+	MaxBufferedSegmentsAhead int    `json:"max_buffered_segments_ahead"`
+	FOVTracePath             string `json:"fov_trace_path"`
+	FOVTraceFPS              int    `json:"fov_trace_fps"`
+	// Classes delivered as datagrams, e.g. "low" or "medium,low".
+	DatagramClasses string `json:"datagram_classes"`
+	// Directory of the client CSVs. The test-client, replay and loadgen
+	// commands create a run directory for each run inside it.
+	OutputDir string `json:"output_dir"`
+	// Also write every request sent to requests-<id>.jsonl in OutputDir,
+	// for the replay command.
+	RecordRequests bool `json:"record_requests"`
+	// Write the qlog of every connection to <command>-<id>-<odcid>.qlog in
+	// OutputDir. The odcid matches the qlog of the server.
+	Qlog bool `json:"qlog"`
+}
+
+// Emulated link (see package netem). Applied to the egress of the server and
+// of each client.
+type NetworkConfig struct {
+	BandwidthMbps float64 `json:"bandwidth_mbps"`
+	BurstBytes    int     `json:"burst_bytes"`
+	DelayMs       float64 `json:"delay_ms"`
+	JitterMs      float64 `json:"jitter_ms"`
+	LossPercent   float64 `json:"loss_percent"`
+	// Gilbert-Elliott loss "p,r[,loss_good,loss_bad]" in %.
+	GilbertElliott string  `json:"gilbert_elliott"`
+	ReorderPercent float64 `json:"reorder_percent"`
+	QueuePackets   int     `json:"queue_packets"`
+	Seed           int64   `json:"seed"`
+	// "timestamp, Mbps" file played in real time instead of bandwidth_mbps.
+	BandwidthTrace     string `json:"bandwidth_trace"`
+	BandwidthTraceLoop bool   `json:"bandwidth_trace_loop"`
+}
+
+type ExperimentConfig struct {
+	// Number of concurrent test clients.
+	Clients int `json:"clients"`
+	// Run directory, which must not exist or be empty. Empty means
+	// runs/<timestamp>-<policy>.
+	OutputDir string `json:"output_dir"`
+}
+
+// Open-loop load generator (loadgen command). Tiles and output directory
+// come from the client section.
+type LoadgenConfig struct {
+	// Arrival process: poisson, fixed or onoff (Poisson during on periods).
+	Arrival string `json:"arrival"`
+	// Requests per second, over all connections (during on periods for onoff).
+	RatePerSec float64 `json:"rate_per_sec"`
+	OnMs       float64 `json:"on_ms"`
+	OffMs      float64 `json:"off_ms"`
+	// Length of the run.
+	DurationS float64 `json:"duration_s"`
+	// QUIC connections the requests are spread over (round robin).
+	Connections int `json:"connections"`
+	// Share of high and medium priority requests; the rest is low.
+	HighShare   float64 `json:"high_share"`
+	MediumShare float64 `json:"medium_share"`
+	// Request deadline: "fixed:<ms>", "uniform:<min>,<max>" or "exp:<mean>".
+	Deadline string `json:"deadline"`
+	// Random seed. 0 uses the current time.
+	Seed int64 `json:"seed"`
+}
+
+// Background cross traffic (experiment and cross-traffic commands).
+type CrossTrafficConfig struct {
+	// udp (constant rate), quic (bulk flows) or empty for none.
+	Mode string `json:"mode"`
+	// Total rate over all flows. Required for udp; 0 leaves quic flows
+	// limited by congestion control only.
+	RateMbps float64 `json:"rate_mbps"`
+	// Parallel flows (UDP sockets or QUIC connections).
+	Flows int `json:"flows"`
+	// On/off pattern. off_ms 0 sends all the time.
+	OnMs  float64 `json:"on_ms"`
+	OffMs float64 `json:"off_ms"`
+	// UDP payload size.
+	PacketBytes int `json:"packet_bytes"`
+	// Port of the sink.
+	Port int `json:"port"`
+	// Length of a cross-traffic command run. 0 runs until interrupted.
+	DurationS float64 `json:"duration_s"`
+}
+
+// Default returns the values previously hard-coded in the commands.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Policy:     "wfq",
+			Address:    "0.0.0.0",
+			Port:       8000,
+			OutputDir:  "/tmp/server_scheduler_test",
+			WFQWeights: WFQWeights{High: 3, Medium: 2, Low: 1},
+			// same interval as the queue_len.csv samples
+			TransportIntervalMs: 100,
+			Feasibility:         "off",
+			MetricsFormat:       "csv",
+		},
+		Client: ClientConfig{
+			ServerURL:                "localhost",
+			Parallelism:              128,
+			BaseLatencyMs:            250,
+			Pipeline:                 false,
+			HighPriorityRatio:        0.0,
+			MediumPriorityRatio:      0.0,
+			TotalTimeSegments:        120,
+			FirstTile:                100,
+			LastTile:                 177,
+			MaxBufferedSegmentsAhead: 3,
+			FOVTracePath:             "data/user_fov.csv",
+			FOVTraceFPS:              30,
+			OutputDir:                "runs",
+		},
+		Experiment: ExperimentConfig{
+			Clients: 1,
+		},
+		Loadgen: LoadgenConfig{
+			Arrival:     "poisson",
+			RatePerSec:  200,
+			OnMs:        1000,
+			OffMs:       1000,
+			DurationS:   30,
+			Connections: 4,
+			HighShare:   0.3,
+			MediumShare: 0,
+			Deadline:    "fixed:1000",
+		},
+		CrossTraffic: CrossTrafficConfig{
+			Flows:       1,
+			OnMs:        1000,
+			PacketBytes: 1200,
+			Port:        8001,
+		},
+	}
+}
+
+// ApplyEnv reads the environment variables the commands used before the
+// config file existed.
+func (c *Config) ApplyEnv() error {
+	if value := os.Getenv("FOV_TRACE_PATH"); value != "" {
+		c.Client.FOVTracePath = value
+	}
+	if value := os.Getenv("FOV_TRACE_FPS"); value != "" {
+		fps, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("FOV_TRACE_FPS: %w", err)
+		}
+		c.Client.FOVTraceFPS = fps
+	}
+	if value := os.Getenv("DATAGRAM_CLASSES"); value != "" {
+		c.Client.DatagramClasses = value
+	}
+
+	link, err := netem.LinkConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	if link.Enabled() {
+		c.Network = NetworkConfig{
+			BandwidthMbps:  link.BandwidthMbps,
+			BurstBytes:     link.BurstBytes,
+			DelayMs:        float64(link.Delay) / float64(time.Millisecond),
+			JitterMs:       float64(link.Jitter) / float64(time.Millisecond),
+			LossPercent:    link.LossPercent,
+			GilbertElliott: os.Getenv("NETEM_GE"),
+			ReorderPercent: link.ReorderPercent,
+			QueuePackets:   link.QueuePackets,
+			Seed:           link.Seed,
+		}
+	}
+	if value := os.Getenv("NETEM_BW_TRACE"); value != "" {
+		c.Network.BandwidthTrace = value
+	}
+	return nil
+}
+
+// LoadFile overwrites the fields present in a JSON file. Unknown fields are
+// an error, so typos do not go unnoticed.
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// WriteFile saves the configuration as indented JSON.
+func (c *Config) WriteFile(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Validate returns the first invalid field.
+func (c *Config) Validate() error {
+	switch c.Server.Policy {
+	case "wfq", "sp", "fifo":
+	default:
+		return fmt.Errorf("server.policy: unknown policy %q (wfq, sp or fifo)", c.Server.Policy)
+	}
+	if c.Server.Port <= 0 || c.Server.Port > 65535 {
+		return fmt.Errorf("server.port: %d out of range", c.Server.Port)
+	}
+	w := c.Server.WFQWeights
+	if w.High <= 0 || w.Medium <= 0 || w.Low <= 0 {
+		return fmt.Errorf("server.wfq_weights: weights must be positive, got %+v", w)
+	}
+	switch c.Server.Feasibility {
+	case "off", "log", "skip":
+	default:
+		return fmt.Errorf("server.feasibility: unknown mode %q (off, log or skip)", c.Server.Feasibility)
+	}
+	if c.Server.TransportIntervalMs < 0 {
+		return fmt.Errorf("server.transport_interval_ms: must not be negative, got %d", c.Server.TransportIntervalMs)
+	}
+
+	cl := c.Client
+	if cl.Parallelism <= 0 {
+		return fmt.Errorf("client.parallelism: must be positive, got %d", cl.Parallelism)
+	}
+	if cl.BaseLatencyMs < 0 {
+		return fmt.Errorf("client.base_latency_ms: must not be negative, got %d", cl.BaseLatencyMs)
+	}
+	if cl.HighPriorityRatio < 0 || cl.MediumPriorityRatio < 0 || cl.HighPriorityRatio+cl.MediumPriorityRatio > 1 {
+		return fmt.Errorf("client: priority ratios must be in [0, 1] and add up to at most 1")
+	}
+	if cl.TotalTimeSegments <= 0 {
+		return fmt.Errorf("client.total_time_segments: must be positive, got %d", cl.TotalTimeSegments)
+	}
+	if cl.FirstTile < 0 || cl.LastTile < cl.FirstTile {
+		return fmt.Errorf("client: invalid tile range %d..%d", cl.FirstTile, cl.LastTile)
+	}
+	if cl.MaxBufferedSegmentsAhead <= 0 {
+		return fmt.Errorf("client.max_buffered_segments_ahead: must be positive, got %d", cl.MaxBufferedSegmentsAhead)
+	}
+	if cl.FOVTraceFPS <= 0 {
+		return fmt.Errorf("client.fov_trace_fps: must be positive, got %d", cl.FOVTraceFPS)
+	}
+	if _, err := model.ParseClassList(cl.DatagramClasses); err != nil {
+		return fmt.Errorf("client.datagram_classes: %w", err)
+	}
+
+	if _, err := c.Network.LinkConfig(); err != nil {
+		return fmt.Errorf("network: %w", err)
+	}
+
+	for i, fault := range c.Server.Faults {
+		if err := fault.validate(); err != nil {
+			return fmt.Errorf("server.faults[%d]: %w", i, err)
+		}
+	}
+	if c.Experiment.Clients <= 0 {
+		return fmt.Errorf("experiment.clients: must be positive, got %d", c.Experiment.Clients)
+	}
+
+	lg := c.Loadgen
+	switch lg.Arrival {
+	case "poisson", "fixed", "onoff":
+	default:
+		return fmt.Errorf("loadgen.arrival: unknown process %q (poisson, fixed or onoff)", lg.Arrival)
+	}
+	if lg.RatePerSec <= 0 || lg.DurationS <= 0 || lg.Connections <= 0 {
+		return fmt.Errorf("loadgen: rate_per_sec, duration_s and connections must be positive")
+	}
+	if lg.Arrival == "onoff" && (lg.OnMs <= 0 || lg.OffMs < 0) {
+		return fmt.Errorf("loadgen: on_ms must be positive and off_ms not negative")
+	}
+	if lg.HighShare < 0 || lg.MediumShare < 0 || lg.HighShare+lg.MediumShare > 1 {
+		return fmt.Errorf("loadgen: shares must be in [0, 1] and add up to at most 1")
+	}
+
+	ct := c.CrossTraffic
+	switch ct.Mode {
+	case "", "udp", "quic":
+	default:
+		return fmt.Errorf("cross_traffic.mode: unknown mode %q (udp, quic or empty)", ct.Mode)
+	}
+	if ct.RateMbps < 0 || ct.Flows <= 0 || ct.OnMs <= 0 || ct.OffMs < 0 || ct.DurationS < 0 {
+		return fmt.Errorf("cross_traffic: flows and on_ms must be positive, rate_mbps, off_ms and duration_s not negative")
+	}
+	if ct.PacketBytes < 64 || ct.PacketBytes > 1400 {
+		return fmt.Errorf("cross_traffic.packet_bytes: must be in [64, 1400], got %d", ct.PacketBytes)
+	}
+	if ct.Port <= 0 || ct.Port > 65535 {
+		return fmt.Errorf("cross_traffic.port: %d out of range", ct.Port)
+	}
+	return nil
+}
+
+func (f FaultConfig) validate() error {
+	if f.Kind == "" {
+		return fmt.Errorf("missing kind")
+	}
+	if f.Probability < 0 || f.Probability > 1 {
+		return fmt.Errorf("probability must be in [0, 1], got %g", f.Probability)
+	}
+	if f.DelayMs < 0 || f.Connection < 0 || f.StartS < 0 || f.EndS < 0 || f.PeriodS < 0 {
+		return fmt.Errorf("negative value in %+v", f)
+	}
+	if f.EndS > 0 && f.EndS <= f.StartS {
+		return fmt.Errorf("end_s must come after start_s")
+	}
+	if f.PeriodS > 0 && (f.StartS >= f.PeriodS || f.EndS > f.PeriodS) {
+		return fmt.Errorf("start_s and end_s must fall within period_s")
+	}
+	if _, err := model.ParseClassList(f.Classes); err != nil {
+		return err
+	}
+	return nil
+}
+
+// LinkConfig converts the network section for netem.NewLink.
+func (n NetworkConfig) LinkConfig() (netem.LinkConfig, error) {
+	cfg := netem.LinkConfig{
+		BandwidthMbps:  n.BandwidthMbps,
+		BurstBytes:     n.BurstBytes,
+		Delay:          time.Duration(n.DelayMs * float64(time.Millisecond)),
+		Jitter:         time.Duration(n.JitterMs * float64(time.Millisecond)),
+		LossPercent:    n.LossPercent,
+		ReorderPercent: n.ReorderPercent,
+		QueuePackets:   n.QueuePackets,
+		Seed:           n.Seed,
+	}
+	if n.BandwidthMbps < 0 || n.DelayMs < 0 || n.JitterMs < 0 || n.QueuePackets < 0 {
+		return cfg, fmt.Errorf("negative value in %+v", n)
+	}
+	if n.LossPercent < 0 || n.LossPercent > 100 || n.ReorderPercent < 0 || n.ReorderPercent > 100 {
+		return cfg, fmt.Errorf("percentages must be in [0, 100]")
+	}
+	if n.GilbertElliott != "" {
+		ge, err := netem.ParseGilbertElliott(n.GilbertElliott)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.GilbertElliott = ge
+	}
+	if n.BandwidthTrace != "" {
+		trace, err := netem.LoadBandwidthTrace(n.BandwidthTrace)
+		if err != nil {
+			return cfg, err
+		}
+		trace.Loop = n.BandwidthTraceLoop
+		cfg.BandwidthTrace = trace
+	}
+	return cfg, nil
+}
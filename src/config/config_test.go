@@ -0,0 +1,117 @@
+package config_test
+
+import (
+	"main/src/config"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests if the defaults are valid.
+func TestDefault_Valid(t *testing.T) {
+	cfg := config.Default()
+	assert.Nil(t, cfg.Validate())
+}
+
+// Tests if flags override the file, which overrides the defaults.
+func TestFlags_OverrideFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{
+		"server": {"policy": "sp", "wfq_weights": {"high": 5}},
+		"client": {"parallelism": 64, "base_latency_ms": 500}
+	}`), 0o644)
+	assert.Nil(t, err)
+
+	flags := config.NewFlags("test")
+	cfg, err := flags.Parse([]string{"-client.parallelism", "32", "-config", path, "-client.pipeline"})
+	assert.Nil(t, err)
+
+	assert.Equal(t, "sp", cfg.Server.Policy)
+	assert.Equal(t, 5, cfg.Server.WFQWeights.High)
+	assert.Equal(t, 2, cfg.Server.WFQWeights.Medium)
+	assert.Equal(t, 32, cfg.Client.Parallelism)
+	assert.Equal(t, 500, cfg.Client.BaseLatencyMs)
+	assert.True(t, cfg.Client.Pipeline)
+}
+
+// Tests if an alias sets the same field.
+func TestFlags_Alias(t *testing.T) {
+	flags := config.NewFlags("test")
+	flags.Alias("policy", "server.policy")
+	cfg, err := flags.Parse([]string{"-policy", "fifo"})
+	assert.Nil(t, err)
+	assert.Equal(t, "fifo", cfg.Server.Policy)
+}
+
+// Tests if unknown fields and invalid values are rejected.
+func TestConfig_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"client": {"paralelism": 64}}`), 0o644))
+	cfg := config.Default()
+	assert.NotNil(t, cfg.LoadFile(path))
+
+	cfg = config.Default()
+	assert.Nil(t, cfg.Set("server.policy", "edf"))
+	assert.NotNil(t, cfg.Validate())
+
+	cfg = config.Default()
+	assert.Nil(t, cfg.Set("network.gilbert_elliott", "5"))
+	assert.NotNil(t, cfg.Validate())
+
+	cfg = config.Default()
+	assert.Nil(t, cfg.Set("server.feasibility", "drop"))
+	assert.NotNil(t, cfg.Validate())
+
+	assert.NotNil(t, cfg.Set("client.parallelism", "many"))
+	assert.NotNil(t, cfg.Set("client.unknown", "1"))
+}
+
+// Tests if Set and Get address nested fields.
+func TestConfig_SetGet(t *testing.T) {
+	cfg := config.Default()
+	assert.Nil(t, cfg.Set("network.delay_ms", "12.5"))
+	assert.Equal(t, 12.5, cfg.Network.DelayMs)
+
+	value, err := cfg.Get("network.delay_ms")
+	assert.Nil(t, err)
+	assert.Equal(t, "12.5", value)
+}
+
+// Tests if the bandwidth trace is loaded into the link configuration.
+func TestNetworkConfig_BandwidthTrace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.csv")
+	assert.Nil(t, os.WriteFile(path, []byte("0,10\n1,5\n"), 0o644))
+
+	cfg := config.Default()
+	cfg.Network.BandwidthTrace = path
+	cfg.Network.BandwidthTraceLoop = true
+	link, err := cfg.Network.LinkConfig()
+	assert.Nil(t, err)
+	assert.True(t, link.Enabled())
+	assert.Equal(t, 2, len(link.BandwidthTrace.Samples))
+	assert.True(t, link.BandwidthTrace.Loop)
+
+	cfg.Network.BandwidthTrace = filepath.Join(t.TempDir(), "missing.csv")
+	assert.NotNil(t, cfg.Validate())
+}
+
+// Tests if the fault list is set from a JSON flag and validated.
+func TestConfig_Faults(t *testing.T) {
+	flags := config.NewFlags("test")
+	cfg, err := flags.Parse([]string{"-server.faults", `[{"kind": "read_error", "probability": 0.2, "classes": "low"}]`})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(cfg.Server.Faults))
+	assert.Equal(t, "read_error", cfg.Server.Faults[0].Kind)
+	assert.Equal(t, 0.2, cfg.Server.Faults[0].Probability)
+
+	value, err := cfg.Get("server.faults")
+	assert.Nil(t, err)
+	assert.Contains(t, value, `"kind":"read_error"`)
+
+	assert.NotNil(t, cfg.Set("server.faults", `[{"kind": "read_error", "chance": 1}]`))
+
+	cfg.Server.Faults[0].Probability = 2
+	assert.NotNil(t, cfg.Validate())
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Flags parses a command line made of -config <file> and one flag per config
+// field, named after its JSON path (-client.parallelism, -server.wfq_weights.high...).
+// Flags override the file whatever their position.
+type Flags struct {
+	set       *flag.FlagSet
+	file      string
+	overrides []override // in command-line order
+}
+
+type override struct {
+	path  string
+	value string
+}
+
+// NewFlags creates the flag set of a command.
+func NewFlags(name string) *Flags {
+	f := &Flags{set: flag.NewFlagSet(name, flag.ContinueOnError)}
+	f.set.StringVar(&f.file, "config", "", "JSON config file")
+	defaults := Default()
+	for _, field := range Fields(&defaults) {
+		f.set.Var(&flagValue{flags: f, field: field}, field.Path, field.Value.Kind().String())
+	}
+	return f
+}
+
+// Alias adds a short flag name for a field, e.g. -policy for -server.policy.
+func (f *Flags) Alias(alias string, path string) {
+	defaults := Default()
+	for _, field := range Fields(&defaults) {
+		if field.Path == path {
+			f.set.Var(&flagValue{flags: f, field: field}, alias, "alias of -"+path)
+			return
+		}
+	}
+	panic("config: no field " + path)
+}
+
+// Parse reads the command line and returns the resulting configuration,
+// already validated.
+func (f *Flags) Parse(args []string) (Config, error) {
+	if err := f.set.Parse(args); err != nil {
+		return Config{}, err
+	}
+	cfg := Default()
+	if err := cfg.ApplyEnv(); err != nil {
+		return cfg, err
+	}
+	if f.file != "" {
+		if err := cfg.LoadFile(f.file); err != nil {
+			return cfg, err
+		}
+	}
+	for _, o := range f.overrides {
+		if err := cfg.Set(o.path, o.value); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+// Args returns the positional arguments left after Parse.
+func (f *Flags) Args() []string {
+	return f.set.Args()
+}
+
+// Field is a leaf of the configuration, addressed by its JSON path.
+type Field struct {
+	Path  string
+	Value reflect.Value
+}
+
+// Fields lists the leaves of cfg. The values can be set through reflection.
+func Fields(cfg *Config) []Field {
+	return appendFields(nil, "", reflect.ValueOf(cfg).Elem())
+}
+
+func appendFields(fields []Field, prefix string, v reflect.Value) []Field {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		path := prefix + name
+		if v.Field(i).Kind() == reflect.Struct {
+			fields = appendFields(fields, path+".", v.Field(i))
+		} else {
+			fields = append(fields, Field{Path: path, Value: v.Field(i)})
+		}
+	}
+	return fields
+}
+
+// Set changes a field given its JSON path and its value as text.
+func (c *Config) Set(path string, value string) error {
+	for _, field := range Fields(c) {
+		if field.Path == path {
+			if err := setValue(field.Value, value); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown config field %q", path)
+}
+
+// Get returns a field as text, given its JSON path.
+func (c *Config) Get(path string) (string, error) {
+	for _, field := range Fields(c) {
+		if field.Path == path {
+			if field.Value.Kind() == reflect.Slice {
+				data, err := json.Marshal(field.Value.Interface())
+				return string(data), err
+			}
+			return fmt.Sprint(field.Value.Interface()), nil
+		}
+	}
+	return "", fmt.Errorf("unknown config field %q", path)
+}
+
+func setValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		x, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(x)
+	case reflect.Slice:
+		// Lists are given as JSON, e.g. -server.faults '[{"kind": "read_error"}]'
+		decoder := json.NewDecoder(strings.NewReader(value))
+		decoder.DisallowUnknownFields()
+		list := reflect.New(v.Type())
+		if err := decoder.Decode(list.Interface()); err != nil {
+			return err
+		}
+		v.Set(list.Elem())
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// flag.Value recording the override; it is applied after the file is loaded.
+type flagValue struct {
+	flags *Flags
+	field Field
+}
+
+func (v *flagValue) String() string {
+	if v == nil || v.flags == nil {
+		return ""
+	}
+	return fmt.Sprint(v.field.Value.Interface())
+}
+
+func (v *flagValue) Set(value string) error {
+	// Type errors are reported by the flag package right away
+	if err := setValue(reflect.New(v.field.Value.Type()).Elem(), value); err != nil {
+		return err
+	}
+	v.flags.overrides = append(v.flags.overrides, override{path: v.field.Path, value: value})
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v != nil && v.field.Value.Kind() == reflect.Bool
+}
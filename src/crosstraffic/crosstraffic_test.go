@@ -0,0 +1,76 @@
+package crosstraffic_test
+
+import (
+	"main/src/config"
+	"main/src/crosstraffic"
+	"main/src/netem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func startSink(t *testing.T, mode string) *crosstraffic.Sink {
+	sink := crosstraffic.NewSink(mode, "127.0.0.1:0")
+	assert.Nil(t, sink.Start())
+	return sink
+}
+
+func run(t *testing.T, cfg config.CrossTrafficConfig, link *netem.Link, duration time.Duration) (sent, received int64) {
+	sink := startSink(t, cfg.Mode)
+	sender := crosstraffic.NewSender(cfg, sink.Addr().String(), link)
+	assert.Nil(t, sender.Start())
+	time.Sleep(duration)
+	sender.Stop()
+	time.Sleep(50 * time.Millisecond)
+	sink.Stop()
+	return sender.SentBytes(), sink.ReceivedBytes()
+}
+
+// Tests if constant-rate UDP achieves its rate
+func TestSender_UDPRate(t *testing.T) {
+	cfg := config.Default().CrossTraffic
+	cfg.Mode = "udp"
+	cfg.RateMbps = 8
+	cfg.Flows = 2
+	sent, received := run(t, cfg, nil, 500*time.Millisecond)
+
+	// 8 Mbps during 0.5 s = 500 kB
+	assert.InDelta(t, 500e3, sent, 50e3)
+	assert.InDelta(t, sent, received, 10e3)
+}
+
+// Tests if nothing is sent during off periods
+func TestSender_OnOff(t *testing.T) {
+	cfg := config.Default().CrossTraffic
+	cfg.Mode = "udp"
+	cfg.RateMbps = 8
+	cfg.OnMs = 100
+	cfg.OffMs = 300
+	sent, _ := run(t, cfg, nil, 800*time.Millisecond)
+
+	// on 200 ms of 800 ms: 200 kB
+	assert.InDelta(t, 200e3, sent, 40e3)
+}
+
+// Tests if bulk QUIC flows are limited by the shared link
+func TestSender_QUICThroughLink(t *testing.T) {
+	link := netem.NewLink(netem.LinkConfig{BandwidthMbps: 16})
+	defer link.Close()
+
+	cfg := config.Default().CrossTraffic
+	cfg.Mode = "quic"
+	cfg.Flows = 2
+	_, received := run(t, cfg, link, time.Second)
+
+	// at most 16 Mbps during 1 s = 2 MB
+	assert.True(t, received > 200e3, "received %d bytes", received)
+	assert.True(t, received < 2.2e6, "received %d bytes", received)
+}
+
+// Tests if UDP without a rate is rejected
+func TestSender_UDPNeedsRate(t *testing.T) {
+	cfg := config.Default().CrossTraffic
+	cfg.Mode = "udp"
+	assert.NotNil(t, crosstraffic.NewSender(cfg, "127.0.0.1:9", nil).Start())
+}
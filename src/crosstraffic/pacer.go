@@ -0,0 +1,66 @@
+package crosstraffic
+
+import (
+	"time"
+)
+
+// Spreads the packets of a flow at a rate, during the on periods of an
+// on/off pattern.
+type pacer struct {
+	bytesPerSec float64 // 0 means no rate limit
+	on, period  time.Duration
+	start       time.Time
+	next        time.Time
+	now         func() time.Time
+}
+
+// Do not sleep for less than this: a short burst is cheaper than a timer.
+const minSleep = time.Millisecond
+
+// Late timers are made up for with bursts of at most this much sending time.
+const maxCatchUp = 5 * time.Millisecond
+
+func newPacer(mbps float64, on, off time.Duration, start time.Time) *pacer {
+	return &pacer{
+		bytesPerSec: mbps * 1e6 / 8,
+		on:          on,
+		period:      on + off,
+		start:       start,
+		next:        start,
+		now:         time.Now,
+	}
+}
+
+// How long to wait before sending size bytes. Call commit after sending.
+func (p *pacer) delay(size int) time.Duration {
+	now := p.now()
+	wait := time.Duration(0)
+	if p.period > p.on {
+		if position := now.Sub(p.start) % p.period; position >= p.on {
+			// silent until the next on period, which starts without backlog
+			wait = p.period - position
+			if p.next.Before(now.Add(wait)) {
+				p.next = now.Add(wait)
+			}
+		}
+	}
+	if p.bytesPerSec > 0 && p.next.Sub(now) > wait {
+		wait = p.next.Sub(now)
+	}
+	if wait < minSleep {
+		return 0
+	}
+	return wait
+}
+
+// Accounts for size bytes sent now.
+func (p *pacer) commit(size int) {
+	if p.bytesPerSec <= 0 {
+		return
+	}
+	now := p.now()
+	if earliest := now.Add(-maxCatchUp); p.next.Before(earliest) {
+		p.next = earliest
+	}
+	p.next = p.next.Add(time.Duration(float64(size) / p.bytesPerSec * float64(time.Second)))
+}
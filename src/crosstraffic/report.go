@@ -0,0 +1,118 @@
+package crosstraffic
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reporter samples the bytes sent by a Sender and received by a Sink (either
+// may be nil) and writes the achieved rates to a CSV, one row per interval:
+// time_s,sent_mbps,received_mbps. Missing sides are left empty.
+type Reporter struct {
+	sender *Sender
+	sink   *Sink
+	file   *os.File
+	writer *bufio.Writer
+	start  time.Time
+
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// Rows logged besides the CSV: one every logEvery intervals.
+const logEvery = 5
+
+func StartReporter(path string, interval time.Duration, sender *Sender, sink *Sink) (*Reporter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reporter{
+		sender: sender,
+		sink:   sink,
+		file:   file,
+		writer: bufio.NewWriter(file),
+		start:  time.Now(),
+		done:   make(chan struct{}),
+	}
+	r.writer.WriteString("time_s,sent_mbps,received_mbps\n")
+
+	r.wg.Add(1)
+	go r.run(interval)
+	return r, nil
+}
+
+func (r *Reporter) run(interval time.Duration) {
+	defer r.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastSent, lastReceived := r.counts()
+	last := r.start
+	for n := 1; ; n++ {
+		select {
+		case <-r.done:
+			return
+		case now := <-ticker.C:
+			sent, received := r.counts()
+			seconds := now.Sub(last).Seconds()
+			sentMbps := r.rate(r.sender != nil, sent-lastSent, seconds)
+			receivedMbps := r.rate(r.sink != nil, received-lastReceived, seconds)
+			fmt.Fprintf(r.writer, "%.3f,%s,%s\n", now.Sub(r.start).Seconds(), sentMbps, receivedMbps)
+			if n%logEvery == 0 {
+				log.Printf("[XTRAFFIC]%s", r.describe(sentMbps, receivedMbps))
+			}
+			lastSent, lastReceived, last = sent, received, now
+		}
+	}
+}
+
+func (r *Reporter) counts() (sent, received int64) {
+	if r.sender != nil {
+		sent = r.sender.SentBytes()
+	}
+	if r.sink != nil {
+		received = r.sink.ReceivedBytes()
+	}
+	return
+}
+
+func (r *Reporter) rate(present bool, bytes int64, seconds float64) string {
+	if !present || seconds <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.3f", float64(bytes)*8/1e6/seconds)
+}
+
+func (r *Reporter) describe(sentMbps, receivedMbps string) string {
+	text := ""
+	if r.sender != nil {
+		text += fmt.Sprintf(" sent=%s Mbps", sentMbps)
+	}
+	if r.sink != nil {
+		text += fmt.Sprintf(" received=%s Mbps", receivedMbps)
+	}
+	return text
+}
+
+// Stop writes the last rows, logs the average rates and closes the CSV.
+func (r *Reporter) Stop() error {
+	r.stopOnce.Do(func() { close(r.done) })
+	r.wg.Wait()
+
+	sent, received := r.counts()
+	seconds := time.Since(r.start).Seconds()
+	log.Printf("[XTRAFFIC] achieved over %.1f s:%s", seconds,
+		r.describe(r.rate(r.sender != nil, sent, seconds), r.rate(r.sink != nil, received, seconds)))
+
+	if err := r.writer.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
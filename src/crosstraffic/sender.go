@@ -0,0 +1,205 @@
+// Package crosstraffic generates background traffic that competes with the
+// tile stream for the link: constant-rate UDP or bulk QUIC flows, optionally
+// in on/off periods. A Sender sends to a Sink, which counts what arrives.
+//
+// In an experiment the sender shares the server's emulated link; on real
+// links the "cross-traffic" command runs each side, replacing iperf.
+package crosstraffic
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"main/src/config"
+	"main/src/netem"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// Application protocol of the QUIC flows.
+const nextProto = "cross-traffic"
+
+// Size of the writes of the QUIC flows.
+const quicChunkBytes = 16 * 1024
+
+type Sender struct {
+	cfg    config.CrossTrafficConfig
+	target string
+	link   *netem.Link
+
+	sentBytes atomic.Int64
+	done      chan struct{}
+	stopOnce  sync.Once
+	wg        sync.WaitGroup
+
+	mu      sync.Mutex
+	closers []func()
+}
+
+// NewSender creates a sender to the sink at target ("host:port"). With a
+// link, every packet goes through it, competing with the other users of the
+// link.
+func NewSender(cfg config.CrossTrafficConfig, target string, link *netem.Link) *Sender {
+	return &Sender{
+		cfg:    cfg,
+		target: target,
+		link:   link,
+		done:   make(chan struct{}),
+	}
+}
+
+// Start opens the flows and sends until Stop.
+func (s *Sender) Start() error {
+	if s.cfg.Mode == "udp" && s.cfg.RateMbps <= 0 {
+		return fmt.Errorf("udp cross traffic needs a rate (cross_traffic.rate_mbps)")
+	}
+	remoteAddr, err := net.ResolveUDPAddr("udp", s.target)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	on := time.Duration(s.cfg.OnMs * float64(time.Millisecond))
+	off := time.Duration(s.cfg.OffMs * float64(time.Millisecond))
+	flowMbps := s.cfg.RateMbps / float64(s.cfg.Flows)
+
+	for i := 0; i < s.cfg.Flows; i++ {
+		udpConn, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			s.Stop()
+			return err
+		}
+		var pc net.PacketConn = udpConn
+		if s.link != nil {
+			pc = s.link.Wrap(udpConn)
+		}
+		s.onStop(func() { udpConn.Close() })
+
+		p := newPacer(flowMbps, on, off, start)
+		s.wg.Add(1)
+		switch s.cfg.Mode {
+		case "udp":
+			go s.sendUDP(pc, remoteAddr, p)
+		case "quic":
+			go s.sendQUIC(pc, remoteAddr, p)
+		default:
+			s.wg.Done()
+			s.Stop()
+			return fmt.Errorf("unknown cross traffic mode %q", s.cfg.Mode)
+		}
+	}
+	log.Printf("[XTRAFFIC] sending %s to %s: %d flows, %.1f Mbps (0 = unlimited), on %.0f ms / off %.0f ms",
+		s.cfg.Mode, s.target, s.cfg.Flows, s.cfg.RateMbps, s.cfg.OnMs, s.cfg.OffMs)
+	return nil
+}
+
+// Stop closes the flows and waits for them.
+func (s *Sender) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.done)
+		s.mu.Lock()
+		// connections before their sockets
+		for i := len(s.closers) - 1; i >= 0; i-- {
+			s.closers[i]()
+		}
+		s.mu.Unlock()
+	})
+	s.wg.Wait()
+}
+
+// Bytes sent so far (UDP payloads or QUIC stream data).
+func (s *Sender) SentBytes() int64 {
+	return s.sentBytes.Load()
+}
+
+func (s *Sender) onStop(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closers = append(s.closers, fn)
+}
+
+// Waits for the pacer. Returns false when stopped.
+func (s *Sender) pace(p *pacer, size int) bool {
+	for {
+		wait := p.delay(size)
+		if wait == 0 {
+			return true
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.done:
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *Sender) sendUDP(pc net.PacketConn, addr net.Addr, p *pacer) {
+	defer s.wg.Done()
+	payload := make([]byte, s.cfg.PacketBytes)
+	for s.pace(p, len(payload)) {
+		if _, err := pc.WriteTo(payload, addr); err != nil {
+			select {
+			case <-s.done:
+			default:
+				log.Printf("[XTRAFFIC] udp send: %v", err)
+			}
+			return
+		}
+		p.commit(len(payload))
+		s.sentBytes.Add(int64(len(payload)))
+	}
+}
+
+func (s *Sender) sendQUIC(pc net.PacketConn, addr net.Addr, p *pacer) {
+	defer s.wg.Done()
+	tlsConf := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{nextProto}}
+
+	// The sink may not be up yet: retry until it is
+	var connection quic.Connection
+	for connection == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		c, err := quic.DialContext(ctx, pc, addr, s.target, tlsConf, &quic.Config{})
+		cancel()
+		if err == nil {
+			connection = c
+			break
+		}
+		log.Printf("[XTRAFFIC] quic dial: %v (retrying)", err)
+		select {
+		case <-s.done:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+	closeConnection := func() { connection.CloseWithError(0, "cross traffic finished") }
+	s.onStop(closeConnection)
+	select {
+	case <-s.done:
+		// Stop may have run before onStop
+		closeConnection()
+		return
+	default:
+	}
+
+	stream, err := connection.OpenStreamSync(context.Background())
+	if err != nil {
+		log.Printf("[XTRAFFIC] quic stream: %v", err)
+		return
+	}
+	chunk := make([]byte, quicChunkBytes)
+	for s.pace(p, len(chunk)) {
+		n, err := stream.Write(chunk)
+		s.sentBytes.Add(int64(n))
+		if err != nil {
+			return
+		}
+		p.commit(n)
+	}
+}
@@ -0,0 +1,163 @@
+package crosstraffic
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// Sink receives cross traffic and discards it, counting the bytes.
+type Sink struct {
+	mode string
+	addr string
+
+	receivedBytes atomic.Int64
+	udpConn       net.PacketConn
+	listener      quic.Listener
+	done          chan struct{}
+	wg            sync.WaitGroup
+}
+
+// NewSink creates a sink for the given mode (udp or quic) listening on addr
+// ("host:port").
+func NewSink(mode string, addr string) *Sink {
+	return &Sink{mode: mode, addr: addr, done: make(chan struct{})}
+}
+
+// Start listens and receives until Stop.
+func (s *Sink) Start() error {
+	switch s.mode {
+	case "udp":
+		conn, err := net.ListenPacket("udp", s.addr)
+		if err != nil {
+			return err
+		}
+		s.udpConn = conn
+		s.wg.Add(1)
+		go s.receiveUDP()
+	case "quic":
+		tlsConf, err := generateTLSConfig()
+		if err != nil {
+			return err
+		}
+		listener, err := quic.ListenAddr(s.addr, tlsConf, &quic.Config{})
+		if err != nil {
+			return err
+		}
+		s.listener = listener
+		s.wg.Add(1)
+		go s.acceptQUIC()
+	default:
+		return fmt.Errorf("unknown cross traffic mode %q", s.mode)
+	}
+	log.Printf("[XTRAFFIC] %s sink listening on %s", s.mode, s.addr)
+	return nil
+}
+
+// Stop closes the socket and waits for the receivers.
+func (s *Sink) Stop() {
+	close(s.done)
+	if s.udpConn != nil {
+		s.udpConn.Close()
+	}
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.wg.Wait()
+}
+
+// Address the sink listens on, once started.
+func (s *Sink) Addr() net.Addr {
+	if s.listener != nil {
+		return s.listener.Addr()
+	}
+	if s.udpConn != nil {
+		return s.udpConn.LocalAddr()
+	}
+	return nil
+}
+
+// Bytes received so far.
+func (s *Sink) ReceivedBytes() int64 {
+	return s.receivedBytes.Load()
+}
+
+func (s *Sink) receiveUDP() {
+	defer s.wg.Done()
+	buffer := make([]byte, 64*1024)
+	for {
+		n, _, err := s.udpConn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		s.receivedBytes.Add(int64(n))
+	}
+}
+
+func (s *Sink) acceptQUIC() {
+	defer s.wg.Done()
+	for {
+		connection, err := s.listener.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			// The listener does not close its connections
+			go func() {
+				<-s.done
+				connection.CloseWithError(0, "sink stopped")
+			}()
+			for {
+				stream, err := connection.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+				s.wg.Add(1)
+				go func() {
+					defer s.wg.Done()
+					io.Copy(countingDiscard{&s.receivedBytes}, stream)
+				}()
+			}
+		}()
+	}
+}
+
+type countingDiscard struct {
+	n *atomic.Int64
+}
+
+func (c countingDiscard) Write(p []byte) (int, error) {
+	c.n.Add(int64(len(p)))
+	return len(p), nil
+}
+
+// Self-signed certificate for the QUIC sink.
+func generateTLSConfig() (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := x509.Certificate{SerialNumber: big.NewInt(1)}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{certDER}, PrivateKey: key}},
+		NextProtos:   []string{nextProto},
+	}, nil
+}
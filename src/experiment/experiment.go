@@ -0,0 +1,139 @@
+// Package experiment runs the server and the test clients in one process,
+// over loopback, and collects every output in a single run directory.
+package experiment
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"main/src/config"
+	"main/src/crosstraffic"
+	"main/src/runinfo"
+	"main/src/server"
+	"main/src/test_client"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Run starts the server, runs the clients to completion and stops the server.
+// The server CSVs go to <run dir>/server, the client CSVs to <run dir>/client,
+// the log to <run dir>/experiment.log and the effective configuration to
+// <run dir>/config.json, and <run dir>/manifest.json describes the run. The
+// run directory must not exist or be empty. The output directories and the
+// server address in cfg are ignored. Returns the run directory.
+func Run(cfg config.Config) (runDir string, err error) {
+	runDir = cfg.Experiment.OutputDir
+	if runDir == "" {
+		runDir = runinfo.NewDir("runs", cfg.Server.Policy)
+	}
+	serverDir := filepath.Join(runDir, "server")
+	clientDir := filepath.Join(runDir, "client")
+	cfg.Experiment.OutputDir = runDir
+	cfg.Server.Address = "127.0.0.1"
+	cfg.Server.OutputDir = serverDir
+	cfg.Client.ServerURL = "127.0.0.1"
+	cfg.Client.OutputDir = clientDir
+	run, err := runinfo.Start(runDir, "experiment", cfg)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if finishErr := run.Finish(err); finishErr != nil && err == nil {
+			err = finishErr
+		}
+	}()
+	for _, dir := range []string{serverDir, clientDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return runDir, err
+		}
+	}
+	if err := cfg.WriteFile(filepath.Join(runDir, "config.json")); err != nil {
+		return runDir, err
+	}
+
+	logFile, err := os.Create(filepath.Join(runDir, "experiment.log"))
+	if err != nil {
+		return runDir, err
+	}
+	defer logFile.Close()
+	log.SetOutput(io.MultiWriter(os.Stdout, logFile))
+	defer log.SetOutput(os.Stdout)
+
+	log.Printf("Experiment: policy=%s clients=%d parallelism=%d baseLatency=%d dir=%s",
+		cfg.Server.Policy, cfg.Experiment.Clients, cfg.Client.Parallelism, cfg.Client.BaseLatencyMs, runDir)
+
+	srv, err := server.NewServerFromConfig(cfg)
+	if err != nil {
+		return runDir, err
+	}
+	if err := srv.Listen(); err != nil {
+		return runDir, err
+	}
+	go srv.Serve()
+
+	stopCrossTraffic := func() {}
+	if cfg.CrossTraffic.Mode != "" {
+		if stopCrossTraffic, err = startCrossTraffic(cfg.CrossTraffic, srv, runDir); err != nil {
+			srv.Stop()
+			return runDir, fmt.Errorf("cross traffic: %w", err)
+		}
+	}
+
+	start := time.Now()
+	errs := make([]error, cfg.Experiment.Clients)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Experiment.Clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = test_client.RunTestClient(test_client.TestClientOptions{
+				ClientConfig: cfg.Client,
+				ServerPort:   cfg.Server.Port,
+				Network:      cfg.Network,
+				RunID:        fmt.Sprintf("client%d", i),
+			})
+		}(i)
+	}
+	wg.Wait()
+	stopCrossTraffic()
+	srv.Stop()
+
+	for i, err := range errs {
+		if err != nil {
+			return runDir, fmt.Errorf("client %d: %w", i, err)
+		}
+	}
+	log.Printf("Experiment finished in %v, outputs in %s", time.Since(start).Round(time.Millisecond), runDir)
+	return runDir, nil
+}
+
+// Sends cross traffic from the server side, through the server's emulated
+// link when there is one, to a sink on loopback. The achieved rates go to
+// <run dir>/cross_traffic.csv.
+func startCrossTraffic(cfg config.CrossTrafficConfig, srv *server.Server, runDir string) (stop func(), err error) {
+	addr := fmt.Sprintf("127.0.0.1:%d", cfg.Port)
+	sink := crosstraffic.NewSink(cfg.Mode, addr)
+	if err := sink.Start(); err != nil {
+		return nil, err
+	}
+	sender := crosstraffic.NewSender(cfg, addr, srv.Link())
+	if err := sender.Start(); err != nil {
+		sink.Stop()
+		return nil, err
+	}
+	reporter, err := crosstraffic.StartReporter(filepath.Join(runDir, "cross_traffic.csv"), time.Second, sender, sink)
+	if err != nil {
+		sender.Stop()
+		sink.Stop()
+		return nil, err
+	}
+	return func() {
+		sender.Stop()
+		sink.Stop()
+		if err := reporter.Stop(); err != nil {
+			log.Printf("[XTRAFFIC] %v", err)
+		}
+	}, nil
+}
@@ -0,0 +1,113 @@
+package experiment_test
+
+import (
+	"encoding/csv"
+	"main/src/config"
+	"main/src/experiment"
+	"main/src/runinfo"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests if a loopback run with one client writes the run directory layout,
+// lists the outputs in the manifest and fills the server and client CSVs.
+func TestRun_Loopback(t *testing.T) {
+	// the server reads the tiles and the client the FOV trace relative to the
+	// working directory, as when the binary runs from the repository root
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(filepath.Join("..", "..")))
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	port := udp.LocalAddr().(*net.UDPAddr).Port
+	udp.Close()
+
+	cfg := config.Default()
+	cfg.Server.Port = port
+	cfg.Experiment.Clients = 1
+	cfg.Experiment.OutputDir = filepath.Join(t.TempDir(), "run1")
+	cfg.Client.TotalTimeSegments = 2
+	cfg.Client.FirstTile = 100
+	cfg.Client.LastTile = 109
+
+	runDir, err := experiment.Run(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, cfg.Experiment.OutputDir, runDir)
+
+	for _, dir := range []string{"server", "client"} {
+		info, err := os.Stat(filepath.Join(runDir, dir))
+		if assert.Nil(t, err) {
+			assert.True(t, info.IsDir())
+		}
+	}
+	for _, name := range []string{"config.json", "experiment.log", runinfo.ManifestName} {
+		_, err := os.Stat(filepath.Join(runDir, name))
+		assert.Nil(t, err, name)
+	}
+
+	manifest, err := runinfo.ReadManifest(runDir)
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", manifest.Status)
+	assert.Equal(t, "experiment", manifest.Command)
+	files := map[string]int64{}
+	for _, file := range manifest.Files {
+		files[file.Path] = file.Bytes
+	}
+	assert.NotContains(t, files, runinfo.ManifestName)
+	for _, name := range []string{"config.json", "experiment.log", "server/reqlog.csv", "server/server_summary.csv", "client/statistics-client0.csv"} {
+		assert.Contains(t, files, name)
+	}
+
+	// a header and at least one row
+	for _, name := range []string{"server/reqlog.csv", "server/server_summary.csv", "client/statistics-client0.csv"} {
+		data, err := os.ReadFile(filepath.Join(runDir, filepath.FromSlash(name)))
+		assert.Nil(t, err, name)
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		assert.GreaterOrEqual(t, len(lines), 2, name)
+		assert.Equal(t, int64(len(data)), files[name], name)
+	}
+
+	// both sides log the segment and the tile in their own columns, and the
+	// server finds the tile files (track<tile>_<segment>)
+	for _, check := range []struct {
+		name                string
+		segment, tile, size int
+	}{
+		{"server/reqlog.csv", 3, 4, 5},
+		{"client/statistics-client0.csv", 1, 2, -1},
+	} {
+		rows := readCSV(t, filepath.Join(runDir, filepath.FromSlash(check.name)))
+		for _, row := range rows[1:] {
+			segment, _ := strconv.Atoi(row[check.segment])
+			tile, _ := strconv.Atoi(row[check.tile])
+			assert.True(t, segment >= 1 && segment <= cfg.Client.TotalTimeSegments, "%s: segment %d", check.name, segment)
+			assert.True(t, tile >= cfg.Client.FirstTile && tile <= cfg.Client.LastTile, "%s: tile %d", check.name, tile)
+			if check.size >= 0 && row[1] == "complete" {
+				assert.NotEqual(t, "0", row[check.size], "%s: empty tile %d_%d", check.name, tile, segment)
+			}
+		}
+	}
+}
+
+func readCSV(t *testing.T, path string) [][]string {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
@@ -0,0 +1,195 @@
+// Package loadgen is an open-loop load generator: requests are sent when the
+// arrival process says so, whether or not earlier requests were answered,
+// unlike the closed-loop test client.
+package loadgen
+
+import (
+	"fmt"
+	"log"
+	"main/src/config"
+	"main/src/model"
+	"main/src/netem"
+	"main/src/test_client"
+	"main/src/workload"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Options of a load generator run.
+type Options struct {
+	Config config.Config
+	// Identifies the output files (defaults to the process ID).
+	RunID string
+}
+
+// Run sends requests to the server for cfg.Loadgen.DurationS seconds and
+// waits for the outstanding ones. The report is written to
+// loadgen-<RunID>.csv in the client output directory.
+func Run(opts Options) (Report, error) {
+	cfg := opts.Config
+	lg := cfg.Loadgen
+
+	deadline, err := ParseDeadline(lg.Deadline)
+	if err != nil {
+		return Report{}, err
+	}
+	seed := lg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	arrivals, err := NewArrivals(lg.Arrival, lg.RatePerSec,
+		time.Duration(lg.OnMs*float64(time.Millisecond)), time.Duration(lg.OffMs*float64(time.Millisecond)), rng)
+	if err != nil {
+		return Report{}, err
+	}
+	mix := ClassMix{High: lg.HighShare, Medium: lg.MediumShare}
+
+	runID := opts.RunID
+	if runID == "" {
+		runID = strconv.Itoa(os.Getpid())
+	}
+	outputDir := cfg.Client.OutputDir
+	if outputDir != "" {
+		if err := os.MkdirAll(outputDir, 0o755); err != nil {
+			return Report{}, err
+		}
+	}
+
+	datagramClasses, err := model.ParseClassList(cfg.Client.DatagramClasses)
+	if err != nil {
+		return Report{}, fmt.Errorf("datagram classes: %w", err)
+	}
+	linkConfig, err := cfg.Network.LinkConfig()
+	if err != nil {
+		return Report{}, fmt.Errorf("link emulation: %w", err)
+	}
+
+	clients := make([]*test_client.Client, lg.Connections)
+	for i := range clients {
+		var link *netem.Link
+		if linkConfig.Enabled() {
+			link = netem.NewLink(linkConfig)
+			defer link.Close()
+		}
+		clients[i] = test_client.NewClient(test_client.ClientOptions{
+			Pipeline:        cfg.Client.Pipeline,
+			ServerURL:       cfg.Client.ServerURL,
+			ServerPort:      cfg.Server.Port,
+			DatagramClasses: datagramClasses,
+			Link:            link,
+			Tracer:          test_client.QlogTracer(cfg.Client, fmt.Sprintf("loadgen-%s-%d", runID, i)),
+		})
+		if err := clients[i].Connect(); err != nil {
+			return Report{}, fmt.Errorf("connection %d: %w", i, err)
+		}
+		defer clients[i].Close()
+	}
+
+	var recorder *workload.Recorder
+	if cfg.Client.RecordRequests {
+		recorder, err = workload.NewRecorder(filepath.Join(outputDir, fmt.Sprintf("requests-%s.jsonl", runID)))
+		if err != nil {
+			return Report{}, err
+		}
+		defer recorder.Close()
+	}
+
+	// Each connection walks through all (segment, tile) pairs in turn, so two
+	// outstanding requests on one connection never ask for the same tile.
+	tiles := tileCycle(cfg.Client.TotalTimeSegments, cfg.Client.FirstTile, cfg.Client.LastTile)
+	if len(tiles) == 0 {
+		return Report{}, fmt.Errorf("no tiles to request")
+	}
+	cursors := make([]int, len(clients))
+
+	duration := time.Duration(lg.DurationS * float64(time.Second))
+	log.Printf("[LOADGEN] %s arrivals at %.1f req/s over %d connections for %v, deadline %s",
+		lg.Arrival, lg.RatePerSec, len(clients), duration, lg.Deadline)
+
+	var mutex sync.Mutex
+	var outcomes []outcome
+	var wg sync.WaitGroup
+
+	startTime := time.Now()
+	for n := 0; ; n++ {
+		at := arrivals.Next()
+		if at >= duration {
+			break
+		}
+		time.Sleep(time.Until(startTime.Add(at)))
+
+		conn := n % len(clients)
+		tile := tiles[cursors[conn]]
+		cursors[conn] = (cursors[conn] + 1) % len(tiles)
+		priority := mix.Draw(rng)
+		timeout := deadline(rng)
+
+		request := model.VideoPacketRequest{
+			ID:       uuid.Must(uuid.NewRandom()),
+			Priority: priority,
+			Bitrate:  model.LOW_BITRATE,
+			Segment:  tile.segment,
+			Tile:     tile.tile,
+			Timeout:  int(timeout / time.Millisecond),
+		}
+		if request.Timeout <= 0 {
+			request.Timeout = 1
+		}
+		if datagramClasses[priority] {
+			request.Delivery = model.DATAGRAM_DELIVERY
+		}
+		recorder.Record(workload.NewRecord(time.Since(startTime), request, false))
+
+		wg.Add(1)
+		go func(client *test_client.Client, request model.VideoPacketRequest, timeout time.Duration) {
+			defer wg.Done()
+			sent := time.Now()
+			response, _ := client.Request(request, timeout)
+			o := outcome{class: request.Priority, latency: time.Since(sent)}
+			if response != nil {
+				o.carried = true
+				o.bytes = len(response.Data)
+			}
+			mutex.Lock()
+			outcomes = append(outcomes, o)
+			mutex.Unlock()
+		}(clients[conn], request, timeout)
+	}
+	wg.Wait()
+
+	report := newReport(outcomes, duration)
+	for _, c := range report.Classes {
+		if c.Offered == 0 {
+			continue
+		}
+		log.Printf("[LOADGEN] %-6s offered=%.1f req/s carried=%.1f req/s (%.1f kbps) drop=%.2f%% p50=%v p95=%v p99=%v",
+			c.Class, float64(c.Offered)/duration.Seconds(), float64(c.Carried)/duration.Seconds(),
+			float64(c.CarriedBytes)*8/1000/duration.Seconds(), 100*c.DropRate(),
+			c.P50.Round(time.Millisecond), c.P95.Round(time.Millisecond), c.P99.Round(time.Millisecond))
+	}
+	if err := report.WriteCSV(filepath.Join(outputDir, fmt.Sprintf("loadgen-%s.csv", runID))); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+type segmentTile struct {
+	segment, tile int
+}
+
+func tileCycle(segments, firstTile, lastTile int) []segmentTile {
+	var tiles []segmentTile
+	for segment := 1; segment <= segments; segment++ {
+		for tile := firstTile; tile <= lastTile; tile++ {
+			tiles = append(tiles, segmentTile{segment, tile})
+		}
+	}
+	return tiles
+}
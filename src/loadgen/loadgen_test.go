@@ -0,0 +1,109 @@
+package loadgen_test
+
+import (
+	"main/src/loadgen"
+	"main/src/model"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests if the fixed process sends at exact intervals
+func TestFixedArrivals(t *testing.T) {
+	arrivals, err := loadgen.NewArrivals("fixed", 100, 0, 0, rand.New(rand.NewSource(1)))
+	assert.Nil(t, err)
+	assert.Equal(t, 10*time.Millisecond, arrivals.Next())
+	assert.Equal(t, 20*time.Millisecond, arrivals.Next())
+}
+
+// Tests if the Poisson process has the configured mean rate
+func TestPoissonArrivalsRate(t *testing.T) {
+	arrivals, err := loadgen.NewArrivals("poisson", 1000, 0, 0, rand.New(rand.NewSource(1)))
+	assert.Nil(t, err)
+	n := 0
+	for arrivals.Next() < 10*time.Second {
+		n++
+	}
+	assert.InDelta(t, 10000, n, 300)
+}
+
+// Tests if the on/off process sends nothing during off periods
+func TestOnOffArrivals(t *testing.T) {
+	arrivals, err := loadgen.NewArrivals("onoff", 1000, 100*time.Millisecond, 300*time.Millisecond, rand.New(rand.NewSource(1)))
+	assert.Nil(t, err)
+	n := 0
+	for at := arrivals.Next(); at < 10*time.Second; at = arrivals.Next() {
+		assert.True(t, at%(400*time.Millisecond) < 100*time.Millisecond, "arrival at %v", at)
+		n++
+	}
+	// on a quarter of the time
+	assert.InDelta(t, 2500, n, 200)
+}
+
+// Tests if unknown processes and invalid rates are rejected
+func TestNewArrivalsInvalid(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	_, err := loadgen.NewArrivals("bursty", 10, 0, 0, rng)
+	assert.NotNil(t, err)
+	_, err = loadgen.NewArrivals("poisson", 0, 0, 0, rng)
+	assert.NotNil(t, err)
+	_, err = loadgen.NewArrivals("onoff", 10, 0, time.Second, rng)
+	assert.NotNil(t, err)
+}
+
+// Tests the deadline distributions
+func TestParseDeadline(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	fixed, err := loadgen.ParseDeadline("fixed:250")
+	assert.Nil(t, err)
+	assert.Equal(t, 250*time.Millisecond, fixed(rng))
+
+	uniform, err := loadgen.ParseDeadline("uniform:100,200")
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		d := uniform(rng)
+		assert.True(t, d >= 100*time.Millisecond && d <= 200*time.Millisecond, "deadline %v", d)
+	}
+
+	exp, err := loadgen.ParseDeadline("exp:100")
+	assert.Nil(t, err)
+	var sum time.Duration
+	for i := 0; i < 10000; i++ {
+		sum += exp(rng)
+	}
+	assert.InDelta(t, 100, float64(sum/10000)/float64(time.Millisecond), 5)
+
+	for _, spec := range []string{"", "fixed", "fixed:-1", "uniform:200,100", "uniform:100", "normal:100"} {
+		_, err := loadgen.ParseDeadline(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+// Tests if the class mix follows the configured shares
+func TestClassMix(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	mix := loadgen.ClassMix{High: 0.2, Medium: 0.3}
+	var counts [model.PRIORITY_LEVEL_COUNT]int
+	for i := 0; i < 10000; i++ {
+		counts[mix.Draw(rng)]++
+	}
+	assert.InDelta(t, 2000, counts[model.HIGH_PRIORITY], 200)
+	assert.InDelta(t, 3000, counts[model.MEDIUM_PRIORITY], 200)
+	assert.InDelta(t, 5000, counts[model.LOW_PRIORITY], 200)
+}
+
+// Tests the nearest-rank percentile
+func TestPercentile(t *testing.T) {
+	var values []time.Duration
+	for i := 1; i <= 100; i++ {
+		values = append(values, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 50*time.Millisecond, loadgen.Percentile(values, 50))
+	assert.Equal(t, 99*time.Millisecond, loadgen.Percentile(values, 99))
+	assert.Equal(t, 100*time.Millisecond, loadgen.Percentile(values, 100))
+	assert.Equal(t, 1*time.Millisecond, loadgen.Percentile(values, 0))
+	assert.Equal(t, time.Duration(0), loadgen.Percentile(nil, 50))
+}
@@ -0,0 +1,126 @@
+package loadgen
+
+import (
+	"fmt"
+	"main/src/model"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Arrivals generates the send times of an arrival process.
+type Arrivals interface {
+	// Next returns the time of the next arrival since the start.
+	Next() time.Duration
+}
+
+// NewArrivals creates an arrival process: "poisson" or "fixed" at rate
+// requests per second, or "onoff": Poisson at rate during on periods,
+// nothing during off periods.
+func NewArrivals(process string, rate float64, on, off time.Duration, rng *rand.Rand) (Arrivals, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("rate must be positive")
+	}
+	switch process {
+	case "poisson":
+		return &poissonArrivals{rate: rate, rng: rng}, nil
+	case "fixed":
+		return &fixedArrivals{gap: time.Duration(float64(time.Second) / rate)}, nil
+	case "onoff":
+		if on <= 0 || off < 0 {
+			return nil, fmt.Errorf("on period must be positive and off period not negative")
+		}
+		return &onOffArrivals{poissonArrivals: poissonArrivals{rate: rate, rng: rng}, on: on, period: on + off}, nil
+	}
+	return nil, fmt.Errorf("unknown arrival process %q", process)
+}
+
+type poissonArrivals struct {
+	rate float64
+	rng  *rand.Rand
+	t    time.Duration
+}
+
+func (a *poissonArrivals) Next() time.Duration {
+	a.t += time.Duration(a.rng.ExpFloat64() / a.rate * float64(time.Second))
+	return a.t
+}
+
+type fixedArrivals struct {
+	gap time.Duration
+	t   time.Duration
+}
+
+func (a *fixedArrivals) Next() time.Duration {
+	a.t += a.gap
+	return a.t
+}
+
+type onOffArrivals struct {
+	poissonArrivals
+	on     time.Duration
+	period time.Duration
+}
+
+// Arrivals falling in an off period move to the start of the next on period.
+// The process is memoryless, so this is the same as pausing it.
+func (a *onOffArrivals) Next() time.Duration {
+	t := a.poissonArrivals.Next()
+	if position := t % a.period; position >= a.on {
+		t += a.period - position
+		a.t = t
+	}
+	return t
+}
+
+// Deadline draws request deadlines.
+type Deadline func(rng *rand.Rand) time.Duration
+
+// ParseDeadline parses "fixed:<ms>", "uniform:<min>,<max>" or "exp:<mean>".
+func ParseDeadline(spec string) (Deadline, error) {
+	kind, args, _ := strings.Cut(spec, ":")
+	var values []float64
+	for _, field := range strings.Split(args, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("deadline %q: invalid value %q", spec, field)
+		}
+		values = append(values, v)
+	}
+	ms := func(v float64) time.Duration { return time.Duration(v * float64(time.Millisecond)) }
+
+	switch {
+	case kind == "fixed" && len(values) == 1:
+		d := ms(values[0])
+		return func(*rand.Rand) time.Duration { return d }, nil
+	case kind == "uniform" && len(values) == 2 && values[0] <= values[1]:
+		min, max := values[0], values[1]
+		return func(rng *rand.Rand) time.Duration { return ms(min + rng.Float64()*(max-min)) }, nil
+	case kind == "exp" && len(values) == 1:
+		mean := values[0]
+		return func(rng *rand.Rand) time.Duration {
+			// at least 1 ms, the resolution of the request timeout
+			return ms(math.Max(1, rng.ExpFloat64()*mean))
+		}, nil
+	}
+	return nil, fmt.Errorf("deadline %q: expected fixed:<ms>, uniform:<min>,<max> or exp:<mean>", spec)
+}
+
+// ClassMix draws the class of each request.
+type ClassMix struct {
+	High, Medium float64 // shares; the rest is low
+}
+
+func (m ClassMix) Draw(rng *rand.Rand) model.Priority {
+	x := rng.Float64()
+	switch {
+	case x < m.High:
+		return model.HIGH_PRIORITY
+	case x < m.High+m.Medium:
+		return model.MEDIUM_PRIORITY
+	default:
+		return model.LOW_PRIORITY
+	}
+}
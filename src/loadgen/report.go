@@ -0,0 +1,122 @@
+package loadgen
+
+import (
+	"encoding/csv"
+	"fmt"
+	"main/src/model"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ClassReport is the outcome of the requests of one class (or of all of them).
+type ClassReport struct {
+	Class string
+	// Requests sent and requests answered before their deadline.
+	Offered, Carried int
+	CarriedBytes     int64
+	// Latency of the answered requests.
+	P50, P90, P95, P99, Max time.Duration
+}
+
+// DropRate is the share of offered requests not answered in time.
+func (r ClassReport) DropRate() float64 {
+	if r.Offered == 0 {
+		return 0
+	}
+	return 1 - float64(r.Carried)/float64(r.Offered)
+}
+
+// Report summarizes a load generator run.
+type Report struct {
+	Duration time.Duration
+	Classes  []ClassReport // high, medium, low, then "all"
+}
+
+type outcome struct {
+	class   model.Priority
+	bytes   int
+	carried bool
+	latency time.Duration
+}
+
+func newReport(outcomes []outcome, duration time.Duration) Report {
+	names := [model.PRIORITY_LEVEL_COUNT]string{"high", "medium", "low"}
+	report := Report{Duration: duration}
+	for class := 0; class <= model.PRIORITY_LEVEL_COUNT; class++ {
+		name := "all"
+		if class < model.PRIORITY_LEVEL_COUNT {
+			name = names[class]
+		}
+		r := ClassReport{Class: name}
+		var latencies []time.Duration
+		for _, o := range outcomes {
+			if class < model.PRIORITY_LEVEL_COUNT && int(o.class) != class {
+				continue
+			}
+			r.Offered++
+			if o.carried {
+				r.Carried++
+				r.CarriedBytes += int64(o.bytes)
+				latencies = append(latencies, o.latency)
+			}
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		r.P50 = Percentile(latencies, 50)
+		r.P90 = Percentile(latencies, 90)
+		r.P95 = Percentile(latencies, 95)
+		r.P99 = Percentile(latencies, 99)
+		r.Max = Percentile(latencies, 100)
+		report.Classes = append(report.Classes, r)
+	}
+	return report
+}
+
+// Percentile returns the nearest-rank p-th percentile of sorted values, or
+// 0 if there are none.
+func Percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// WriteCSV writes one row per class: offered and carried load in requests
+// per second, carried goodput in kbit/s, drop rate and latency percentiles
+// in milliseconds.
+func (r Report) WriteCSV(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	seconds := r.Duration.Seconds()
+	rate := func(n float64) string { return strconv.FormatFloat(n/seconds, 'f', 3, 64) }
+	ms := func(d time.Duration) string { return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64) }
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"class", "offered", "carried", "offered_rps", "carried_rps", "carried_kbps",
+		"drop_rate", "p50_ms", "p90_ms", "p95_ms", "p99_ms", "max_ms"})
+	for _, c := range r.Classes {
+		w.Write([]string{c.Class, strconv.Itoa(c.Offered), strconv.Itoa(c.Carried),
+			rate(float64(c.Offered)), rate(float64(c.Carried)),
+			rate(float64(c.CarriedBytes) * 8 / 1000),
+			strconv.FormatFloat(c.DropRate(), 'f', 4, 64),
+			ms(c.P50), ms(c.P90), ms(c.P95), ms(c.P99), ms(c.Max)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package matrix
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// Metrics of one repetition: server_summary.csv columns prefixed with
+// "server.", statistics-summary-*.csv columns prefixed with "client.". Rows
+// of several connections or clients are averaged.
+type Metrics map[string]float64
+
+// ReadRun reads the metrics of an experiment run directory.
+func ReadRun(runDir string) (Metrics, error) {
+	metrics := Metrics{}
+	serverFiles := []string{filepath.Join(runDir, "server", "server_summary.csv")}
+	if err := addMeanColumns(metrics, "server.", serverFiles); err != nil {
+		return nil, err
+	}
+	clientFiles, err := filepath.Glob(filepath.Join(runDir, "client", "statistics-summary-*.csv"))
+	if err != nil {
+		return nil, err
+	}
+	if err := addMeanColumns(metrics, "client.", clientFiles); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// Adds the mean of every numeric column of the files. -1 marks values that do
+// not apply (no FoV trace, no datagrams...) and is skipped.
+func addMeanColumns(metrics Metrics, prefix string, paths []string) error {
+	sums := map[string]float64{}
+	counts := map[string]int{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		r := csv.NewReader(f)
+		header, err := r.Read()
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for {
+			row, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			for i, value := range row {
+				if i >= len(header) {
+					break
+				}
+				v, err := strconv.ParseFloat(value, 64)
+				if err != nil || v == -1 {
+					continue
+				}
+				sums[header[i]] += v
+				counts[header[i]]++
+			}
+		}
+		f.Close()
+	}
+	for name, sum := range sums {
+		metrics[prefix+name] = sum / float64(counts[name])
+	}
+	return nil
+}
+
+// Result of one metric in one cell.
+type Result struct {
+	Cell    Cell
+	Metric  string
+	Summary Summary
+}
+
+// Aggregate summarises the repetitions of a cell, metric by metric, in
+// alphabetical order.
+func Aggregate(cell Cell, reps []Metrics) []Result {
+	values := map[string][]float64{}
+	for _, rep := range reps {
+		for name, v := range rep {
+			values[name] = append(values[name], v)
+		}
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make([]Result, 0, len(names))
+	for _, name := range names {
+		results = append(results, Result{Cell: cell, Metric: name, Summary: Summarize(values[name])})
+	}
+	return results
+}
+
+// WriteResults writes the results in long format: one row per cell and
+// metric, with the value of each axis.
+func WriteResults(path string, axisNames []string, results []Result) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	header := append([]string{"cell"}, axisNames...)
+	header = append(header, "metric", "n", "mean", "stddev", "ci95", "ci95_low", "ci95_high")
+	_ = w.Write(header)
+	for _, r := range results {
+		s := r.Summary
+		row := append([]string{r.Cell.ID}, r.Cell.Labels...)
+		row = append(row, r.Metric, strconv.Itoa(s.N),
+			f6(s.Mean), f6(s.StdDev), f6(s.CI95), f6(s.Mean-s.CI95), f6(s.Mean+s.CI95))
+		_ = w.Write(row)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func f6(v float64) string {
+	return strconv.FormatFloat(v, 'f', 6, 64)
+}
@@ -0,0 +1,196 @@
+// Package matrix runs an experiment for every combination of a set of
+// parameters, several times each, and summarises the repetitions with means
+// and 95% confidence intervals.
+package matrix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"main/src/config"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Spec describes the matrix, e.g.
+//
+//	{
+//	  "repetitions": 5,
+//	  "axes": [
+//	    {"field": "server.policy", "values": ["wfq", "sp", "fifo"]},
+//	    {"field": "client.parallelism", "values": [32, 128]},
+//	    {"name": "link", "profiles": [
+//	      {"name": "clean", "set": {}},
+//	      {"name": "lossy", "set": {"network.delay_ms": 24, "network.loss_percent": 2}}
+//	    ]}
+//	  ]
+//	}
+type Spec struct {
+	// Command run for each repetition: "experiment" (default) or "sim".
+	Command     string `json:"command,omitempty"`
+	Repetitions int    `json:"repetitions"`
+	Axes        []Axis `json:"axes"`
+}
+
+// Axis is either a single config field with its values, or a set of named
+// profiles, each setting several fields (link profiles, FoV traces...).
+type Axis struct {
+	Field    string        `json:"field,omitempty"`
+	Values   []interface{} `json:"values,omitempty"`
+	Name     string        `json:"name,omitempty"`
+	Profiles []Profile     `json:"profiles,omitempty"`
+}
+
+type Profile struct {
+	Name string                 `json:"name"`
+	Set  map[string]interface{} `json:"set"`
+}
+
+// Cell is one combination of axis values.
+type Cell struct {
+	// Directory name of the cell, e.g. "003-wfq-32-lossy".
+	ID string
+	// Value of each axis, in the order of Spec.Axes.
+	Labels []string
+	// Config fields to set.
+	Set map[string]string
+}
+
+// LoadSpec reads a spec from a JSON file.
+func LoadSpec(path string) (Spec, error) {
+	var spec Spec
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return spec, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber() // keeps integers such as seeds exact
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&spec); err != nil {
+		return spec, fmt.Errorf("%s: %w", path, err)
+	}
+	if spec.Repetitions <= 0 {
+		spec.Repetitions = 1
+	}
+	if spec.Command == "" {
+		spec.Command = "experiment"
+	}
+	if spec.Command != "experiment" && spec.Command != "sim" {
+		return spec, fmt.Errorf("%s: unknown command %q (experiment or sim)", path, spec.Command)
+	}
+	return spec, nil
+}
+
+// AxisNames returns the column name of each axis.
+func (s Spec) AxisNames() []string {
+	names := make([]string, len(s.Axes))
+	for i, axis := range s.Axes {
+		if axis.Field != "" {
+			names[i] = axis.Field
+		} else {
+			names[i] = axis.Name
+		}
+	}
+	return names
+}
+
+// Validate checks every axis against the base configuration, so that a typo
+// fails before hours of runs.
+func (s Spec) Validate(base config.Config) error {
+	cells, err := s.Cells()
+	if err != nil {
+		return err
+	}
+	for _, cell := range cells {
+		cfg := base
+		if err := cell.Apply(&cfg); err != nil {
+			return fmt.Errorf("cell %s: %w", cell.ID, err)
+		}
+	}
+	return nil
+}
+
+// Cells expands the axes into their cartesian product. The last axis varies
+// fastest.
+func (s Spec) Cells() ([]Cell, error) {
+	cells := []Cell{{Set: map[string]string{}}}
+	for i, axis := range s.Axes {
+		options, err := axis.options()
+		if err != nil {
+			return nil, fmt.Errorf("axis %d: %w", i, err)
+		}
+		var next []Cell
+		for _, cell := range cells {
+			for _, option := range options {
+				c := Cell{
+					Labels: append(append([]string(nil), cell.Labels...), option.label),
+					Set:    make(map[string]string, len(cell.Set)+len(option.set)),
+				}
+				for k, v := range cell.Set {
+					c.Set[k] = v
+				}
+				for k, v := range option.set {
+					c.Set[k] = v
+				}
+				next = append(next, c)
+			}
+		}
+		cells = next
+	}
+	for i := range cells {
+		parts := append([]string{fmt.Sprintf("%03d", i)}, cells[i].Labels...)
+		cells[i].ID = sanitize(strings.Join(parts, "-"))
+	}
+	return cells, nil
+}
+
+// Apply sets the fields of the cell in cfg.
+func (c Cell) Apply(cfg *config.Config) error {
+	for path, value := range c.Set {
+		if err := cfg.Set(path, value); err != nil {
+			return err
+		}
+	}
+	return cfg.Validate()
+}
+
+type axisOption struct {
+	label string
+	set   map[string]string
+}
+
+func (a Axis) options() ([]axisOption, error) {
+	switch {
+	case a.Field != "" && len(a.Profiles) == 0:
+		if len(a.Values) == 0 {
+			return nil, fmt.Errorf("%s: no values", a.Field)
+		}
+		options := make([]axisOption, len(a.Values))
+		for i, v := range a.Values {
+			value := fmt.Sprint(v)
+			options[i] = axisOption{label: value, set: map[string]string{a.Field: value}}
+		}
+		return options, nil
+	case a.Field == "" && a.Name != "" && len(a.Profiles) > 0:
+		options := make([]axisOption, len(a.Profiles))
+		for i, p := range a.Profiles {
+			if p.Name == "" {
+				return nil, fmt.Errorf("%s: profile %d has no name", a.Name, i)
+			}
+			set := make(map[string]string, len(p.Set))
+			for k, v := range p.Set {
+				set[k] = fmt.Sprint(v)
+			}
+			options[i] = axisOption{label: p.Name, set: set}
+		}
+		return options, nil
+	}
+	return nil, fmt.Errorf("an axis needs either field and values, or name and profiles")
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func sanitize(s string) string {
+	return unsafeChars.ReplaceAllString(s, "_")
+}
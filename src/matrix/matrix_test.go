@@ -0,0 +1,84 @@
+package matrix_test
+
+import (
+	"main/src/config"
+	"main/src/matrix"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+// Tests if the axes are expanded into their cartesian product.
+func TestSpec_Cells(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "matrix.json")
+	writeFile(t, path, `{
+		"repetitions": 3,
+		"axes": [
+			{"field": "server.policy", "values": ["wfq", "sp"]},
+			{"field": "client.parallelism", "values": [32, 128]},
+			{"name": "link", "profiles": [
+				{"name": "clean", "set": {}},
+				{"name": "lossy", "set": {"network.delay_ms": 24, "network.loss_percent": 2}}
+			]}
+		]
+	}`)
+	spec, err := matrix.LoadSpec(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, spec.Repetitions)
+	assert.Equal(t, []string{"server.policy", "client.parallelism", "link"}, spec.AxisNames())
+
+	cells, err := spec.Cells()
+	assert.Nil(t, err)
+	assert.Equal(t, 8, len(cells))
+	assert.Equal(t, "000-wfq-32-clean", cells[0].ID)
+	assert.Equal(t, "007-sp-128-lossy", cells[7].ID)
+	assert.Equal(t, []string{"sp", "128", "lossy"}, cells[7].Labels)
+
+	cfg := config.Default()
+	assert.Nil(t, cells[7].Apply(&cfg))
+	assert.Equal(t, "sp", cfg.Server.Policy)
+	assert.Equal(t, 128, cfg.Client.Parallelism)
+	assert.Equal(t, 24.0, cfg.Network.DelayMs)
+	assert.Equal(t, 2.0, cfg.Network.LossPercent)
+
+	assert.Nil(t, spec.Validate(config.Default()))
+}
+
+// Tests if invalid fields and values are rejected before running.
+func TestSpec_Invalid(t *testing.T) {
+	spec := matrix.Spec{Axes: []matrix.Axis{{Field: "client.paralelism", Values: []interface{}{1}}}}
+	assert.NotNil(t, spec.Validate(config.Default()))
+
+	spec = matrix.Spec{Axes: []matrix.Axis{{Field: "server.policy", Values: []interface{}{"edf"}}}}
+	assert.NotNil(t, spec.Validate(config.Default()))
+
+	spec = matrix.Spec{Axes: []matrix.Axis{{Field: "server.policy"}}}
+	assert.NotNil(t, spec.Validate(config.Default()))
+}
+
+// Tests if the summaries of a run are read and averaged.
+func TestReadRun(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "server", "server_summary.csv"),
+		"ts_start,duration_s,jain_fairness\n2026-01-01T00:00:00Z,10,0.5\n2026-01-01T00:00:00Z,20,0.7\n")
+	writeFile(t, filepath.Join(dir, "client", "statistics-summary-client0.csv"),
+		"join_latency_ms,datagram_tile_loss_rate_percent\n200,-1.00\n")
+	writeFile(t, filepath.Join(dir, "client", "statistics-summary-client1.csv"),
+		"join_latency_ms,datagram_tile_loss_rate_percent\n300,4.00\n")
+
+	metrics, err := matrix.ReadRun(dir)
+	assert.Nil(t, err)
+	assert.InDelta(t, 15.0, metrics["server.duration_s"], 1e-9)
+	assert.InDelta(t, 0.6, metrics["server.jain_fairness"], 1e-9)
+	assert.InDelta(t, 250.0, metrics["client.join_latency_ms"], 1e-9)
+	assert.InDelta(t, 4.0, metrics["client.datagram_tile_loss_rate_percent"], 1e-9)
+	_, hasTimestamp := metrics["server.ts_start"]
+	assert.False(t, hasTimestamp)
+}
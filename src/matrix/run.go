@@ -0,0 +1,90 @@
+package matrix
+
+import (
+	"fmt"
+	"log"
+	"main/src/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Run executes every repetition of every cell, each in its own process (the
+// server metrics are process-wide), then writes <outDir>/results.csv.
+//
+// Layout: <outDir>/<cell>/config.json and <outDir>/<cell>/rep<k>/, the run
+// directory of each repetition. When the link emulator has a fixed seed,
+// repetition k uses seed+k-1 so the repetitions are not identical.
+func Run(spec Spec, base config.Config, outDir string) error {
+	if err := spec.Validate(base); err != nil {
+		return err
+	}
+	cells, err := spec.Cells()
+	if err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	total := len(cells) * spec.Repetitions
+	if spec.Command == "" {
+		spec.Command = "experiment"
+	}
+	log.Printf("Matrix: %d cells x %d repetitions = %d runs in %s", len(cells), spec.Repetitions, total, outDir)
+
+	var results []Result
+	done := 0
+	for _, cell := range cells {
+		cfg := base
+		if err := cell.Apply(&cfg); err != nil {
+			return err
+		}
+		cellDir := filepath.Join(outDir, cell.ID)
+		if err := os.MkdirAll(cellDir, 0o755); err != nil {
+			return err
+		}
+		cellConfig := filepath.Join(cellDir, "config.json")
+		if err := cfg.WriteFile(cellConfig); err != nil {
+			return err
+		}
+
+		var reps []Metrics
+		for rep := 1; rep <= spec.Repetitions; rep++ {
+			done++
+			repDir := filepath.Join(cellDir, fmt.Sprintf("rep%d", rep))
+			args := []string{spec.Command, "-config", cellConfig, "-experiment.output_dir", repDir}
+			if cfg.Network.Seed != 0 {
+				args = append(args, "-network.seed", strconv.FormatInt(cfg.Network.Seed+int64(rep-1), 10))
+			}
+
+			start := time.Now()
+			log.Printf("[%d/%d] %s rep %d", done, total, cell.ID, rep)
+			cmd := exec.Command(executable, args...)
+			cmd.Stderr = os.Stderr // stdout is kept in the experiment.log of the run
+			if err := cmd.Run(); err != nil {
+				log.Printf("[%d/%d] %s rep %d failed: %v", done, total, cell.ID, rep, err)
+				continue
+			}
+
+			metrics, err := ReadRun(repDir)
+			if err != nil {
+				log.Printf("[%d/%d] %s rep %d: %v", done, total, cell.ID, rep, err)
+				continue
+			}
+			reps = append(reps, metrics)
+			log.Printf("[%d/%d] %s rep %d done in %v", done, total, cell.ID, rep, time.Since(start).Round(time.Second))
+		}
+		results = append(results, Aggregate(cell, reps)...)
+	}
+
+	path := filepath.Join(outDir, "results.csv")
+	if err := WriteResults(path, spec.AxisNames(), results); err != nil {
+		return err
+	}
+	log.Printf("Matrix finished, results in %s", path)
+	return nil
+}
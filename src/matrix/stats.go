@@ -0,0 +1,79 @@
+package matrix
+
+import "math"
+
+// Summary of the repetitions of one metric.
+type Summary struct {
+	N      int
+	Mean   float64
+	StdDev float64 // sample standard deviation
+	// Half width of the 95% confidence interval of the mean (Student's t).
+	// 0 with a single repetition.
+	CI95 float64
+}
+
+// Summarize computes the mean and the 95% confidence interval of values.
+func Summarize(values []float64) Summary {
+	n := len(values)
+	if n == 0 {
+		return Summary{Mean: math.NaN()}
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(n)
+	if n == 1 {
+		return Summary{N: 1, Mean: mean}
+	}
+	sq := 0.0
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	sd := math.Sqrt(sq / float64(n-1))
+	return Summary{
+		N:      n,
+		Mean:   mean,
+		StdDev: sd,
+		CI95:   TCritical95(n-1) * sd / math.Sqrt(float64(n)),
+	}
+}
+
+// Two-sided 95% critical values of Student's t for 1..30 degrees of freedom.
+var tTable = [...]float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// Critical values beyond the table, by degrees of freedom (0 = infinity).
+var tTail = []struct {
+	df int
+	t  float64
+}{{30, 2.042}, {40, 2.021}, {60, 2.000}, {120, 1.980}, {0, 1.960}}
+
+// TCritical95 returns t(0.975, df). Beyond the table, it interpolates
+// linearly in 1/df between the values of 40, 60, 120 and infinite degrees of
+// freedom, which is within 0.001 of the exact value.
+func TCritical95(df int) float64 {
+	switch {
+	case df <= 0:
+		return math.NaN()
+	case df <= len(tTable):
+		return tTable[df-1]
+	}
+	inv := func(df int) float64 {
+		if df == 0 {
+			return 0
+		}
+		return 1 / float64(df)
+	}
+	for i := 1; i < len(tTail); i++ {
+		lo, hi := tTail[i-1], tTail[i]
+		if hi.df == 0 || df <= hi.df {
+			frac := (inv(lo.df) - inv(df)) / (inv(lo.df) - inv(hi.df))
+			return lo.t + frac*(hi.t-lo.t)
+		}
+	}
+	return tTail[len(tTail)-1].t
+}
@@ -0,0 +1,45 @@
+package matrix_test
+
+import (
+	"main/src/matrix"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests the mean and the confidence interval of a known sample.
+func TestSummarize(t *testing.T) {
+	s := matrix.Summarize([]float64{2, 4, 4, 4, 5, 5, 7, 9})
+	assert.Equal(t, 8, s.N)
+	assert.InDelta(t, 5.0, s.Mean, 1e-9)
+	assert.InDelta(t, 2.138090, s.StdDev, 1e-6)
+	// t(0.975, 7) = 2.365
+	assert.InDelta(t, 2.365*2.138090/math.Sqrt(8), s.CI95, 1e-6)
+}
+
+// Tests the degenerate samples.
+func TestSummarize_Small(t *testing.T) {
+	s := matrix.Summarize([]float64{3})
+	assert.Equal(t, 1, s.N)
+	assert.Equal(t, 3.0, s.Mean)
+	assert.Equal(t, 0.0, s.CI95)
+
+	s = matrix.Summarize(nil)
+	assert.Equal(t, 0, s.N)
+	assert.True(t, math.IsNaN(s.Mean))
+}
+
+// Tests the critical values of Student's t.
+func TestTCritical95(t *testing.T) {
+	assert.Equal(t, 12.706, matrix.TCritical95(1))
+	assert.Equal(t, 2.262, matrix.TCritical95(9))
+	assert.Equal(t, 2.042, matrix.TCritical95(30))
+	assert.InDelta(t, 2.030, matrix.TCritical95(35), 0.001)
+	assert.InDelta(t, 2.021, matrix.TCritical95(40), 1e-9)
+	assert.InDelta(t, 2.009, matrix.TCritical95(50), 0.001)
+	assert.InDelta(t, 1.990, matrix.TCritical95(80), 0.001)
+	assert.InDelta(t, 1.962, matrix.TCritical95(1000), 0.001)
+	assert.InDelta(t, 1.960, matrix.TCritical95(100000), 0.001)
+	assert.True(t, math.IsNaN(matrix.TCritical95(0)))
+}
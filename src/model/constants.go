@@ -1,5 +1,11 @@
 package model
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 type Priority int
 
 const (
@@ -17,3 +23,32 @@ const (
 	MEDIUM_BITRATE Bitrate = 5
 	HIGH_BITRATE   Bitrate = 10
 )
+
+// ParseClassList parses a comma separated list of classes, given either by
+// name (high, medium, low) or by number, e.g. "low" or "medium,low".
+func ParseClassList(value string) (map[Priority]bool, error) {
+	classes := make(map[Priority]bool)
+	for _, token := range strings.Split(value, ",") {
+		token = strings.ToLower(strings.TrimSpace(token))
+		switch token {
+		case "":
+			continue
+		case "high":
+			classes[HIGH_PRIORITY] = true
+		case "medium":
+			classes[MEDIUM_PRIORITY] = true
+		case "low":
+			classes[LOW_PRIORITY] = true
+		default:
+			n, err := strconv.Atoi(token)
+			if err != nil || n < 0 || n >= PRIORITY_LEVEL_COUNT {
+				return nil, fmt.Errorf("unknown class %q", token)
+			}
+			classes[Priority(n)] = true
+		}
+	}
+	if len(classes) == 0 {
+		return nil, nil
+	}
+	return classes, nil
+}
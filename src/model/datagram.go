@@ -0,0 +1,206 @@
+package model
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// How a response is delivered to the client.
+type DeliveryMode int
+
+const (
+	// Reliable delivery on the request's QUIC stream (default).
+	STREAM_DELIVERY DeliveryMode = iota
+	// Unreliable delivery as fragmented QUIC datagrams (RFC 9221), without
+	// retransmission.
+	DATAGRAM_DELIVERY
+)
+
+func (d DeliveryMode) String() string {
+	switch d {
+	case STREAM_DELIVERY:
+		return "stream"
+	case DATAGRAM_DELIVERY:
+		return "datagram"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(d))
+	}
+}
+
+// Parse a delivery mode from its String() representation.
+func ParseDeliveryMode(value string) (DeliveryMode, error) {
+	switch value {
+	case "", "stream":
+		return STREAM_DELIVERY, nil
+	case "datagram":
+		return DATAGRAM_DELIVERY, nil
+	default:
+		return STREAM_DELIVERY, fmt.Errorf("unknown delivery mode %q", value)
+	}
+}
+
+// Maximum payload carried by a single datagram fragment.
+//
+// quic-go limits DATAGRAM frames to 1200 bytes, and the frame must also fit
+// in a single packet, so this leaves room for the QUIC and fragment headers.
+const DATAGRAM_FRAGMENT_PAYLOAD int = 1000
+
+// Magic byte identifying a fragment, so stray datagrams are rejected.
+const datagramFragmentMagic byte = 0xD7
+
+// Size of the binary fragment header.
+const datagramFragmentHeaderSize = 1 + 1 + 1 + 4 + 4 + 2 + 2 + 4 + 4
+
+// A fragment of a VideoPacketResponse sent as a QUIC datagram.
+type DatagramFragment struct {
+	Priority    Priority
+	Bitrate     Bitrate
+	Segment     int
+	Tile        int
+	Index       int
+	Count       int
+	TotalLength int
+	// Position of Data within the response body.
+	Offset int
+	Data   []byte
+}
+
+// Split a VideoPacketResponse into encoded datagram fragments of at most
+// maxPayload data bytes each.
+func FragmentResponse(r *VideoPacketResponse, maxPayload int) ([][]byte, error) {
+	if maxPayload <= 0 {
+		return nil, errors.New("invalid fragment payload size")
+	}
+	count := (len(r.Data) + maxPayload - 1) / maxPayload
+	if count == 0 {
+		count = 1
+	}
+	if count > 0xFFFF {
+		return nil, fmt.Errorf("response too large: %d fragments", count)
+	}
+
+	fragments := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		start := i * maxPayload
+		end := start + maxPayload
+		if end > len(r.Data) {
+			end = len(r.Data)
+		}
+		fragments = append(fragments, (&DatagramFragment{
+			Priority:    r.Priority,
+			Bitrate:     r.Bitrate,
+			Segment:     r.Segment,
+			Tile:        r.Tile,
+			Index:       i,
+			Count:       count,
+			TotalLength: len(r.Data),
+			Offset:      start,
+			Data:        r.Data[start:end],
+		}).Marshal())
+	}
+	return fragments, nil
+}
+
+// Encode the fragment.
+func (f *DatagramFragment) Marshal() []byte {
+	b := make([]byte, datagramFragmentHeaderSize, datagramFragmentHeaderSize+len(f.Data))
+	b[0] = datagramFragmentMagic
+	b[1] = byte(f.Priority)
+	b[2] = byte(f.Bitrate)
+	binary.BigEndian.PutUint32(b[3:], uint32(f.Segment))
+	binary.BigEndian.PutUint32(b[7:], uint32(f.Tile))
+	binary.BigEndian.PutUint16(b[11:], uint16(f.Index))
+	binary.BigEndian.PutUint16(b[13:], uint16(f.Count))
+	binary.BigEndian.PutUint32(b[15:], uint32(f.TotalLength))
+	binary.BigEndian.PutUint32(b[19:], uint32(f.Offset))
+	return append(b, f.Data...)
+}
+
+// Decode a fragment.
+func ParseDatagramFragment(b []byte) (*DatagramFragment, error) {
+	if len(b) < datagramFragmentHeaderSize {
+		return nil, errors.New("fragment too short")
+	}
+	if b[0] != datagramFragmentMagic {
+		return nil, errors.New("not a datagram fragment")
+	}
+	f := &DatagramFragment{
+		Priority:    Priority(b[1]),
+		Bitrate:     Bitrate(b[2]),
+		Segment:     int(binary.BigEndian.Uint32(b[3:])),
+		Tile:        int(binary.BigEndian.Uint32(b[7:])),
+		Index:       int(binary.BigEndian.Uint16(b[11:])),
+		Count:       int(binary.BigEndian.Uint16(b[13:])),
+		TotalLength: int(binary.BigEndian.Uint32(b[15:])),
+		Offset:      int(binary.BigEndian.Uint32(b[19:])),
+		Data:        b[datagramFragmentHeaderSize:],
+	}
+	if f.Count == 0 || f.Index >= f.Count {
+		return nil, fmt.Errorf("invalid fragment %d/%d", f.Index, f.Count)
+	}
+	if f.Offset+len(f.Data) > f.TotalLength {
+		return nil, errors.New("fragment exceeds response length")
+	}
+	return f, nil
+}
+
+// Reassembles the fragments of a single response.
+//
+// Fragments may arrive in any order and duplicates are ignored. The response
+// is only available once every fragment has been received.
+type DatagramAssembly struct {
+	first    *DatagramFragment
+	data     []byte
+	received []bool
+	missing  int
+}
+
+// Create an assembly from the first fragment received for a response.
+func NewDatagramAssembly(f *DatagramFragment) *DatagramAssembly {
+	a := &DatagramAssembly{
+		first:    f,
+		data:     make([]byte, f.TotalLength),
+		received: make([]bool, f.Count),
+		missing:  f.Count,
+	}
+	a.Add(f)
+	return a
+}
+
+// Add a fragment. Returns true when the response is complete.
+func (a *DatagramAssembly) Add(f *DatagramFragment) bool {
+	if f.Count != len(a.received) || f.TotalLength != len(a.data) {
+		return a.Complete()
+	}
+	if !a.received[f.Index] {
+		copy(a.data[f.Offset:], f.Data)
+		a.received[f.Index] = true
+		a.missing--
+	}
+	return a.Complete()
+}
+
+// Whether every fragment has been received.
+func (a *DatagramAssembly) Complete() bool {
+	return a.missing == 0
+}
+
+// Number of fragments still missing.
+func (a *DatagramAssembly) Missing() int {
+	return a.missing
+}
+
+// Returns the reassembled response, or nil if it is not complete.
+func (a *DatagramAssembly) Response() *VideoPacketResponse {
+	if !a.Complete() {
+		return nil
+	}
+	return &VideoPacketResponse{
+		Priority: a.first.Priority,
+		Bitrate:  a.first.Bitrate,
+		Segment:  a.first.Segment,
+		Tile:     a.first.Tile,
+		Data:     a.data,
+	}
+}
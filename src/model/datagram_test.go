@@ -0,0 +1,94 @@
+package model_test
+
+import (
+	"bufio"
+	"bytes"
+	"main/src/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteRequestDatagram(t *testing.T) {
+	buf := &bytes.Buffer{}
+	(&model.VideoPacketRequest{
+		Priority: 2,
+		Bitrate:  3,
+		Segment:  100,
+		Tile:     7,
+		Timeout:  500,
+		Delivery: model.DATAGRAM_DELIVERY,
+	}).Write(buf)
+	expected := []byte(`Priority: 2
+Bitrate: 3
+Segment: 100
+Tile: 7
+Timeout: 500
+Delivery: datagram
+
+`)
+
+	assert.Equal(t, expected, buf.Bytes())
+
+	req, err := model.ReadVideoPacketRequest(bufio.NewReader(buf))
+	assert.Nil(t, err)
+	assert.Equal(t, model.DATAGRAM_DELIVERY, req.Delivery)
+}
+
+func TestFragmentReassembly(t *testing.T) {
+	data := make([]byte, 2500)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	res := &model.VideoPacketResponse{
+		Priority: model.LOW_PRIORITY,
+		Bitrate:  model.LOW_BITRATE,
+		Segment:  101,
+		Tile:     3,
+		Data:     data,
+	}
+
+	fragments, err := model.FragmentResponse(res, 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(fragments))
+
+	// Deliver out of order, with a duplicate
+	order := []int{2, 0, 2, 1}
+	var assembly *model.DatagramAssembly
+	for i, idx := range order {
+		f, err := model.ParseDatagramFragment(fragments[idx])
+		assert.Nil(t, err)
+		if assembly == nil {
+			assembly = model.NewDatagramAssembly(f)
+		} else {
+			assembly.Add(f)
+		}
+		assert.Equal(t, i == len(order)-1, assembly.Complete())
+	}
+
+	out := assembly.Response()
+	assert.NotNil(t, out)
+	assert.Equal(t, res.Segment, out.Segment)
+	assert.Equal(t, res.Tile, out.Tile)
+	assert.Equal(t, res.Priority, out.Priority)
+	assert.Equal(t, data, out.Data)
+}
+
+func TestFragmentMissing(t *testing.T) {
+	res := &model.VideoPacketResponse{Segment: 1, Tile: 1, Data: make([]byte, 2001)}
+	fragments, _ := model.FragmentResponse(res, 1000)
+
+	f0, _ := model.ParseDatagramFragment(fragments[0])
+	f2, _ := model.ParseDatagramFragment(fragments[2])
+	assembly := model.NewDatagramAssembly(f0)
+	assembly.Add(f2)
+
+	assert.False(t, assembly.Complete())
+	assert.Equal(t, 1, assembly.Missing())
+	assert.Nil(t, assembly.Response())
+}
+
+func TestParseFragmentFail(t *testing.T) {
+	_, err := model.ParseDatagramFragment([]byte{1, 2, 3})
+	assert.NotNil(t, err)
+}
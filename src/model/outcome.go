@@ -0,0 +1,80 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// Why a request ended the way it did, as recorded by the server (reqlog.csv)
+// and by the client (statistics.csv).
+type Outcome int
+
+const (
+	// The response was sent (server) or received (client) by the deadline.
+	ON_TIME_OUTCOME Outcome = iota
+	// The response was sent or received after the deadline.
+	LATE_OUTCOME
+	// The deadline passed while the request waited in the server queue.
+	EXPIRED_BEFORE_SERVICE_OUTCOME
+	// The server refused the request: predicted infeasible, or the
+	// scheduler had stopped.
+	REJECTED_OUTCOME
+	// The request was abandoned: still queued when its connection closed,
+	// reset by the peer, or not sent by the client because its deadline had
+	// passed.
+	CANCELLED_OUTCOME
+	// The tile could not be read (missing, empty or a read error).
+	NOT_FOUND_OUTCOME
+	// Writing the response failed.
+	WRITE_ERROR_OUTCOME
+	// The client stopped waiting without a response.
+	CLIENT_TIMEOUT_OUTCOME
+	// The connection failed.
+	TRANSPORT_ERROR_OUTCOME
+)
+
+const OUTCOME_COUNT int = int(TRANSPORT_ERROR_OUTCOME) + 1
+
+var outcomeNames = [OUTCOME_COUNT]string{
+	"on_time", "late", "expired_before_service", "rejected", "cancelled",
+	"not_found", "write_error", "client_timeout", "transport_error",
+}
+
+// Outcomes the server records; the client timeout only exists on the client.
+var ServerOutcomes = []Outcome{
+	ON_TIME_OUTCOME, LATE_OUTCOME, EXPIRED_BEFORE_SERVICE_OUTCOME,
+	REJECTED_OUTCOME, CANCELLED_OUTCOME, NOT_FOUND_OUTCOME,
+	WRITE_ERROR_OUTCOME, TRANSPORT_ERROR_OUTCOME,
+}
+
+// Outcomes the client records. The server sends nothing when it fails, so
+// the client only tells its own cases apart.
+var ClientOutcomes = []Outcome{
+	ON_TIME_OUTCOME, LATE_OUTCOME, CANCELLED_OUTCOME,
+	CLIENT_TIMEOUT_OUTCOME, TRANSPORT_ERROR_OUTCOME,
+}
+
+func (o Outcome) String() string {
+	if o < 0 || int(o) >= OUTCOME_COUNT {
+		return fmt.Sprintf("unknown(%d)", int(o))
+	}
+	return outcomeNames[o]
+}
+
+// Parse an outcome from its String() representation.
+func ParseOutcome(value string) (Outcome, error) {
+	for o, name := range outcomeNames {
+		if name == value {
+			return Outcome(o), nil
+		}
+	}
+	return ON_TIME_OUTCOME, fmt.Errorf("unknown outcome %q", value)
+}
+
+// Outcome of a response delivered at the given time.
+func DeliveryOutcome(at, deadline time.Time) Outcome {
+	if at.After(deadline) {
+		return LATE_OUTCOME
+	}
+	return ON_TIME_OUTCOME
+}
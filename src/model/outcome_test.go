@@ -0,0 +1,29 @@
+package model_test
+
+import (
+	"main/src/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests if every outcome parses back from its name.
+func TestOutcomeNames(t *testing.T) {
+	for o := model.Outcome(0); int(o) < model.OUTCOME_COUNT; o++ {
+		parsed, err := model.ParseOutcome(o.String())
+		assert.Nil(t, err)
+		assert.Equal(t, o, parsed)
+	}
+	assert.Equal(t, "expired_before_service", model.EXPIRED_BEFORE_SERVICE_OUTCOME.String())
+
+	_, err := model.ParseOutcome("timedout")
+	assert.NotNil(t, err)
+}
+
+// Tests if a delivery exactly at the deadline is on time.
+func TestDeliveryOutcome(t *testing.T) {
+	deadline := time.Unix(10, 0)
+	assert.Equal(t, model.ON_TIME_OUTCOME, model.DeliveryOutcome(deadline, deadline))
+	assert.Equal(t, model.LATE_OUTCOME, model.DeliveryOutcome(deadline.Add(time.Nanosecond), deadline))
+}
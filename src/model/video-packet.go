@@ -12,6 +12,7 @@ import (
 )
 
 type VideoPacketRequest struct {
+	// Sent when set, so the server logs can be joined with the client's.
 	ID       uuid.UUID
 	Priority Priority
 	Bitrate  Bitrate
@@ -19,6 +20,8 @@ type VideoPacketRequest struct {
 	Tile     int
 	// [milliseconds] If this timeout elapses, do not send a response.
 	Timeout int
+	// How the response should be delivered. Defaults to a reliable stream.
+	Delivery DeliveryMode
 }
 
 type VideoPacketResponse struct {
@@ -35,9 +38,18 @@ func (r *VideoPacketRequest) Write(writer io.Writer) (err error) {
 	// Headers - "Key: Value" separated by \n
 	// Followed by empty line
 	// Followed by optional data
-	_, err = fmt.Fprintf(writer,
-		"Priority: %d\nBitrate: %d\nSegment: %d\nTile: %d\nTimeout: %d\n\n",
+	header := fmt.Sprintf(
+		"Priority: %d\nBitrate: %d\nSegment: %d\nTile: %d\nTimeout: %d\n",
 		r.Priority, r.Bitrate, r.Segment, r.Tile, r.Timeout)
+	// The default (stream) delivery is implicit to keep the header compact.
+	if r.Delivery != STREAM_DELIVERY {
+		header += fmt.Sprintf("Delivery: %s\n", r.Delivery)
+	}
+	if r.ID != uuid.Nil {
+		header += fmt.Sprintf("Id: %s\n", r.ID)
+	}
+	// Single write, so pipelined requests are never interleaved
+	_, err = io.WriteString(writer, header+"\n")
 	return
 }
 
@@ -97,6 +109,14 @@ func ReadVideoPacketRequest(reader *bufio.Reader) (req *VideoPacketRequest, err
 				return
 			}
 			request.Timeout = intValue
+		case "Delivery":
+			if request.Delivery, err = ParseDeliveryMode(value); err != nil {
+				return
+			}
+		case "Id":
+			if request.ID, err = uuid.Parse(value); err != nil {
+				return
+			}
 		}
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"main/src/model"
 	"testing"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -49,6 +50,18 @@ Timeout: 2000
 	assert.Equal(t, 2000, req.Timeout)
 }
 
+// Tests if the request ID goes through the wire
+func TestRequestID(t *testing.T) {
+	id := uuid.MustParse("0b7f3c1e-58a4-4c8e-9d6e-2f1a3b4c5d6e")
+	buf := &bytes.Buffer{}
+	(&model.VideoPacketRequest{ID: id, Priority: 1, Segment: 3, Tile: 4, Timeout: 2000}).Write(buf)
+	assert.Contains(t, buf.String(), "Id: 0b7f3c1e-58a4-4c8e-9d6e-2f1a3b4c5d6e\n")
+
+	req, err := model.ReadVideoPacketRequest(bufio.NewReader(buf))
+	assert.Nil(t, err)
+	assert.Equal(t, id, req.ID)
+}
+
 func TestReadRequestFail(t *testing.T) {
 	buf := bytes.NewBuffer([]byte(`Priority: 1`))
 	res, err := model.ReadVideoPacketRequest(bufio.NewReader(buf))
@@ -0,0 +1,114 @@
+package netem
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reads a LinkConfig from the environment:
+//
+//	NETEM_BW       bandwidth in Mbps
+//	NETEM_BURST    token bucket size in bytes
+//	NETEM_DELAY    one-way delay in ms
+//	NETEM_JITTER   delay variation in ms
+//	NETEM_LOSS     random loss in %
+//	NETEM_GE       Gilbert-Elliott loss "p,r,loss_good,loss_bad" in %
+//	NETEM_REORDER  reordering in %
+//	NETEM_QUEUE    queue size in packets
+//	NETEM_SEED     random seed
+//
+// The names mirror the SERVER_BW/DELAY/LOSS parameters of the Mininet
+// scripts, but are prefixed so the Mininet processes are not emulated twice.
+func LinkConfigFromEnv() (LinkConfig, error) {
+	var cfg LinkConfig
+	var err error
+
+	if cfg.BandwidthMbps, err = envFloat("NETEM_BW"); err != nil {
+		return cfg, err
+	}
+	if cfg.BurstBytes, err = envInt("NETEM_BURST"); err != nil {
+		return cfg, err
+	}
+	if cfg.Delay, err = envMs("NETEM_DELAY"); err != nil {
+		return cfg, err
+	}
+	if cfg.Jitter, err = envMs("NETEM_JITTER"); err != nil {
+		return cfg, err
+	}
+	if cfg.LossPercent, err = envFloat("NETEM_LOSS"); err != nil {
+		return cfg, err
+	}
+	if cfg.ReorderPercent, err = envFloat("NETEM_REORDER"); err != nil {
+		return cfg, err
+	}
+	if cfg.QueuePackets, err = envInt("NETEM_QUEUE"); err != nil {
+		return cfg, err
+	}
+	seed, err := envInt("NETEM_SEED")
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Seed = int64(seed)
+
+	if value := os.Getenv("NETEM_GE"); value != "" {
+		if cfg.GilbertElliott, err = ParseGilbertElliott(value); err != nil {
+			return cfg, fmt.Errorf("NETEM_GE: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+// Parses "p,r,loss_good,loss_bad" (percentages). loss_good and loss_bad are
+// optional and default to 0 and 100.
+func ParseGilbertElliott(value string) (*GilbertElliott, error) {
+	fields := strings.Split(value, ",")
+	if len(fields) < 2 || len(fields) > 4 {
+		return nil, fmt.Errorf("expected p,r[,loss_good[,loss_bad]], got %q", value)
+	}
+	values := []float64{0, 0, 0, 100}
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return &GilbertElliott{
+		P:        values[0],
+		R:        values[1],
+		LossGood: values[2],
+		LossBad:  values[3],
+	}, nil
+}
+
+func envFloat(name string) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return v, nil
+}
+
+func envInt(name string) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return v, nil
+}
+
+func envMs(name string) (time.Duration, error) {
+	v, err := envFloat(name)
+	return time.Duration(v * float64(time.Millisecond)), err
+}
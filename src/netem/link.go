@@ -0,0 +1,430 @@
+// Package netem emulates a network link inside the process.
+//
+// A Link shapes the packets written through the net.PacketConn values it
+// wraps: bandwidth (token bucket), bounded queue (tail drop), random or
+// Gilbert-Elliott loss, one-way delay with jitter and reordering. It applies
+// to the egress direction only, like tc/netem on an interface, so each end
+// of a connection wraps its own socket to emulate both directions.
+//
+// This lets the scenarios of scripts/mininet run on any machine without root.
+package netem
+
+import (
+	"container/heap"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Default queue size, in packets, when LinkConfig.QueuePackets is 0.
+const DefaultQueuePackets = 1000
+
+// Link parameters. The zero value is a transparent link.
+type LinkConfig struct {
+	// Bandwidth in Mbps. 0 means unlimited.
+	BandwidthMbps float64
+	// Token bucket size in bytes. 0 means one full-size packet.
+	BurstBytes int
+	// One-way delay added after the packet leaves the queue.
+	Delay time.Duration
+	// Uniform random variation of the delay, in [-Jitter, +Jitter].
+	Jitter time.Duration
+	// Random (Bernoulli) loss, in percent.
+	LossPercent float64
+	// Bursty loss. Replaces LossPercent when set.
+	GilbertElliott *GilbertElliott
+	// Percentage of packets sent without delay, overtaking the ones in
+	// flight. Without reordering, jitter never changes the packet order.
+	ReorderPercent float64
+	// Maximum packets waiting for bandwidth. Excess packets are dropped.
+	QueuePackets int
+	// Random seed. 0 uses the current time.
+	Seed int64
+	// Bandwidth time series played from the creation of the link. Replaces
+	// BandwidthMbps.
+	BandwidthTrace *BandwidthTrace
+}
+
+// Two-state Gilbert-Elliott loss model. All values are percentages.
+type GilbertElliott struct {
+	// Probability of moving from the good to the bad state, per packet.
+	P float64
+	// Probability of moving from the bad to the good state, per packet.
+	R float64
+	// Loss probability in the good state.
+	LossGood float64
+	// Loss probability in the bad state.
+	LossBad float64
+}
+
+// Returns true if the configuration changes anything.
+func (c LinkConfig) Enabled() bool {
+	return c.BandwidthMbps > 0 || c.BurstBytes > 0 || c.Delay > 0 || c.Jitter > 0 ||
+		c.LossPercent > 0 || c.GilbertElliott != nil || c.ReorderPercent > 0 ||
+		c.QueuePackets > 0 || c.BandwidthTrace != nil
+}
+
+// Counters of a Link.
+type LinkStats struct {
+	Sent          uint64
+	SentBytes     uint64
+	Delivered     uint64
+	LostRandom    uint64
+	DroppedQueue  uint64
+	Reordered     uint64
+	QueuedPackets int
+}
+
+// An emulated link (one direction). It may be shared by several connections,
+// which then compete for the same bandwidth and queue.
+type Link struct {
+	mu  sync.Mutex
+	cfg LinkConfig
+	rng *rand.Rand
+	now func() time.Time
+
+	// token bucket
+	rateBytesPerSec float64
+	burst           float64
+	tokens          float64
+	tokensAt        time.Time
+	lastDeparture   time.Time
+	departures      []time.Time // departure times of packets still queued
+
+	// loss state
+	geBad bool
+
+	// delay line
+	lastDelivery time.Time
+	inFlight     packetHeap
+	seq          uint64
+	wake         chan struct{}
+	done         chan struct{}
+	closeOnce    sync.Once
+
+	// bandwidth trace playback
+	traceStep      TraceStep
+	traceObservers map[int]func(TraceStep)
+	traceObserverN int
+
+	stats LinkStats
+}
+
+// Create a link and start its delivery goroutine.
+func NewLink(cfg LinkConfig) *Link {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	if cfg.QueuePackets <= 0 {
+		cfg.QueuePackets = DefaultQueuePackets
+	}
+	l := &Link{
+		rng:  rand.New(rand.NewSource(seed)),
+		now:  time.Now,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	if cfg.BandwidthTrace != nil {
+		l.traceStep, _ = cfg.BandwidthTrace.Position(0)
+		cfg.BandwidthMbps = l.traceStep.Mbps
+	}
+	l.setConfigLocked(cfg)
+	l.tokens = l.burst
+	l.tokensAt = l.now()
+	go l.deliverLoop()
+	if cfg.BandwidthTrace != nil {
+		go l.playTrace(cfg.BandwidthTrace, l.now())
+	}
+	return l
+}
+
+// Current configuration.
+func (l *Link) Config() LinkConfig {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cfg
+}
+
+// Change the bandwidth while the link is running. Packets already queued keep
+// their departure time.
+func (l *Link) SetBandwidth(mbps float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refillLocked(l.now())
+	cfg := l.cfg
+	cfg.BandwidthMbps = mbps
+	l.setConfigLocked(cfg)
+}
+
+func (l *Link) setConfigLocked(cfg LinkConfig) {
+	l.cfg = cfg
+	l.rateBytesPerSec = cfg.BandwidthMbps * 1e6 / 8
+	l.burst = float64(cfg.BurstBytes)
+	if l.burst <= 0 {
+		l.burst = maxPacketSize
+	}
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// Snapshot of the counters.
+func (l *Link) Stats() LinkStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expireDeparturesLocked(l.now())
+	stats := l.stats
+	stats.QueuedPackets = len(l.departures)
+	return stats
+}
+
+// Stop the link. Packets still in flight are discarded.
+func (l *Link) Close() {
+	l.closeOnce.Do(func() { close(l.done) })
+}
+
+// Wrap a PacketConn so its writes go through the link. Reads are not
+// affected.
+func (l *Link) Wrap(pc net.PacketConn) net.PacketConn {
+	return &conn{PacketConn: pc, link: l}
+}
+
+// Largest UDP payload we expect to see; used as the default burst.
+const maxPacketSize = 1500
+
+// Enqueue a packet. Returns false if the packet was dropped.
+func (l *Link) send(pc net.PacketConn, p []byte, addr net.Addr) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.stats.Sent++
+	l.stats.SentBytes += uint64(len(p))
+
+	departure := now
+	if l.rateBytesPerSec > 0 {
+		l.expireDeparturesLocked(now)
+		if len(l.departures) >= l.cfg.QueuePackets {
+			l.stats.DroppedQueue++
+			return false
+		}
+		departure = l.departAtLocked(now, len(p))
+		l.departures = append(l.departures, departure)
+	}
+
+	if l.lostLocked() {
+		l.stats.LostRandom++
+		return false
+	}
+
+	delivery := departure
+	if l.cfg.ReorderPercent > 0 && l.chance(l.cfg.ReorderPercent) {
+		// Skips the delay line entirely
+		l.stats.Reordered++
+	} else {
+		delivery = delivery.Add(l.delayLocked())
+		// Jitter alone must not reorder packets
+		if delivery.Before(l.lastDelivery) {
+			delivery = l.lastDelivery
+		}
+		l.lastDelivery = delivery
+	}
+
+	data := make([]byte, len(p))
+	copy(data, p)
+	l.seq++
+	heap.Push(&l.inFlight, &packet{
+		at:   delivery,
+		seq:  l.seq,
+		conn: pc,
+		addr: addr,
+		data: data,
+	})
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Token bucket: the departure time of a packet of the given size that joins
+// the end of the queue at time now.
+func (l *Link) departAtLocked(now time.Time, size int) time.Time {
+	start := now
+	if l.lastDeparture.After(start) {
+		start = l.lastDeparture
+	}
+	l.refillLocked(start)
+	need := float64(size)
+	if l.tokens < need {
+		wait := time.Duration((need - l.tokens) / l.rateBytesPerSec * float64(time.Second))
+		start = start.Add(wait)
+		l.tokens = need
+		l.tokensAt = start
+	}
+	l.tokens -= need
+	l.lastDeparture = start
+	return start
+}
+
+func (l *Link) refillLocked(t time.Time) {
+	if t.After(l.tokensAt) {
+		if l.rateBytesPerSec > 0 {
+			l.tokens += t.Sub(l.tokensAt).Seconds() * l.rateBytesPerSec
+			if l.tokens > l.burst {
+				l.tokens = l.burst
+			}
+		}
+		l.tokensAt = t
+	}
+}
+
+// Forget packets that already left the queue.
+func (l *Link) expireDeparturesLocked(now time.Time) {
+	i := 0
+	for i < len(l.departures) && !l.departures[i].After(now) {
+		i++
+	}
+	if i > 0 {
+		l.departures = append(l.departures[:0], l.departures[i:]...)
+	}
+}
+
+func (l *Link) lostLocked() bool {
+	ge := l.cfg.GilbertElliott
+	if ge == nil {
+		return l.cfg.LossPercent > 0 && l.chance(l.cfg.LossPercent)
+	}
+	if l.geBad {
+		if l.chance(ge.R) {
+			l.geBad = false
+		}
+	} else if l.chance(ge.P) {
+		l.geBad = true
+	}
+	if l.geBad {
+		return l.chance(ge.LossBad)
+	}
+	return l.chance(ge.LossGood)
+}
+
+func (l *Link) delayLocked() time.Duration {
+	d := l.cfg.Delay
+	if l.cfg.Jitter > 0 {
+		d += time.Duration((l.rng.Float64()*2 - 1) * float64(l.cfg.Jitter))
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+func (l *Link) chance(percent float64) bool {
+	return l.rng.Float64()*100 < percent
+}
+
+// Hands packets to their sockets when their delivery time comes.
+func (l *Link) deliverLoop() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		l.mu.Lock()
+		var due []*packet
+		now := l.now()
+		for l.inFlight.Len() > 0 && !l.inFlight[0].at.After(now) {
+			due = append(due, heap.Pop(&l.inFlight).(*packet))
+		}
+		wait := time.Hour
+		if l.inFlight.Len() > 0 {
+			wait = l.inFlight[0].at.Sub(now)
+		}
+		l.stats.Delivered += uint64(len(due))
+		l.mu.Unlock()
+
+		for _, p := range due {
+			_, _ = p.conn.WriteTo(p.data, p.addr)
+		}
+		if len(due) > 0 {
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-l.done:
+			return
+		case <-l.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// ----------------------------- Delay line ---------------------------------
+
+type packet struct {
+	at   time.Time
+	seq  uint64
+	conn net.PacketConn
+	addr net.Addr
+	data []byte
+}
+
+type packetHeap []*packet
+
+func (h packetHeap) Len() int { return len(h) }
+
+func (h packetHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+
+func (h packetHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *packetHeap) Push(x any) { *h = append(*h, x.(*packet)) }
+
+func (h *packetHeap) Pop() any {
+	old := *h
+	n := len(old)
+	p := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return p
+}
+
+// ----------------------------- PacketConn ---------------------------------
+
+type conn struct {
+	net.PacketConn
+	link *Link
+}
+
+// WriteTo never blocks. Dropped packets are reported as written, as a real
+// network would.
+func (c *conn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.link.send(c.PacketConn, p, addr)
+	return len(p), nil
+}
+
+// Lets quic-go tune the socket buffers of the wrapped connection.
+func (c *conn) SetReadBuffer(bytes int) error {
+	if b, ok := c.PacketConn.(interface{ SetReadBuffer(int) error }); ok {
+		return b.SetReadBuffer(bytes)
+	}
+	return nil
+}
+
+func (c *conn) SetWriteBuffer(bytes int) error {
+	if b, ok := c.PacketConn.(interface{ SetWriteBuffer(int) error }); ok {
+		return b.SetWriteBuffer(bytes)
+	}
+	return nil
+}
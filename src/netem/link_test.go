@@ -0,0 +1,154 @@
+package netem_test
+
+import (
+	"main/src/netem"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Records the packets delivered by the link.
+type recorder struct {
+	net.PacketConn
+	mu      sync.Mutex
+	packets [][]byte
+	times   []time.Time
+}
+
+func (r *recorder) WriteTo(p []byte, addr net.Addr) (int, error) {
+	r.mu.Lock()
+	r.packets = append(r.packets, p)
+	r.times = append(r.times, time.Now())
+	r.mu.Unlock()
+	return len(p), nil
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.packets)
+}
+
+func waitFor(r *recorder, n int, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for r.count() < n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Tests if a transparent link delivers every packet in order.
+func TestLink_Transparent(t *testing.T) {
+	link := netem.NewLink(netem.LinkConfig{})
+	defer link.Close()
+	rec := &recorder{}
+	conn := link.Wrap(rec)
+
+	for i := 0; i < 100; i++ {
+		conn.WriteTo([]byte{byte(i)}, nil)
+	}
+	waitFor(rec, 100, time.Second)
+
+	assert.Equal(t, 100, rec.count())
+	for i, p := range rec.packets {
+		assert.Equal(t, byte(i), p[0])
+	}
+}
+
+// Tests if every parameter, including the queue and burst sizes, enables
+// the link.
+func TestLinkConfig_Enabled(t *testing.T) {
+	assert.False(t, netem.LinkConfig{}.Enabled())
+	assert.False(t, netem.LinkConfig{Seed: 1}.Enabled())
+	assert.True(t, netem.LinkConfig{QueuePackets: 10}.Enabled())
+	assert.True(t, netem.LinkConfig{BurstBytes: 3000}.Enabled())
+	assert.True(t, netem.LinkConfig{Delay: time.Millisecond}.Enabled())
+}
+
+// Tests if the delay is applied.
+func TestLink_Delay(t *testing.T) {
+	link := netem.NewLink(netem.LinkConfig{Delay: 50 * time.Millisecond})
+	defer link.Close()
+	rec := &recorder{}
+	conn := link.Wrap(rec)
+
+	start := time.Now()
+	conn.WriteTo([]byte{1}, nil)
+	waitFor(rec, 1, time.Second)
+
+	assert.Equal(t, 1, rec.count())
+	assert.True(t, rec.times[0].Sub(start) >= 50*time.Millisecond)
+}
+
+// Tests if the bandwidth limits the delivery rate.
+func TestLink_Bandwidth(t *testing.T) {
+	// 1 Mbps = 125000 B/s; 10 packets of 1250 B take 100 ms, minus the burst
+	link := netem.NewLink(netem.LinkConfig{BandwidthMbps: 1, BurstBytes: 1250})
+	defer link.Close()
+	rec := &recorder{}
+	conn := link.Wrap(rec)
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		conn.WriteTo(make([]byte, 1250), nil)
+	}
+	waitFor(rec, 10, time.Second)
+
+	assert.Equal(t, 10, rec.count())
+	elapsed := rec.times[9].Sub(start)
+	assert.True(t, elapsed >= 85*time.Millisecond, "elapsed %v", elapsed)
+	assert.True(t, elapsed < 300*time.Millisecond, "elapsed %v", elapsed)
+}
+
+// Tests if packets beyond the queue size are dropped.
+func TestLink_Queue(t *testing.T) {
+	link := netem.NewLink(netem.LinkConfig{BandwidthMbps: 0.1, QueuePackets: 5})
+	defer link.Close()
+	rec := &recorder{}
+	conn := link.Wrap(rec)
+
+	for i := 0; i < 20; i++ {
+		conn.WriteTo(make([]byte, 1000), nil)
+	}
+
+	// The first packet leaves at once with the burst; 5 more wait in the queue
+	stats := link.Stats()
+	assert.Equal(t, uint64(20), stats.Sent)
+	assert.Equal(t, uint64(14), stats.DroppedQueue)
+}
+
+// Tests if the random loss rate is close to the configured one.
+func TestLink_Loss(t *testing.T) {
+	link := netem.NewLink(netem.LinkConfig{LossPercent: 20, Seed: 1})
+	defer link.Close()
+	rec := &recorder{}
+	conn := link.Wrap(rec)
+
+	for i := 0; i < 5000; i++ {
+		conn.WriteTo([]byte{0}, nil)
+	}
+
+	lost := float64(link.Stats().LostRandom) / 5000
+	assert.InDelta(t, 0.2, lost, 0.03)
+}
+
+// Tests if Gilbert-Elliott loss only happens in the bad state.
+func TestLink_GilbertElliott(t *testing.T) {
+	ge, err := netem.ParseGilbertElliott("5,50")
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, ge.LossBad)
+
+	link := netem.NewLink(netem.LinkConfig{GilbertElliott: ge, Seed: 1})
+	defer link.Close()
+	conn := link.Wrap(&recorder{})
+
+	for i := 0; i < 10000; i++ {
+		conn.WriteTo([]byte{0}, nil)
+	}
+
+	// Stationary probability of the bad state: p / (p + r) = 5/55
+	lost := float64(link.Stats().LostRandom) / 10000
+	assert.InDelta(t, 5.0/55.0, lost, 0.03)
+}
@@ -0,0 +1,193 @@
+package netem
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lowest bandwidth applied from a trace. Outages (0 Mbps) are clamped to it,
+// since a bandwidth of 0 means an unlimited link.
+const MinTraceMbps = 0.01
+
+// One point of a bandwidth trace: the bandwidth from At until the next sample.
+type BandwidthSample struct {
+	At   time.Duration
+	Mbps float64
+}
+
+// A bandwidth time series, played in real time by the links configured with
+// it.
+type BandwidthTrace struct {
+	Samples []BandwidthSample
+	// Start again from the first sample after the last one. Otherwise the
+	// last bandwidth is kept.
+	Loop bool
+}
+
+// The position of the playback of a trace.
+type TraceStep struct {
+	// Time since the playback started.
+	Elapsed time.Duration
+	// Time in the trace (Elapsed modulo the trace length when looping).
+	TraceTime time.Duration
+	// Index of the current sample.
+	Sample int
+	// Bandwidth applied to the link.
+	Mbps float64
+}
+
+// Loads a "timestamp, Mbps" trace, as in common 4G/LTE/5G throughput logs.
+// Timestamps are in seconds, and relative to the first one, so both epoch
+// and relative timestamps work. Empty lines, "#" comments and a header line
+// are skipped.
+func LoadBandwidthTrace(path string) (*BandwidthTrace, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	trace := &BandwidthTrace{}
+	var first float64
+	header := false
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == ';' })
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"timestamp, Mbps\", got %q", path, line, text)
+		}
+		timestamp, err1 := strconv.ParseFloat(fields[0], 64)
+		mbps, err2 := strconv.ParseFloat(fields[1], 64)
+		if err1 != nil || err2 != nil {
+			if len(trace.Samples) == 0 && !header {
+				header = true
+				continue
+			}
+			return nil, fmt.Errorf("%s:%d: invalid sample %q", path, line, text)
+		}
+		if len(trace.Samples) == 0 {
+			first = timestamp
+		}
+		at := time.Duration((timestamp - first) * float64(time.Second))
+		if n := len(trace.Samples); n > 0 && at < trace.Samples[n-1].At {
+			return nil, fmt.Errorf("%s:%d: timestamps must not decrease", path, line)
+		}
+		if mbps < 0 {
+			return nil, fmt.Errorf("%s:%d: negative bandwidth", path, line)
+		}
+		trace.Samples = append(trace.Samples, BandwidthSample{At: at, Mbps: mbps})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(trace.Samples) == 0 {
+		return nil, fmt.Errorf("%s: empty trace", path)
+	}
+	return trace, nil
+}
+
+// Length of one pass over the trace. The last sample lasts as long as the
+// interval before it.
+func (t *BandwidthTrace) Duration() time.Duration {
+	n := len(t.Samples)
+	if n < 2 {
+		return 0
+	}
+	last := t.Samples[n-1].At
+	return last + (last - t.Samples[n-2].At)
+}
+
+// The playback position after elapsed time, and the time until the next
+// sample (0 if the bandwidth does not change anymore).
+func (t *BandwidthTrace) Position(elapsed time.Duration) (TraceStep, time.Duration) {
+	step := TraceStep{Elapsed: elapsed, TraceTime: elapsed}
+	duration := t.Duration()
+	if t.Loop && duration > 0 {
+		step.TraceTime = elapsed % duration
+	}
+
+	i := 0
+	for i+1 < len(t.Samples) && t.Samples[i+1].At <= step.TraceTime {
+		i++
+	}
+	step.Sample = i
+	step.Mbps = t.Samples[i].Mbps
+	if step.Mbps < MinTraceMbps {
+		step.Mbps = MinTraceMbps
+	}
+
+	var next time.Duration
+	switch {
+	case i+1 < len(t.Samples):
+		next = t.Samples[i+1].At - step.TraceTime
+	case t.Loop && duration > 0:
+		next = duration - step.TraceTime
+	}
+	return step, next
+}
+
+// Current position of the bandwidth trace, if the link plays one.
+func (l *Link) TraceStep() (TraceStep, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.traceStep, l.cfg.BandwidthTrace != nil
+}
+
+// Calls fn with the current trace position and then at every bandwidth
+// change, until the returned function is called. Does nothing if the link
+// plays no trace. fn runs with the link locked and must not call it.
+func (l *Link) OnTraceStep(fn func(TraceStep)) (cancel func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cfg.BandwidthTrace == nil {
+		return func() {}
+	}
+	if l.traceObservers == nil {
+		l.traceObservers = make(map[int]func(TraceStep))
+	}
+	id := l.traceObserverN
+	l.traceObserverN++
+	l.traceObservers[id] = fn
+	fn(l.traceStep)
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.traceObservers, id)
+	}
+}
+
+// Applies the samples of the trace as their time comes.
+func (l *Link) playTrace(trace *BandwidthTrace, start time.Time) {
+	_, next := trace.Position(0)
+	for next > 0 {
+		timer := time.NewTimer(next)
+		select {
+		case <-l.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		var step TraceStep
+		step, next = trace.Position(l.now().Sub(start))
+
+		l.mu.Lock()
+		l.refillLocked(l.now())
+		cfg := l.cfg
+		cfg.BandwidthMbps = step.Mbps
+		l.setConfigLocked(cfg)
+		l.traceStep = step
+		for _, fn := range l.traceObservers {
+			fn(step)
+		}
+		l.mu.Unlock()
+	}
+}
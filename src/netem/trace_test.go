@@ -0,0 +1,100 @@
+package netem_test
+
+import (
+	"main/src/netem"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTrace(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "trace.csv")
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+// Tests if header, comments and epoch timestamps are handled
+func TestLoadBandwidthTrace(t *testing.T) {
+	path := writeTrace(t, "# LTE walk\ntimestamp,mbps\n1600000000.0, 20\n1600000000.5, 5.5\n\n1600000001.5 0\n")
+	trace, err := netem.LoadBandwidthTrace(path)
+	assert.Nil(t, err)
+	assert.Equal(t, []netem.BandwidthSample{
+		{At: 0, Mbps: 20},
+		{At: 500 * time.Millisecond, Mbps: 5.5},
+		{At: 1500 * time.Millisecond, Mbps: 0},
+	}, trace.Samples)
+	assert.Equal(t, 2500*time.Millisecond, trace.Duration())
+}
+
+// Tests if invalid traces are rejected
+func TestLoadBandwidthTrace_Invalid(t *testing.T) {
+	for _, content := range []string{
+		"",
+		"timestamp,mbps\n",
+		"0,10\nfoo,bar\n",
+		"0,10\n1\n",
+		"1,10\n0,10\n",
+		"0,-1\n",
+	} {
+		_, err := netem.LoadBandwidthTrace(writeTrace(t, content))
+		assert.NotNil(t, err, content)
+	}
+}
+
+// Tests the playback position, with and without looping
+func TestBandwidthTrace_Position(t *testing.T) {
+	trace := &netem.BandwidthTrace{Samples: []netem.BandwidthSample{
+		{At: 0, Mbps: 10},
+		{At: time.Second, Mbps: 0},
+	}}
+
+	step, next := trace.Position(500 * time.Millisecond)
+	assert.Equal(t, 0, step.Sample)
+	assert.Equal(t, 10.0, step.Mbps)
+	assert.Equal(t, 500*time.Millisecond, next)
+
+	// outages are clamped, and the last sample is kept
+	step, next = trace.Position(5 * time.Second)
+	assert.Equal(t, 1, step.Sample)
+	assert.Equal(t, netem.MinTraceMbps, step.Mbps)
+	assert.Equal(t, time.Duration(0), next)
+
+	trace.Loop = true
+	step, next = trace.Position(4500 * time.Millisecond)
+	assert.Equal(t, 500*time.Millisecond, step.TraceTime)
+	assert.Equal(t, 0, step.Sample)
+	assert.Equal(t, 500*time.Millisecond, next)
+}
+
+// Tests if the link applies the samples in real time
+func TestLink_BandwidthTrace(t *testing.T) {
+	link := netem.NewLink(netem.LinkConfig{BandwidthTrace: &netem.BandwidthTrace{Samples: []netem.BandwidthSample{
+		{At: 0, Mbps: 10},
+		{At: 20 * time.Millisecond, Mbps: 20},
+		{At: 40 * time.Millisecond, Mbps: 30},
+	}}})
+	defer link.Close()
+	assert.Equal(t, 10.0, link.Config().BandwidthMbps)
+
+	var mu sync.Mutex
+	var steps []netem.TraceStep
+	cancel := link.OnTraceStep(func(step netem.TraceStep) {
+		mu.Lock()
+		steps = append(steps, step)
+		mu.Unlock()
+	})
+	defer cancel()
+
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 3, len(steps))
+	for i, step := range steps {
+		assert.Equal(t, i, step.Sample)
+	}
+	assert.Equal(t, 30.0, link.Config().BandwidthMbps)
+}
@@ -0,0 +1,254 @@
+// Package runinfo gives every run its own directory and describes it in
+// <run dir>/manifest.json: run ID, start and end times, effective
+// configuration, build and host, and the files the run wrote.
+package runinfo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"main/src/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ManifestName is the file name of the manifest in the run directory.
+const ManifestName = "manifest.json"
+
+const quicGoModule = "github.com/lucas-clemente/quic-go"
+
+// Manifest is the content of manifest.json.
+type Manifest struct {
+	// Name of the run directory.
+	RunID   string   `json:"run_id"`
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	// running while the run goes on, then ok or failed.
+	Status string     `json:"status"`
+	Error  string     `json:"error,omitempty"`
+	Start  time.Time  `json:"start"`
+	End    *time.Time `json:"end,omitempty"`
+	// Queue policy of the server.
+	Policy string        `json:"policy"`
+	Config config.Config `json:"config"`
+	Build  Build         `json:"build"`
+	Host   Host          `json:"host"`
+	// Files of the run directory, manifest excluded, when the run ended.
+	Files []File `json:"files"`
+}
+
+type Build struct {
+	// Git commit the binary was built from, if known, and whether the tree
+	// had uncommitted changes.
+	GitRevision string `json:"git_revision"`
+	GitModified bool   `json:"git_modified"`
+	GoVersion   string `json:"go_version"`
+	QuicGo      string `json:"quic_go_version"`
+}
+
+type Host struct {
+	Hostname string `json:"hostname"`
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	CPUs     int    `json:"cpus"`
+	PID      int    `json:"pid"`
+}
+
+type File struct {
+	// Relative to the run directory, with forward slashes.
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Run is a run directory with its manifest.
+type Run struct {
+	Dir string
+
+	mu       sync.Mutex
+	manifest Manifest
+}
+
+// NewDir returns a directory under base named <timestamp>-<label> (with -2,
+// -3... if it is taken) that does not exist yet.
+func NewDir(base, label string) string {
+	name := time.Now().Format("20060102-150405")
+	if label != "" {
+		name += "-" + label
+	}
+	dir := filepath.Join(base, name)
+	for i := 2; exists(dir); i++ {
+		dir = filepath.Join(base, fmt.Sprintf("%s-%d", name, i))
+	}
+	return dir
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Start creates the run directory, which must not exist or be empty so runs
+// never mix, and writes a manifest with status running.
+func Start(dir, command string, cfg config.Config) (*Run, error) {
+	entries, err := os.ReadDir(dir)
+	if err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("run directory %s is not empty", dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	r := &Run{
+		Dir: dir,
+		manifest: Manifest{
+			RunID:   filepath.Base(dir),
+			Command: command,
+			Args:    os.Args[1:],
+			Status:  "running",
+			Start:   time.Now(),
+			Policy:  cfg.Server.Policy,
+			Config:  cfg,
+			Build:   readBuild(),
+			Host:    readHost(),
+			Files:   []File{},
+		},
+	}
+	if err := r.write(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ID returns the run ID, the name of the run directory.
+func (r *Run) ID() string {
+	return r.manifest.RunID
+}
+
+// Finish records the end of the run, its outcome and the files in the run
+// directory.
+func (r *Run) Finish(runErr error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	end := time.Now()
+	r.manifest.End = &end
+	r.manifest.Status = "ok"
+	if runErr != nil {
+		r.manifest.Status = "failed"
+		r.manifest.Error = runErr.Error()
+	}
+	files, err := listFiles(r.Dir)
+	if err != nil {
+		return err
+	}
+	r.manifest.Files = files
+	return r.writeLocked()
+}
+
+func (r *Run) write() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.writeLocked()
+}
+
+// Written to a temporary file and renamed, so a reader never sees half of it.
+func (r *Run) writeLocked() error {
+	data, err := json.MarshalIndent(r.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(r.Dir, ManifestName)
+	if err := os.WriteFile(path+".tmp", append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// ReadManifest reads the manifest of a run directory.
+func ReadManifest(dir string) (Manifest, error) {
+	var manifest Manifest
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(data, &manifest)
+	return manifest, err
+}
+
+func listFiles(dir string) ([]File, error) {
+	files := []File{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == ManifestName || rel == ManifestName+".tmp" {
+			return nil
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil // removed meanwhile
+		}
+		if err != nil {
+			return err
+		}
+		files = append(files, File{Path: rel, Bytes: info.Size()})
+		return nil
+	})
+	return files, err
+}
+
+// The revision comes from the build stamps of "go build", or from git in the
+// working directory for "go run" and tests.
+func readBuild() Build {
+	build := Build{GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				build.GitRevision = setting.Value
+			case "vcs.modified":
+				build.GitModified = setting.Value == "true"
+			}
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == quicGoModule {
+				build.QuicGo = dep.Version
+				if dep.Replace != nil {
+					build.QuicGo = dep.Replace.Version
+				}
+			}
+		}
+	}
+	if build.GitRevision == "" {
+		if out, err := exec.Command("git", "rev-parse", "HEAD").Output(); err == nil {
+			build.GitRevision = strings.TrimSpace(string(out))
+			out, err := exec.Command("git", "status", "--porcelain", "--untracked-files=no").Output()
+			build.GitModified = err == nil && len(strings.TrimSpace(string(out))) > 0
+		}
+	}
+	return build
+}
+
+func readHost() Host {
+	hostname, _ := os.Hostname()
+	return Host{
+		Hostname: hostname,
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		CPUs:     runtime.NumCPU(),
+		PID:      os.Getpid(),
+	}
+}
@@ -0,0 +1,70 @@
+package runinfo_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"main/src/config"
+	"main/src/runinfo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests if the manifest is written on Start and completed on Finish with the
+// files of the run
+func TestRun_Manifest(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "run1")
+	cfg := config.Default()
+	cfg.Server.Policy = "sp"
+
+	run, err := runinfo.Start(dir, "experiment", cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, "run1", run.ID())
+	manifest, err := runinfo.ReadManifest(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, "running", manifest.Status)
+	assert.Nil(t, manifest.End)
+	assert.Equal(t, "sp", manifest.Policy)
+	assert.Equal(t, cfg, manifest.Config)
+	assert.Equal(t, runtime.Version(), manifest.Build.GoVersion)
+	assert.Equal(t, os.Getpid(), manifest.Host.PID)
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "server"), 0o755))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "server", "reqlog.csv"), []byte("a,b\n"), 0o644))
+	assert.Nil(t, run.Finish(nil))
+
+	manifest, err = runinfo.ReadManifest(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", manifest.Status)
+	assert.True(t, manifest.End != nil && !manifest.End.Before(manifest.Start))
+	assert.Equal(t, []runinfo.File{{Path: "server/reqlog.csv", Bytes: 4}}, manifest.Files)
+}
+
+// Tests if a failed run keeps its error
+func TestRun_Failed(t *testing.T) {
+	dir := t.TempDir()
+	run, err := runinfo.Start(dir, "sim", config.Default())
+	assert.Nil(t, err)
+	assert.Nil(t, run.Finish(errors.New("boom")))
+
+	manifest, err := runinfo.ReadManifest(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, "failed", manifest.Status)
+	assert.Equal(t, "boom", manifest.Error)
+}
+
+// Tests if runs never share a directory
+func TestRun_NotEmpty(t *testing.T) {
+	base := t.TempDir()
+	dir := runinfo.NewDir(base, "wfq")
+	_, err := runinfo.Start(dir, "experiment", config.Default())
+	assert.Nil(t, err)
+
+	_, err = runinfo.Start(dir, "experiment", config.Default())
+	assert.NotNil(t, err)
+	other := runinfo.NewDir(base, "wfq")
+	assert.NotEqual(t, dir, other)
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"main/src/server/metrics"
+	"main/src/server/stream_handler"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Admin API: a local HTTP listener to inspect and control a running server.
+//
+//	GET  /connections  connections, their streams and queue lengths
+//	GET  /queue        queued tasks with age and deadline
+//	GET  /policy       policy and WFQ weights of the server and of each connection
+//	POST /policy       switch the policy and/or the weights: {"policy": "sp", "wfq_weights": {...}}
+//	POST /pause        stop starting new tasks (queued tasks wait)
+//	POST /resume       serve the queues again
+//	POST /flush        write a partial summary row to the server_summary CSVs
+//
+// Every endpoint takes ?connection=<id> to act on one connection. Without it,
+// /policy, /pause and /resume also apply to later connections, and /flush
+// writes the server aggregate too.
+
+type adminConnection struct {
+	ID         int                       `json:"id"`
+	RemoteAddr string                    `json:"remote_addr"`
+	Policy     string                    `json:"policy"`
+	WFQWeights stream_handler.WFQWeights `json:"wfq_weights"`
+	Paused     bool                      `json:"paused"`
+	Queued     map[string]int            `json:"queued"`
+	Streams    []adminStream             `json:"streams"`
+}
+
+type adminStream struct {
+	ID       int64   `json:"id"`
+	AgeS     float64 `json:"age_s"`
+	Requests int64   `json:"requests"`
+	Pending  int64   `json:"pending"`
+}
+
+type adminTask struct {
+	Connection int     `json:"connection"`
+	Class      string  `json:"class"`
+	Segment    int     `json:"segment"`
+	Tile       int     `json:"tile"`
+	AgeMs      float64 `json:"age_ms"`
+	// Time left until the deadline (negative once missed)
+	DeadlineInMs float64 `json:"deadline_in_ms"`
+}
+
+type adminPolicy struct {
+	Policy     string                    `json:"policy"`
+	WFQWeights stream_handler.WFQWeights `json:"wfq_weights"`
+	Paused     bool                      `json:"paused"`
+	// Per connection, in GET responses
+	Connections map[string]adminPolicy `json:"connections,omitempty"`
+}
+
+// SetAdminAddr serves the admin API at http://addr. Must be called before
+// Start. Bind it to a local address: the API is not authenticated.
+func (s *Server) SetAdminAddr(addr string) {
+	s.adminAddr = addr
+}
+
+// AdminHandler serves the admin API endpoints, as served at SetAdminAddr.
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/connections", s.adminOnly("GET", s.adminConnections))
+	mux.HandleFunc("/queue", s.adminOnly("GET", s.adminQueue))
+	mux.HandleFunc("/policy", s.adminPolicy)
+	mux.HandleFunc("/pause", s.adminOnly("POST", func(w http.ResponseWriter, r *http.Request) { s.adminPause(w, r, true) }))
+	mux.HandleFunc("/resume", s.adminOnly("POST", func(w http.ResponseWriter, r *http.Request) { s.adminPause(w, r, false) }))
+	mux.HandleFunc("/flush", s.adminOnly("POST", s.adminFlush))
+	return mux
+}
+
+func (s *Server) serveAdmin() error {
+	listener, err := net.Listen("tcp", s.adminAddr)
+	if err != nil {
+		return fmt.Errorf("admin API: %w", err)
+	}
+	s.adminServer = &http.Server{Handler: s.AdminHandler()}
+	go func() {
+		if err := s.adminServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("admin API: %v", err)
+		}
+	}()
+	log.Printf("Admin API at http://%s", listener.Addr())
+	return nil
+}
+
+func (s *Server) stopAdmin() {
+	if s.adminServer != nil {
+		_ = s.adminServer.Close()
+	}
+}
+
+func (s *Server) adminOnly(method string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// Handlers of the running connections, by ID, filtered by ?connection=.
+// all reports whether no filter was given.
+func (s *Server) adminHandlers(r *http.Request) (handlers []*stream_handler.StreamHandler, all bool, err error) {
+	filter := 0
+	if value := r.URL.Query().Get("connection"); value != "" {
+		if filter, err = strconv.Atoi(value); err != nil || filter <= 0 {
+			return nil, false, fmt.Errorf("invalid connection %q", value)
+		}
+	}
+	s.mu.Lock()
+	for _, handler := range s.connections {
+		if handler != nil && (filter == 0 || handler.ConnectionID() == filter) {
+			handlers = append(handlers, handler)
+		}
+	}
+	s.mu.Unlock()
+	if filter != 0 && len(handlers) == 0 {
+		return nil, false, fmt.Errorf("no connection %d", filter)
+	}
+	sort.Slice(handlers, func(i, j int) bool { return handlers[i].ConnectionID() < handlers[j].ConnectionID() })
+	return handlers, filter == 0, nil
+}
+
+func (s *Server) adminConnections(w http.ResponseWriter, r *http.Request) {
+	handlers, _, err := s.adminHandlers(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	connections := []adminConnection{}
+	for _, handler := range handlers {
+		scheduler := handler.Scheduler()
+		policy, weights := scheduler.Policy()
+		c := adminConnection{
+			ID:         handler.ConnectionID(),
+			RemoteAddr: handler.Connection().RemoteAddr().String(),
+			Policy:     string(policy),
+			WFQWeights: weights,
+			Paused:     scheduler.Paused(),
+			Queued:     map[string]int{},
+			Streams:    []adminStream{},
+		}
+		for class, n := range scheduler.QueueLenPerClass() {
+			c.Queued[metrics.ClassName(class)] = n
+		}
+		for _, st := range handler.Streams() {
+			c.Streams = append(c.Streams, adminStream{
+				ID:       int64(st.ID),
+				AgeS:     now.Sub(st.Opened).Seconds(),
+				Requests: st.Requests,
+				Pending:  st.Pending,
+			})
+		}
+		connections = append(connections, c)
+	}
+	writeJSON(w, connections)
+}
+
+func (s *Server) adminQueue(w http.ResponseWriter, r *http.Request) {
+	handlers, _, err := s.adminHandlers(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	tasks := []adminTask{}
+	for _, handler := range handlers {
+		for _, t := range handler.Scheduler().QueuedTasks() {
+			task := adminTask{
+				Connection: handler.ConnectionID(),
+				Class:      metrics.ClassName(t.Class),
+				Segment:    t.Segment,
+				Tile:       t.Tile,
+				AgeMs:      float64(now.Sub(t.Enqueued)) / float64(time.Millisecond),
+			}
+			if !t.Deadline.IsZero() {
+				task.DeadlineInMs = float64(t.Deadline.Sub(now)) / float64(time.Millisecond)
+			}
+			tasks = append(tasks, task)
+		}
+	}
+	writeJSON(w, tasks)
+}
+
+func (s *Server) adminPolicy(w http.ResponseWriter, r *http.Request) {
+	handlers, all, err := s.adminHandlers(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var change struct {
+			Policy     string                     `json:"policy"`
+			WFQWeights *stream_handler.WFQWeights `json:"wfq_weights"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&change); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var policy stream_handler.QueuePolicy
+		if change.Policy != "" {
+			if policy, err = stream_handler.ParseQueuePolicy(change.Policy); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if change.WFQWeights != nil && (change.WFQWeights.High <= 0 || change.WFQWeights.Medium <= 0 || change.WFQWeights.Low <= 0) {
+			http.Error(w, "wfq_weights must be positive", http.StatusBadRequest)
+			return
+		}
+		// missing fields keep the current values
+		apply := func(current stream_handler.QueuePolicy, weights stream_handler.WFQWeights) (stream_handler.QueuePolicy, stream_handler.WFQWeights) {
+			if policy != "" {
+				current = policy
+			}
+			if change.WFQWeights != nil {
+				weights = *change.WFQWeights
+			}
+			return current, weights
+		}
+		// only a change for the whole server changes the weights of the
+		// aggregate metrics
+		if all {
+			s.mu.Lock()
+			s.queuePolicy, s.wfqWeights = apply(s.queuePolicy, s.wfqWeights)
+			stream_handler.ExposeWeights(s.metrics, s.queuePolicy, s.wfqWeights)
+			s.mu.Unlock()
+		}
+		for _, handler := range handlers {
+			handler.Scheduler().UpdatePolicy(apply)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.Lock()
+	response := adminPolicy{
+		Policy:      string(s.queuePolicy),
+		WFQWeights:  s.wfqWeights,
+		Paused:      s.paused,
+		Connections: map[string]adminPolicy{},
+	}
+	s.mu.Unlock()
+	for _, handler := range handlers {
+		policy, weights := handler.Scheduler().Policy()
+		response.Connections[strconv.Itoa(handler.ConnectionID())] = adminPolicy{
+			Policy:     string(policy),
+			WFQWeights: weights,
+			Paused:     handler.Scheduler().Paused(),
+		}
+	}
+	writeJSON(w, response)
+}
+
+func (s *Server) adminPause(w http.ResponseWriter, r *http.Request, pause bool) {
+	handlers, all, err := s.adminHandlers(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if all {
+		s.mu.Lock()
+		s.paused = pause
+		s.mu.Unlock()
+	}
+	ids := []int{}
+	for _, handler := range handlers {
+		if pause {
+			handler.Scheduler().Pause()
+		} else {
+			handler.Scheduler().Resume()
+		}
+		ids = append(ids, handler.ConnectionID())
+	}
+	log.Printf("[ADMIN] paused=%t connections=%v", pause, ids)
+	writeJSON(w, map[string]interface{}{"paused": pause, "connections": ids})
+}
+
+func (s *Server) adminFlush(w http.ResponseWriter, r *http.Request) {
+	handlers, all, err := s.adminHandlers(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if all {
+		s.metrics.FlushSummary()
+	}
+	ids := []int{}
+	for _, handler := range handlers {
+		handler.Metrics().FlushSummary()
+		ids = append(ids, handler.ConnectionID())
+	}
+	writeJSON(w, map[string]interface{}{"flushed": ids})
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(value)
+}
@@ -0,0 +1,193 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"main/src/model"
+	"main/src/server"
+	"main/src/server/stream_handler"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type policyResponse struct {
+	Policy      string                    `json:"policy"`
+	WFQWeights  stream_handler.WFQWeights `json:"wfq_weights"`
+	Paused      bool                      `json:"paused"`
+	Connections map[string]policyResponse `json:"connections"`
+}
+
+type queuedTask struct {
+	Connection int    `json:"connection"`
+	Class      string `json:"class"`
+	Segment    int    `json:"segment"`
+	Tile       int    `json:"tile"`
+}
+
+// A listening server with one client connection and its admin API.
+func startAdmin(t *testing.T) (*httptest.Server, quic.Connection, string) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	port := udp.LocalAddr().(*net.UDPAddr).Port
+	udp.Close()
+
+	dir := t.TempDir()
+	srv := server.NewServer("127.0.0.1", port, "wfq")
+	srv.SetOutputDir(dir)
+	if err := srv.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve()
+	admin := httptest.NewServer(srv.AdminHandler())
+	t.Cleanup(func() {
+		admin.Close()
+		srv.Stop()
+	})
+
+	tlsConf := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"quic-streaming"}}
+	connection, err := quic.DialAddr(fmt.Sprintf("127.0.0.1:%d", port), tlsConf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = connection.CloseWithError(0, "") })
+
+	// the connection is listed once the server accepted it
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var policy policyResponse
+		call(t, admin, "GET", "/policy", "", http.StatusOK, &policy)
+		if len(policy.Connections) == 1 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return admin, connection, dir
+}
+
+// Sends a request to the admin API, checks the status and decodes the JSON
+// response into out (if not nil).
+func call(t *testing.T, admin *httptest.Server, method, path, body string, status int, out interface{}) {
+	request, err := http.NewRequest(method, admin.URL+path, bytes.NewBufferString(body))
+	assert.Nil(t, err)
+	response, err := admin.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	assert.Equal(t, status, response.StatusCode, "%s %s", method, path)
+	if out != nil && response.StatusCode == http.StatusOK {
+		assert.Nil(t, json.NewDecoder(response.Body).Decode(out))
+	}
+}
+
+// Tests if /policy reports the policies and changes the server and every
+// connection, or a single connection without touching the server.
+func TestAdmin_Policy(t *testing.T) {
+	admin, _, _ := startAdmin(t)
+
+	var policy policyResponse
+	call(t, admin, "GET", "/policy", "", http.StatusOK, &policy)
+	assert.Equal(t, "wfq", policy.Policy)
+	assert.Equal(t, stream_handler.DefaultWFQWeights, policy.WFQWeights)
+	assert.Equal(t, "wfq", policy.Connections["1"].Policy)
+
+	// one connection: the server keeps its policy
+	call(t, admin, "POST", "/policy?connection=1", `{"policy": "sp"}`, http.StatusOK, &policy)
+	assert.Equal(t, "wfq", policy.Policy)
+	assert.Equal(t, "sp", policy.Connections["1"].Policy)
+	assert.Equal(t, stream_handler.DefaultWFQWeights, policy.Connections["1"].WFQWeights)
+
+	// the whole server: missing fields keep the current values
+	weights := stream_handler.WFQWeights{High: 8, Medium: 4, Low: 1}
+	call(t, admin, "POST", "/policy", `{"wfq_weights": {"high": 8, "medium": 4, "low": 1}}`, http.StatusOK, &policy)
+	assert.Equal(t, "wfq", policy.Policy)
+	assert.Equal(t, weights, policy.WFQWeights)
+	assert.Equal(t, "sp", policy.Connections["1"].Policy)
+	assert.Equal(t, weights, policy.Connections["1"].WFQWeights)
+
+	call(t, admin, "GET", "/policy?connection=1", "", http.StatusOK, &policy)
+	assert.Equal(t, 1, len(policy.Connections))
+	call(t, admin, "GET", "/policy?connection=7", "", http.StatusNotFound, nil)
+}
+
+// Tests if invalid changes and methods are rejected and change nothing.
+func TestAdmin_Invalid(t *testing.T) {
+	admin, _, _ := startAdmin(t)
+
+	call(t, admin, "POST", "/policy", `{"wfq_weights": {"high": 3, "medium": 0, "low": 1}}`, http.StatusBadRequest, nil)
+	call(t, admin, "POST", "/policy", `{"wfq_weights": {"high": -1, "medium": 2, "low": 1}}`, http.StatusBadRequest, nil)
+	call(t, admin, "POST", "/policy", `{"policy": "edf"}`, http.StatusBadRequest, nil)
+	call(t, admin, "POST", "/policy", `{"weights": {}}`, http.StatusBadRequest, nil)
+	call(t, admin, "DELETE", "/policy", "", http.StatusMethodNotAllowed, nil)
+	call(t, admin, "GET", "/pause", "", http.StatusMethodNotAllowed, nil)
+	call(t, admin, "POST", "/queue", "", http.StatusMethodNotAllowed, nil)
+	call(t, admin, "POST", "/pause?connection=abc", "", http.StatusNotFound, nil)
+
+	var policy policyResponse
+	call(t, admin, "GET", "/policy", "", http.StatusOK, &policy)
+	assert.Equal(t, "wfq", policy.Policy)
+	assert.Equal(t, stream_handler.DefaultWFQWeights, policy.WFQWeights)
+	assert.Equal(t, stream_handler.DefaultWFQWeights, policy.Connections["1"].WFQWeights)
+}
+
+// Tests if a paused connection keeps its requests queued, listed by /queue,
+// until /resume.
+func TestAdmin_PauseQueueResume(t *testing.T) {
+	admin, connection, _ := startAdmin(t)
+
+	call(t, admin, "POST", "/pause?connection=1", "", http.StatusOK, nil)
+	stream, err := connection.OpenStreamSync(context.Background())
+	assert.Nil(t, err)
+	for tile := 1; tile <= 3; tile++ {
+		request := model.VideoPacketRequest{Priority: model.LOW_PRIORITY, Segment: 1, Tile: tile, Timeout: 10000}
+		assert.Nil(t, request.Write(stream))
+	}
+
+	var tasks []queuedTask
+	deadline := time.Now().Add(5 * time.Second)
+	for len(tasks) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		call(t, admin, "GET", "/queue", "", http.StatusOK, &tasks)
+	}
+	assert.Equal(t, 3, len(tasks))
+	for _, task := range tasks {
+		assert.Equal(t, 1, task.Connection)
+		assert.Equal(t, "low", task.Class)
+	}
+
+	call(t, admin, "POST", "/resume?connection=1", "", http.StatusOK, nil)
+	deadline = time.Now().Add(5 * time.Second)
+	for len(tasks) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		call(t, admin, "GET", "/queue?connection=1", "", http.StatusOK, &tasks)
+	}
+	assert.Equal(t, 0, len(tasks))
+}
+
+// Tests if /flush writes a summary row for the server and the connection.
+func TestAdmin_Flush(t *testing.T) {
+	admin, _, dir := startAdmin(t)
+
+	var flushed struct {
+		Flushed []int `json:"flushed"`
+	}
+	call(t, admin, "POST", "/flush", "", http.StatusOK, &flushed)
+	assert.Equal(t, []int{1}, flushed.Flushed)
+	for _, name := range []string{"server_summary.csv", "server_summary-conn1.csv"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		assert.Nil(t, err, name)
+		// header and the partial row
+		assert.Equal(t, 2, bytes.Count(data, []byte("\n")), name)
+	}
+}
@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"time"
+
+	"main/src/model"
+)
+
+// Accounting é o estado do scheduler de um escopo (uma conexão ou o
+// servidor), atualizado a cada evento com o tempo exato dele:
+//
+//	Enqueue -> a tarefa entra na fila da classe
+//	Dequeue -> sai da fila e começa o serviço (conta inversão)
+//	Finish  -> o serviço termina (completa ou descartada por deadline)
+//	Drop    -> sai da fila sem serviço
+//
+// Entre eventos acumula o tempo com backlog (alguma fila > 0), dividido entre
+// com e sem serviço (work-conserving). É a única fonte desses valores:
+// server_summary, work_conserving.csv, queue_len.csv, a fairness ponderada e
+// o Prometheus leem daqui.
+//
+// Não é seguro para uso concorrente (Metrics o protege com seu lock). O
+// tempo é dado por quem chama, real no servidor e virtual no sim.
+type Accounting struct {
+	last       time.Time
+	queued     [model.PRIORITY_LEVEL_COUNT]int
+	inService  int
+	inversions int64
+
+	// desde o início e desde a última janela
+	total, window BacklogTime
+}
+
+// BacklogTime é o tempo com alguma tarefa na fila.
+type BacklogTime struct {
+	Busy time.Duration // fila > 0 e alguma tarefa em serviço
+	Idle time.Duration // fila > 0 e nada em serviço
+}
+
+// Backlog é o tempo total com fila > 0.
+func (b BacklogTime) Backlog() time.Duration {
+	return b.Busy + b.Idle
+}
+
+// NewAccounting começa sem fila nem serviço em now.
+func NewAccounting(now time.Time) *Accounting {
+	return &Accounting{last: now}
+}
+
+// advance acumula o tempo desde o último evento no estado de então. Eventos
+// de goroutines diferentes podem chegar um pouco fora de ordem: um now
+// anterior ao último não conta tempo.
+func (a *Accounting) advance(now time.Time) {
+	dt := now.Sub(a.last)
+	if dt <= 0 {
+		return
+	}
+	a.last = now
+	if a.Backlog() == 0 {
+		return
+	}
+	if a.inService > 0 {
+		a.total.Busy += dt
+		a.window.Busy += dt
+	} else {
+		a.total.Idle += dt
+		a.window.Idle += dt
+	}
+}
+
+// Enqueue põe uma tarefa na fila de class.
+func (a *Accounting) Enqueue(now time.Time, class Class) {
+	a.advance(now)
+	a.queued[class]++
+}
+
+// Dequeue tira uma tarefa de class da fila para o serviço. É inversão
+// começar uma classe com outra de prioridade maior (model.Priority menor)
+// esperando. Com inversion nil a decisão vem das filas deste escopo; senão
+// vale o valor dado (o agregado do servidor repete a decisão da conexão,
+// cujas filas são as que importam). Retorna se contou uma inversão.
+func (a *Accounting) Dequeue(now time.Time, class Class, inversion *bool) bool {
+	a.advance(now)
+	if a.queued[class] > 0 {
+		a.queued[class]--
+	}
+	a.inService++
+	inverted := inversion != nil && *inversion
+	if inversion == nil {
+		for c := Class(0); c < class; c++ {
+			if a.queued[c] > 0 {
+				inverted = true
+			}
+		}
+	}
+	if inverted {
+		a.inversions++
+	}
+	return inverted
+}
+
+// Finish termina o serviço de uma tarefa.
+func (a *Accounting) Finish(now time.Time) {
+	a.advance(now)
+	if a.inService > 0 {
+		a.inService--
+	}
+}
+
+// Drop tira uma tarefa de class da fila sem servi-la.
+func (a *Accounting) Drop(now time.Time, class Class) {
+	a.advance(now)
+	if a.queued[class] > 0 {
+		a.queued[class]--
+	}
+}
+
+// Queued retorna o tamanho da fila de class.
+func (a *Accounting) Queued(class Class) int {
+	return a.queued[class]
+}
+
+// Backlog retorna o total de tarefas na fila.
+func (a *Accounting) Backlog() int {
+	total := 0
+	for _, n := range a.queued {
+		total += n
+	}
+	return total
+}
+
+// InService retorna quantas tarefas estão em serviço.
+func (a *Accounting) InService() int {
+	return a.inService
+}
+
+// Inversions retorna quantas inversões de prioridade houve.
+func (a *Accounting) Inversions() int64 {
+	return a.inversions
+}
+
+// Total retorna o tempo com backlog desde o início até now.
+func (a *Accounting) Total(now time.Time) BacklogTime {
+	a.advance(now)
+	return a.total
+}
+
+// Window retorna o tempo com backlog desde a última janela até now e começa
+// outra.
+func (a *Accounting) Window(now time.Time) BacklogTime {
+	a.advance(now)
+	w := a.window
+	a.window = BacklogTime{}
+	return w
+}
@@ -0,0 +1,69 @@
+package metrics_test
+
+import (
+	"main/src/model"
+	"main/src/server/metrics"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var t0 = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func at(ms int) time.Time {
+	return t0.Add(time.Duration(ms) * time.Millisecond)
+}
+
+// Tests if the time with backlog is split exactly into busy and idle at the
+// event times, in total and per window
+func TestAccounting_Backlog(t *testing.T) {
+	a := metrics.NewAccounting(t0)
+	a.Enqueue(at(10), model.LOW_PRIORITY) // backlog, idle
+	a.Enqueue(at(20), model.LOW_PRIORITY)
+	a.Dequeue(at(30), model.LOW_PRIORITY, nil) // busy
+	assert.Equal(t, metrics.BacklogTime{Busy: 20 * time.Millisecond, Idle: 20 * time.Millisecond}, a.Window(at(50)))
+	a.Finish(at(80))                           // idle again
+	a.Dequeue(at(90), model.LOW_PRIORITY, nil) // no backlog
+	a.Finish(at(150))
+
+	assert.Equal(t, metrics.BacklogTime{Busy: 30 * time.Millisecond, Idle: 10 * time.Millisecond}, a.Window(at(200)))
+	total := a.Total(at(200))
+	assert.Equal(t, 50*time.Millisecond, total.Busy)
+	assert.Equal(t, 30*time.Millisecond, total.Idle)
+	assert.Equal(t, 80*time.Millisecond, total.Backlog())
+	assert.Equal(t, 0, a.Backlog())
+	assert.Equal(t, 0, a.InService())
+}
+
+// Tests if only starting a class while a higher priority one waits is an
+// inversion, and a given decision overrides the queues
+func TestAccounting_Inversions(t *testing.T) {
+	a := metrics.NewAccounting(t0)
+	a.Enqueue(at(1), model.HIGH_PRIORITY)
+	a.Enqueue(at(1), model.LOW_PRIORITY)
+	assert.True(t, a.Dequeue(at(2), model.LOW_PRIORITY, nil))
+	assert.False(t, a.Dequeue(at(3), model.HIGH_PRIORITY, nil))
+
+	a.Enqueue(at(4), model.LOW_PRIORITY)
+	a.Enqueue(at(4), model.MEDIUM_PRIORITY)
+	assert.False(t, a.Dequeue(at(5), model.MEDIUM_PRIORITY, nil)) // low waits: fine
+	assert.Equal(t, int64(1), a.Inversions())
+
+	decided := true
+	assert.True(t, a.Dequeue(at(6), model.LOW_PRIORITY, &decided))
+	assert.Equal(t, int64(2), a.Inversions())
+	assert.Equal(t, 4, a.InService())
+}
+
+// Tests if a drop leaves the queue without entering service, and events out
+// of order count no time
+func TestAccounting_Drop(t *testing.T) {
+	a := metrics.NewAccounting(t0)
+	a.Enqueue(at(10), model.MEDIUM_PRIORITY)
+	a.Drop(at(5), model.MEDIUM_PRIORITY)
+	a.Drop(at(20), model.MEDIUM_PRIORITY) // already empty
+	assert.Equal(t, 0, a.Queued(model.MEDIUM_PRIORITY))
+	assert.Equal(t, 0, a.InService())
+	assert.Equal(t, metrics.BacklogTime{}, a.Total(at(30)))
+}
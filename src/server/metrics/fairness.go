@@ -1,116 +1,174 @@
 package metrics
 
 import (
-	"encoding/csv"
-	"os"
-	"path/filepath"
+	"math"
 	"strconv"
 	"sync"
 	"time"
+
+	"main/src/model"
 )
 
 type ClassInt = int
 
 type Fairness struct {
 	mu       sync.Mutex
-	file     *os.File
-	w        *csv.Writer
+	out      *sink
 	ticker   *time.Ticker
 	stop     chan struct{}
+	done     chan struct{}
 	classes  []ClassInt
 	bytesWin map[ClassInt]int64
+
+	// fairness ponderada, só com as classes com backlog
+	weighted *WeightedFairness
 }
 
-var fairnessOnce sync.Once
-var fairnessInst *Fairness
+// Colunas por classe do fairness.csv, na ordem.
+var fairnessClasses = []Class{model.LOW_PRIORITY, model.MEDIUM_PRIORITY, model.HIGH_PRIORITY}
 
-func StartFairnessWriter(csvPath string, classes []ClassInt, interval time.Duration) {
-	fairnessOnce.Do(func() {
-		if csvPath == "" {
-			csvPath = "/tmp/server_scheduler_test/fairness.csv"
-		}
-		_ = os.MkdirAll(filepath.Dir(csvPath), 0o755)
-		f, _ := os.OpenFile(csvPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
-		w := csv.NewWriter(f)
-		if st, _ := f.Stat(); st != nil && st.Size() == 0 {
-			_ = w.Write([]string{"ts", "bytes_low", "bytes_medium", "bytes_high",
-				"share_low", "share_medium", "share_high", "jain"})
-			w.Flush()
-		}
-		fairnessInst = &Fairness{
-			file:     f,
-			w:        w,
-			ticker:   time.NewTicker(interval),
-			stop:     make(chan struct{}),
-			classes:  classes,
-			bytesWin: map[ClassInt]int64{},
-		}
-		go fairnessInst.loop()
-	})
+// NewFairnessWriter abre csvPath e escreve, a cada interval, os bytes e as
+// shares por classe da janela, o Jain das shares brutas e a fairness
+// ponderada (ver WeightedFairness). Retorna nil se o arquivo não abre.
+func NewFairnessWriter(csvPath string, classes []ClassInt, interval time.Duration) *Fairness {
+	out := openSink(csvPath, []string{"ts", "bytes_low", "bytes_medium", "bytes_high",
+		"share_low", "share_medium", "share_high", "jain",
+		"backlogged_low", "backlogged_medium", "backlogged_high",
+		"weighted_jain", "min_max_ratio"})
+	if out == nil {
+		return nil
+	}
+	fw := &Fairness{
+		out:      out,
+		ticker:   time.NewTicker(interval),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		classes:  classes,
+		bytesWin: map[ClassInt]int64{},
+		weighted: NewWeightedFairness(),
+	}
+	go fw.loop()
+	return fw
 }
 
-func StopFairnessWriter() {
-	if fairnessInst == nil {
+// Stop escreve a janela incompleta e fecha o CSV.
+func (f *Fairness) Stop() {
+	if f == nil {
 		return
 	}
-	close(fairnessInst.stop)
-	fairnessInst.ticker.Stop()
-	fairnessInst.w.Flush()
-	_ = fairnessInst.file.Close()
-	fairnessInst = nil
+	close(f.stop)
+	<-f.done
+	f.ticker.Stop()
+	f.mu.Lock()
+	f.writeWindow(time.Now())
+	f.out.close()
+	f.mu.Unlock()
 }
 
 // chame isso quando uma requisição COMPLETA enviar bytes>0
-func RecordBytesForFairness(class ClassInt, bytes int) {
-	if fairnessInst == nil || bytes <= 0 {
+func (f *Fairness) Record(class ClassInt, bytes int) {
+	if f == nil || bytes <= 0 {
+		return
+	}
+	f.mu.Lock()
+	f.bytesWin[class] += int64(bytes)
+	f.weighted.Sent(Class(class), bytes)
+	f.mu.Unlock()
+}
+
+// Queue informa o tamanho da fila de class, que diz se a classe tem
+// backlog.
+func (f *Fairness) Queue(class Class, length int) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	f.weighted.Queue(class, length)
+	f.mu.Unlock()
+}
+
+// SetWeights define os pesos da fairness ponderada (nil = iguais).
+func (f *Fairness) SetWeights(weights map[ClassInt]float64) {
+	if f == nil {
 		return
 	}
-	fairnessInst.mu.Lock()
-	fairnessInst.bytesWin[class] += int64(bytes)
-	fairnessInst.mu.Unlock()
+	f.mu.Lock()
+	f.weighted.SetWeights(weights)
+	f.mu.Unlock()
+}
+
+// Totals retorna o resumo da fairness ponderada (ver WeightedFairness.Totals).
+func (f *Fairness) Totals() (windows int64, jain, minMaxRatio float64) {
+	if f == nil {
+		return 0, math.NaN(), math.NaN()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.weighted.Totals()
 }
 
 func (f *Fairness) loop() {
+	defer close(f.done)
 	for {
 		select {
 		case <-f.stop:
 			return
 		case now := <-f.ticker.C:
 			f.mu.Lock()
-			var total int64
-			for _, c := range f.classes {
-				total += f.bytesWin[c]
-			}
-			share := map[ClassInt]float64{}
-			var s, s2 float64
-			for _, c := range f.classes {
-				if total > 0 {
-					share[c] = float64(f.bytesWin[c]) / float64(total)
-				} else {
-					share[c] = 0
-				}
-				s += share[c]
-				s2 += share[c] * share[c]
-			}
-			var jain float64
-			n := float64(len(f.classes))
-			if s2 > 0 {
-				jain = (s * s) / (n * s2)
-			}
-			rec := []string{
-				now.Format(time.RFC3339Nano),
-				i642(f.bytesWin[0]), i642(f.bytesWin[1]), i642(f.bytesWin[2]),
-				f642(share[0]), f642(share[1]), f642(share[2]), f642(jain),
-			}
-			_ = f.w.Write(rec)
-			f.w.Flush()
-			for k := range f.bytesWin {
-				f.bytesWin[k] = 0
-			}
+			f.writeWindow(now)
 			f.mu.Unlock()
 		}
 	}
 }
 
+func (f *Fairness) writeWindow(now time.Time) {
+	var total int64
+	for _, c := range f.classes {
+		total += f.bytesWin[c]
+	}
+	share := map[ClassInt]float64{}
+	var s, s2 float64
+	for _, c := range f.classes {
+		if total > 0 {
+			share[c] = float64(f.bytesWin[c]) / float64(total)
+		} else {
+			share[c] = 0
+		}
+		s += share[c]
+		s2 += share[c] * share[c]
+	}
+	var jain float64
+	n := float64(len(f.classes))
+	if s2 > 0 {
+		jain = (s * s) / (n * s2)
+	}
+	win := f.weighted.Window()
+
+	rec := []string{now.Format(time.RFC3339Nano)}
+	for _, c := range fairnessClasses {
+		rec = append(rec, i642(f.bytesWin[int(c)]))
+	}
+	for _, c := range fairnessClasses {
+		rec = append(rec, f642(share[int(c)]))
+	}
+	rec = append(rec, f642(jain))
+	for _, c := range fairnessClasses {
+		rec = append(rec, strconv.FormatBool(win.Backlogged[c]))
+	}
+	rec = append(rec, f642NaN(win.Jain), f642NaN(win.MinMaxRatio))
+	f.out.write(rec)
+	for k := range f.bytesWin {
+		f.bytesWin[k] = 0
+	}
+}
+
 func i642(v int64) string   { return strconv.FormatInt(v, 10) }
 func f642(v float64) string { return strconv.FormatFloat(v, 'f', 6, 64) }
+
+// f642NaN escreve NaN (sem valor) como vazio.
+func f642NaN(v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return f642(v)
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import "time"
+
+// Limites superiores dos buckets dos histogramas de latência.
+var HistogramBounds = []time.Duration{
+	1 * time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	1 * time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// Histogram conta observações por bucket (HistogramBounds, mais um bucket
+// final para o que passa do último limite). O valor zero está pronto para uso.
+type Histogram struct {
+	Counts []int64 // não cumulativo, len(HistogramBounds)+1
+	Count  int64
+	Sum    time.Duration
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	if h.Counts == nil {
+		h.Counts = make([]int64, len(HistogramBounds)+1)
+	}
+	i := 0
+	for i < len(HistogramBounds) && d > HistogramBounds[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+}
+
+// clone copia os buckets, para ler fora do lock.
+func (h Histogram) clone() Histogram {
+	if h.Counts != nil {
+		h.Counts = append([]int64(nil), h.Counts...)
+	}
+	return h
+}
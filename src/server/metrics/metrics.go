@@ -1,11 +1,7 @@
 package metrics
 
 import (
-	"encoding/csv"
-	"log"
 	"main/src/model"
-	"os"
-	"path/filepath"
 	"strconv"
 	"sync"
 	"time"
@@ -17,103 +13,77 @@ type Class = model.Priority
 
 type classCounters struct {
 	Enqueued, Started, Completed, DroppedDeadline  int64
+	Failed                                         int64 // servidas sem bytes, antes do deadline
 	BytesSent, BytesOnTime                         int64
 	QueueDelaySum, ServiceTimeSum, ResponseTimeSum int64 // ms
 
 	SlackSum      int64 // ms
 	TimeToDropSum int64 // ms
 	OnTimeCount   int64 // reqs concluídas no prazo
+
+	// distribuições (endpoint Prometheus)
+	QueueDelay, ServiceTime, ResponseTime Histogram
+
+	// percentis: desde o início (server_summary) e da janela atual (latency)
+	Latency ClassLatency
+	window  ClassLatency
 }
 
 // -------- métricas globais --------
 type globalCounters struct {
-	// Concurrency de serviço
-	inService int64
-
-	// Preempção e inversão
+	// Preempção (inversões e work-conserving ficam em Accounting)
 	Preemptions int64
-	Inversions  int64
-
-	// Work-conserving
-	lastTick                  time.Time
-	queuePositiveDur          time.Duration // tempo com Q>0
-	idleWhileQueuePositiveDur time.Duration // tempo com Q>0 e inService==0
 
 	// Stale bytes (bytes que teriam sido enviados mas expiraram)
 	StaleBytes int64
-}
 
-// -------- CSV writers --------
+	// Previsões de viabilidade do deadline: quantas foram conferidas com o
+	// resultado, quantas acertaram, e tiles descartados por serem inviáveis
+	FeasibilityPredictions int64
+	FeasibilityCorrect     int64
+	InfeasibleSkipped      int64
 
-type csvOut struct {
-	f  *os.File
-	w  *csv.Writer
-	mu sync.Mutex
+	// Requisições por motivo do resultado (model.Outcome)
+	Outcomes [model.OUTCOME_COUNT]int64
 }
 
-func (c *csvOut) open(path string, hdr []string) {
-	if path == "" {
-		return
-	}
-	_ = os.MkdirAll(filepath.Dir(path), 0o755)
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
-	if err != nil {
-		log.Printf("[METRICS] csv open %s: %v", path, err)
-		return
-	}
-	w := csv.NewWriter(f)
-	if st, _ := f.Stat(); st != nil && st.Size() == 0 {
-		_ = w.Write(hdr)
-		w.Flush()
-	}
-	c.f, c.w = f, w
-}
-
-func (c *csvOut) write(row []string) {
-	if c == nil || c.w == nil {
-		return
-	}
-	c.mu.Lock()
-	_ = c.w.Write(row)
-	c.w.Flush()
-	c.mu.Unlock()
-}
-
-func (c *csvOut) close() {
-	if c == nil {
-		return
-	}
-	c.mu.Lock()
-	if c.w != nil {
-		c.w.Flush()
-	}
-	if c.f != nil {
-		_ = c.f.Close()
-	}
-	c.mu.Unlock()
-}
-
-// -------- Metrics singleton --------
+// -------- Metrics --------
 
+// Metrics acumula os contadores de um escopo (uma conexão ou o servidor) e
+// escreve seus CSVs. Normalmente usado através de uma Session.
 type Metrics struct {
 	mu  sync.Mutex
 	cls map[Class]*classCounters
 	gl  globalCounters
 
-	// queue len (por classe) mais recente
-	queueLen map[Class]int
+	// filas, serviço, work-conserving e inversões, por evento do scheduler
+	acct *Accounting
 
 	// CSV paths
 	classAggPath string
 	queueLenPath string
 	summaryPath  string
 
-	// CSV writers
-	classAgg csvOut
-	queueCSV csvOut // <- RENOMEADO (antes era queueLen)
+	// writers (assíncronos, ver sink.go)
+	classAgg *sink
+	queueCSV *sink
 
 	// também mantemos um writer para o summary
-	summary csvOut
+	summary *sink
+
+	// séries periódicas, escritas por goroutines: percentis por janela
+	// (latency), amostras das filas (queue_len) e work-conserving
+	latency        *sink
+	workConserving *sink
+	series         []*periodic
+
+	// fairness ponderada do server_summary (nil = sem)
+	fairness *Fairness
+
+	// linhas descartadas (ver sink): sinks da sessão fora do Metrics
+	// (reqlog, wfq_utilization) e o total das sessões filhas já fechadas
+	rowSinks          []*sink
+	closedRowsDropped int64
 
 	// run timing
 	runStart time.Time
@@ -126,25 +96,15 @@ type TaskCtx struct {
 	StartedAt  time.Time
 }
 
-var (
-	globalInst *Metrics
-	once       sync.Once
-)
-
-func M() *Metrics {
-	once.Do(func() {
-		m := &Metrics{
-			cls:      map[Class]*classCounters{},
-			queueLen: map[Class]int{},
-		}
-		for c := Class(0); c < Class(model.PRIORITY_LEVEL_COUNT); c++ {
-			m.cls[c] = &classCounters{}
-			m.queueLen[c] = 0
-		}
-		m.gl.lastTick = time.Now()
-		globalInst = m
-	})
-	return globalInst
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		cls:  map[Class]*classCounters{},
+		acct: NewAccounting(time.Now()),
+	}
+	for c := Class(0); c < Class(model.PRIORITY_LEVEL_COUNT); c++ {
+		m.cls[c] = &classCounters{}
+	}
+	return m
 }
 
 // -------------------- Init & CSVs --------------------
@@ -153,12 +113,13 @@ func (m *Metrics) InitClassAgg(path string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.classAggPath = path
-	m.classAgg.open(path, []string{
+	m.classAgg = openSink(path, []string{
 		"ts",
 		"class",
-		"event", // complete | drop
+		"event", // complete | drop | fail
 		"completed",
 		"dropped_deadline",
+		"failed",
 		"bytes_sent",
 		"bytes_on_time",
 		"avg_queue_delay_ms",
@@ -171,28 +132,153 @@ func (m *Metrics) InitClassAgg(path string) {
 	})
 }
 
-func (m *Metrics) InitQueueCSV(path string) {
+// InitQueueCSV escreve, a cada interval, o tamanho da fila de cada classe.
+func (m *Metrics) InitQueueCSV(path string, interval time.Duration) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.queueLenPath = path
-	m.queueCSV.open(path, []string{"ts", "class", "queue_len"}) // <- usa queueCSV
+	m.queueCSV = openSink(path, []string{"ts", "class", "queue_len"})
+	if m.queueCSV != nil {
+		m.series = append(m.series, startPeriodic(interval, m.writeQueueSample))
+	}
+}
+
+func (m *Metrics) writeQueueSample(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ts := now.Format(time.RFC3339Nano)
+	for c := Class(0); c < Class(model.PRIORITY_LEVEL_COUNT); c++ {
+		m.queueCSV.write([]string{ts, ClassName(c), strconv.Itoa(m.acct.Queued(c))})
+	}
+}
+
+// InitWorkConservingCSV escreve, a cada interval, quanto tempo da janela
+// houve fila com e sem serviço.
+func (m *Metrics) InitWorkConservingCSV(path string, interval time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.workConserving = openSink(path, []string{"ts", "busy_ms", "idle_ms", "backlog_ms", "ratio"})
+	if m.workConserving != nil {
+		m.series = append(m.series, startPeriodic(interval, m.writeWorkConservingWindow))
+	}
+}
+
+func (m *Metrics) writeWorkConservingWindow(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w := m.acct.Window(now)
+	ratio := 1.0
+	if w.Backlog() > 0 {
+		ratio = float64(w.Busy) / float64(w.Backlog())
+	}
+	m.workConserving.write([]string{
+		now.Format(time.RFC3339Nano),
+		i64(w.Busy.Milliseconds()), i64(w.Idle.Milliseconds()), i64(w.Backlog().Milliseconds()),
+		f64(ratio),
+	})
+}
+
+// InitLatencyCSV escreve, a cada interval, os percentis de latência de cada
+// classe na janela.
+func (m *Metrics) InitLatencyCSV(path string, interval time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latency = openSink(path, append([]string{"ts", "class", "started", "completed"}, latencyColumns("")...))
+	if m.latency != nil {
+		m.series = append(m.series, startPeriodic(interval, m.writeLatencyWindow))
+	}
+}
+
+// periodic chama fn a cada intervalo numa goroutine, até stop.
+type periodic struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+func startPeriodic(interval time.Duration, fn func(now time.Time)) *periodic {
+	p := &periodic{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case now := <-ticker.C:
+				fn(now)
+			}
+		}
+	}()
+	return p
+}
+
+// stopAndWait para a goroutine e espera a última chamada terminar.
+func (p *periodic) stopAndWait() {
+	close(p.stop)
+	<-p.done
+}
+
+// writeLatencyWindow escreve uma linha por classe com os percentis da janela
+// e começa outra.
+func (m *Metrics) writeLatencyWindow(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ts := now.Format(time.RFC3339Nano)
+	for c := Class(0); c < Class(model.PRIORITY_LEVEL_COUNT); c++ {
+		w := &m.cls[c].window
+		row := []string{ts, ClassName(c), i64(w.QueueDelay.Count()), i64(w.ServiceTime.Count())}
+		m.latency.write(append(row, w.values()...))
+		w.reset()
+	}
 }
 
 func (m *Metrics) InitSummary(path string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.summaryPath = path
-	m.summary.open(path, []string{
-		"ts_start", "ts_end", "duration_s",
-		"bytes_low", "bytes_med", "bytes_high",
-		"throughput_low_kbps", "throughput_med_kbps", "throughput_high_kbps",
-		"class_share_low_pct", "class_share_med_pct", "class_share_high_pct",
-		"jain_fairness",
-		"drop_rate_low_pct", "drop_rate_med_pct", "drop_rate_high_pct",
-		"preemptions", "inversions",
-		"work_conserving_ratio_pct",
-		"stale_bytes",
-	})
+	m.summary = openSink(path, SummaryHeader)
+}
+
+// Colunas do server_summary.csv (também usadas pelo simulador).
+var SummaryHeader = append([]string{
+	"ts_start", "ts_end", "duration_s",
+	"bytes_low", "bytes_med", "bytes_high",
+	"throughput_low_kbps", "throughput_med_kbps", "throughput_high_kbps",
+	"class_share_low_pct", "class_share_med_pct", "class_share_high_pct",
+	"jain_fairness",
+	"weighted_jain_fairness", "min_max_service_ratio", "backlogged_windows",
+	"drop_rate_low_pct", "drop_rate_med_pct", "drop_rate_high_pct",
+	"preemptions", "inversions",
+	"work_conserving_ratio_pct",
+	"stale_bytes",
+	"feasibility_predictions", "feasibility_accuracy_pct", "infeasible_skipped",
+	"metrics_rows_dropped",
+}, append(summaryOutcomeColumns(), summaryLatencyColumns()...)...)
+
+// Classes das colunas por classe do server_summary, na ordem.
+var summaryClasses = []struct {
+	class Class
+	name  string
+}{{model.LOW_PRIORITY, "low"}, {model.MEDIUM_PRIORITY, "med"}, {model.HIGH_PRIORITY, "high"}}
+
+// Colunas de contagem por motivo do server_summary: outcome_<motivo>, na
+// ordem de model.ServerOutcomes.
+func summaryOutcomeColumns() []string {
+	var columns []string
+	for _, o := range model.ServerOutcomes {
+		columns = append(columns, "outcome_"+o.String())
+	}
+	return columns
+}
+
+// Colunas de percentis do server_summary: <métrica>_<p50...max>_<classe>_ms.
+func summaryLatencyColumns() []string {
+	var columns []string
+	for _, c := range summaryClasses {
+		columns = append(columns, latencyColumns("_"+c.name)...)
+	}
+	return columns
 }
 
 func (m *Metrics) MarkRunStart() {
@@ -203,7 +289,8 @@ func (m *Metrics) MarkRunStart() {
 
 // -------------------- Internals --------------------
 
-func (m *Metrics) toClassName(c Class) string {
+// ClassName é o nome da classe nos CSVs, nos rótulos do Prometheus e na API admin.
+func ClassName(c Class) string {
 	switch c {
 	case model.LOW_PRIORITY:
 		return "low"
@@ -239,6 +326,7 @@ func (m *Metrics) writeClassAggRow(className, event string, cl *classCounters) {
 		event,
 		i64(cl.Completed),
 		i64(cl.DroppedDeadline),
+		i64(cl.Failed),
 		i64(cl.BytesSent),
 		i64(cl.BytesOnTime),
 		f64(div(cl.QueueDelaySum, cl.Started)),
@@ -251,45 +339,28 @@ func (m *Metrics) writeClassAggRow(className, event string, cl *classCounters) {
 	})
 }
 
-// Atualiza contabilidade de work-conserving com base no estado atual.
-func (m *Metrics) updateWorkConservingLocked(now time.Time) {
-	// total queued
-	qtot := 0
-	for _, q := range m.queueLen {
-		qtot += q
-	}
-	// tempo desde última amostra
-	dt := now.Sub(m.gl.lastTick)
-	if dt < 0 {
-		dt = 0
-	}
-	if qtot > 0 {
-		m.gl.queuePositiveDur += dt
-		if m.gl.inService == 0 {
-			m.gl.idleWhileQueuePositiveDur += dt
-		}
-	}
-	m.gl.lastTick = now
-}
-
 // -------------------- Eventos básicos --------------------
 
 func (m *Metrics) OnEnqueue(class Class) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	// apenas contador — fila real deve ser amostrada via OnQueueSample
+	// apenas contador — a fila real vem dos eventos Enqueue/Dequeue do
+	// Accounting (onTaskEnqueued/onTaskDequeued)
 	m.cls[class].Enqueued++
-	// atualizar work-conserving relógio
-	m.updateWorkConservingLocked(time.Now())
 }
 
 func (m *Metrics) OnStart(ctx *TaskCtx) {
-	now := time.Now()
+	m.onStart(ctx, time.Now())
+}
+
+// onStart registra o início do serviço de ctx em now.
+func (m *Metrics) onStart(ctx *TaskCtx, now time.Time) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	cl := m.cls[ctx.Class]
 	cl.Started++
 	cl.QueueDelaySum += now.Sub(ctx.EnqueuedAt).Milliseconds()
+	cl.QueueDelay.Observe(now.Sub(ctx.EnqueuedAt))
 
 	// slack = deadline - agora (>=0)
 	slack := ctx.Deadline.Sub(now).Milliseconds()
@@ -297,21 +368,11 @@ func (m *Metrics) OnStart(ctx *TaskCtx) {
 		slack = 0
 	}
 	cl.SlackSum += slack
-
-	// Concurrency
-	m.gl.inService++
-
-	// Inversão de ordem: se há alguma fila com classe superior > 0
-	// e estamos iniciando uma classe inferior, conta inversão.
-	// Prioridade: low=0 < med=1 < high=2
-	for c, q := range m.queueLen {
-		if int(c) > int(ctx.Class) && q > 0 {
-			m.gl.Inversions++
-			break
-		}
+	for _, l := range []*ClassLatency{&cl.Latency, &cl.window} {
+		l.QueueDelay.Record(now.Sub(ctx.EnqueuedAt))
+		l.Slack.Record(ctx.Deadline.Sub(now))
 	}
 
-	m.updateWorkConservingLocked(now)
 	ctx.StartedAt = now
 }
 
@@ -323,17 +384,18 @@ func (m *Metrics) OnComplete(ctx *TaskCtx, bytes int, dropped bool) {
 	cl.Completed++
 	cl.ServiceTimeSum += now.Sub(ctx.StartedAt).Milliseconds()
 	cl.ResponseTimeSum += now.Sub(ctx.EnqueuedAt).Milliseconds()
+	cl.ServiceTime.Observe(now.Sub(ctx.StartedAt))
+	cl.ResponseTime.Observe(now.Sub(ctx.EnqueuedAt))
+	for _, l := range []*ClassLatency{&cl.Latency, &cl.window} {
+		l.ServiceTime.Record(now.Sub(ctx.StartedAt))
+		l.ResponseTime.Record(now.Sub(ctx.EnqueuedAt))
+	}
 	cl.BytesSent += int64(bytes)
 	if !dropped && (now.Before(ctx.Deadline) || now.Equal(ctx.Deadline)) {
 		cl.BytesOnTime += int64(bytes)
 		cl.OnTimeCount++
 	}
-	// Concurrency down
-	if m.gl.inService > 0 {
-		m.gl.inService--
-	}
-	className := m.toClassName(ctx.Class)
-	m.updateWorkConservingLocked(now)
+	className := ClassName(ctx.Class)
 	m.mu.Unlock()
 
 	// escreve 1 linha por COMPLETE (snapshot por classe)
@@ -351,13 +413,7 @@ func (m *Metrics) OnDeadlineDropWithBytes(ctx *TaskCtx, estBytes int64) {
 	cl.DroppedDeadline++
 	cl.TimeToDropSum += now.Sub(ctx.EnqueuedAt).Milliseconds()
 	m.gl.StaleBytes += estBytes
-
-	// Concurrency down (se algum serviço estivesse ativo para esse ctx; em geral inicia com 0)
-	if m.gl.inService > 0 {
-		m.gl.inService--
-	}
-	className := m.toClassName(ctx.Class)
-	m.updateWorkConservingLocked(now)
+	className := ClassName(ctx.Class)
 	m.mu.Unlock()
 
 	// escreve 1 linha por DROP (snapshot por classe)
@@ -366,6 +422,44 @@ func (m *Metrics) OnDeadlineDropWithBytes(ctx *TaskCtx, estBytes int64) {
 	m.mu.Unlock()
 }
 
+// OnFailure registra uma requisição que terminou antes do deadline sem
+// entregar o tile (not_found, write_error, inclusive envio parcial). Não entra em completed nem nas
+// latências de serviço e resposta.
+func (m *Metrics) OnFailure(ctx *TaskCtx) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cl := m.cls[ctx.Class]
+	cl.Failed++
+	m.writeClassAggRow(ClassName(ctx.Class), "fail", cl)
+}
+
+// OnFeasibility confere uma previsão de viabilidade com o resultado da
+// requisição servida.
+func (m *Metrics) OnFeasibility(predictedFeasible, onTime bool) {
+	m.mu.Lock()
+	m.gl.FeasibilityPredictions++
+	if predictedFeasible == onTime {
+		m.gl.FeasibilityCorrect++
+	}
+	m.mu.Unlock()
+}
+
+// OnOutcome conta uma requisição terminada pelo motivo do resultado.
+func (m *Metrics) OnOutcome(outcome model.Outcome) {
+	m.mu.Lock()
+	m.gl.Outcomes[outcome]++
+	m.mu.Unlock()
+}
+
+// OnInfeasibleSkip registra um tile descartado antes do serviço por não
+// caber no deadline. Conta também como drop por deadline.
+func (m *Metrics) OnInfeasibleSkip(ctx *TaskCtx, estBytes int64) {
+	m.mu.Lock()
+	m.gl.InfeasibleSkipped++
+	m.mu.Unlock()
+	m.OnDeadlineDropWithBytes(ctx, estBytes)
+}
+
 // Compatibilidade: versão sem bytes estimados (soma 0).
 func (m *Metrics) OnDeadlineDrop(ctx *TaskCtx) {
 	m.OnDeadlineDropWithBytes(ctx, 0)
@@ -375,44 +469,182 @@ func (m *Metrics) OnDeadlineDrop(ctx *TaskCtx) {
 func (m *Metrics) OnPreempt(preempted, preemptor Class) {
 	m.mu.Lock()
 	m.gl.Preemptions++
-	m.updateWorkConservingLocked(time.Now())
 	m.mu.Unlock()
 }
 
-// Amostra tamanhos de fila por classe; útil para queue_len e work-conserving.
-func (m *Metrics) OnQueueSample(lens map[Class]int) {
-	now := time.Now()
+// -------------------- Estado do scheduler --------------------
+
+// onTaskEnqueued registra uma tarefa entrando na fila de class. Retorna o
+// novo tamanho da fila.
+func (m *Metrics) onTaskEnqueued(class Class, now time.Time) int {
 	m.mu.Lock()
-	for c, v := range lens {
-		m.queueLen[c] = v
-		// escreve por classe
-		m.queueCSV.write([]string{ // <- usa queueCSV
-			now.Format(time.RFC3339Nano),
-			m.toClassName(c),
-			strconv.Itoa(v),
-		})
-	}
-	m.updateWorkConservingLocked(now)
+	defer m.mu.Unlock()
+	m.acct.Enqueue(now, class)
+	return m.acct.Queued(class)
+}
+
+// onTaskDequeued registra uma tarefa saindo da fila para o serviço (ver
+// Accounting.Dequeue). Retorna o novo tamanho da fila e se houve inversão.
+func (m *Metrics) onTaskDequeued(class Class, now time.Time, inversion *bool) (int, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inverted := m.acct.Dequeue(now, class, inversion)
+	return m.acct.Queued(class), inverted
+}
+
+// onTaskDropped registra uma tarefa saindo da fila sem serviço. Retorna o
+// novo tamanho da fila.
+func (m *Metrics) onTaskDropped(class Class, now time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.acct.Drop(now, class)
+	return m.acct.Queued(class)
+}
+
+func (m *Metrics) onTaskFinished(now time.Time) {
+	m.mu.Lock()
+	m.acct.Finish(now)
 	m.mu.Unlock()
 }
 
+// schedulerState retorna as filas por classe e as tarefas em serviço.
+func (m *Metrics) schedulerState() (queued [model.PRIORITY_LEVEL_COUNT]int, inService int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for c := range queued {
+		queued[c] = m.acct.Queued(Class(c))
+	}
+	return queued, m.acct.InService()
+}
+
 // -------------------- Summary --------------------
 
 func (m *Metrics) WriteSummaryAndClose() {
+	// as goroutines das séries também pegam m.mu
+	if m.series != nil {
+		for _, p := range m.series {
+			p.stopAndWait()
+		}
+		m.series = nil
+		// janelas incompletas
+		now := time.Now()
+		if m.latency != nil {
+			m.writeLatencyWindow(now)
+		}
+		if m.workConserving != nil {
+			m.writeWorkConservingWindow(now)
+		}
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	tsStart := m.runStart
-	tsEnd := time.Now()
-	dur := tsEnd.Sub(tsStart).Seconds()
+	m.writeSummaryLocked()
+
+	// fechar CSVs
+	m.classAgg.close()
+	m.queueCSV.close()
+	m.summary.close()
+	m.latency.close()
+	m.workConserving.close()
+}
+
+// droppedRowsLocked soma as linhas descartadas pelos sinks desta sessão e
+// das sessões filhas já fechadas.
+func (m *Metrics) droppedRowsLocked() int64 {
+	n := m.closedRowsDropped
+	for _, s := range append([]*sink{m.classAgg, m.queueCSV, m.summary, m.latency, m.workConserving}, m.rowSinks...) {
+		n += s.droppedRows()
+	}
+	if m.fairness != nil {
+		n += m.fairness.out.droppedRows()
+	}
+	return n
+}
+
+// droppedRows é droppedRowsLocked pegando o lock.
+func (m *Metrics) droppedRows() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.droppedRowsLocked()
+}
+
+// addClosedRowsDropped soma as linhas descartadas de uma sessão filha que
+// fechou.
+func (m *Metrics) addClosedRowsDropped(n int64) {
+	m.mu.Lock()
+	m.closedRowsDropped += n
+	m.mu.Unlock()
+}
+
+// WriteSummary escreve uma linha de resumo parcial (do início até agora) sem
+// fechar os CSVs. Retorna depois que a linha chega ao arquivo.
+func (m *Metrics) WriteSummary() {
+	m.mu.Lock()
+	m.writeSummaryLocked()
+	m.mu.Unlock()
+	m.summary.sync()
+}
+
+func (m *Metrics) writeSummaryLocked() {
+	in := SummaryInput{
+		Start:                  m.runStart,
+		End:                    time.Now(),
+		Preemptions:            m.gl.Preemptions,
+		Inversions:             m.acct.Inversions(),
+		StaleBytes:             m.gl.StaleBytes,
+		FeasibilityPredictions: m.gl.FeasibilityPredictions,
+		FeasibilityCorrect:     m.gl.FeasibilityCorrect,
+		InfeasibleSkipped:      m.gl.InfeasibleSkipped,
+		Outcomes:               m.gl.Outcomes,
+		MetricsRowsDropped:     m.droppedRowsLocked(),
+	}
+	backlog := m.acct.Total(in.End)
+	in.QueuePositive, in.IdleWhileQueuePositive = backlog.Backlog(), backlog.Idle
+	in.BackloggedWindows, in.WeightedJain, in.MinMaxRatio = m.fairness.Totals()
+	for c := range in.BytesSent {
+		in.BytesSent[c] = m.cls[Class(c)].BytesSent
+		in.Enqueued[c] = m.cls[Class(c)].Enqueued
+		in.DroppedDeadline[c] = m.cls[Class(c)].DroppedDeadline
+		in.Latency[c] = m.cls[Class(c)].Latency
+	}
+	m.summary.write(SummaryRow(in))
+}
+
+// Contadores de uma execução, indexados por classe (model.Priority).
+type SummaryInput struct {
+	Start, End             time.Time
+	BytesSent              [model.PRIORITY_LEVEL_COUNT]int64
+	Enqueued               [model.PRIORITY_LEVEL_COUNT]int64
+	DroppedDeadline        [model.PRIORITY_LEVEL_COUNT]int64
+	Preemptions            int64
+	Inversions             int64
+	QueuePositive          time.Duration // tempo com Q>0
+	IdleWhileQueuePositive time.Duration // tempo com Q>0 e nada em serviço
+	StaleBytes             int64
+	FeasibilityPredictions int64 // previsões conferidas com o resultado
+	FeasibilityCorrect     int64
+	InfeasibleSkipped      int64
+	MetricsRowsDropped     int64                                    // linhas de métricas descartadas (ver sink)
+	Outcomes               [model.OUTCOME_COUNT]int64               // por model.Outcome
+	Latency                [model.PRIORITY_LEVEL_COUNT]ClassLatency // percentis desde Start
+	// Fairness ponderada (ver WeightedFairness.Totals)
+	BackloggedWindows int64
+	WeightedJain      float64
+	MinMaxRatio       float64
+}
+
+// SummaryRow calcula a linha do server_summary.csv (colunas SummaryHeader).
+func SummaryRow(in SummaryInput) []string {
+	dur := in.End.Sub(in.Start).Seconds()
 	if dur <= 0 {
 		dur = 1
 	}
 
 	// per-class bytes & rates
-	bl := float64(m.cls[model.LOW_PRIORITY].BytesSent)
-	bm := float64(m.cls[model.MEDIUM_PRIORITY].BytesSent)
-	bh := float64(m.cls[model.HIGH_PRIORITY].BytesSent)
+	bl := float64(in.BytesSent[model.LOW_PRIORITY])
+	bm := float64(in.BytesSent[model.MEDIUM_PRIORITY])
+	bh := float64(in.BytesSent[model.HIGH_PRIORITY])
 	bt := bl + bm + bh
 	shL, shM, shH := 0.0, 0.0, 0.0
 	if bt > 0 {
@@ -434,6 +666,12 @@ func (m *Metrics) WriteSummaryAndClose() {
 		jain = (sum * sum) / (3.0 * sum2)
 	}
 
+	// Fairness ponderada: vazia sem janelas com duas classes com backlog
+	weightedJain, minMaxRatio := "", ""
+	if in.BackloggedWindows > 0 {
+		weightedJain, minMaxRatio = f64(in.WeightedJain), f64(in.MinMaxRatio)
+	}
+
 	// Throughput kbps
 	tL := (bl * 8.0 / 1000.0) / dur
 	tM := (bm * 8.0 / 1000.0) / dur
@@ -441,37 +679,48 @@ func (m *Metrics) WriteSummaryAndClose() {
 
 	// Drop rate por classe (sobre enqueued)
 	dr := func(c Class) float64 {
-		enq := m.cls[c].Enqueued
+		enq := in.Enqueued[c]
 		if enq == 0 {
 			return 0
 		}
-		return 100.0 * float64(m.cls[c].DroppedDeadline) / float64(enq)
+		return 100.0 * float64(in.DroppedDeadline[c]) / float64(enq)
 	}
 
 	// Work-conserving ratio (porcentagem do tempo com Q>0 em que ficamos ociosos)
 	wcr := 0.0
-	if m.gl.queuePositiveDur > 0 {
-		wcr = 100.0 * float64(m.gl.idleWhileQueuePositiveDur) / float64(m.gl.queuePositiveDur)
+	if in.QueuePositive > 0 {
+		wcr = 100.0 * float64(in.IdleWhileQueuePositive) / float64(in.QueuePositive)
 	}
 
-	m.summary.write([]string{
-		tsStart.Format(time.RFC3339Nano),
-		tsEnd.Format(time.RFC3339Nano),
+	// Acerto das previsões de viabilidade
+	accuracy := 0.0
+	if in.FeasibilityPredictions > 0 {
+		accuracy = 100.0 * float64(in.FeasibilityCorrect) / float64(in.FeasibilityPredictions)
+	}
+
+	row := []string{
+		in.Start.Format(time.RFC3339Nano),
+		in.End.Format(time.RFC3339Nano),
 		f64(dur),
-		i64(int64(m.cls[model.LOW_PRIORITY].BytesSent)),
-		i64(int64(m.cls[model.MEDIUM_PRIORITY].BytesSent)),
-		i64(int64(m.cls[model.HIGH_PRIORITY].BytesSent)),
+		i64(in.BytesSent[model.LOW_PRIORITY]),
+		i64(in.BytesSent[model.MEDIUM_PRIORITY]),
+		i64(in.BytesSent[model.HIGH_PRIORITY]),
 		f64(tL), f64(tM), f64(tH),
 		f64(shL), f64(shM), f64(shH),
 		f64(jain),
+		weightedJain, minMaxRatio, i64(in.BackloggedWindows),
 		f64(dr(model.LOW_PRIORITY)), f64(dr(model.MEDIUM_PRIORITY)), f64(dr(model.HIGH_PRIORITY)),
-		i64(m.gl.Preemptions), i64(m.gl.Inversions),
+		i64(in.Preemptions), i64(in.Inversions),
 		f64(wcr),
-		i64(m.gl.StaleBytes),
-	})
-
-	// fechar CSVs
-	m.classAgg.close()
-	m.queueCSV.close() // <- usa queueCSV
-	m.summary.close()
+		i64(in.StaleBytes),
+		i64(in.FeasibilityPredictions), f64(accuracy), i64(in.InfeasibleSkipped),
+		i64(in.MetricsRowsDropped),
+	}
+	for _, o := range model.ServerOutcomes {
+		row = append(row, i64(in.Outcomes[o]))
+	}
+	for _, c := range summaryClasses {
+		row = append(row, in.Latency[c.class].values()...)
+	}
+	return row
 }
@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// LatencyHistogram é um histograma no estilo HDR: buckets log-lineares em
+// microssegundos, exatos até 128 µs e com erro relativo de até 1/64 (~1,6%)
+// acima disso, até 1 h (valores maiores contam como 1 h, negativos como 0).
+// O valor zero está pronto para uso.
+type LatencyHistogram struct {
+	counts   []int64
+	count    int64
+	min, max time.Duration
+}
+
+const (
+	latencySubBuckets = 128 // valores exatos abaixo disto (µs)
+	latencyHalf       = latencySubBuckets / 2
+	latencyMaxMicros  = int64(time.Hour / time.Microsecond)
+)
+
+var latencyBuckets = latencyIndex(latencyMaxMicros) + 1
+
+// Índice do bucket de v µs.
+func latencyIndex(v int64) int {
+	if v < latencySubBuckets {
+		return int(v)
+	}
+	e := bits.Len64(uint64(v)) - 7 // v>>e em [64, 128)
+	return latencySubBuckets + (e-1)*latencyHalf + int(v>>e) - latencyHalf
+}
+
+// Maior valor (µs) que cai no bucket i.
+func latencyUpper(i int) int64 {
+	if i < latencySubBuckets {
+		return int64(i)
+	}
+	j := i - latencySubBuckets
+	e := j/latencyHalf + 1
+	sub := int64(j%latencyHalf + latencyHalf)
+	return (sub+1)<<e - 1
+}
+
+func (h *LatencyHistogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	v := int64(d / time.Microsecond)
+	if v > latencyMaxMicros {
+		v = latencyMaxMicros
+		d = time.Hour
+	}
+	if h.counts == nil {
+		h.counts = make([]int64, latencyBuckets)
+	}
+	h.counts[latencyIndex(v)]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+}
+
+func (h *LatencyHistogram) Count() int64 {
+	return h.count
+}
+
+func (h *LatencyHistogram) Max() time.Duration {
+	return h.max
+}
+
+// Quantile devolve o valor abaixo do qual estão q (0..1) das observações
+// (o limite superior do bucket, sem passar do máximo); 0 se vazio.
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			value := time.Duration(latencyUpper(i)) * time.Microsecond
+			if value > h.max {
+				value = h.max
+			}
+			if value < h.min {
+				value = h.min
+			}
+			return value
+		}
+	}
+	return h.max
+}
+
+// Reset esvazia o histograma mantendo os buckets alocados.
+func (h *LatencyHistogram) Reset() {
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.count, h.min, h.max = 0, 0, 0
+}
+
+// ClassLatency reúne os histogramas de uma classe: espera na fila, serviço,
+// resposta (fila + serviço) e folga até o deadline no início do serviço.
+type ClassLatency struct {
+	QueueDelay, ServiceTime, ResponseTime, Slack LatencyHistogram
+}
+
+func (l *ClassLatency) reset() {
+	l.QueueDelay.Reset()
+	l.ServiceTime.Reset()
+	l.ResponseTime.Reset()
+	l.Slack.Reset()
+}
+
+// Percentis das colunas (server_summary e latency), na ordem.
+var latencyQuantiles = []struct {
+	name string
+	q    float64
+}{{"p50", 0.50}, {"p90", 0.90}, {"p95", 0.95}, {"p99", 0.99}}
+
+var latencyMetrics = []string{"queue_delay", "service_time", "response_time", "slack"}
+
+func (l *ClassLatency) histograms() []*LatencyHistogram {
+	return []*LatencyHistogram{&l.QueueDelay, &l.ServiceTime, &l.ResponseTime, &l.Slack}
+}
+
+// Nomes das colunas de percentis: <métrica>_<p50...max><suffix>_ms.
+func latencyColumns(suffix string) []string {
+	var columns []string
+	for _, metric := range latencyMetrics {
+		for _, q := range latencyQuantiles {
+			columns = append(columns, metric+"_"+q.name+suffix+"_ms")
+		}
+		columns = append(columns, metric+"_max"+suffix+"_ms")
+	}
+	return columns
+}
+
+// Valores das colunas de latencyColumns, em ms.
+func (l *ClassLatency) values() []string {
+	var values []string
+	for _, h := range l.histograms() {
+		for _, q := range latencyQuantiles {
+			values = append(values, durationMs(h.Quantile(q.q)))
+		}
+		values = append(values, durationMs(h.Max()))
+	}
+	return values
+}
+
+func durationMs(d time.Duration) string {
+	return f64(float64(d) / float64(time.Millisecond))
+}
@@ -0,0 +1,75 @@
+package metrics_test
+
+import (
+	"main/src/model"
+	"main/src/server/metrics"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests if the quantiles of a uniform distribution stay within the bucket error
+func TestLatencyHistogram_Quantile(t *testing.T) {
+	var h metrics.LatencyHistogram
+	for i := 1; i <= 10000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	assert.Equal(t, int64(10000), h.Count())
+	assert.Equal(t, 10*time.Second, h.Max())
+	for _, q := range []float64{0.5, 0.9, 0.95, 0.99} {
+		want := q * 10000 * float64(time.Millisecond)
+		got := float64(h.Quantile(q))
+		assert.True(t, got >= want, "q=%v got %v", q, time.Duration(got))
+		assert.True(t, got <= want*(1+1.0/64), "q=%v got %v", q, time.Duration(got))
+	}
+	assert.Equal(t, 10*time.Second, h.Quantile(1))
+}
+
+// Tests if small values are exact and out-of-range values are clamped
+func TestLatencyHistogram_Bounds(t *testing.T) {
+	var h metrics.LatencyHistogram
+	assert.Equal(t, time.Duration(0), h.Quantile(0.5))
+
+	h.Record(42 * time.Microsecond)
+	assert.Equal(t, 42*time.Microsecond, h.Quantile(0.5))
+	h.Record(-time.Second)
+	assert.Equal(t, time.Duration(0), h.Quantile(0.5))
+	h.Record(2 * time.Hour)
+	assert.Equal(t, time.Hour, h.Max())
+	assert.Equal(t, time.Hour, h.Quantile(0.99))
+
+	h.Reset()
+	assert.Equal(t, int64(0), h.Count())
+	assert.Equal(t, time.Duration(0), h.Quantile(0.99))
+	h.Record(time.Millisecond)
+	assert.Equal(t, time.Millisecond, h.Quantile(0.5))
+}
+
+// Tests if the summary has the percentiles of each class
+func TestSession_LatencySummary(t *testing.T) {
+	dir := t.TempDir()
+	s := metrics.NewSession(dir, "", nil, metrics.Formats{})
+	ctx := &metrics.TaskCtx{
+		Class:      model.HIGH_PRIORITY,
+		EnqueuedAt: time.Now().Add(-50 * time.Millisecond),
+		Deadline:   time.Now().Add(time.Second),
+	}
+	s.OnEnqueue(model.HIGH_PRIORITY)
+	s.OnStart(ctx)
+	s.OnComplete(ctx, 1000, false)
+	s.Close()
+
+	summary := readSummary(t, filepath.Join(dir, "server_summary.csv"))
+	queueDelay, err := strconv.ParseFloat(summary["queue_delay_p99_high_ms"], 64)
+	assert.Nil(t, err)
+	assert.True(t, queueDelay >= 50 && queueDelay < 100, "queue delay %v", queueDelay)
+	slack, err := strconv.ParseFloat(summary["slack_max_high_ms"], 64)
+	assert.Nil(t, err)
+	assert.True(t, slack > 900, "slack %v", slack)
+	assert.Equal(t, "0.000", summary["response_time_p50_low_ms"])
+	_, ok := summary["service_time_p95_med_ms"]
+	assert.True(t, ok)
+}
@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"main/src/model"
+)
+
+// Snapshot é uma cópia consistente do estado de um Metrics.
+type Snapshot struct {
+	Classes     map[Class]ClassSnapshot
+	InService   int64
+	Preemptions int64
+	Inversions  int64
+	StaleBytes  int64
+	Outcomes    [model.OUTCOME_COUNT]int64
+}
+
+// Contadores, fila e histogramas de uma classe.
+type ClassSnapshot struct {
+	Enqueued, Started, Completed, DroppedDeadline int64
+	Failed                                        int64
+	BytesSent, BytesOnTime                        int64
+	QueueLen                                      int
+
+	QueueDelay, ServiceTime, ResponseTime Histogram
+}
+
+// Snapshot copia o estado atual.
+func (m *Metrics) Snapshot() Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snap := Snapshot{
+		Classes:     map[Class]ClassSnapshot{},
+		InService:   int64(m.acct.InService()),
+		Preemptions: m.gl.Preemptions,
+		Inversions:  m.acct.Inversions(),
+		StaleBytes:  m.gl.StaleBytes,
+		Outcomes:    m.gl.Outcomes,
+	}
+	for c, cl := range m.cls {
+		snap.Classes[c] = ClassSnapshot{
+			Enqueued:        cl.Enqueued,
+			Started:         cl.Started,
+			Completed:       cl.Completed,
+			DroppedDeadline: cl.DroppedDeadline,
+			Failed:          cl.Failed,
+			BytesSent:       cl.BytesSent,
+			BytesOnTime:     cl.BytesOnTime,
+			QueueLen:        m.acct.Queued(c),
+			QueueDelay:      cl.QueueDelay.clone(),
+			ServiceTime:     cl.ServiceTime.clone(),
+			ResponseTime:    cl.ResponseTime.clone(),
+		}
+	}
+	return snap
+}
+
+// Snapshot do estado da sessão.
+func (s *Session) Snapshot() Snapshot {
+	return s.m.Snapshot()
+}
+
+// Prefixo dos nomes das métricas Prometheus.
+const prometheusPrefix = "tccquic_"
+
+// PrometheusHandler serve o estado da sessão no formato texto do Prometheus
+// (/metrics).
+func PrometheusHandler(s *Session) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		out := bufio.NewWriter(w)
+		WritePrometheus(out, s.Snapshot())
+		_ = out.Flush()
+	})
+}
+
+// WritePrometheus escreve snap no formato texto do Prometheus.
+func WritePrometheus(w *bufio.Writer, snap Snapshot) {
+	classes := make([]Class, 0, model.PRIORITY_LEVEL_COUNT)
+	for c := Class(0); c < Class(model.PRIORITY_LEVEL_COUNT); c++ {
+		classes = append(classes, c)
+	}
+	perClass := func(name, kind, help string, value func(ClassSnapshot) string) {
+		fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s %s\n", prometheusPrefix, name, help, prometheusPrefix, name, kind)
+		for _, c := range classes {
+			fmt.Fprintf(w, "%s%s{class=%q} %s\n", prometheusPrefix, name, ClassName(c), value(snap.Classes[c]))
+		}
+	}
+	single := func(name, kind, help string, value int64) {
+		fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s %s\n%s%s %d\n",
+			prometheusPrefix, name, help, prometheusPrefix, name, kind, prometheusPrefix, name, value)
+	}
+	count := func(v int64) string { return strconv.FormatInt(v, 10) }
+
+	perClass("requests_enqueued_total", "counter", "Requests enqueued in the scheduler.",
+		func(c ClassSnapshot) string { return count(c.Enqueued) })
+	perClass("requests_started_total", "counter", "Requests whose service started.",
+		func(c ClassSnapshot) string { return count(c.Started) })
+	perClass("requests_completed_total", "counter", "Requests served with data, on time or late.",
+		func(c ClassSnapshot) string { return count(c.Completed) })
+	perClass("requests_dropped_total", "counter", "Requests dropped for missing their deadline.",
+		func(c ClassSnapshot) string { return count(c.DroppedDeadline) })
+	perClass("requests_failed_total", "counter", "Requests that failed without data before their deadline.",
+		func(c ClassSnapshot) string { return count(c.Failed) })
+	perClass("bytes_sent_total", "counter", "Tile bytes sent.",
+		func(c ClassSnapshot) string { return count(c.BytesSent) })
+	perClass("bytes_on_time_total", "counter", "Tile bytes sent before the deadline.",
+		func(c ClassSnapshot) string { return count(c.BytesOnTime) })
+	perClass("queue_length", "gauge", "Requests waiting in the scheduler queues.",
+		func(c ClassSnapshot) string { return strconv.Itoa(c.QueueLen) })
+
+	single("in_service", "gauge", "Requests being served.", snap.InService)
+	single("preemptions_total", "counter", "Preemptions.", snap.Preemptions)
+	single("inversions_total", "counter", "Services started while a higher class was queued.", snap.Inversions)
+	single("stale_bytes_total", "counter", "Estimated bytes of the requests dropped for their deadline.", snap.StaleBytes)
+
+	fmt.Fprintf(w, "# HELP %srequests_outcome_total Requests ended, by outcome reason.\n# TYPE %srequests_outcome_total counter\n",
+		prometheusPrefix, prometheusPrefix)
+	for _, o := range model.ServerOutcomes {
+		fmt.Fprintf(w, "%srequests_outcome_total{reason=%q} %d\n", prometheusPrefix, o, snap.Outcomes[o])
+	}
+
+	histogram := func(name, help string, value func(ClassSnapshot) Histogram) {
+		fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s histogram\n", prometheusPrefix, name, help, prometheusPrefix, name)
+		for _, c := range classes {
+			h := value(snap.Classes[c])
+			label := ClassName(c)
+			var cumulative int64
+			for i, bound := range HistogramBounds {
+				if h.Counts != nil {
+					cumulative += h.Counts[i]
+				}
+				fmt.Fprintf(w, "%s%s_bucket{class=%q,le=%q} %d\n", prometheusPrefix, name, label,
+					strconv.FormatFloat(bound.Seconds(), 'g', -1, 64), cumulative)
+			}
+			fmt.Fprintf(w, "%s%s_bucket{class=%q,le=\"+Inf\"} %d\n", prometheusPrefix, name, label, h.Count)
+			fmt.Fprintf(w, "%s%s_sum{class=%q} %s\n", prometheusPrefix, name, label,
+				strconv.FormatFloat(h.Sum.Seconds(), 'g', -1, 64))
+			fmt.Fprintf(w, "%s%s_count{class=%q} %d\n", prometheusPrefix, name, label, h.Count)
+		}
+	}
+	histogram("queue_delay_seconds", "Time from enqueue to the start of service.",
+		func(c ClassSnapshot) Histogram { return c.QueueDelay })
+	histogram("service_time_seconds", "Time from the start of service to the response.",
+		func(c ClassSnapshot) Histogram { return c.ServiceTime })
+	histogram("response_time_seconds", "Time from enqueue to the response.",
+		func(c ClassSnapshot) Histogram { return c.ResponseTime })
+}
@@ -0,0 +1,80 @@
+package metrics_test
+
+import (
+	"io"
+	"main/src/model"
+	"main/src/server/metrics"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests if /metrics exposes the counters, gauges and histograms of the
+// session in the Prometheus text format.
+func TestPrometheusHandler(t *testing.T) {
+	s := metrics.NewSession(t.TempDir(), "", nil, metrics.Formats{})
+	defer s.Close()
+	serve(s, model.HIGH_PRIORITY, 100)
+	serve(s, model.HIGH_PRIORITY, 50)
+	for i := 0; i < 3; i++ {
+		s.OnTaskEnqueued(model.LOW_PRIORITY)
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.PrometheusHandler(s).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(recorder.Body)
+	assert.Nil(t, err)
+	text := string(body)
+
+	assert.Contains(t, recorder.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, text, "# TYPE tccquic_requests_enqueued_total counter\n")
+	assert.Contains(t, text, `tccquic_requests_completed_total{class="high"} 2`)
+	assert.Contains(t, text, `tccquic_bytes_sent_total{class="high"} 150`)
+	assert.Contains(t, text, `tccquic_queue_length{class="low"} 3`)
+	assert.Contains(t, text, "tccquic_in_service 0\n")
+	assert.Contains(t, text, `tccquic_requests_outcome_total{reason="on_time"} 2`)
+	assert.Contains(t, text, `tccquic_requests_outcome_total{reason="write_error"} 0`)
+	assert.Contains(t, text, "# TYPE tccquic_response_time_seconds histogram\n")
+	assert.Contains(t, text, `tccquic_response_time_seconds_bucket{class="high",le="+Inf"} 2`)
+	assert.Contains(t, text, `tccquic_response_time_seconds_count{class="low"} 0`)
+}
+
+// Tests if observations land in the first bucket that holds them.
+func TestHistogram_Observe(t *testing.T) {
+	var h metrics.Histogram
+	h.Observe(0)
+	h.Observe(metrics.HistogramBounds[0])
+	h.Observe(metrics.HistogramBounds[0] + 1)
+	h.Observe(2 * metrics.HistogramBounds[len(metrics.HistogramBounds)-1])
+
+	assert.Equal(t, int64(2), h.Counts[0])
+	assert.Equal(t, int64(1), h.Counts[1])
+	assert.Equal(t, int64(1), h.Counts[len(metrics.HistogramBounds)])
+	assert.Equal(t, int64(4), h.Count)
+}
+
+// Tests if a request that fails without data counts as failed, not as
+// completed, and stays out of the response times.
+func TestPrometheusHandler_Failure(t *testing.T) {
+	s := metrics.NewSession(t.TempDir(), "", nil, metrics.Formats{})
+	defer s.Close()
+	serve(s, model.HIGH_PRIORITY, 100)
+	ctx := &metrics.TaskCtx{Class: model.HIGH_PRIORITY, EnqueuedAt: time.Now(), Deadline: time.Now().Add(time.Second)}
+	s.OnEnqueue(model.HIGH_PRIORITY)
+	s.OnStart(ctx)
+	s.OnFailure(ctx)
+	s.OnOutcome(model.NOT_FOUND_OUTCOME)
+
+	recorder := httptest.NewRecorder()
+	metrics.PrometheusHandler(s).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(recorder.Body)
+	assert.Nil(t, err)
+	text := string(body)
+
+	assert.Contains(t, text, `tccquic_requests_completed_total{class="high"} 1`)
+	assert.Contains(t, text, `tccquic_requests_failed_total{class="high"} 1`)
+	assert.Contains(t, text, `tccquic_requests_outcome_total{reason="not_found"} 1`)
+	assert.Contains(t, text, `tccquic_response_time_seconds_count{class="high"} 1`)
+}
@@ -0,0 +1,333 @@
+package metrics
+
+import (
+	"main/src/model"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Colunas do reqlog.csv (também usadas pelo simulador).
+var ReqlogHeader = []string{
+	"time_ns", "event", "class", "segment", "tile",
+	"bytes", "ontime", "drop",
+	"qd_ms", "svc_ms", "rsp_ms",
+	"fault",
+	"pred_ms", "feasible",
+	"request_id",
+	"outcome",
+}
+
+// Classes e intervalo das séries periódicas (fairness, work-conserving, WFQ).
+var seriesClasses = []ClassInt{0, 1, 2}
+
+const seriesInterval = 1 * time.Second
+
+// Intervalo das amostras do queue_len.
+const queueSampleInterval = 100 * time.Millisecond
+
+// Session reúne as métricas de um escopo — uma conexão ou o servidor todo —
+// e os CSVs dele: reqlog, class_agg, queue_len, server_summary, fairness,
+// work_conserving e wfq_utilization.
+//
+// Uma sessão com parent (conexão) repassa seus eventos ao parent (agregado do
+// servidor), de modo que o agregado cobre todas as conexões. Os métodos
+// aceitam uma *Session nil e não fazem nada.
+type Session struct {
+	id     string
+	parent *Session
+
+	formats Formats
+
+	m        *Metrics
+	reqlog   *sink
+	fairness *Fairness
+	wfq      *WFQUtil
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewSession abre os CSVs da sessão em dir e começa a contagem do tempo.
+// Com id vazio os arquivos têm os nomes de sempre (reqlog.csv...); senão
+// levam o id como sufixo (reqlog-<id>.csv...). formats escolhe CSV ou JSONL
+// por série (reqlog.jsonl...); uma sessão com parent usa os do parent.
+func NewSession(dir string, id string, parent *Session, formats Formats) *Session {
+	if parent != nil {
+		formats = parent.formats
+	}
+	s := &Session{
+		id:      id,
+		parent:  parent,
+		formats: formats,
+		m:       NewMetrics(),
+	}
+	s.reqlog = openSink(s.path(dir, "reqlog"), ReqlogHeader)
+	s.m.InitClassAgg(s.path(dir, "class_agg"))
+	s.m.InitQueueCSV(s.path(dir, "queue_len"), queueSampleInterval)
+	s.m.InitSummary(s.path(dir, "server_summary"))
+	s.m.InitLatencyCSV(s.path(dir, "latency"), seriesInterval)
+	s.m.MarkRunStart()
+	s.fairness = NewFairnessWriter(s.path(dir, "fairness"), seriesClasses, seriesInterval)
+	s.m.fairness = s.fairness
+	s.m.InitWorkConservingCSV(s.path(dir, "work_conserving"), seriesInterval)
+	s.wfq = NewWFQUtilWriter(s.path(dir, "wfq_utilization"), seriesClasses, seriesInterval)
+	s.m.rowSinks = []*sink{s.reqlog}
+	if s.wfq != nil {
+		s.m.rowSinks = append(s.m.rowSinks, s.wfq.out)
+	}
+	return s
+}
+
+func (s *Session) path(dir, series string) string {
+	name := series
+	if s.id != "" {
+		name += "-" + s.id
+	}
+	return filepath.Join(dir, name+"."+string(s.formats.For(series)))
+}
+
+// Close escreve o resumo final e fecha os CSVs. O estado da sessão (filas,
+// serviço) sai do agregado do parent.
+func (s *Session) Close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	if s.parent != nil {
+		queued, inService := s.m.schedulerState()
+		s.parent.release(queued, inService, time.Now())
+	}
+
+	// a última janela da fairness entra no resumo
+	s.fairness.Stop()
+	s.m.WriteSummaryAndClose()
+	s.reqlog.close()
+	s.wfq.Stop()
+	// as linhas descartadas entram no server_summary do agregado
+	if s.parent != nil {
+		s.parent.m.addClosedRowsDropped(s.m.droppedRows())
+	}
+}
+
+// FlushSummary escreve uma linha de resumo parcial (do início até agora) no
+// server_summary desta sessão.
+func (s *Session) FlushSummary() {
+	if s == nil {
+		return
+	}
+	s.m.WriteSummary()
+}
+
+// -------------------- Eventos por requisição --------------------
+
+func (s *Session) OnEnqueue(class Class) {
+	if s == nil {
+		return
+	}
+	s.m.OnEnqueue(class)
+	s.parent.OnEnqueue(class)
+}
+
+func (s *Session) OnStart(ctx *TaskCtx) {
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	s.m.onStart(ctx, now)
+	if s.parent != nil {
+		s.parent.m.onStart(ctx, now)
+	}
+}
+
+func (s *Session) OnComplete(ctx *TaskCtx, bytes int, dropped bool) {
+	if s == nil {
+		return
+	}
+	s.m.OnComplete(ctx, bytes, dropped)
+	s.parent.OnComplete(ctx, bytes, dropped)
+}
+
+func (s *Session) OnDeadlineDropWithBytes(ctx *TaskCtx, estBytes int64) {
+	if s == nil {
+		return
+	}
+	s.m.OnDeadlineDropWithBytes(ctx, estBytes)
+	s.parent.OnDeadlineDropWithBytes(ctx, estBytes)
+}
+
+// OnFailure registra uma requisição que terminou sem bytes antes do deadline.
+func (s *Session) OnFailure(ctx *TaskCtx) {
+	if s == nil {
+		return
+	}
+	s.m.OnFailure(ctx)
+	s.parent.OnFailure(ctx)
+}
+
+// OnFeasibility confere uma previsão de viabilidade com o resultado.
+func (s *Session) OnFeasibility(predictedFeasible, onTime bool) {
+	if s == nil {
+		return
+	}
+	s.m.OnFeasibility(predictedFeasible, onTime)
+	s.parent.OnFeasibility(predictedFeasible, onTime)
+}
+
+// OnOutcome conta uma requisição terminada pelo motivo do resultado
+// (colunas outcome_* do server_summary).
+func (s *Session) OnOutcome(outcome model.Outcome) {
+	if s == nil {
+		return
+	}
+	s.m.OnOutcome(outcome)
+	s.parent.OnOutcome(outcome)
+}
+
+func (s *Session) OnInfeasibleSkip(ctx *TaskCtx, estBytes int64) {
+	if s == nil {
+		return
+	}
+	s.m.OnInfeasibleSkip(ctx, estBytes)
+	s.parent.OnInfeasibleSkip(ctx, estBytes)
+}
+
+func (s *Session) OnPreempt(preempted, preemptor Class) {
+	if s == nil {
+		return
+	}
+	s.m.OnPreempt(preempted, preemptor)
+	s.parent.OnPreempt(preempted, preemptor)
+}
+
+// RecordBytes conta bytes enviados por uma requisição completa (fairness e
+// WFQ utilization).
+func (s *Session) RecordBytes(class Class, bytes int) {
+	if s == nil {
+		return
+	}
+	s.fairness.Record(int(class), bytes)
+	s.wfq.Record(int(class), bytes)
+	s.parent.RecordBytes(class, bytes)
+}
+
+// LogRequest escreve uma linha do reqlog (colunas ReqlogHeader).
+func (s *Session) LogRequest(row []string) {
+	if s == nil {
+		return
+	}
+	s.reqlog.write(row)
+	s.parent.LogRequest(row)
+}
+
+// -------------------- Estado do scheduler --------------------
+
+// Os eventos do scheduler vão para o Accounting da sessão e do parent (ver
+// Accounting), com o mesmo tempo.
+
+// OnTaskEnqueued: o scheduler pôs uma tarefa de class na fila.
+func (s *Session) OnTaskEnqueued(class Class) {
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	s.fairness.Queue(class, s.m.onTaskEnqueued(class, now))
+	if s.parent != nil {
+		s.parent.fairness.Queue(class, s.parent.m.onTaskEnqueued(class, now))
+	}
+}
+
+// OnTaskDequeued: o scheduler tirou uma tarefa de class da fila para servi-la.
+// A inversão é decidida pelas filas da sessão e repetida no parent.
+func (s *Session) OnTaskDequeued(class Class) {
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	n, inversion := s.m.onTaskDequeued(class, now, nil)
+	s.fairness.Queue(class, n)
+	if s.parent != nil {
+		n, _ := s.parent.m.onTaskDequeued(class, now, &inversion)
+		s.parent.fairness.Queue(class, n)
+	}
+}
+
+// OnTaskDropped: o scheduler tirou uma tarefa de class da fila sem servi-la.
+func (s *Session) OnTaskDropped(class Class) {
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	s.fairness.Queue(class, s.m.onTaskDropped(class, now))
+	if s.parent != nil {
+		s.parent.fairness.Queue(class, s.parent.m.onTaskDropped(class, now))
+	}
+}
+
+// OnTaskFinished: o serviço de uma tarefa terminou.
+func (s *Session) OnTaskFinished() {
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	s.m.onTaskFinished(now)
+	if s.parent != nil {
+		s.parent.m.onTaskFinished(now)
+	}
+}
+
+// release tira do agregado as filas e serviços de uma conexão que fechou.
+func (s *Session) release(queued [model.PRIORITY_LEVEL_COUNT]int, inService int, now time.Time) {
+	for c, n := range queued {
+		for ; n > 0; n-- {
+			s.fairness.Queue(Class(c), s.m.onTaskDropped(Class(c), now))
+		}
+	}
+	for ; inService > 0; inService-- {
+		s.m.onTaskFinished(now)
+	}
+}
+
+// SetWFQWeights define os pesos alvo do wfq_utilization.csv, nesta sessão
+// e no agregado.
+func (s *Session) SetWFQWeights(weights map[ClassInt]float64) {
+	if s == nil {
+		return
+	}
+	s.SetOwnWFQWeights(weights)
+	s.parent.SetWFQWeights(weights)
+}
+
+// SetFairnessWeights define os pesos da fairness ponderada (fairness.csv e
+// server_summary), nesta sessão e no agregado; nil = pesos iguais.
+func (s *Session) SetFairnessWeights(weights map[ClassInt]float64) {
+	if s == nil {
+		return
+	}
+	s.SetOwnFairnessWeights(weights)
+	s.parent.SetFairnessWeights(weights)
+}
+
+// SetOwnWFQWeights é como SetWFQWeights, mas só nesta sessão: a política de
+// uma conexão mudou sem mudar a do servidor.
+func (s *Session) SetOwnWFQWeights(weights map[ClassInt]float64) {
+	if s == nil {
+		return
+	}
+	s.wfq.SetWeights(weights)
+}
+
+// SetOwnFairnessWeights é como SetFairnessWeights, mas só nesta sessão.
+func (s *Session) SetOwnFairnessWeights(weights map[ClassInt]float64) {
+	if s == nil {
+		return
+	}
+	s.fairness.SetWeights(weights)
+}
@@ -0,0 +1,110 @@
+package metrics_test
+
+import (
+	"encoding/csv"
+	"main/src/model"
+	"main/src/server/metrics"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readSummary(t *testing.T, path string) map[string]string {
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rows))
+	values := map[string]string{}
+	for i, name := range rows[0] {
+		values[name] = rows[1][i]
+	}
+	return values
+}
+
+func serve(s *metrics.Session, class model.Priority, bytes int) {
+	ctx := &metrics.TaskCtx{Class: class, EnqueuedAt: time.Now(), Deadline: time.Now().Add(time.Second)}
+	s.OnEnqueue(class)
+	s.OnTaskEnqueued(class)
+	s.OnTaskDequeued(class)
+	s.OnStart(ctx)
+	s.RecordBytes(class, bytes)
+	s.OnComplete(ctx, bytes, false)
+	s.OnTaskFinished()
+	s.OnOutcome(model.ON_TIME_OUTCOME)
+}
+
+// Tests if each connection writes its own files and the server aggregate
+// adds them up, whatever the order in which the connections close.
+func TestSession_Aggregate(t *testing.T) {
+	dir := t.TempDir()
+	server := metrics.NewSession(dir, "", nil, metrics.Formats{})
+	conn1 := metrics.NewSession(dir, "conn1", server, metrics.Formats{})
+	conn2 := metrics.NewSession(dir, "conn2", server, metrics.Formats{})
+
+	serve(conn1, model.HIGH_PRIORITY, 100)
+	conn1.Close()
+	// a connection that arrives after another one closed still counts
+	conn3 := metrics.NewSession(dir, "conn3", server, metrics.Formats{})
+	serve(conn2, model.LOW_PRIORITY, 20)
+	serve(conn3, model.HIGH_PRIORITY, 5)
+	conn2.Close()
+	conn3.Close()
+	server.Close()
+
+	assert.Equal(t, "100", readSummary(t, filepath.Join(dir, "server_summary-conn1.csv"))["bytes_high"])
+	assert.Equal(t, "20", readSummary(t, filepath.Join(dir, "server_summary-conn2.csv"))["bytes_low"])
+	total := readSummary(t, filepath.Join(dir, "server_summary.csv"))
+	assert.Equal(t, "105", total["bytes_high"])
+	assert.Equal(t, "20", total["bytes_low"])
+	assert.Equal(t, "3", total["outcome_on_time"])
+	assert.Equal(t, "0", total["outcome_not_found"])
+}
+
+// Tests if a nil session is a no-op.
+func TestSession_Nil(t *testing.T) {
+	var s *metrics.Session
+	serve(s, model.HIGH_PRIORITY, 1)
+	s.OnTaskEnqueued(model.HIGH_PRIORITY)
+	s.Close()
+}
+
+// Tests if the aggregate counts the inversions decided by each connection and
+// forgets the queues of a connection that closed.
+func TestSession_SchedulerState(t *testing.T) {
+	dir := t.TempDir()
+	server := metrics.NewSession(dir, "", nil, metrics.Formats{})
+	conn1 := metrics.NewSession(dir, "conn1", server, metrics.Formats{})
+	conn2 := metrics.NewSession(dir, "conn2", server, metrics.Formats{})
+
+	// high waits on conn1 while low starts: inversion
+	conn1.OnTaskEnqueued(model.HIGH_PRIORITY)
+	conn1.OnTaskEnqueued(model.LOW_PRIORITY)
+	conn1.OnTaskDequeued(model.LOW_PRIORITY)
+	// low starts on conn2 with nothing of its own waiting: no inversion,
+	// even though conn1 has high queued
+	conn2.OnTaskEnqueued(model.LOW_PRIORITY)
+	conn2.OnTaskDequeued(model.LOW_PRIORITY)
+
+	snap := server.Snapshot()
+	assert.Equal(t, int64(1), snap.Inversions)
+	assert.Equal(t, int64(2), snap.InService)
+	assert.Equal(t, 1, snap.Classes[model.HIGH_PRIORITY].QueueLen)
+
+	conn1.Close()
+	snap = server.Snapshot()
+	assert.Equal(t, int64(1), snap.Inversions)
+	assert.Equal(t, int64(1), snap.InService)
+	assert.Equal(t, 0, snap.Classes[model.HIGH_PRIORITY].QueueLen)
+
+	conn2.OnTaskFinished()
+	conn2.Close()
+	server.Close()
+	assert.Equal(t, "1", readSummary(t, filepath.Join(dir, "server_summary-conn1.csv"))["inversions"])
+	assert.Equal(t, "0", readSummary(t, filepath.Join(dir, "server_summary-conn2.csv"))["inversions"])
+	assert.Equal(t, "1", readSummary(t, filepath.Join(dir, "server_summary.csv"))["inversions"])
+}
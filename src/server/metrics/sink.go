@@ -0,0 +1,258 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Format é a codificação de um arquivo de métricas.
+type Format string
+
+const (
+	FormatCSV   Format = "csv"   // cabeçalho + uma linha por registro
+	FormatJSONL Format = "jsonl" // um objeto JSON por linha, com as colunas como chaves
+)
+
+// Formats escolhe o formato de cada série pelo nome (reqlog, class_agg,
+// queue_len, server_summary, latency, fairness, work_conserving,
+// wfq_utilization).
+// Default vale para as séries fora de Series; o zero é CSV em todas.
+type Formats struct {
+	Default Format
+	Series  map[string]Format
+}
+
+// ParseFormats lê "jsonl", "csv,reqlog=jsonl"...: um formato sozinho é o
+// padrão, e nome=formato escolhe o de uma série.
+func ParseFormats(spec string) (Formats, error) {
+	var formats Formats
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, named := strings.Cut(item, "=")
+		if !named {
+			name, value = "", item
+		}
+		format := Format(strings.TrimSpace(value))
+		if format != FormatCSV && format != FormatJSONL {
+			return Formats{}, fmt.Errorf("unknown metrics format %q (csv or jsonl)", value)
+		}
+		if !named {
+			formats.Default = format
+			continue
+		}
+		if formats.Series == nil {
+			formats.Series = map[string]Format{}
+		}
+		formats.Series[strings.TrimSpace(name)] = format
+	}
+	return formats, nil
+}
+
+// For devolve o formato da série name.
+func (f Formats) For(name string) Format {
+	if format, ok := f.Series[name]; ok {
+		return format
+	}
+	if f.Default != "" {
+		return f.Default
+	}
+	return FormatCSV
+}
+
+// Capacidade da fila de linhas de um sink e intervalo entre flushes.
+const (
+	sinkBuffer        = 8192
+	sinkFlushInterval = 1 * time.Second
+)
+
+// sink escreve as linhas de um arquivo de métricas em background: write só
+// enfileira (nunca bloqueia o caminho de serviço) e uma goroutine codifica,
+// com flush periódico e no close. O formato vem da extensão do arquivo
+// (.jsonl ou CSV).
+//
+// Compromisso: a fila tem sinkBuffer linhas. Se o disco não acompanhar e a
+// fila encher, a linha é descartada e contada em vez de esperar, para que a
+// E/S de métricas nunca entre no svc_ms e a memória fique limitada. O total
+// vai para o log no close e para a coluna metrics_rows_dropped do
+// server_summary; com ela acima de 0, o reqlog e as séries estão incompletos.
+// Os métodos aceitam um *sink nil e não fazem nada.
+type sink struct {
+	path   string
+	header []string
+
+	mu      sync.RWMutex // write x close
+	closed  bool
+	rows    chan []string
+	syncs   chan chan struct{}
+	done    chan struct{}
+	dropped atomic.Int64 // linhas descartadas com a fila cheia
+}
+
+// openSink cria (ou trunca) o arquivo em path: uma nova execução no mesmo
+// diretório nunca soma linhas às da anterior. Retorna nil se o arquivo não
+// abre.
+func openSink(path string, header []string) *sink {
+	if path == "" {
+		return nil
+	}
+	_ = os.MkdirAll(filepath.Dir(path), 0o755)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Printf("[METRICS] open %s: %v", path, err)
+		return nil
+	}
+	w := bufio.NewWriter(f)
+	var encode func(row []string)
+	if strings.HasSuffix(path, ".jsonl") {
+		encode = func(row []string) { writeJSONL(w, header, row) }
+	} else {
+		cw := csv.NewWriter(w)
+		_ = cw.Write(header)
+		encode = func(row []string) {
+			_ = cw.Write(row)
+			cw.Flush() // só até o bufio
+		}
+	}
+
+	s := &sink{
+		path:   path,
+		header: header,
+		rows:   make(chan []string, sinkBuffer),
+		syncs:  make(chan chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go s.run(f, w, encode)
+	return s
+}
+
+func (s *sink) run(f *os.File, w *bufio.Writer, encode func(row []string)) {
+	defer close(s.done)
+	ticker := time.NewTicker(sinkFlushInterval)
+	defer ticker.Stop()
+	drain := func() {
+		for n := len(s.rows); n > 0; n-- {
+			encode(<-s.rows)
+		}
+	}
+	for {
+		select {
+		case row, ok := <-s.rows:
+			if !ok {
+				_ = w.Flush()
+				_ = f.Close()
+				return
+			}
+			encode(row)
+		case <-ticker.C:
+			_ = w.Flush()
+		case ack := <-s.syncs:
+			drain()
+			_ = w.Flush()
+			close(ack)
+		}
+	}
+}
+
+// write enfileira uma linha (colunas do cabeçalho) sem bloquear; com a fila
+// cheia a linha é descartada e contada.
+func (s *sink) write(row []string) {
+	if s == nil {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.rows <- row:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// droppedRows conta as linhas descartadas até agora.
+func (s *sink) droppedRows() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.dropped.Load()
+}
+
+// sync espera as linhas já enfileiradas chegarem ao arquivo.
+func (s *sink) sync() {
+	if s == nil {
+		return
+	}
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return
+	}
+	ack := make(chan struct{})
+	s.syncs <- ack
+	s.mu.RUnlock()
+	<-ack
+}
+
+// close escreve o que falta e fecha o arquivo.
+func (s *sink) close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.rows)
+	s.mu.Unlock()
+	<-s.done
+	if n := s.dropped.Load(); n > 0 {
+		log.Printf("[METRICS] %s: %d rows dropped (sink full)", s.path, n)
+	}
+}
+
+// writeJSONL escreve row como um objeto com as chaves de header, na ordem.
+// Números e booleanos viram valores JSON; o resto, strings.
+func writeJSONL(w *bufio.Writer, header, row []string) {
+	_ = w.WriteByte('{')
+	for i, value := range row {
+		if i > 0 {
+			_ = w.WriteByte(',')
+		}
+		key := fmt.Sprintf("col%d", i)
+		if i < len(header) {
+			key = header[i]
+		}
+		name, _ := json.Marshal(key)
+		_, _ = w.Write(name)
+		_ = w.WriteByte(':')
+		_, _ = w.WriteString(jsonValue(value))
+	}
+	_, _ = w.WriteString("}\n")
+}
+
+func jsonValue(value string) string {
+	if value == "true" || value == "false" {
+		return value
+	}
+	if value != "" && (value[0] == '-' || value[0] >= '0' && value[0] <= '9') && json.Valid([]byte(value)) {
+		return value
+	}
+	quoted, _ := json.Marshal(value)
+	return string(quoted)
+}
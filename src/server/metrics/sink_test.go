@@ -0,0 +1,105 @@
+package metrics_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"main/src/model"
+	"main/src/server/metrics"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests if a bare format is the default and name=format picks one series.
+func TestParseFormats(t *testing.T) {
+	formats, err := metrics.ParseFormats("csv, reqlog=jsonl")
+	assert.Nil(t, err)
+	assert.Equal(t, metrics.FormatJSONL, formats.For("reqlog"))
+	assert.Equal(t, metrics.FormatCSV, formats.For("queue_len"))
+
+	formats, err = metrics.ParseFormats("jsonl,server_summary=csv")
+	assert.Nil(t, err)
+	assert.Equal(t, metrics.FormatJSONL, formats.For("reqlog"))
+	assert.Equal(t, metrics.FormatCSV, formats.For("server_summary"))
+
+	assert.Equal(t, metrics.FormatCSV, metrics.Formats{}.For("reqlog"))
+
+	_, err = metrics.ParseFormats("parquet")
+	assert.NotNil(t, err)
+	_, err = metrics.ParseFormats("reqlog=xml")
+	assert.NotNil(t, err)
+}
+
+func readJSONL(t *testing.T, path string) []map[string]interface{} {
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+// Tests if the series chosen as JSONL are written as typed objects, the
+// others as CSV, and connections follow the formats of the aggregate.
+func TestSession_JSONL(t *testing.T) {
+	dir := t.TempDir()
+	formats, err := metrics.ParseFormats("csv,reqlog=jsonl")
+	assert.Nil(t, err)
+	server := metrics.NewSession(dir, "", nil, formats)
+	conn := metrics.NewSession(dir, "conn1", server, metrics.Formats{})
+
+	serve(conn, model.HIGH_PRIORITY, 1000)
+	conn.LogRequest([]string{"1", "complete", "0", "3", "101", "1000", "true", "false", "2", "5", "7", "", "", "", ""})
+	conn.Close()
+	server.Close()
+
+	for _, name := range []string{"reqlog.jsonl", "reqlog-conn1.jsonl"} {
+		records := readJSONL(t, filepath.Join(dir, name))
+		assert.Equal(t, 1, len(records))
+		assert.Equal(t, "complete", records[0]["event"])
+		assert.Equal(t, 1000.0, records[0]["bytes"])
+		assert.Equal(t, true, records[0]["ontime"])
+		assert.Equal(t, "", records[0]["fault"])
+	}
+	_, err = os.Stat(filepath.Join(dir, "reqlog.csv"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, "1000", readSummary(t, filepath.Join(dir, "server_summary-conn1.csv"))["bytes_high"])
+}
+
+// Tests if a partial summary is on disk when FlushSummary returns.
+func TestSession_FlushSummary(t *testing.T) {
+	dir := t.TempDir()
+	s := metrics.NewSession(dir, "", nil, metrics.Formats{})
+	defer s.Close()
+
+	serve(s, model.LOW_PRIORITY, 500)
+	s.FlushSummary()
+	assert.Equal(t, "500", readSummary(t, filepath.Join(dir, "server_summary.csv"))["bytes_low"])
+}
+
+// Tests if a second run in the same directory replaces the files of the
+// first instead of appending to them.
+func TestSession_RerunTruncates(t *testing.T) {
+	dir := t.TempDir()
+	s := metrics.NewSession(dir, "", nil, metrics.Formats{})
+	serve(s, model.HIGH_PRIORITY, 1000)
+	s.Close()
+
+	s = metrics.NewSession(dir, "", nil, metrics.Formats{})
+	serve(s, model.HIGH_PRIORITY, 300)
+	s.Close()
+
+	// one header and one row each
+	assert.Equal(t, "300", readSummary(t, filepath.Join(dir, "server_summary.csv"))["bytes_high"])
+	data, err := os.ReadFile(filepath.Join(dir, "class_agg.csv"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, bytes.Count(data, []byte("\n")))
+}
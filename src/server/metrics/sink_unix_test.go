@@ -0,0 +1,58 @@
+//go:build unix
+
+package metrics_test
+
+import (
+	"bufio"
+	"main/src/server/metrics"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests if rows written while the file writer is stalled are dropped and
+// counted instead of blocking, and the count reaches the summaries of the
+// connection and of the aggregate. The reqlog is a FIFO nobody reads until
+// every row was written.
+func TestSession_StalledWriterDropsRows(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "reqlog-conn1.csv")
+	assert.Nil(t, syscall.Mkfifo(path, 0o644))
+	reader, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	assert.Nil(t, err)
+	defer reader.Close()
+
+	server := metrics.NewSession(dir, "", nil, metrics.Formats{})
+	s := metrics.NewSession(dir, "conn1", server, metrics.Formats{})
+	const rows = 3 * 8192
+	for i := 0; i < rows; i++ {
+		s.LogRequest([]string{"1", "complete", "0", "3", "101", "1000", "true", "false", "2", "5", "7", "", "", "", "", "on_time"})
+	}
+
+	lines := make(chan int)
+	go func() {
+		n := 0
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			n++
+		}
+		lines <- n
+	}()
+	s.Close()
+	written := <-lines - 1 // header
+
+	server.Close()
+
+	dropped, err := strconv.Atoi(readSummary(t, filepath.Join(dir, "server_summary-conn1.csv"))["metrics_rows_dropped"])
+	assert.Nil(t, err)
+	assert.True(t, dropped > 0)
+	assert.Equal(t, rows, written+dropped)
+	// plus any rows the aggregate dropped in its own reqlog
+	total, err := strconv.Atoi(readSummary(t, filepath.Join(dir, "server_summary.csv"))["metrics_rows_dropped"])
+	assert.Nil(t, err)
+	assert.True(t, total >= dropped)
+}
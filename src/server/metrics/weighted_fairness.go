@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"math"
+
+	"main/src/model"
+)
+
+// WeightedFairness mede a fairness ponderada por janelas. Numa janela só
+// entram as classes com backlog (trabalho na fila em algum momento da
+// janela), e o serviço de cada uma é normalizado pelo seu peso: x = bytes /
+// peso. Sobre esses x calcula o índice de Jain (1 = cada classe recebeu na
+// proporção do peso) e a razão min/max (max-min normalizada: 1 = justo,
+// 0 = alguma classe com backlog não foi servida).
+//
+// Não é seguro para uso concorrente; quem chama protege com seu lock. O
+// tempo é dado por quem chama (relógio real no servidor, virtual no sim).
+type WeightedFairness struct {
+	weights    [model.PRIORITY_LEVEL_COUNT]float64
+	queued     [model.PRIORITY_LEVEL_COUNT]int
+	backlogged [model.PRIORITY_LEVEL_COUNT]bool
+	bytes      [model.PRIORITY_LEVEL_COUNT]int64
+
+	// janelas com pelo menos duas classes com backlog
+	windows  int64
+	jainSum  float64
+	ratioSum float64
+}
+
+// FairnessWindow é o resultado de uma janela. Jain e MinMaxRatio são NaN
+// quando menos de duas classes com backlog (e peso) foram comparadas, ou
+// nada foi enviado.
+type FairnessWindow struct {
+	Bytes       [model.PRIORITY_LEVEL_COUNT]int64
+	Backlogged  [model.PRIORITY_LEVEL_COUNT]bool
+	Jain        float64
+	MinMaxRatio float64
+}
+
+// NewWeightedFairness começa com pesos iguais (FIFO, SP).
+func NewWeightedFairness() *WeightedFairness {
+	w := &WeightedFairness{}
+	w.SetWeights(nil)
+	return w
+}
+
+// SetWeights define os pesos por classe; sem pesos positivos, todos iguais.
+func (w *WeightedFairness) SetWeights(weights map[ClassInt]float64) {
+	var sum float64
+	for c := 0; c < model.PRIORITY_LEVEL_COUNT; c++ {
+		sum += math.Max(weights[c], 0)
+	}
+	for c := 0; c < model.PRIORITY_LEVEL_COUNT; c++ {
+		if sum > 0 {
+			w.weights[c] = math.Max(weights[c], 0) / sum
+		} else {
+			w.weights[c] = 1.0 / float64(model.PRIORITY_LEVEL_COUNT)
+		}
+	}
+}
+
+// Weights retorna os pesos normalizados (somando 1).
+func (w *WeightedFairness) Weights() [model.PRIORITY_LEVEL_COUNT]float64 {
+	return w.weights
+}
+
+// Queue informa o tamanho da fila de uma classe.
+func (w *WeightedFairness) Queue(class Class, length int) {
+	w.queued[class] = length
+	if length > 0 {
+		w.backlogged[class] = true
+	}
+}
+
+// Sent conta bytes enviados por uma classe.
+func (w *WeightedFairness) Sent(class Class, bytes int) {
+	if bytes > 0 {
+		w.bytes[class] += int64(bytes)
+	}
+}
+
+// Window fecha a janela atual e começa outra, na qual as classes ainda com
+// fila já contam com backlog.
+func (w *WeightedFairness) Window() FairnessWindow {
+	win := FairnessWindow{Bytes: w.bytes, Backlogged: w.backlogged}
+	win.Jain, win.MinMaxRatio = weightedFairness(w.bytes, w.backlogged, w.weights)
+	if !math.IsNaN(win.Jain) {
+		w.windows++
+		w.jainSum += win.Jain
+		w.ratioSum += win.MinMaxRatio
+	}
+	for c := range w.bytes {
+		w.bytes[c] = 0
+		w.backlogged[c] = w.queued[c] > 0
+	}
+	return win
+}
+
+// Totals retorna quantas janelas tinham ao menos duas classes com backlog e
+// as médias de Jain e da razão min/max sobre elas (NaN se nenhuma).
+func (w *WeightedFairness) Totals() (windows int64, jain, minMaxRatio float64) {
+	if w.windows == 0 {
+		return 0, math.NaN(), math.NaN()
+	}
+	n := float64(w.windows)
+	return w.windows, w.jainSum / n, w.ratioSum / n
+}
+
+// Jain e min/max de bytes/peso sobre as classes com backlog e peso.
+func weightedFairness(bytes [model.PRIORITY_LEVEL_COUNT]int64, backlogged [model.PRIORITY_LEVEL_COUNT]bool,
+	weights [model.PRIORITY_LEVEL_COUNT]float64) (jain, ratio float64) {
+	var sum, sum2 float64
+	lo, hi := math.Inf(1), 0.0
+	n := 0
+	for c := 0; c < model.PRIORITY_LEVEL_COUNT; c++ {
+		if !backlogged[c] || weights[c] <= 0 {
+			continue
+		}
+		x := float64(bytes[c]) / weights[c]
+		sum += x
+		sum2 += x * x
+		lo = math.Min(lo, x)
+		hi = math.Max(hi, x)
+		n++
+	}
+	if n < 2 || sum2 == 0 {
+		return math.NaN(), math.NaN()
+	}
+	return (sum * sum) / (float64(n) * sum2), lo / hi
+}
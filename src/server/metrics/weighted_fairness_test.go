@@ -0,0 +1,84 @@
+package metrics_test
+
+import (
+	"main/src/model"
+	"main/src/server/metrics"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// WFQ weights low=1, medium=2, high=3.
+func wfqFairness() *metrics.WeightedFairness {
+	w := metrics.NewWeightedFairness()
+	w.SetWeights(map[metrics.ClassInt]float64{
+		int(model.LOW_PRIORITY): 1, int(model.MEDIUM_PRIORITY): 2, int(model.HIGH_PRIORITY): 3,
+	})
+	return w
+}
+
+// Tests if service in proportion to the weights is perfectly fair
+func TestWeightedFairness_Proportional(t *testing.T) {
+	w := wfqFairness()
+	for _, c := range []model.Priority{model.LOW_PRIORITY, model.MEDIUM_PRIORITY, model.HIGH_PRIORITY} {
+		w.Queue(c, 1)
+	}
+	w.Sent(model.LOW_PRIORITY, 1000)
+	w.Sent(model.MEDIUM_PRIORITY, 2000)
+	w.Sent(model.HIGH_PRIORITY, 3000)
+	win := w.Window()
+	assert.InDelta(t, 1, win.Jain, 1e-9)
+	assert.InDelta(t, 1, win.MinMaxRatio, 1e-9)
+}
+
+// Tests if a backlogged class left unserved is unfair, and equal shares under
+// unequal weights are not perfectly fair
+func TestWeightedFairness_Unfair(t *testing.T) {
+	w := wfqFairness()
+	w.Queue(model.LOW_PRIORITY, 2)
+	w.Queue(model.HIGH_PRIORITY, 2)
+	w.Sent(model.HIGH_PRIORITY, 3000)
+	win := w.Window()
+	assert.InDelta(t, 0.5, win.Jain, 1e-9)
+	assert.Equal(t, 0.0, win.MinMaxRatio)
+
+	// both still queued: backlogged in the next window too
+	w.Sent(model.LOW_PRIORITY, 1000)
+	w.Sent(model.HIGH_PRIORITY, 1000)
+	win = w.Window()
+	assert.True(t, win.Backlogged[model.LOW_PRIORITY])
+	assert.InDelta(t, 1.0/3, win.MinMaxRatio, 1e-9)
+	assert.InDelta(t, 16.0/20, win.Jain, 1e-9) // x = 1000/(1/6), 1000/(3/6)
+
+	windows, jain, ratio := w.Totals()
+	assert.Equal(t, int64(2), windows)
+	assert.InDelta(t, (0.5+0.8)/2, jain, 1e-9)
+	assert.InDelta(t, (0+1.0/3)/2, ratio, 1e-9)
+}
+
+// Tests if idle classes are left out and windows with fewer than two
+// backlogged classes have no value
+func TestWeightedFairness_IdleClasses(t *testing.T) {
+	w := metrics.NewWeightedFairness() // equal weights
+	w.Queue(model.HIGH_PRIORITY, 3)
+	w.Queue(model.MEDIUM_PRIORITY, 1)
+	w.Queue(model.MEDIUM_PRIORITY, 0)
+	w.Sent(model.HIGH_PRIORITY, 500)
+	w.Sent(model.MEDIUM_PRIORITY, 500)
+	w.Sent(model.LOW_PRIORITY, 5000) // never queued
+	win := w.Window()
+	assert.False(t, win.Backlogged[model.LOW_PRIORITY])
+	assert.InDelta(t, 1, win.Jain, 1e-9)
+
+	w.Queue(model.HIGH_PRIORITY, 0)
+	win = w.Window() // only high was backlogged
+	assert.True(t, math.IsNaN(win.Jain))
+	assert.True(t, math.IsNaN(win.MinMaxRatio))
+
+	windows, _, _ := w.Totals()
+	assert.Equal(t, int64(1), windows)
+	windows, jain, _ := metrics.NewWeightedFairness().Totals()
+	assert.Equal(t, int64(0), windows)
+	assert.True(t, math.IsNaN(jain))
+}
@@ -1,10 +1,7 @@
 package metrics
 
 import (
-	"encoding/csv"
 	"math"
-	"os"
-	"path/filepath"
 	"strconv"
 	"sync"
 	"time"
@@ -12,8 +9,7 @@ import (
 
 type WFQUtil struct {
 	mu      sync.Mutex
-	file    *os.File
-	w       *csv.Writer
+	out     *sink
 	ticker  *time.Ticker
 	stop    chan struct{}
 	classes []ClassInt
@@ -22,77 +18,69 @@ type WFQUtil struct {
 	bytes   map[ClassInt]int64   // bytes por janela
 }
 
-var wfqOnce sync.Once
-var wfqInst *WFQUtil
-
-func StartWFQUtilWriter(csvPath string, classes []ClassInt, interval time.Duration) {
-	wfqOnce.Do(func() {
-		if csvPath == "" {
-			csvPath = "/tmp/server_scheduler_test/wfq_utilization.csv"
-		}
-		_ = os.MkdirAll(filepath.Dir(csvPath), 0o755)
-		f, _ := os.OpenFile(csvPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
-		w := csv.NewWriter(f)
-		if st, _ := f.Stat(); st != nil && st.Size() == 0 {
-			_ = w.Write([]string{
-				"ts", "w_low", "w_medium", "w_high",
-				"share_low", "share_medium", "share_high",
-				"err_low", "err_medium", "err_high", "mae",
-			})
-			w.Flush()
-		}
-		wfqInst = &WFQUtil{
-			file:    f,
-			w:       w,
-			ticker:  time.NewTicker(interval),
-			stop:    make(chan struct{}),
-			classes: classes,
-			weights: map[ClassInt]float64{},
-			bytes:   map[ClassInt]int64{},
-		}
-		go wfqInst.loop()
+// NewWFQUtilWriter abre csvPath e escreve, a cada interval, as shares
+// observadas por classe contra os pesos WFQ. Retorna nil se o arquivo não
+// abre.
+func NewWFQUtilWriter(csvPath string, classes []ClassInt, interval time.Duration) *WFQUtil {
+	out := openSink(csvPath, []string{
+		"ts", "w_low", "w_medium", "w_high",
+		"share_low", "share_medium", "share_high",
+		"err_low", "err_medium", "err_high", "mae",
 	})
+	if out == nil {
+		return nil
+	}
+	u := &WFQUtil{
+		out:     out,
+		ticker:  time.NewTicker(interval),
+		stop:    make(chan struct{}),
+		classes: classes,
+		weights: map[ClassInt]float64{},
+		bytes:   map[ClassInt]int64{},
+	}
+	go u.loop()
+	return u
 }
 
-func StopWFQUtilWriter() {
-	if wfqInst == nil {
+func (u *WFQUtil) Stop() {
+	if u == nil {
 		return
 	}
-	close(wfqInst.stop)
-	wfqInst.ticker.Stop()
-	wfqInst.w.Flush()
-	_ = wfqInst.file.Close()
-	wfqInst = nil
+	close(u.stop)
+	u.ticker.Stop()
+	u.mu.Lock()
+	u.out.close()
+	u.mu.Unlock()
 }
 
 // defina os pesos WFQ (ex.: {low:1, medium:2, high:3})
-func SetWFQWeights(weights map[ClassInt]float64) {
-	if wfqInst == nil {
+func (u *WFQUtil) SetWeights(weights map[ClassInt]float64) {
+	if u == nil {
 		return
 	}
 	// normaliza p/ somar 1
 	var sum float64
-	for _, c := range wfqInst.classes {
+	for _, c := range u.classes {
 		sum += weights[c]
 	}
-	wfqInst.mu.Lock()
-	wfqInst.weights = map[ClassInt]float64{}
+	u.mu.Lock()
+	u.weights = map[ClassInt]float64{}
 	if sum > 0 {
-		for _, c := range wfqInst.classes {
-			wfqInst.weights[c] = weights[c] / sum
+		for _, c := range u.classes {
+			u.weights[c] = weights[c] / sum
 		}
 	}
-	wfqInst.mu.Unlock()
+	u.mu.Unlock()
 }
 
 // chame quando uma requisição COMPLETA enviar bytes>0
-func RecordBytesForWFQ(class ClassInt, bytes int) {
-	if wfqInst == nil || bytes <= 0 {
+func (u *WFQUtil) Record(class ClassInt, bytes int) {
+	if u == nil || bytes <= 0 {
 		return
 	}
-	wfqInst.mu.Lock()
-	wfqInst.bytes[class] += int64(bytes)
-	wfqInst.mu.Unlock()
+	u.mu.Lock()
+	u.bytes[class] += int64(bytes)
+	u.mu.Unlock()
 }
 
 func (u *WFQUtil) loop() {
@@ -130,8 +118,7 @@ func (u *WFQUtil) loop() {
 				f614(err[0]), f614(err[1]), f614(err[2]),
 				f614(mae),
 			}
-			_ = u.w.Write(rec)
-			u.w.Flush()
+			u.out.write(rec)
 			for k := range u.bytes {
 				u.bytes[k] = 0
 			}
@@ -5,16 +5,67 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"main/src/config"
+	"main/src/model"
+	"main/src/netem"
+	"main/src/server/metrics"
 	"main/src/server/stream_handler"
+	"main/src/tracing"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/logging"
 )
 
 type Server struct {
 	serverURL   string
 	serverPort  int
 	queuePolicy stream_handler.QueuePolicy
+
+	// Emulated link applied to the packets sent by the server (optional)
+	link     *netem.Link
+	ownsLink bool // created by NewServerFromConfig, closed by Stop
+
+	// Directory of the CSVs written by the stream handlers
+	outputDir  string
+	wfqWeights stream_handler.WFQWeights
+	// Aggregate of the metrics of every connection, created by Listen, and
+	// the format (CSV or JSONL) of each series
+	metrics        *metrics.Session
+	metricsFormats metrics.Formats
+	// Prometheus endpoint serving the aggregate (optional)
+	metricsAddr string
+	httpServer  *http.Server
+	// Admin API (optional, see admin.go)
+	adminAddr   string
+	adminServer *http.Server
+	// qlog and transport.csv of the connections (optional), named on accept
+	qlog              bool
+	qlogTracer        *tracing.QlogTracer
+	transportInterval time.Duration
+	transportTracer   *tracing.TransportTracer
+	// Deadline feasibility prediction, from the rates of the transport tracer
+	feasibility stream_handler.FeasibilityMode
+
+	// Faults injected in the service path (optional). The injector is created
+	// by Listen, so the fault schedule counts from the server start.
+	faultRules []stream_handler.FaultRule
+	faultSeed  int64
+	faults     *stream_handler.FaultInjector
+
+	listener   quic.Listener
+	packetConn net.PacketConn // only when the socket is ours (emulated link)
+
+	mu          sync.Mutex
+	stopped     bool
+	connections map[quic.Connection]*stream_handler.StreamHandler // nil until created
+	connectionN int                                               // accepted so far, numbers the connections from 1
+	paused      bool                                              // by the admin API, also for new connections
+	handlers    sync.WaitGroup
 }
 
 func NewServer(serverURL string, serverPort int, queuePolicy string) *Server {
@@ -22,43 +73,308 @@ func NewServer(serverURL string, serverPort int, queuePolicy string) *Server {
 		serverURL:   serverURL,
 		serverPort:  serverPort,
 		queuePolicy: stream_handler.QueuePolicy(queuePolicy),
+		outputDir:   stream_handler.DefaultOutputDir,
+		wfqWeights:  stream_handler.DefaultWFQWeights,
+		connections: make(map[quic.Connection]*stream_handler.StreamHandler),
+	}
+}
+
+// NewServerFromConfig creates a server with the server and network sections
+// of cfg.
+func NewServerFromConfig(cfg config.Config) (*Server, error) {
+	s := NewServer(cfg.Server.Address, cfg.Server.Port, cfg.Server.Policy)
+	s.SetOutputDir(cfg.Server.OutputDir)
+	w := cfg.Server.WFQWeights
+	s.SetWFQWeights(stream_handler.WFQWeights{High: w.High, Medium: w.Medium, Low: w.Low})
+
+	rules, err := faultRules(cfg.Server.Faults)
+	if err != nil {
+		return nil, err
+	}
+	s.SetFaults(rules, cfg.Server.FaultSeed)
+	s.SetMetricsAddr(cfg.Server.MetricsAddr)
+	formats, err := metrics.ParseFormats(cfg.Server.MetricsFormat)
+	if err != nil {
+		return nil, fmt.Errorf("server.metrics_format: %w", err)
+	}
+	s.SetMetricsFormats(formats)
+	s.SetAdminAddr(cfg.Server.AdminAddr)
+	s.SetQlog(cfg.Server.Qlog)
+	s.SetTransportInterval(time.Duration(cfg.Server.TransportIntervalMs) * time.Millisecond)
+	feasibility, err := stream_handler.ParseFeasibilityMode(cfg.Server.Feasibility)
+	if err != nil {
+		return nil, fmt.Errorf("server.feasibility: %w", err)
+	}
+	s.SetFeasibility(feasibility)
+
+	linkConfig, err := cfg.Network.LinkConfig()
+	if err != nil {
+		return nil, fmt.Errorf("link emulation: %w", err)
 	}
+	if linkConfig.Enabled() {
+		s.EmulateLink(netem.NewLink(linkConfig))
+		s.ownsLink = true
+	}
+	return s, nil
+}
+
+// EmulateLink sends every packet of the server through an emulated link
+// (bandwidth, delay, loss...). Must be called before Start.
+func (s *Server) EmulateLink(link *netem.Link) {
+	s.link = link
+}
+
+// Link returns the emulated link of the server, or nil.
+func (s *Server) Link() *netem.Link {
+	return s.link
 }
 
+// SetOutputDir changes the directory of the server CSVs. Must be called
+// before Start.
+func (s *Server) SetOutputDir(dir string) {
+	s.outputDir = dir
+}
+
+// SetWFQWeights changes the class weights of the WFQ policy. Must be called
+// before Start.
+func (s *Server) SetWFQWeights(weights stream_handler.WFQWeights) {
+	s.wfqWeights = weights
+}
+
+// SetFaults makes the server inject faults in the requests matched by the
+// rules (seed 0 uses the current time). Must be called before Start.
+func (s *Server) SetFaults(rules []stream_handler.FaultRule, seed int64) {
+	s.faultRules = rules
+	s.faultSeed = seed
+}
+
+// SetMetricsFormats chooses CSV or JSONL for each metrics series. Must be
+// called before Start.
+func (s *Server) SetMetricsFormats(formats metrics.Formats) {
+	s.metricsFormats = formats
+}
+
+// SetMetricsAddr serves the aggregate metrics in the Prometheus text format
+// at http://addr/metrics. Must be called before Start.
+func (s *Server) SetMetricsAddr(addr string) {
+	s.metricsAddr = addr
+}
+
+// SetQlog writes the qlog of every connection to conn<id>-<odcid>.qlog in
+// the output directory. Must be called before Start.
+func (s *Server) SetQlog(enabled bool) {
+	s.qlog = enabled
+}
+
+// SetTransportInterval samples the transport state of the connections
+// (RTT, congestion window, bytes in flight, losses) to transport.csv in the
+// output directory every interval. 0 disables it. Must be called before Start.
+func (s *Server) SetTransportInterval(interval time.Duration) {
+	s.transportInterval = interval
+}
+
+// SetFeasibility makes the stream handlers predict whether each tile meets
+// its deadline at the delivery rate of the connection, and drop the hopeless
+// ones with FeasibilitySkip. Must be called before Start.
+func (s *Server) SetFeasibility(mode stream_handler.FeasibilityMode) {
+	s.feasibility = mode
+}
+
+// Start listens and serves connections until Stop is called.
 func (s *Server) Start() {
+	if err := s.Listen(); err != nil {
+		log.Println(err)
+		return
+	}
+	s.Serve()
+}
 
+// Listen opens the QUIC listener. After it returns, clients can connect.
+func (s *Server) Listen() error {
 	url := fmt.Sprintf("%s:%d", s.serverURL, s.serverPort)
 	config := &quic.Config{
 		MaxIdleTimeout:        500 * time.Minute, // Set a longer maximum idle timeout
 		HandshakeIdleTimeout:  100 * time.Second, // Set the receive connection flow control window size to 20 MB
 		MaxIncomingStreams:    20000,             // Set the maximum number of incoming streams
 		MaxIncomingUniStreams: 20000,             // Set the maximum number of incoming unidirectional streams
+		EnableDatagrams:       true,              // Allow responses as unreliable datagrams (RFC 9221)
+	}
+	if len(s.faultRules) > 0 {
+		s.faults = stream_handler.NewFaultInjector(s.faultRules, s.faultSeed)
+		log.Printf("Injecting %d fault rules", len(s.faultRules))
+	}
+	var tracers []logging.Tracer
+	if s.qlog {
+		s.qlogTracer = tracing.NewQlogTracer(s.outputDir, "")
+		tracers = append(tracers, s.qlogTracer)
+	}
+	if s.transportInterval > 0 {
+		s.transportTracer = tracing.NewTransportTracer(filepath.Join(s.outputDir, "transport.csv"), s.transportInterval)
+	} else if s.feasibility != "" && s.feasibility != stream_handler.FeasibilityOff {
+		// only the state, for the delivery rates
+		s.transportTracer = tracing.NewTransportTracer("", 0)
 	}
-	listener, err := quic.ListenAddr(url, generateTLSConfig(), config)
+	if s.transportTracer != nil {
+		tracers = append(tracers, s.transportTracer)
+	}
+	config.Tracer = tracing.Join(tracers...)
+	s.metrics = metrics.NewSession(s.outputDir, "", nil, s.metricsFormats)
+	if s.metricsAddr != "" {
+		if err := s.serveMetrics(); err != nil {
+			s.metrics.Close()
+			s.transportTracer.Close()
+			return err
+		}
+	}
+	if s.adminAddr != "" {
+		if err := s.serveAdmin(); err != nil {
+			s.stopMetrics()
+			s.metrics.Close()
+			s.transportTracer.Close()
+			return err
+		}
+	}
+	listener, err := s.listen(url, config)
 	if err != nil {
-		log.Println(err)
+		s.stopAdmin()
+		s.stopMetrics()
+		s.metrics.Close()
+		s.transportTracer.Close()
+		return err
 	}
+	s.listener = listener
 
 	log.Println("Server listening on", url)
+	return nil
+}
 
+// Serve accepts connections until the listener is closed.
+func (s *Server) Serve() {
 	for {
-
-		connection, err := listener.Accept(context.Background())
+		connection, err := s.listener.Accept(context.Background())
 		if err != nil {
 			log.Println(err)
+			return
 		}
-		if err == nil {
-			s.onConnectionAccepted(connection)
+		s.onConnectionAccepted(connection)
+	}
+}
+
+// Stop closes the listener and every connection, and waits until the stream
+// handlers have written their summaries.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.stopped = true
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+	for connection := range s.connections {
+		_ = connection.CloseWithError(0, "server stopped")
+	}
+	s.mu.Unlock()
+
+	s.handlers.Wait()
+	s.stopAdmin()
+	s.stopMetrics()
+	s.metrics.Close()
+	s.transportTracer.Close()
+	if s.packetConn != nil {
+		_ = s.packetConn.Close()
+	}
+	if s.ownsLink {
+		s.link.Close()
+	}
+	s.faults.LogCounts()
+	log.Println("Server stopped")
+}
+
+// listen opens the QUIC listener, through the emulated link if configured.
+func (s *Server) listen(url string, config *quic.Config) (quic.Listener, error) {
+	if s.link == nil {
+		return quic.ListenAddr(url, generateTLSConfig(), config)
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", url)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	s.packetConn = udpConn
+	log.Printf("Emulating link: %+v", s.link.Config())
+	return quic.Listen(s.link.Wrap(udpConn), generateTLSConfig(), config)
+}
+
+func (s *Server) serveMetrics() error {
+	httpListener, err := net.Listen("tcp", s.metricsAddr)
+	if err != nil {
+		return fmt.Errorf("metrics endpoint: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.PrometheusHandler(s.metrics))
+	s.httpServer = &http.Server{Handler: mux}
+	go func() {
+		if err := s.httpServer.Serve(httpListener); err != nil && err != http.ErrServerClosed {
+			log.Printf("metrics endpoint: %v", err)
 		}
+	}()
+	log.Printf("Serving metrics at http://%s/metrics", httpListener.Addr())
+	return nil
+}
 
+func (s *Server) stopMetrics() {
+	if s.httpServer != nil {
+		_ = s.httpServer.Close()
 	}
 }
 
 func (s *Server) onConnectionAccepted(connection quic.Connection) {
-	streamHandler := stream_handler.NewStreamHandler(s.queuePolicy)
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		_ = connection.CloseWithError(0, "server stopped")
+		return
+	}
+	s.connections[connection] = nil
+	s.handlers.Add(1)
+	s.connectionN++
+	connectionID := s.connectionN
+	// the policy may be changed by the admin API
+	policy, weights, paused := s.queuePolicy, s.wfqWeights, s.paused
+	s.mu.Unlock()
+
+	// same number as the CSVs of the connection
+	name := fmt.Sprintf("conn%d", connectionID)
+	s.qlogTracer.SetName(connection.Context(), name)
+	s.transportTracer.SetName(connection.Context(), name)
+
+	streamHandler := stream_handler.NewStreamHandler(connection, stream_handler.Options{
+		Policy:       policy,
+		OutputDir:    s.outputDir,
+		WFQWeights:   weights,
+		Faults:       s.faults,
+		ConnectionID: connectionID,
+		Metrics:      s.metrics,
+		Feasibility:  s.feasibility,
+		// nil without transport tracer: the rate measured in the writes is used
+		TransportRate: s.transportTracer.DeliveryRate(connection.Context()),
+	})
+	if paused {
+		streamHandler.Scheduler().Pause()
+	}
+	s.mu.Lock()
+	s.connections[connection] = streamHandler
+	s.mu.Unlock()
 
 	// accept streams in background
 	go func() {
+		defer s.handlers.Done()
 		for {
 			stream, err := connection.AcceptStream(context.Background())
 			if err != nil {
@@ -66,6 +382,9 @@ func (s *Server) onConnectionAccepted(connection quic.Connection) {
 			}
 			if streamFinished := connection.Context().Err(); streamFinished != nil {
 				streamHandler.Stop()
+				s.mu.Lock()
+				delete(s.connections, connection)
+				s.mu.Unlock()
 				return
 			}
 			if err == nil {
@@ -77,3 +396,30 @@ func (s *Server) onConnectionAccepted(connection quic.Connection) {
 	// handle streams, non blocking
 	streamHandler.Start()
 }
+
+// Converts the faults of the configuration.
+func faultRules(faults []config.FaultConfig) ([]stream_handler.FaultRule, error) {
+	var rules []stream_handler.FaultRule
+	for i, f := range faults {
+		kind, err := stream_handler.ParseFaultKind(f.Kind)
+		if err != nil {
+			return nil, fmt.Errorf("server.faults[%d]: %w", i, err)
+		}
+		classes, err := model.ParseClassList(f.Classes)
+		if err != nil {
+			return nil, fmt.Errorf("server.faults[%d]: %w", i, err)
+		}
+		seconds := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+		rules = append(rules, stream_handler.FaultRule{
+			Kind:        kind,
+			Probability: f.Probability,
+			Delay:       time.Duration(f.DelayMs * float64(time.Millisecond)),
+			Classes:     classes,
+			Connection:  f.Connection,
+			Start:       seconds(f.StartS),
+			End:         seconds(f.EndS),
+			Period:      seconds(f.PeriodS),
+		})
+	}
+	return rules, nil
+}
@@ -0,0 +1,157 @@
+package stream_handler
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"main/src/model"
+)
+
+// FaultKind identifica o ponto do caminho de serviço onde a falha é injetada.
+type FaultKind string
+
+const (
+	FaultStorageDelay FaultKind = "storage_delay" // latência extra no readFile
+	FaultReadError    FaultKind = "read_error"    // readFile falha
+	FaultWriteDelay   FaultKind = "write_delay"   // res.Write/Flush lentos
+	FaultWriteError   FaultKind = "write_error"   // res.Write/Flush falham (nada é enviado)
+	FaultWorkerStall  FaultKind = "worker_stall"  // worker do scheduler trava antes do serviço
+)
+
+var faultKinds = []FaultKind{FaultStorageDelay, FaultReadError, FaultWriteDelay, FaultWriteError, FaultWorkerStall}
+
+// ParseFaultKind valida o nome de um tipo de falha.
+func ParseFaultKind(name string) (FaultKind, error) {
+	for _, kind := range faultKinds {
+		if string(kind) == name {
+			return kind, nil
+		}
+	}
+	return "", fmt.Errorf("unknown fault kind %q", name)
+}
+
+// FaultRule descreve uma falha e as requisições que ela atinge.
+type FaultRule struct {
+	Kind        FaultKind
+	Probability float64       // chance por requisição que casa com a regra
+	Delay       time.Duration // storage_delay, write_delay e worker_stall
+	Classes     map[model.Priority]bool
+	Connection  int // 0 = todas; senão a n-ésima conexão aceita (a partir de 1)
+
+	// janela ativa desde a criação do injetor; End 0 = sem fim.
+	// Com Period, a janela se repete a cada Period.
+	Start, End, Period time.Duration
+}
+
+// FaultInjector decide, por requisição, quais falhas injetar. É compartilhado
+// pelos StreamHandlers de um servidor (a agenda conta do início do servidor).
+// Um *FaultInjector nil não injeta nada.
+type FaultInjector struct {
+	rules []FaultRule
+	start time.Time
+
+	mu     sync.Mutex
+	rng    *rand.Rand
+	counts map[FaultKind]int64
+}
+
+// NewFaultInjector cria o injetor; seed 0 usa o relógio.
+func NewFaultInjector(rules []FaultRule, seed int64) *FaultInjector {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &FaultInjector{
+		rules:  rules,
+		start:  time.Now(),
+		rng:    rand.New(rand.NewSource(seed)),
+		counts: make(map[FaultKind]int64),
+	}
+}
+
+// Inject sorteia as regras de kind que casam com a requisição. Retorna se a
+// falha ocorre e o atraso a aplicar (soma das regras sorteadas).
+func (f *FaultInjector) Inject(kind FaultKind, class model.Priority, connection int) (bool, time.Duration) {
+	if f == nil {
+		return false, 0
+	}
+	elapsed := time.Since(f.start)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	hit := false
+	var delay time.Duration
+	for _, rule := range f.rules {
+		if rule.Kind != kind || !rule.matches(class, connection, elapsed) {
+			continue
+		}
+		if f.rng.Float64() < rule.Probability {
+			hit = true
+			delay += rule.Delay
+		}
+	}
+	if hit {
+		f.counts[kind]++
+	}
+	return hit, delay
+}
+
+func (r FaultRule) matches(class model.Priority, connection int, elapsed time.Duration) bool {
+	if r.Classes != nil && !r.Classes[class] {
+		return false
+	}
+	if r.Connection != 0 && r.Connection != connection {
+		return false
+	}
+	if r.Period > 0 {
+		elapsed %= r.Period
+	}
+	return elapsed >= r.Start && (r.End == 0 || elapsed < r.End)
+}
+
+// Counts devolve quantas requisições receberam cada tipo de falha.
+func (f *FaultInjector) Counts() map[FaultKind]int64 {
+	counts := make(map[FaultKind]int64)
+	if f == nil {
+		return counts
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for kind, n := range f.counts {
+		counts[kind] = n
+	}
+	return counts
+}
+
+// LogCounts registra no log o total de falhas injetadas por tipo.
+func (f *FaultInjector) LogCounts() {
+	if f == nil {
+		return
+	}
+	counts := f.Counts()
+	var parts []string
+	for kind, n := range counts {
+		parts = append(parts, fmt.Sprintf("%s=%d", kind, n))
+	}
+	sort.Strings(parts)
+	log.Printf("[FAULT] injected: %s", strings.Join(parts, " "))
+}
+
+// faultSet acumula as falhas aplicadas a uma requisição (coluna fault do reqlog).
+type faultSet []FaultKind
+
+func (s *faultSet) add(kind FaultKind) {
+	*s = append(*s, kind)
+}
+
+func (s faultSet) String() string {
+	names := make([]string, len(s))
+	for i, kind := range s {
+		names[i] = string(kind)
+	}
+	return strings.Join(names, "+")
+}
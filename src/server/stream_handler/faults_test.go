@@ -0,0 +1,87 @@
+package stream_handler_test
+
+import (
+	"main/src/model"
+	"main/src/server/stream_handler"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests if a nil injector never injects.
+func TestFaultInjector_Nil(t *testing.T) {
+	var f *stream_handler.FaultInjector
+	hit, delay := f.Inject(stream_handler.FaultReadError, model.HIGH_PRIORITY, 1)
+	assert.False(t, hit)
+	assert.Equal(t, time.Duration(0), delay)
+	assert.Equal(t, 0, len(f.Counts()))
+}
+
+// Tests if probabilities 0 and 1 never and always inject, and if only the
+// kind of the rule is injected.
+func TestFaultInjector_Probability(t *testing.T) {
+	f := stream_handler.NewFaultInjector([]stream_handler.FaultRule{
+		{Kind: stream_handler.FaultReadError, Probability: 1},
+		{Kind: stream_handler.FaultWriteError, Probability: 0},
+		{Kind: stream_handler.FaultStorageDelay, Probability: 1, Delay: 20 * time.Millisecond},
+	}, 1)
+
+	for i := 0; i < 100; i++ {
+		hit, _ := f.Inject(stream_handler.FaultReadError, model.LOW_PRIORITY, 1)
+		assert.True(t, hit)
+		hit, _ = f.Inject(stream_handler.FaultWriteError, model.LOW_PRIORITY, 1)
+		assert.False(t, hit)
+		hit, _ = f.Inject(stream_handler.FaultWorkerStall, model.LOW_PRIORITY, 1)
+		assert.False(t, hit)
+	}
+	hit, delay := f.Inject(stream_handler.FaultStorageDelay, model.LOW_PRIORITY, 1)
+	assert.True(t, hit)
+	assert.Equal(t, 20*time.Millisecond, delay)
+
+	counts := f.Counts()
+	assert.Equal(t, int64(100), counts[stream_handler.FaultReadError])
+	assert.Equal(t, int64(0), counts[stream_handler.FaultWriteError])
+	assert.Equal(t, int64(1), counts[stream_handler.FaultStorageDelay])
+}
+
+// Tests if a rule only hits its classes and its connection.
+func TestFaultInjector_Filters(t *testing.T) {
+	classes, err := model.ParseClassList("medium,low")
+	assert.Nil(t, err)
+	f := stream_handler.NewFaultInjector([]stream_handler.FaultRule{
+		{Kind: stream_handler.FaultReadError, Probability: 1, Classes: classes, Connection: 2},
+	}, 1)
+
+	hit, _ := f.Inject(stream_handler.FaultReadError, model.LOW_PRIORITY, 2)
+	assert.True(t, hit)
+	hit, _ = f.Inject(stream_handler.FaultReadError, model.HIGH_PRIORITY, 2)
+	assert.False(t, hit)
+	hit, _ = f.Inject(stream_handler.FaultReadError, model.MEDIUM_PRIORITY, 1)
+	assert.False(t, hit)
+}
+
+// Tests if a rule is only active within its time window.
+func TestFaultInjector_Schedule(t *testing.T) {
+	f := stream_handler.NewFaultInjector([]stream_handler.FaultRule{
+		{Kind: stream_handler.FaultReadError, Probability: 1, Start: 50 * time.Millisecond, End: 100 * time.Millisecond},
+	}, 1)
+
+	hit, _ := f.Inject(stream_handler.FaultReadError, model.HIGH_PRIORITY, 1)
+	assert.False(t, hit)
+	time.Sleep(70 * time.Millisecond)
+	hit, _ = f.Inject(stream_handler.FaultReadError, model.HIGH_PRIORITY, 1)
+	assert.True(t, hit)
+	time.Sleep(50 * time.Millisecond)
+	hit, _ = f.Inject(stream_handler.FaultReadError, model.HIGH_PRIORITY, 1)
+	assert.False(t, hit)
+}
+
+// Tests if unknown fault kinds are rejected.
+func TestParseFaultKind(t *testing.T) {
+	kind, err := stream_handler.ParseFaultKind("worker_stall")
+	assert.Nil(t, err)
+	assert.Equal(t, stream_handler.FaultWorkerStall, kind)
+	_, err = stream_handler.ParseFaultKind("disk_on_fire")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,109 @@
+package stream_handler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FeasibilityMode diz o que o serviço faz com a previsão de que um tile cabe
+// (ou não) no deadline.
+type FeasibilityMode string
+
+const (
+	FeasibilityOff  FeasibilityMode = "off"  // sem previsão
+	FeasibilityLog  FeasibilityMode = "log"  // prevê e registra, mas serve tudo
+	FeasibilitySkip FeasibilityMode = "skip" // descarta os tiles previstos como inviáveis
+)
+
+// ParseFeasibilityMode valida o nome de um modo ("" é off).
+func ParseFeasibilityMode(name string) (FeasibilityMode, error) {
+	switch mode := FeasibilityMode(name); mode {
+	case "":
+		return FeasibilityOff, nil
+	case FeasibilityOff, FeasibilityLog, FeasibilitySkip:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown feasibility mode %q (off, log or skip)", name)
+	}
+}
+
+// Peso da última escrita na média móvel da taxa medida.
+const measuredRateAlpha = 0.2
+
+// RateEstimator estima a taxa de entrega (bytes/s) de uma conexão. Usa a
+// estimativa do transporte (cwnd/RTT do tracer da conexão) quando existe;
+// senão a média móvel das taxas medidas nas escritas de respostas.
+// Um *RateEstimator nil não estima nada.
+type RateEstimator struct {
+	transport func() float64 // opcional; 0 se ainda desconhecida
+
+	mu       sync.Mutex
+	measured float64
+}
+
+func NewRateEstimator(transport func() float64) *RateEstimator {
+	return &RateEstimator{transport: transport}
+}
+
+// Observe registra uma escrita de bytes que levou elapsed.
+func (r *RateEstimator) Observe(bytes int, elapsed time.Duration) {
+	if r == nil || bytes <= 0 || elapsed <= 0 {
+		return
+	}
+	rate := float64(bytes) / elapsed.Seconds()
+	r.mu.Lock()
+	if r.measured == 0 {
+		r.measured = rate
+	} else {
+		r.measured = measuredRateAlpha*rate + (1-measuredRateAlpha)*r.measured
+	}
+	r.mu.Unlock()
+}
+
+// Rate devolve a taxa estimada em bytes/s (0 se desconhecida).
+func (r *RateEstimator) Rate() float64 {
+	if r == nil {
+		return 0
+	}
+	if r.transport != nil {
+		if rate := r.transport(); rate > 0 {
+			return rate
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.measured
+}
+
+// Prediction é a previsão de viabilidade de um tile.
+type Prediction struct {
+	Known       bool          // false sem taxa ou sem tamanho do tile
+	Size        int64         // bytes do tile
+	ServiceTime time.Duration // tempo previsto para enviar o tile
+	Feasible    bool          // now + ServiceTime <= deadline
+}
+
+// Predict prevê se size bytes, enviados a partir de now, terminam até o
+// deadline na taxa atual.
+func (r *RateEstimator) Predict(size int64, now, deadline time.Time) Prediction {
+	rate := r.Rate()
+	if rate <= 0 || size <= 0 {
+		return Prediction{Size: size}
+	}
+	serviceTime := time.Duration(float64(size) / rate * float64(time.Second))
+	return Prediction{
+		Known:       true,
+		Size:        size,
+		ServiceTime: serviceTime,
+		Feasible:    !now.Add(serviceTime).After(deadline),
+	}
+}
+
+// Colunas pred_ms e feasible do reqlog (vazias sem previsão).
+func (p Prediction) columns() (string, string) {
+	if !p.Known {
+		return "", ""
+	}
+	return fmt.Sprintf("%d", p.ServiceTime.Milliseconds()), fmt.Sprintf("%t", p.Feasible)
+}
@@ -0,0 +1,62 @@
+package stream_handler_test
+
+import (
+	"main/src/server/stream_handler"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests if the modes are parsed and "" means off.
+func TestParseFeasibilityMode(t *testing.T) {
+	mode, err := stream_handler.ParseFeasibilityMode("")
+	assert.Nil(t, err)
+	assert.Equal(t, stream_handler.FeasibilityOff, mode)
+
+	mode, err = stream_handler.ParseFeasibilityMode("skip")
+	assert.Nil(t, err)
+	assert.Equal(t, stream_handler.FeasibilitySkip, mode)
+
+	_, err = stream_handler.ParseFeasibilityMode("drop")
+	assert.NotNil(t, err)
+}
+
+// Tests if the transport rate is preferred to the measured one, which is
+// only a fallback.
+func TestRateEstimator_Rate(t *testing.T) {
+	transport := 0.0
+	r := stream_handler.NewRateEstimator(func() float64 { return transport })
+	assert.Equal(t, 0.0, r.Rate())
+
+	r.Observe(1000, time.Second)
+	assert.Equal(t, 1000.0, r.Rate())
+	r.Observe(2000, time.Second)
+	assert.InDelta(t, 1200.0, r.Rate(), 1e-9)
+
+	transport = 5000
+	assert.Equal(t, 5000.0, r.Rate())
+
+	var nilEstimator *stream_handler.RateEstimator
+	nilEstimator.Observe(1000, time.Second)
+	assert.Equal(t, 0.0, nilEstimator.Rate())
+}
+
+// Tests if a tile is feasible only when it is sent before the deadline.
+func TestRateEstimator_Predict(t *testing.T) {
+	r := stream_handler.NewRateEstimator(func() float64 { return 1000 })
+	now := time.Now()
+
+	p := r.Predict(500, now, now.Add(time.Second))
+	assert.True(t, p.Known)
+	assert.Equal(t, 500*time.Millisecond, p.ServiceTime)
+	assert.True(t, p.Feasible)
+
+	p = r.Predict(2000, now, now.Add(time.Second))
+	assert.True(t, p.Known)
+	assert.False(t, p.Feasible)
+
+	// without rate or size there is no prediction
+	assert.False(t, stream_handler.NewRateEstimator(nil).Predict(500, now, now).Known)
+	assert.False(t, r.Predict(0, now, now).Known)
+}
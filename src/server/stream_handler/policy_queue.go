@@ -0,0 +1,172 @@
+package stream_handler
+
+import (
+	"time"
+
+	"main/src/model"
+)
+
+// PolicyQueue guarda as filas por classe e escolhe a próxima entrada conforme
+// a política (FIFO/SP/WFQ). Não é thread-safe e não usa relógio próprio: o
+// Scheduler a protege com seu mutex e o simulador (src/sim) a usa com tempo
+// virtual.
+type PolicyQueue[T any] struct {
+	policy QueuePolicy
+
+	// filas por prioridade (índice = model.Priority, high=0, med=1, low=2)
+	queues [model.PRIORITY_LEVEL_COUNT][]queued[T]
+
+	// WFQ: pesos e estado do RR
+	wfqWeights [model.PRIORITY_LEVEL_COUNT]int
+	wfqCursor  int
+	wfqBudget  [model.PRIORITY_LEVEL_COUNT]int
+	wfqStarted bool
+}
+
+type queued[T any] struct {
+	value    T
+	enqueued time.Time
+}
+
+// NewPolicyQueue cria as filas de uma política. Os pesos só valem para WFQ.
+func NewPolicyQueue[T any](policy QueuePolicy, weights WFQWeights) *PolicyQueue[T] {
+	q := &PolicyQueue[T]{}
+	q.Reconfigure(policy, weights)
+	return q
+}
+
+// Push enfileira value na classe p; at é o instante de chegada (usado pelo FIFO).
+func (q *PolicyQueue[T]) Push(p model.Priority, value T, at time.Time) {
+	q.queues[p] = append(q.queues[p], queued[T]{value: value, enqueued: at})
+}
+
+// Pop remove a próxima entrada segundo a política. ok=false se vazia.
+func (q *PolicyQueue[T]) Pop() (value T, ok bool) {
+	if q.Len() == 0 {
+		return value, false
+	}
+	switch q.policy {
+	case PolicySP:
+		return q.popSP(), true
+	case PolicyWFQ:
+		return q.popWFQ(), true
+	default:
+		return q.popFIFO(), true
+	}
+}
+
+// Len retorna o total de entradas enfileiradas.
+func (q *PolicyQueue[T]) Len() int {
+	n := 0
+	for i := range q.queues {
+		n += len(q.queues[i])
+	}
+	return n
+}
+
+// LenPerClass retorna o tamanho da fila de cada classe.
+func (q *PolicyQueue[T]) LenPerClass() map[model.Priority]int {
+	m := make(map[model.Priority]int, model.PRIORITY_LEVEL_COUNT)
+	for i := range q.queues {
+		m[model.Priority(i)] = len(q.queues[i])
+	}
+	return m
+}
+
+// Each chama fn para cada entrada, por classe e em ordem de chegada.
+func (q *PolicyQueue[T]) Each(fn func(p model.Priority, value T, enqueued time.Time)) {
+	for c := range q.queues {
+		for _, e := range q.queues[c] {
+			fn(model.Priority(c), e.value, e.enqueued)
+		}
+	}
+}
+
+// Reconfigure troca a política e os pesos sem perder as entradas. A rodada
+// do WFQ recomeça.
+func (q *PolicyQueue[T]) Reconfigure(policy QueuePolicy, weights WFQWeights) {
+	q.policy = policy
+	q.wfqWeights[model.LOW_PRIORITY] = weights.Low
+	q.wfqWeights[model.MEDIUM_PRIORITY] = weights.Medium
+	q.wfqWeights[model.HIGH_PRIORITY] = weights.High
+	q.wfqCursor = 0
+	q.wfqBudget = [model.PRIORITY_LEVEL_COUNT]int{}
+	q.wfqStarted = false
+}
+
+// ----------------------------- Políticas ----------------------------------
+
+func (q *PolicyQueue[T]) take(class int) T {
+	v := q.queues[class][0].value
+	q.queues[class][0] = queued[T]{}
+	q.queues[class] = q.queues[class][1:]
+	return v
+}
+
+// FIFO global: pega o mais antigo entre todas as filas.
+func (q *PolicyQueue[T]) popFIFO() T {
+	found := false
+	var bestQ int
+	var bestEnq time.Time
+
+	for c := range q.queues {
+		if len(q.queues[c]) == 0 {
+			continue
+		}
+		e := q.queues[c][0]
+		if !found || e.enqueued.Before(bestEnq) {
+			found = true
+			bestEnq = e.enqueued
+			bestQ = c
+		}
+	}
+	return q.take(bestQ)
+}
+
+// SP (strict priority, não-preemptivo): sempre escolhe a maior prioridade disponível.
+func (q *PolicyQueue[T]) popSP() T {
+	// high → medium → low (high tem o menor valor)
+	for c := int(model.HIGH_PRIORITY); c <= int(model.LOW_PRIORITY); c++ {
+		if len(q.queues[c]) > 0 {
+			// (se implementar preempção real no futuro, chame Session.OnPreempt)
+			return q.take(c)
+		}
+	}
+	// não deveria chegar aqui (Pop já verificou Len > 0)
+	return q.popFIFO()
+}
+
+// WFQ simples por fatia de tarefas (peso = nº de tarefas por rodada)
+func (q *PolicyQueue[T]) popWFQ() T {
+	nClasses := model.PRIORITY_LEVEL_COUNT
+	// garante orçamento inicial. Só na primeira chamada: recarregar aqui a
+	// classe sob o cursor faria ela ser servida até esvaziar, ignorando os pesos.
+	if !q.wfqStarted {
+		q.wfqStarted = true
+		for i := 0; i < nClasses; i++ {
+			q.wfqBudget[i] = q.wfqWeights[i]
+		}
+	}
+
+	checked := 0
+	for checked < nClasses {
+		c := q.wfqCursor % nClasses
+
+		if len(q.queues[c]) > 0 && q.wfqBudget[c] > 0 {
+			// serve dessa fila e consome orçamento
+			q.wfqBudget[c]--
+			// fica no mesmo cursor para tentar servir +1 da mesma fila se ainda há orçamento
+			return q.take(c)
+		}
+
+		// avança cursor e, se orçamento esgotado, recarrega
+		if q.wfqBudget[c] <= 0 {
+			q.wfqBudget[c] = q.wfqWeights[c]
+		}
+		q.wfqCursor = (q.wfqCursor + 1) % nClasses
+		checked++
+	}
+
+	// fallback: se nada foi escolhido (todas filas vazias no giro), devolve FIFO
+	return q.popFIFO()
+}
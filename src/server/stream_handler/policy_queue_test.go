@@ -0,0 +1,68 @@
+package stream_handler_test
+
+import (
+	"main/src/model"
+	"main/src/server/stream_handler"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fill(q *stream_handler.PolicyQueue[model.Priority], n int) {
+	t := time.Now()
+	for i := 0; i < n; i++ {
+		for _, p := range []model.Priority{model.LOW_PRIORITY, model.MEDIUM_PRIORITY, model.HIGH_PRIORITY} {
+			t = t.Add(time.Millisecond)
+			q.Push(p, p, t)
+		}
+	}
+}
+
+// Tests if FIFO serves in order of arrival, whatever the class.
+func TestPolicyQueue_FIFO(t *testing.T) {
+	q := stream_handler.NewPolicyQueue[model.Priority](stream_handler.PolicyFIFO, stream_handler.DefaultWFQWeights)
+	fill(q, 1)
+
+	for _, want := range []model.Priority{model.LOW_PRIORITY, model.MEDIUM_PRIORITY, model.HIGH_PRIORITY} {
+		got, ok := q.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, want, got)
+	}
+	_, ok := q.Pop()
+	assert.False(t, ok)
+}
+
+// Tests if strict priority empties the high queue first.
+func TestPolicyQueue_SP(t *testing.T) {
+	q := stream_handler.NewPolicyQueue[model.Priority](stream_handler.PolicySP, stream_handler.DefaultWFQWeights)
+	fill(q, 2)
+
+	var order []model.Priority
+	for q.Len() > 0 {
+		p, _ := q.Pop()
+		order = append(order, p)
+	}
+	assert.Equal(t, []model.Priority{
+		model.HIGH_PRIORITY, model.HIGH_PRIORITY,
+		model.MEDIUM_PRIORITY, model.MEDIUM_PRIORITY,
+		model.LOW_PRIORITY, model.LOW_PRIORITY,
+	}, order)
+}
+
+// Tests if WFQ serves the backlogged classes in proportion to their weights.
+func TestPolicyQueue_WFQ(t *testing.T) {
+	q := stream_handler.NewPolicyQueue[model.Priority](stream_handler.PolicyWFQ, stream_handler.DefaultWFQWeights)
+	fill(q, 100)
+
+	served := map[model.Priority]int{}
+	for i := 0; i < 60; i++ {
+		p, _ := q.Pop()
+		served[p]++
+	}
+	assert.Equal(t, 30, served[model.HIGH_PRIORITY])
+	assert.Equal(t, 20, served[model.MEDIUM_PRIORITY])
+	assert.Equal(t, 10, served[model.LOW_PRIORITY])
+	assert.Equal(t, 240, q.Len())
+	assert.Equal(t, 70, q.LenPerClass()[model.HIGH_PRIORITY])
+}
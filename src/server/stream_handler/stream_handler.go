@@ -2,171 +2,122 @@ package stream_handler
 
 import (
 	"bufio"
-	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"main/src/model"
 	"main/src/server/metrics"
 	"os"
-	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/lucas-clemente/quic-go"
 )
 
-//
 // ============================== VISÃO GERAL ===============================
 //
 // Este handler recebe streams QUIC do cliente e processa requisições de
 // “tiles” de vídeo (segment/tile) através de um TaskScheduler com política
 // (FIFO/SP/WFQ).
 //
-// CSVs gerados (lado servidor):
-// 1) reqlog.csv        — por requisição (tempos e status por request)
+// CSVs gerados (lado servidor), via metrics.Session:
+// 1) reqlog.csv        — por requisição (tempos, status e motivo do resultado)
 // 2) class_agg.csv     — agregado por classe (médias e somatórios por classe)
-// 3) queue_len.csv     — (opcional) amostras de tamanho de fila por classe (se o scheduler expor)
+// 3) queue_len.csv     — amostras de tamanho de fila por classe, a cada 100 ms
 // 4) server_summary.csv — resumo ao final (shares, Jain, throughput, contadores)
 //
+// Com ConnectionID, os arquivos da conexão levam o sufixo -conn<ID> e os
+// eventos também vão para a sessão agregada do servidor (Options.Metrics),
+// que escreve os nomes acima.
+//
 // ========================================================================
-// ===== CSV SIMPLES EMBUTIDO (sem dependências externas) =====
-
-type csvSink struct {
-	mu     sync.Mutex
-	f      *os.File
-	w      *csv.Writer
-	header []string
-	path   string
-}
+// Colunas do reqlog.csv (também usadas pelo simulador).
+var ReqlogHeader = metrics.ReqlogHeader
 
-// newCSVSink cria (e se vazio, escreve header) um writer de CSV em "path".
-func newCSVSink(path string, header []string) *csvSink {
-	dir := filepath.Dir(path)
-	_ = os.MkdirAll(dir, 0o755)
+// DefaultOutputDir é o diretório remoto onde guardamos logs/CSVs no host Mininet.
+const DefaultOutputDir = "/tmp/server_scheduler_test"
 
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
-	if err != nil {
-		log.Printf("[CSV] open %s: %v", path, err)
-		return nil
-	}
-	w := csv.NewWriter(f)
-	// header se novo (arquivo zerado)
-	if st, _ := f.Stat(); st != nil && st.Size() == 0 {
-		_ = w.Write(header)
-		w.Flush()
-	}
-	log.Printf("[CSV] ready at %s", path)
-	return &csvSink{f: f, w: w, header: header, path: path}
+// StreamHandler orquestra o loop de leitura de streams e o escalonamento.
+type StreamHandler struct {
+	taskScheduler *Scheduler
+	connection    quic.Connection  // usado para respostas via datagram
+	metrics       *metrics.Session // métricas desta conexão
+	faults        *FaultInjector   // falhas injetadas (nil = nenhuma)
+	connectionID  int              // ordem de chegada da conexão (alvo das falhas)
+
+	// previsão de viabilidade do deadline
+	feasibility FeasibilityMode
+	rate        *RateEstimator
+
+	// streams abertos (inspeção pela API admin)
+	streamsMu sync.Mutex
+	streams   map[quic.StreamID]*stream
 }
 
-// write adiciona uma linha no CSV com flush imediato (durabilidade).
-func (s *csvSink) write(rec []string) {
-	if s == nil || s.w == nil {
-		return
-	}
-	s.mu.Lock()
-	_ = s.w.Write(rec)
-	s.w.Flush()
-	s.mu.Unlock()
+// Opções do StreamHandler.
+type Options struct {
+	Policy     QueuePolicy
+	OutputDir  string     // diretório dos CSVs (DefaultOutputDir se vazio)
+	WFQWeights WFQWeights // DefaultWFQWeights se zero
+
+	// Injeção de falhas (opcional) e número da conexão no servidor, a partir de 1
+	Faults       *FaultInjector
+	ConnectionID int
+
+	// Sessão agregada do servidor, que também recebe as métricas da conexão
+	// (opcional)
+	Metrics *metrics.Session
+
+	// Previsão de viabilidade do deadline (off se vazio) e taxa de entrega
+	// estimada pelo transporte, em bytes/s (opcional; sem ela vale a taxa
+	// medida nas escritas)
+	Feasibility   FeasibilityMode
+	TransportRate func() float64
 }
 
-// close garante flush e fecha o arquivo.
-func (s *csvSink) close() {
-	if s == nil {
-		return
+// NewStreamHandler instancia o handler com a política desejada.
+// A conexão é usada para enviar respostas pedidas com entrega via datagram.
+func NewStreamHandler(connection quic.Connection, opts Options) *StreamHandler {
+	if opts.OutputDir == "" {
+		opts.OutputDir = DefaultOutputDir
 	}
-	s.mu.Lock()
-	if s.w != nil {
-		s.w.Flush()
+	if opts.WFQWeights == (WFQWeights{}) {
+		opts.WFQWeights = DefaultWFQWeights
 	}
-	if s.f != nil {
-		_ = s.f.Close()
+	if opts.Feasibility == "" {
+		opts.Feasibility = FeasibilityOff
 	}
-	s.mu.Unlock()
-}
-
-// remoteDir é o diretório remoto onde guardamos logs/CSVs no host Mininet.
-const remoteDir = "/tmp/server_scheduler_test"
-
-// Interface opcional: se o TaskScheduler implementar, amostramos filas periodicamente.
-type QueueLenProvider interface {
-	// Retorna tamanho das filas por classe (prioridade → len).
-	QueueLenPerClass() map[model.Priority]int
-}
-
-// StreamHandler orquestra o loop de leitura de streams e o escalonamento.
-type StreamHandler struct {
-	taskScheduler TaskScheduler
-
-	reqlog       *csvSink // CSV por requisição
-	queueSampler *time.Ticker
-	stopSample   chan struct{}
-}
-
-// NewStreamHandler instancia o handler com a política desejada.
-func NewStreamHandler(policy QueuePolicy) *StreamHandler {
+	// CSVs da conexão (reqlog, class_agg, queue_len, server_summary, séries)
+	id := ""
+	if opts.ConnectionID > 0 {
+		id = fmt.Sprintf("conn%d", opts.ConnectionID)
+	}
+	// (formatos CSV/JSONL herdados do agregado)
+	session := metrics.NewSession(opts.OutputDir, id, opts.Metrics, metrics.Formats{})
 	return &StreamHandler{
-		taskScheduler: NewTaskScheduler(policy),
+		taskScheduler: newScheduler(opts.Policy, opts.WFQWeights, session),
+		connection:    connection,
+		metrics:       session,
+		faults:        opts.Faults,
+		connectionID:  opts.ConnectionID,
+		feasibility:   opts.Feasibility,
+		rate:          NewRateEstimator(opts.TransportRate),
+		streams:       make(map[quic.StreamID]*stream),
 	}
 }
 
-// Start inicializa o scheduler e os CSVs.
-// Também fixa o caminho do CSV de agregados por classe via metrics.M().
+// Start inicializa o scheduler. As filas chegam às métricas pelos eventos do
+// scheduler.
 func (s *StreamHandler) Start() {
-	// garanta logs no stdout
-	log.SetOutput(os.Stdout)
 	log.Println("[SERVER] StreamHandler starting")
 
-	// 1) REQLOG (por requisição) — usado para CDF/p95 etc.
-	s.reqlog = newCSVSink(
-		filepath.Join(remoteDir, "reqlog.csv"),
-		[]string{
-			"time_ns", "event", "class", "segment", "tile",
-			"bytes", "ontime", "drop",
-			"qd_ms", "svc_ms", "rsp_ms",
-		},
-	)
-
-	// 2) AGREGADOS POR CLASSE (módulo metrics) — arquivo separado
-	metrics.M().InitClassAgg(filepath.Join(remoteDir, "class_agg.csv"))
-
-	// 3) Queue length CSV & work-conserving (opcional via provider)
-	metrics.M().InitQueueCSV(filepath.Join(remoteDir, "queue_len.csv"))
-	metrics.M().InitSummary(filepath.Join(remoteDir, "server_summary.csv"))
-	metrics.M().MarkRunStart()
-
-	// Se o scheduler expõe QueueLenPerClass, amostrar periodicamente
-	if qp, ok := any(s.taskScheduler).(QueueLenProvider); ok {
-		s.stopSample = make(chan struct{})
-		s.queueSampler = time.NewTicker(100 * time.Millisecond)
-		go func() {
-			for {
-				select {
-				case <-s.queueSampler.C:
-					m := qp.QueueLenPerClass()
-					metrics.M().OnQueueSample(m)
-				case <-s.stopSample:
-					return
-				}
-			}
-		}()
-	} else {
-		log.Println("[SERVER] QueueLenProvider not implemented by scheduler (queue_len.csv disabled)")
-	}
-
 	// run scheduler (loop de escalonamento)
 	go s.taskScheduler.Run()
 
-	// fairness (1s)
-	metrics.StartFairnessWriter(filepath.Join(remoteDir, "fairness.csv"), []int{0, 1, 2}, 1*time.Second)
-
-	// work-conserving (1s)
-	metrics.StartWorkConservingWriter(filepath.Join(remoteDir, "work_conserving.csv"), 1*time.Second)
-
-	// wfq utilization (1s) — só fará sentido se a política for WFQ; pode setar peso padrão
-	metrics.StartWFQUtilWriter(filepath.Join(remoteDir, "wfq_utilization.csv"), []int{0, 1, 2}, 1*time.Second)
-
 	log.Println("[SERVER] StreamHandler started")
 }
 
@@ -175,69 +126,112 @@ func (s *StreamHandler) Stop() {
 	log.Println("[SERVER] stopping...")
 	s.taskScheduler.Stop()
 
-	if s.queueSampler != nil {
-		s.queueSampler.Stop()
-	}
-	if s.stopSample != nil {
-		close(s.stopSample)
-	}
-
-	// Resumo final (shares, Jain, throughput, contadores)
-	metrics.M().WriteSummaryAndClose()
-
-	if s.reqlog != nil {
-		s.reqlog.close()
-	}
+	// Resumo final da conexão (shares, Jain, throughput, contadores) e CSVs
+	s.metrics.Close()
 	log.Println("[SERVER] stopped")
-
-	metrics.StopFairnessWriter()
-	metrics.StopWorkConservingWriter()
-	metrics.StopWFQUtilWriter()
-
 }
 
 // HandleStream é chamado para cada novo stream QUIC aceito.
 func (s *StreamHandler) HandleStream(quicStream quic.Stream) {
 	log.Printf("[STREAM] accepted id=%d", quicStream.StreamID())
 
-	go (&stream{
+	st := &stream{
 		parent:        s,
 		taskScheduler: s.taskScheduler,
 		quicStream:    quicStream,
 		reader:        bufio.NewReader(quicStream),
 		writer:        bufio.NewWriter(quicStream),
-		usageCount:    0,
-	}).listen()
+		openedAt:      time.Now(),
+	}
+	s.streamsMu.Lock()
+	s.streams[quicStream.StreamID()] = st
+	s.streamsMu.Unlock()
+	go st.listen()
+}
+
+// ConnectionID é o número da conexão no servidor (0 se não informado).
+func (s *StreamHandler) ConnectionID() int {
+	return s.connectionID
+}
+
+// Connection retorna a conexão QUIC atendida.
+func (s *StreamHandler) Connection() quic.Connection {
+	return s.connection
+}
+
+// Scheduler da conexão (política, pausa, tarefas na fila).
+func (s *StreamHandler) Scheduler() *Scheduler {
+	return s.taskScheduler
+}
+
+// Metrics retorna a sessão de métricas da conexão.
+func (s *StreamHandler) Metrics() *metrics.Session {
+	return s.metrics
+}
+
+// StreamInfo descreve um stream aberto.
+type StreamInfo struct {
+	ID       quic.StreamID
+	Opened   time.Time
+	Requests int64 // requisições lidas
+	Pending  int64 // requisições ainda não respondidas
+}
+
+// Streams lista os streams abertos, em ordem de ID.
+func (s *StreamHandler) Streams() []StreamInfo {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	infos := make([]StreamInfo, 0, len(s.streams))
+	for id, st := range s.streams {
+		pending := int64(st.usageCount.Load()) - 1 // sem o próprio listen
+		if pending < 0 {
+			pending = 0
+		}
+		infos = append(infos, StreamInfo{ID: id, Opened: st.openedAt, Requests: st.requests.Load(), Pending: pending})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
 }
 
 // stream encapsula o ciclo de vida de uma conexão de pedidos no QUIC.
 type stream struct {
 	parent        *StreamHandler
-	taskScheduler TaskScheduler
+	taskScheduler *Scheduler
 	quicStream    quic.Stream
 	reader        *bufio.Reader
 	writer        *bufio.Writer
-	usageCount    int
+	usageCount    atomic.Int32
+	openedAt      time.Time
+	requests      atomic.Int64
 }
 
 // decreaseUsageCount fecha o stream quando o uso chega a zero.
 func (s *stream) decreaseUsageCount() {
-	s.usageCount--
-	if s.usageCount == 0 {
+	if s.usageCount.Add(-1) == 0 {
 		_ = s.quicStream.Close()
 		log.Printf("[STREAM] closed id=%d", s.quicStream.StreamID())
+		if s.parent != nil {
+			s.parent.streamsMu.Lock()
+			delete(s.parent.streams, s.quicStream.StreamID())
+			s.parent.streamsMu.Unlock()
+		}
 	}
 }
 
 // listen lê requisições do cliente, agenda execução e registra métricas.
 // Fluxo da métrica por request:
 //
-//	ENQUEUE  -> metrics.M().OnEnqueue(class)
-//	START    -> metrics.M().OnStart(ctx)
-//	COMPLETE -> metrics.M().OnComplete(ctx, bytes, dropped=false)   OU
-//	DROP     -> metrics.M().OnDeadlineDropWithBytes(ctx, estBytes)
+//	ENQUEUE  -> Session.OnEnqueue(class)
+//	START    -> Session.OnStart(ctx)
+//	COMPLETE -> Session.OnComplete(ctx, bytes, dropped=false)   OU
+//	DROP     -> Session.OnDeadlineDropWithBytes(ctx, estBytes)   OU
+//	FAIL     -> Session.OnFailure(ctx) (tile não entregue, antes do deadline)
+//	FIM      -> Session.OnOutcome(motivo), também na coluna outcome do reqlog
+//
+// Requisições que nunca começam (scheduler parado, ou ainda na fila quando a
+// conexão fecha) entram no reqlog como reject/cancel.
 func (s *stream) listen() {
-	s.usageCount++
+	s.usageCount.Add(1)
 	defer s.decreaseUsageCount()
 
 	for {
@@ -251,15 +245,15 @@ func (s *stream) listen() {
 			}
 			return
 		}
-		log.Printf("[REQ] recv seg=%d tile=%d prio=%d timeout_ms=%d",
-			req.Segment, req.Tile, req.Priority, req.Timeout)
+		log.Printf("[REQ] recv seg=%d tile=%d prio=%d timeout_ms=%d delivery=%s",
+			req.Segment, req.Tile, req.Priority, req.Timeout, req.Delivery)
 
 		// 2) Marcação de chegada + deadline
 		enqueuedAt := time.Now()
 		deadline := enqueuedAt.Add(time.Duration(req.Timeout) * time.Millisecond)
 
 		// 3) MÉTRICAS (agregados): evento de fila
-		metrics.M().OnEnqueue(req.Priority)
+		s.session().OnEnqueue(req.Priority)
 
 		// 4) Contexto da tarefa p/ correlacionar tempos e classe
 		ctx := &metrics.TaskCtx{
@@ -269,88 +263,172 @@ func (s *stream) listen() {
 		}
 
 		// 5) Enfileirar no escalonador conforme a política
-		s.usageCount++
-		ok := s.taskScheduler.Enqueue(req.Priority, func() {
+		s.requests.Add(1)
+		s.usageCount.Add(1)
+		info := TaskInfo{Class: req.Priority, Segment: req.Segment, Tile: req.Tile, Deadline: deadline}
+		cancel := func() {
+			defer s.decreaseUsageCount()
+			s.logUnserved(req, enqueuedAt, "cancel", model.CANCELLED_OUTCOME)
+		}
+		ok := s.taskScheduler.EnqueueCancellable(info, func() {
 			defer s.decreaseUsageCount()
 
+			// 5.0) Falha injetada: worker travado antes de começar o serviço
+			var faults faultSet
+			s.injectFault(FaultWorkerStall, req, &faults)
+
 			// 5.1) START (marca início de serviço e computa slack/inversão)
-			metrics.M().OnStart(ctx)
+			s.session().OnStart(ctx)
 
 			// 5.2) Métricas de tempos por request (reqlog)
 			startedAt := time.Now()
 			qdMs := startedAt.Sub(enqueuedAt).Milliseconds()
 
-			// 5.3) Serviço: lê arquivo e envia resposta no QUIC
-			bytes := s.handleRequestMeasured(req, deadline)
-			if bytes > 0 {
-				metrics.RecordBytesForFairness(int(req.Priority), bytes)
-				metrics.RecordBytesForWFQ(int(req.Priority), bytes)
+			// 5.3) Viabilidade: o tile cabe no deadline na taxa atual da conexão?
+			pred := s.predictFeasibility(req, startedAt, deadline)
+			skip := pred.Known && !pred.Feasible && s.parent.feasibility == FeasibilitySkip
+
+			// 5.4) Serviço: lê arquivo e envia resposta no QUIC
+			bytes, failure := 0, model.REJECTED_OUTCOME
+			if !skip {
+				bytes, failure = s.handleRequestMeasured(req, deadline, &faults)
 			}
+			s.session().RecordBytes(req.Priority, bytes)
 
 			now := time.Now()
 			svcMs := now.Sub(startedAt).Milliseconds()
 			rspMs := now.Sub(enqueuedAt).Milliseconds()
-			onTime := bytes > 0 && (now.Before(deadline) || now.Equal(deadline))
-			deadlineDrop := bytes <= 0 && now.After(deadline)
-
-			// 5.4) MÉTRICAS (agregados): COMPLETE vs DROP por deadline
-			if deadlineDrop {
+			// motivo: entregue no prazo ou atrasado, ou a falha (sem bytes ou
+			// com o tile incompleto)
+			outcome := failure
+			delivered := !skip && (failure == model.ON_TIME_OUTCOME || failure == model.LATE_OUTCOME)
+			if delivered {
+				outcome = model.DeliveryOutcome(now, deadline)
+			}
+			onTime := outcome == model.ON_TIME_OUTCOME
+			deadlineDrop := skip || (!delivered && now.After(deadline))
+
+			// 5.5) MÉTRICAS (agregados): COMPLETE vs DROP por deadline vs FAIL
+			event := "complete"
+			switch {
+			case skip:
+				// tile inviável descartado: também é drop por deadline
+				event = "skip"
+				s.session().OnInfeasibleSkip(ctx, pred.Size)
+			case deadlineDrop:
 				// estimar "stale bytes" usando tamanho do arquivo (se existir)
+				event = "drop"
 				est := int64(estimateTileSize(req))
-				metrics.M().OnDeadlineDropWithBytes(ctx, est)
-			} else {
-				metrics.M().OnComplete(ctx, bytes /*dropped=*/, false)
+				s.session().OnDeadlineDropWithBytes(ctx, est)
+			case !delivered:
+				// not_found/write_error antes do deadline: não é complete
+				event = "fail"
+				s.session().OnFailure(ctx)
+			default:
+				s.session().OnComplete(ctx, bytes /*dropped=*/, false)
 			}
-
-			// 5.5) REQLOG (linha por request) — tempos e flags
-			if s.parent != nil && s.parent.reqlog != nil {
-				s.parent.reqlog.write([]string{
-					fmt.Sprintf("%d", now.UnixNano()),
-					func() string {
-						if deadlineDrop {
-							return "drop"
-						}
-						return "complete"
-					}(),
-					fmt.Sprintf("%d", req.Priority),
-					fmt.Sprintf("%d", req.Segment),
-					fmt.Sprintf("%d", req.Tile),
-					fmt.Sprintf("%d", bytes),
-					fmt.Sprintf("%t", onTime),
-					fmt.Sprintf("%t", deadlineDrop),
-					fmt.Sprintf("%d", qdMs),
-					fmt.Sprintf("%d", svcMs),
-					fmt.Sprintf("%d", rspMs),
-				})
+			// acerto da previsão (as falhas injetadas distorcem o resultado)
+			if pred.Known && !skip && len(faults) == 0 {
+				s.session().OnFeasibility(pred.Feasible, onTime)
 			}
+			predMs, feasible := pred.columns()
+			s.session().OnOutcome(outcome)
+
+			// 5.6) REQLOG (linha por request) — tempos, flags e motivo
+			s.session().LogRequest(requestLog{
+				at: now, event: event, req: req,
+				bytes: bytes, onTime: onTime, drop: deadlineDrop,
+				qdMs: qdMs, svcMs: svcMs, rspMs: rspMs,
+				faults: faults, predMs: predMs, feasible: feasible,
+				outcome: outcome,
+			}.row())
 
 			log.Printf(
-				"[METRICS_REQ] seg=%d tile=%d prio=%d bytes=%d ontime=%t drop=%t qd_ms=%d svc_ms=%d rsp_ms=%d",
-				req.Segment, req.Tile, req.Priority, bytes, onTime, deadlineDrop, qdMs, svcMs, rspMs,
+				"[METRICS_REQ] seg=%d tile=%d prio=%d bytes=%d ontime=%t drop=%t qd_ms=%d svc_ms=%d rsp_ms=%d fault=%s pred_ms=%s feasible=%s outcome=%s",
+				req.Segment, req.Tile, req.Priority, bytes, onTime, deadlineDrop, qdMs, svcMs, rspMs, faults, predMs, feasible, outcome,
 			)
-		})
+		}, cancel)
 		if !ok {
 			log.Println("[SCHED] task enqueue failed")
+			s.logUnserved(req, enqueuedAt, "reject", model.REJECTED_OUTCOME)
+			s.decreaseUsageCount()
 			return
 		}
 	}
 }
 
+// requestLog é uma linha do reqlog (colunas ReqlogHeader).
+type requestLog struct {
+	at                 time.Time
+	event              string
+	req                *model.VideoPacketRequest
+	bytes              int
+	onTime, drop       bool
+	qdMs, svcMs, rspMs int64
+	faults             faultSet
+	predMs, feasible   string
+	outcome            model.Outcome
+}
+
+func (r requestLog) row() []string {
+	return []string{
+		fmt.Sprintf("%d", r.at.UnixNano()),
+		r.event,
+		fmt.Sprintf("%d", r.req.Priority),
+		fmt.Sprintf("%d", r.req.Segment),
+		fmt.Sprintf("%d", r.req.Tile),
+		fmt.Sprintf("%d", r.bytes),
+		fmt.Sprintf("%t", r.onTime),
+		fmt.Sprintf("%t", r.drop),
+		fmt.Sprintf("%d", r.qdMs),
+		fmt.Sprintf("%d", r.svcMs),
+		fmt.Sprintf("%d", r.rspMs),
+		r.faults.String(),
+		r.predMs,
+		r.feasible,
+		requestID(r.req),
+		r.outcome.String(),
+	}
+}
+
+// logUnserved registra uma requisição que nunca começou o serviço (event
+// reject ou cancel): o tempo de espera vai em qd_ms e rsp_ms.
+func (s *stream) logUnserved(req *model.VideoPacketRequest, enqueuedAt time.Time, event string, outcome model.Outcome) {
+	now := time.Now()
+	waited := now.Sub(enqueuedAt).Milliseconds()
+	s.session().OnOutcome(outcome)
+	s.session().LogRequest(requestLog{
+		at: now, event: event, req: req,
+		qdMs: waited, rspMs: waited,
+		outcome: outcome,
+	}.row())
+	log.Printf("[REQ] %s seg=%d tile=%d prio=%d waited_ms=%d", outcome, req.Segment, req.Tile, req.Priority, waited)
+}
+
 // handleRequestMeasured executa o “serviço”: valida deadline,
 // carrega o tile do disco e envia a resposta via QUIC.
-// Retorna o número de bytes efetivamente enviados (0 em falha/timeout).
-func (s *stream) handleRequestMeasured(req *model.VideoPacketRequest, deadline time.Time) int {
+// Retorna o número de bytes efetivamente enviados (0 em falha/timeout,
+// parcial se o envio por datagrams falhou no meio) e o motivo do resultado:
+// on_time/late se o tile inteiro foi enviado, senão o motivo da falha.
+// As falhas injetadas são acumuladas em faults.
+func (s *stream) handleRequestMeasured(req *model.VideoPacketRequest, deadline time.Time, faults *faultSet) (int, model.Outcome) {
 	// Se já passou o deadline, não vale mais processar (drop por deadline).
 	if time.Now().After(deadline) {
 		log.Printf("[REQ] timed out before service seg=%d tile=%d", req.Segment, req.Tile)
-		return 0
+		return 0, model.EXPIRED_BEFORE_SERVICE_OUTCOME
 	}
 
-	data := readFile(req)
+	s.injectFault(FaultStorageDelay, req, faults)
+	var data []byte
+	if s.injectFault(FaultReadError, req, faults) {
+		log.Printf("[FS] injected read error seg=%d tile=%d", req.Segment, req.Tile)
+	} else {
+		data = readFile(req)
+	}
 	if data == nil || len(data) == 0 {
 		// Falha de E/S não conta como deadline drop — bytes=0 e ontime=false
 		log.Printf("[REQ] file empty/missing seg=%d tile=%d", req.Segment, req.Tile)
-		return 0
+		return 0, model.NOT_FOUND_OUTCOME
 	}
 
 	res := model.VideoPacketResponse{
@@ -360,28 +438,129 @@ func (s *stream) handleRequestMeasured(req *model.VideoPacketRequest, deadline t
 		Tile:     req.Tile,
 		Data:     data,
 	}
+	s.injectFault(FaultWriteDelay, req, faults)
+	if s.injectFault(FaultWriteError, req, faults) {
+		log.Printf("[RESP] injected write error seg=%d tile=%d", req.Segment, req.Tile)
+		return 0, model.WRITE_ERROR_OUTCOME
+	}
+	if req.Delivery == model.DATAGRAM_DELIVERY && s.datagramsSupported() {
+		sent, err := s.sendDatagrams(&res)
+		if err != nil {
+			// tile incompleto não é entrega: bytes parciais, motivo da falha
+			return sent, s.writeFailure(err)
+		}
+		return sent, model.DeliveryOutcome(time.Now(), deadline)
+	}
+	writeStart := time.Now()
 	if err := res.Write(s.writer); err != nil {
 		log.Printf("[RESP] write error: %v", err)
-		return 0
+		return 0, s.writeFailure(err)
 	}
 	// flush é essencial para não acumular no buffer e atrasar deadline
 	if err := s.writer.Flush(); err != nil {
 		log.Printf("[RESP] flush error: %v", err)
-		return 0
+		return 0, s.writeFailure(err)
+	}
+	if s.parent != nil {
+		s.parent.rate.Observe(len(data), time.Since(writeStart))
 	}
 
 	log.Printf("[RESP] sent seg=%d tile=%d bytes=%d", req.Segment, req.Tile, len(data))
-	return len(data)
+	return len(data), model.DeliveryOutcome(time.Now(), deadline)
 }
 
-// readFile monta o caminho do arquivo do tile e lê do disco.
+// writeFailure classifica uma falha de envio: stream cancelado pelo cliente,
+// conexão caída ou erro de escrita.
+func (s *stream) writeFailure(err error) model.Outcome {
+	var streamErr *quic.StreamError
+	switch {
+	case errors.As(err, &streamErr):
+		return model.CANCELLED_OUTCOME
+	case s.parent != nil && s.parent.connection != nil && s.parent.connection.Context().Err() != nil:
+		return model.TRANSPORT_ERROR_OUTCOME
+	}
+	return model.WRITE_ERROR_OUTCOME
+}
+
+// predictFeasibility prevê se o tile termina até o deadline, se a previsão
+// estiver ligada.
+func (s *stream) predictFeasibility(req *model.VideoPacketRequest, now, deadline time.Time) Prediction {
+	if s.parent == nil || s.parent.feasibility == FeasibilityOff {
+		return Prediction{}
+	}
+	return s.parent.rate.Predict(estimateTileSize(req), now, deadline)
+}
+
+// session devolve a sessão de métricas da conexão (nil sem parent).
+func (s *stream) session() *metrics.Session {
+	if s.parent == nil {
+		return nil
+	}
+	return s.parent.metrics
+}
+
+// injectFault sorteia a falha kind para a requisição; se ocorrer, aplica o
+// atraso configurado e a registra em faults.
+func (s *stream) injectFault(kind FaultKind, req *model.VideoPacketRequest, faults *faultSet) bool {
+	if s.parent == nil {
+		return false
+	}
+	hit, delay := s.parent.faults.Inject(kind, req.Priority, s.parent.connectionID)
+	if !hit {
+		return false
+	}
+	faults.add(kind)
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return true
+}
+
+// datagramsSupported indica se o peer negociou DATAGRAM frames (RFC 9221).
+// Sem suporte, a resposta cai de volta para o stream confiável.
+func (s *stream) datagramsSupported() bool {
+	if s.parent == nil || s.parent.connection == nil {
+		return false
+	}
+	return s.parent.connection.ConnectionState().SupportsDatagrams
+}
+
+// sendDatagrams envia a resposta fragmentada em datagrams QUIC, sem
+// retransmissão. Retorna os bytes de payload entregues à pilha QUIC
+// (parcial se algum fragmento falhou) e o erro que interrompeu o envio.
+func (s *stream) sendDatagrams(res *model.VideoPacketResponse) (int, error) {
+	fragments, err := model.FragmentResponse(res, model.DATAGRAM_FRAGMENT_PAYLOAD)
+	if err != nil {
+		log.Printf("[RESP] fragment error: %v", err)
+		return 0, err
+	}
+	sent := 0
+	for i, fragment := range fragments {
+		if err := s.parent.connection.SendMessage(fragment); err != nil {
+			log.Printf("[RESP] datagram error seg=%d tile=%d frag=%d/%d: %v",
+				res.Segment, res.Tile, i, len(fragments), err)
+			return sent, err
+		}
+		chunk := len(res.Data) - sent
+		if chunk > model.DATAGRAM_FRAGMENT_PAYLOAD {
+			chunk = model.DATAGRAM_FRAGMENT_PAYLOAD
+		}
+		sent += chunk
+	}
+	log.Printf("[RESP] sent seg=%d tile=%d bytes=%d fragments=%d (datagram)",
+		res.Segment, res.Tile, sent, len(fragments))
+	return sent, nil
+}
+
+// readFile monta o caminho do arquivo do tile (track<tile>_<segmento>) e lê do
+// disco.
 func readFile(req *model.VideoPacketRequest) []byte {
 	basePath, err := os.Getwd()
 	if err != nil {
 		log.Printf("[FS] getwd err: %v", err)
 	}
 	filePath := fmt.Sprintf("/data/segments/video_tiled_10_dash_track%d_%d.m4s",
-		req.Segment, req.Tile)
+		req.Tile, req.Segment)
 	full := basePath + filePath
 	data, err := os.ReadFile(full)
 	if err != nil {
@@ -395,10 +574,19 @@ func readFile(req *model.VideoPacketRequest) []byte {
 func estimateTileSize(req *model.VideoPacketRequest) int64 {
 	basePath, _ := os.Getwd()
 	full := fmt.Sprintf("%s/data/segments/video_tiled_10_dash_track%d_%d.m4s",
-		basePath, req.Segment, req.Tile)
+		basePath, req.Tile, req.Segment)
 	st, err := os.Stat(full)
 	if err != nil {
 		return 0
 	}
 	return st.Size()
 }
+
+// requestID é o ID enviado pelo cliente, para juntar o reqlog às estatísticas
+// dele; vazio se o cliente não mandou.
+func requestID(req *model.VideoPacketRequest) string {
+	if req.ID == uuid.Nil {
+		return ""
+	}
+	return req.ID.String()
+}
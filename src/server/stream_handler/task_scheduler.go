@@ -1,6 +1,7 @@
 package stream_handler
 
 import (
+	"fmt"
 	"log"
 	"sync"
 	"time"
@@ -19,6 +20,35 @@ const (
 	PolicyWFQ  QueuePolicy = "wfq" // weighted fair queuing simples
 )
 
+// ParseQueuePolicy valida o nome de uma política.
+func ParseQueuePolicy(name string) (QueuePolicy, error) {
+	switch p := QueuePolicy(name); p {
+	case PolicyFIFO, PolicySP, PolicyWFQ:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown policy %q (wfq, sp or fifo)", name)
+}
+
+// WFQWeights são os pesos por classe da política WFQ.
+type WFQWeights struct {
+	High   int `json:"high"`
+	Medium int `json:"medium"`
+	Low    int `json:"low"`
+}
+
+// DefaultWFQWeights: low=1, med=2, high=3
+var DefaultWFQWeights = WFQWeights{High: 3, Medium: 2, Low: 1}
+
+// Map retorna os pesos indexados pela classe (model.Priority), como as
+// métricas os recebem.
+func (w WFQWeights) Map() map[int]float64 {
+	return map[int]float64{
+		int(model.LOW_PRIORITY):    float64(w.Low),
+		int(model.MEDIUM_PRIORITY): float64(w.Medium),
+		int(model.HIGH_PRIORITY):   float64(w.High),
+	}
+}
+
 // TaskScheduler é a interface usada pelo stream_handler.go
 type TaskScheduler interface {
 	Enqueue(p model.Priority, fn func()) bool
@@ -26,73 +56,118 @@ type TaskScheduler interface {
 	Stop()
 }
 
+// TaskInfo descreve uma tarefa enfileirada (para inspeção pela API admin).
+type TaskInfo struct {
+	Class    model.Priority
+	Segment  int
+	Tile     int
+	Deadline time.Time // zero se desconhecido
+}
+
+// QueuedTask é uma tarefa ainda na fila.
+type QueuedTask struct {
+	TaskInfo
+	Enqueued time.Time
+}
+
 // ----------------------------- Implementação -----------------------------
 
 type task struct {
-	prio     model.Priority
-	fn       func()
-	enqueued time.Time
+	info   TaskInfo
+	fn     func()
+	cancel func() // nil = nada a fazer se a tarefa nunca rodar
 }
 
 type Scheduler struct {
-	policy QueuePolicy
+	policy  QueuePolicy
+	weights WFQWeights
+	metrics *metrics.Session // nil = sem métricas
 
-	// filas por prioridade (low=0, med=1, high=2)
-	queues [int(model.PRIORITY_LEVEL_COUNT)][]task
+	// filas por classe + estado da política
+	queue *PolicyQueue[task]
 
 	// controle de execução
 	mu      sync.Mutex
 	cond    *sync.Cond
 	stopped bool
 	running bool
+	paused  bool // tarefas continuam entrando, mas nenhuma começa
 
-	// WFQ: pesos e estado do RR
-	wfqWeights [int(model.PRIORITY_LEVEL_COUNT)]int // default: low=1, med=2, high=3
-	wfqCursor  int
-	wfqBudget  [int(model.PRIORITY_LEVEL_COUNT)]int
+	// tarefa em serviço no Run; Stop espera por ela
+	serving sync.WaitGroup
 }
 
 // NewTaskScheduler cria um escalonador com a política desejada
 func NewTaskScheduler(policy QueuePolicy) TaskScheduler {
+	return NewTaskSchedulerWithWeights(policy, DefaultWFQWeights)
+}
+
+// NewTaskSchedulerWithWeights cria um escalonador com pesos WFQ próprios
+// (ignorados pelas outras políticas).
+func NewTaskSchedulerWithWeights(policy QueuePolicy, weights WFQWeights) TaskScheduler {
+	return newScheduler(policy, weights, nil)
+}
+
+// newScheduler cria um escalonador que informa backlog e serviço à sessão de
+// métricas (nil = nenhuma).
+func newScheduler(policy QueuePolicy, weights WFQWeights, session *metrics.Session) *Scheduler {
 	s := &Scheduler{
-		policy: policy,
+		policy:  policy,
+		weights: weights,
+		metrics: session,
+		queue:   NewPolicyQueue[task](policy, weights),
 	}
-	// pesos WFQ default (ajuste se quiser)
-	s.wfqWeights[model.LOW_PRIORITY] = 1
-	s.wfqWeights[model.MEDIUM_PRIORITY] = 2
-	s.wfqWeights[model.HIGH_PRIORITY] = 3
 
 	s.cond = sync.NewCond(&s.mu)
+	// a política inicial é a do servidor: vale também para o agregado
+	exposeWeights(session, policy, weights, true)
+	return s
+}
 
-	// Expor pesos ao módulo de WFQ utilization (se for WFQ)
-	if policy == PolicyWFQ {
-		metrics.SetWFQWeights(map[int]float64{
-			int(model.LOW_PRIORITY):    float64(s.wfqWeights[model.LOW_PRIORITY]),
-			int(model.MEDIUM_PRIORITY): float64(s.wfqWeights[model.MEDIUM_PRIORITY]),
-			int(model.HIGH_PRIORITY):   float64(s.wfqWeights[model.HIGH_PRIORITY]),
-		})
-	}
+// ExposeWeights informa à sessão os pesos da política: ao WFQ utilization
+// (se for WFQ) e à fairness ponderada, que nas outras políticas usa pesos
+// iguais. Os pesos passam também ao agregado (parent) da sessão.
+func ExposeWeights(session *metrics.Session, policy QueuePolicy, weights WFQWeights) {
+	exposeWeights(session, policy, weights, true)
+}
 
-	return s
+// exposeWeights é ExposeWeights; com shared = false, só a sessão muda.
+func exposeWeights(session *metrics.Session, policy QueuePolicy, weights WFQWeights, shared bool) {
+	setWFQ, setFairness := session.SetWFQWeights, session.SetFairnessWeights
+	if !shared {
+		setWFQ, setFairness = session.SetOwnWFQWeights, session.SetOwnFairnessWeights
+	}
+	if policy != PolicyWFQ {
+		setFairness(nil)
+		return
+	}
+	m := weights.Map()
+	setWFQ(m)
+	setFairness(m)
 }
 
 // ----------------------------- API pública -------------------------------
 
 func (s *Scheduler) Enqueue(p model.Priority, fn func()) bool {
+	return s.EnqueueTask(TaskInfo{Class: p}, fn)
+}
+
+// EnqueueTask enfileira fn na classe info.Class, guardando info para inspeção.
+func (s *Scheduler) EnqueueTask(info TaskInfo, fn func()) bool {
+	return s.EnqueueCancellable(info, fn, nil)
+}
+
+// EnqueueCancellable é como EnqueueTask, mas chama cancel (fora do lock) se
+// o scheduler parar com a tarefa ainda na fila.
+func (s *Scheduler) EnqueueCancellable(info TaskInfo, fn func(), cancel func()) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.stopped {
 		return false
 	}
 	// enfileira
-	s.queues[int(p)] = append(s.queues[int(p)], task{
-		prio:     p,
-		fn:       fn,
-		enqueued: time.Now(),
-	})
-
-	// backlog mudou (soma de todas as filas)
-	metrics.UpdateBacklog(s.totalQueuedLocked())
+	s.queue.Push(info.Class, task{info: info, fn: fn, cancel: cancel}, time.Now())
+	s.metrics.OnTaskEnqueued(info.Class)
 
 	// acorda a goroutine do Run
 	s.cond.Signal()
@@ -112,167 +187,149 @@ func (s *Scheduler) Run() {
 
 	for {
 		// escolhe próxima tarefa (bloqueando se necessário)
-		t, ok := s.nextTaskBlocking()
+		fn, ok := s.nextTaskBlocking()
 		if !ok {
 			// parado
 			break
 		}
 
 		// executa fora do lock
-		t.fn()
+		fn()
+		s.metrics.OnTaskFinished()
+		s.serving.Done()
 	}
 	log.Printf("[SCHED] stopped")
 }
 
+// Stop para o scheduler. As tarefas ainda na fila saem dela sem rodar e são
+// canceladas, e a que está em serviço termina antes de Stop retornar (a
+// sessão de métricas pode ser fechada em seguida). Não deve ser chamado de
+// dentro de uma tarefa.
 func (s *Scheduler) Stop() {
 	s.mu.Lock()
 	s.stopped = true
+	var cancelled []task
+	for {
+		t, ok := s.queue.Pop()
+		if !ok {
+			break
+		}
+		s.metrics.OnTaskDropped(t.info.Class)
+		cancelled = append(cancelled, t)
+	}
 	s.mu.Unlock()
 	s.cond.Broadcast()
-}
 
-// ----------------------- QueueLenProvider (opcional) ----------------------
+	for _, t := range cancelled {
+		if t.cancel != nil {
+			t.cancel()
+		}
+	}
+	s.serving.Wait()
+}
 
-// QueueLenPerClass expõe comprimentos das filas por classe (para sampling)
-func (s *Scheduler) QueueLenPerClass() map[model.Priority]int {
+// Pause impede que novas tarefas comecem (a que está em serviço termina).
+func (s *Scheduler) Pause() {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	m := make(map[model.Priority]int, int(model.PRIORITY_LEVEL_COUNT))
-	for i := 0; i < int(model.PRIORITY_LEVEL_COUNT); i++ {
-		m[model.Priority(i)] = len(s.queues[i])
-	}
-	return m
+	s.paused = true
+	s.mu.Unlock()
 }
 
-// ----------------------------- Seleção ------------------------------------
+// Resume volta a servir as filas.
+func (s *Scheduler) Resume() {
+	s.mu.Lock()
+	s.paused = false
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
 
-func (s *Scheduler) nextTaskBlocking() (task, bool) {
+func (s *Scheduler) Paused() bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.paused
+}
 
-	for {
-		if s.stopped {
-			return task{}, false
-		}
-
-		if total := s.totalQueuedLocked(); total > 0 {
-			var t task
-			switch s.policy {
-			case PolicyFIFO:
-				t = s.pickFIFO()
-			case PolicySP:
-				t = s.pickSP()
-			case PolicyWFQ:
-				t = s.pickWFQ()
-			default:
-				t = s.pickFIFO()
-			}
-
-			// após remover a task das filas, o backlog mudou
-			metrics.UpdateBacklog(s.totalQueuedLocked())
-			// vamos começar a processar => estado busy
-			metrics.UpdateServiceState(true)
-
-			return t, true
-		}
-
-		// filas vazias → servidor idle (antes de bloquear)
-		metrics.UpdateServiceState(false)
+// SetPolicy troca a política e os pesos WFQ com o scheduler rodando. As
+// tarefas enfileiradas são mantidas.
+func (s *Scheduler) SetPolicy(policy QueuePolicy, weights WFQWeights) {
+	s.UpdatePolicy(func(QueuePolicy, WFQWeights) (QueuePolicy, WFQWeights) {
+		return policy, weights
+	})
+}
 
-		s.cond.Wait()
-	}
+// UpdatePolicy troca a política e os pesos pelo resultado de update, chamado
+// com os atuais sob o lock do scheduler (mudanças concorrentes não se
+// sobrescrevem). Os novos pesos valem só para a sessão deste scheduler; o
+// agregado do servidor fica com os seus (ver ExposeWeights).
+func (s *Scheduler) UpdatePolicy(update func(QueuePolicy, WFQWeights) (QueuePolicy, WFQWeights)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy, s.weights = update(s.policy, s.weights)
+	s.queue.Reconfigure(s.policy, s.weights)
+	exposeWeights(s.metrics, s.policy, s.weights, false)
+	log.Printf("[SCHED] policy=%s weights=%+v", s.policy, s.weights)
 }
 
-// total de itens enfileirados (com lock)
-func (s *Scheduler) totalQueuedLocked() int {
-	n := 0
-	for i := 0; i < int(model.PRIORITY_LEVEL_COUNT); i++ {
-		n += len(s.queues[i])
-	}
-	return n
+// Policy retorna a política e os pesos atuais.
+func (s *Scheduler) Policy() (QueuePolicy, WFQWeights) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.policy, s.weights
 }
 
-// ----------------------------- Políticas ----------------------------------
+// QueuedTasks lista as tarefas na fila, por classe e em ordem de chegada.
+func (s *Scheduler) QueuedTasks() []QueuedTask {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tasks []QueuedTask
+	s.queue.Each(func(p model.Priority, t task, enqueued time.Time) {
+		tasks = append(tasks, QueuedTask{TaskInfo: t.info, Enqueued: enqueued})
+	})
+	return tasks
+}
 
-// FIFO global: pega o mais antigo entre todas as filas.
-func (s *Scheduler) pickFIFO() task {
-	// encontra o task com menor enqueued
-	found := false
-	var bestIdx int
-	var bestQ int
-	var bestEnq time.Time
+// ----------------------------- Inspeção -----------------------------------
 
-	for q := 0; q < int(model.PRIORITY_LEVEL_COUNT); q++ {
-		if len(s.queues[q]) == 0 {
-			continue
-		}
-		t := s.queues[q][0]
-		if !found || t.enqueued.Before(bestEnq) {
-			found = true
-			bestEnq = t.enqueued
-			bestIdx = 0
-			bestQ = q
-		}
-	}
-	t := s.queues[bestQ][bestIdx]
-	// remove da fila
-	s.queues[bestQ] = s.queues[bestQ][1:]
-	return t
+// QueueLenPerClass expõe comprimentos das filas por classe (API admin)
+func (s *Scheduler) QueueLenPerClass() map[model.Priority]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queue.LenPerClass()
 }
 
-// SP (strict priority, não-preemptivo): sempre escolhe a maior prioridade disponível.
-func (s *Scheduler) pickSP() task {
-	// high → medium → low
-	for q := int(model.HIGH_PRIORITY); q >= int(model.LOW_PRIORITY); q-- {
-		if len(s.queues[q]) > 0 {
-			t := s.queues[q][0]
-			s.queues[q] = s.queues[q][1:]
-			// (se implementar preempção real no futuro, chame metrics.M().OnPreempt)
-			return t
-		}
-	}
-	// não deveria chegar aqui (já verificamos total > 0)
-	return task{}
-}
+// ----------------------------- Seleção ------------------------------------
+
+func (s *Scheduler) nextTaskBlocking() (func(), bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-// WFQ simples por fatia de tarefas (peso = nº de tarefas por rodada)
-func (s *Scheduler) pickWFQ() task {
-	nClasses := int(model.PRIORITY_LEVEL_COUNT)
-	// garante orçamento inicial
-	for i := 0; i < nClasses; i++ {
-		if s.wfqBudget[i] <= 0 {
-			s.wfqBudget[i] = s.wfqWeights[i]
+	for {
+		if s.stopped {
+			return nil, false
 		}
-	}
 
-	checked := 0
-	for checked < nClasses {
-		q := s.wfqCursor % nClasses
-
-		if len(s.queues[q]) > 0 && s.wfqBudget[q] > 0 {
-			// serve dessa fila e consome orçamento
-			t := s.queues[q][0]
-			s.queues[q] = s.queues[q][1:]
-			s.wfqBudget[q]--
-			// fica no mesmo cursor para tentar servir +1 da mesma fila se ainda há orçamento
-			return t
+		if s.paused {
+			// pausado: as tarefas esperam na fila até Resume
+			s.cond.Wait()
+			continue
 		}
 
-		// avança cursor e, se orçamento esgotado, recarrega
-		if s.wfqBudget[q] <= 0 {
-			s.wfqBudget[q] = s.wfqWeights[q]
+		if t, ok := s.queue.Pop(); ok {
+			// sai da fila e entra em serviço (conta inversão); com o lock,
+			// para que Stop não comece a esperar antes do Add
+			s.metrics.OnTaskDequeued(t.info.Class)
+			s.serving.Add(1)
+			return t.fn, true
 		}
-		s.wfqCursor = (s.wfqCursor + 1) % nClasses
-		checked++
-	}
 
-	// fallback: se nada foi escolhido (todas filas vazias no giro), devolve FIFO
-	return s.pickFIFO()
+		// filas vazias → bloqueia até o próximo Enqueue
+		s.cond.Wait()
+	}
 }
 
 // ----------------------------- Utilidades ---------------------------------
 
 // (Opcional) Se em algum momento você adicionar preempção real ao SP, chame isto:
 func (s *Scheduler) notifyPreemption(preempted, preemptor model.Priority) {
-	metrics.M().OnPreempt(preempted, preemptor)
+	s.metrics.OnPreempt(preempted, preemptor)
 }
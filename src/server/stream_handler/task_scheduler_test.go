@@ -0,0 +1,155 @@
+package stream_handler_test
+
+import (
+	"main/src/model"
+	"main/src/server/stream_handler"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Serves the given classes, queued in this order before Run, and returns
+// the order in which they ran.
+func serveOrder(policy stream_handler.QueuePolicy, weights stream_handler.WFQWeights, classes []model.Priority) []model.Priority {
+	s := stream_handler.NewTaskSchedulerWithWeights(policy, weights)
+	order := make(chan model.Priority, len(classes))
+	for _, p := range classes {
+		p := p
+		s.Enqueue(p, func() { order <- p })
+	}
+	go s.Run()
+	defer s.Stop()
+	var served []model.Priority
+	for range classes {
+		served = append(served, <-order)
+	}
+	return served
+}
+
+// Tests if strict priority serves high, then medium, then low.
+func TestScheduler_StrictPriorityOrder(t *testing.T) {
+	const high, medium, low = model.HIGH_PRIORITY, model.MEDIUM_PRIORITY, model.LOW_PRIORITY
+	served := serveOrder(stream_handler.PolicySP, stream_handler.DefaultWFQWeights, []model.Priority{low, medium, high, low})
+	assert.Equal(t, []model.Priority{high, medium, low, low}, served)
+}
+
+// Tests if WFQ serves each backlogged class as many tasks per round as its
+// weight, instead of emptying the first class.
+func TestScheduler_WFQRounds(t *testing.T) {
+	const high, low = model.HIGH_PRIORITY, model.LOW_PRIORITY
+	var classes []model.Priority
+	for i := 0; i < 6; i++ {
+		classes = append(classes, high, low)
+	}
+	served := serveOrder(stream_handler.PolicyWFQ, stream_handler.WFQWeights{High: 3, Medium: 2, Low: 1}, classes)
+	assert.Equal(t, []model.Priority{high, high, high, low, high, high, high, low}, served[:8])
+}
+
+// Tests if a paused scheduler keeps its tasks queued until Resume.
+func TestScheduler_PauseResume(t *testing.T) {
+	s := stream_handler.NewTaskSchedulerWithWeights(stream_handler.PolicyFIFO, stream_handler.DefaultWFQWeights).(*stream_handler.Scheduler)
+	s.Pause()
+	go s.Run()
+	defer s.Stop()
+
+	done := make(chan struct{})
+	deadline := time.Now().Add(time.Second)
+	s.EnqueueTask(stream_handler.TaskInfo{Class: model.LOW_PRIORITY, Segment: 3, Tile: 7, Deadline: deadline}, func() { close(done) })
+
+	select {
+	case <-done:
+		t.Fatal("task ran while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	tasks := s.QueuedTasks()
+	assert.Equal(t, 1, len(tasks))
+	assert.Equal(t, model.LOW_PRIORITY, tasks[0].Class)
+	assert.Equal(t, 3, tasks[0].Segment)
+	assert.Equal(t, 7, tasks[0].Tile)
+	assert.Equal(t, deadline, tasks[0].Deadline)
+	assert.True(t, s.Paused())
+
+	s.Resume()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("task did not run after resume")
+	}
+	assert.Equal(t, 0, len(s.QueuedTasks()))
+}
+
+// Tests if switching the policy keeps the queued tasks and serves them with
+// the new policy.
+func TestScheduler_SetPolicy(t *testing.T) {
+	s := stream_handler.NewTaskSchedulerWithWeights(stream_handler.PolicyFIFO, stream_handler.DefaultWFQWeights).(*stream_handler.Scheduler)
+	s.Pause()
+	go s.Run()
+	defer s.Stop()
+
+	order := make(chan model.Priority, 3)
+	for _, p := range []model.Priority{model.LOW_PRIORITY, model.MEDIUM_PRIORITY, model.HIGH_PRIORITY} {
+		p := p
+		s.Enqueue(p, func() { order <- p })
+		time.Sleep(time.Millisecond)
+	}
+	weights := stream_handler.WFQWeights{High: 5, Medium: 1, Low: 1}
+	s.SetPolicy(stream_handler.PolicySP, weights)
+	policy, got := s.Policy()
+	assert.Equal(t, stream_handler.PolicySP, policy)
+	assert.Equal(t, weights, got)
+
+	s.Resume()
+	for _, want := range []model.Priority{model.HIGH_PRIORITY, model.MEDIUM_PRIORITY, model.LOW_PRIORITY} {
+		assert.Equal(t, want, <-order)
+	}
+}
+
+// Tests if stopping cancels the queued tasks instead of dropping them
+// silently, and refuses new ones.
+func TestScheduler_StopCancels(t *testing.T) {
+	s := stream_handler.NewTaskSchedulerWithWeights(stream_handler.PolicyFIFO, stream_handler.DefaultWFQWeights).(*stream_handler.Scheduler)
+	s.Pause()
+	go s.Run()
+
+	ran, cancelled := 0, 0
+	for i := 0; i < 2; i++ {
+		s.EnqueueCancellable(stream_handler.TaskInfo{Class: model.LOW_PRIORITY}, func() { ran++ }, func() { cancelled++ })
+	}
+	s.Stop()
+	assert.Equal(t, 0, ran)
+	assert.Equal(t, 2, cancelled)
+	assert.Equal(t, 0, len(s.QueuedTasks()))
+	assert.False(t, s.EnqueueCancellable(stream_handler.TaskInfo{}, func() {}, func() { cancelled++ }))
+	assert.Equal(t, 2, cancelled)
+}
+
+// Tests if stopping waits for the task in service to finish.
+func TestScheduler_StopWaitsForRunningTask(t *testing.T) {
+	s := stream_handler.NewTaskSchedulerWithWeights(stream_handler.PolicyFIFO, stream_handler.DefaultWFQWeights).(*stream_handler.Scheduler)
+	go s.Run()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	finished := false
+	s.Enqueue(model.LOW_PRIORITY, func() {
+		close(started)
+		<-release
+		finished = true
+	})
+	<-started
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	s.Stop()
+	assert.True(t, finished)
+}
+
+// Tests if unknown policies are rejected.
+func TestParseQueuePolicy(t *testing.T) {
+	policy, err := stream_handler.ParseQueuePolicy("wfq")
+	assert.Nil(t, err)
+	assert.Equal(t, stream_handler.PolicyWFQ, policy)
+	_, err = stream_handler.ParseQueuePolicy("edf")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,78 @@
+package sim
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Clock is a virtual clock driving a discrete-event simulation. Events run in
+// time order, and in scheduling order at equal times. Not thread-safe: events
+// run one after the other on the goroutine calling Run.
+type Clock struct {
+	now    time.Time
+	seq    uint64
+	events eventHeap
+}
+
+// NewClock creates a clock starting at start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the current virtual time.
+func (c *Clock) Now() time.Time {
+	return c.now
+}
+
+// At schedules fn at time t. Times in the past run at the current time.
+func (c *Clock) At(t time.Time, fn func()) {
+	if t.Before(c.now) {
+		t = c.now
+	}
+	c.seq++
+	heap.Push(&c.events, &event{at: t, seq: c.seq, fn: fn})
+}
+
+// After schedules fn after d.
+func (c *Clock) After(d time.Duration, fn func()) {
+	c.At(c.now.Add(d), fn)
+}
+
+// Run executes the events until there are none left.
+func (c *Clock) Run() {
+	for c.events.Len() > 0 {
+		e := heap.Pop(&c.events).(*event)
+		c.now = e.at
+		e.fn()
+	}
+}
+
+type event struct {
+	at  time.Time
+	seq uint64
+	fn  func()
+}
+
+type eventHeap []*event
+
+func (h eventHeap) Len() int { return len(h) }
+
+func (h eventHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+
+func (h eventHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *eventHeap) Push(x any) { *h = append(*h, x.(*event)) }
+
+func (h *eventHeap) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
@@ -0,0 +1,36 @@
+package sim
+
+import (
+	"encoding/csv"
+	"os"
+)
+
+// A CSV file written by the simulation, flushed on close.
+type csvFile struct {
+	f *os.File
+	w *csv.Writer
+}
+
+func createCSV(path string, header []string) (*csvFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &csvFile{f: f, w: csv.NewWriter(f)}
+	c.write(header)
+	return c, nil
+}
+
+func (c *csvFile) write(row []string) {
+	if c != nil {
+		_ = c.w.Write(row)
+	}
+}
+
+func (c *csvFile) close() {
+	if c == nil {
+		return
+	}
+	c.w.Flush()
+	_ = c.f.Close()
+}
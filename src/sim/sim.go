@@ -0,0 +1,569 @@
+// Package sim evaluates the server queue policies with a discrete-event
+// simulation in virtual time, instead of running QUIC over a real or emulated
+// network.
+//
+// It reuses the policy implementation of the server (stream_handler.PolicyQueue)
+// and replays the test client workload: tiles requested segment by segment with
+// the same prefetch window, deadlines and parallelism limit, FoV tiles in high
+// priority. The network is a single bottleneck: responses are serialised at
+// the link bandwidth, one at a time like the server scheduler loop, and every
+// message takes the one-way delay. Loss only reduces the effective bandwidth
+// (retransmissions); jitter and reordering are not modelled.
+//
+// The outputs have the columns of the server's reqlog.csv and
+// server_summary.csv, so the same analysis applies.
+package sim
+
+import (
+	"fmt"
+	"log"
+	"main/src/config"
+	"main/src/model"
+	"main/src/netem"
+	"main/src/server/metrics"
+	"main/src/server/stream_handler"
+	"main/src/test_client"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Bandwidth used when the configuration leaves the link unlimited.
+const DefaultBandwidthMbps = 100.0
+
+// Virtual time of the start of every simulation, so outputs are reproducible.
+var Epoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Options of a simulation.
+type Options struct {
+	// Server policy and weights, client workload and link.
+	Config config.Config
+	// Size in bytes of a tile. nil reads the files under data/segments, like
+	// the server; missing files are served as empty responses.
+	TileSize func(segment, tile int) int
+	// FoV trace. nil loads Config.Client.FOVTracePath, if it exists.
+	FOVTrace *test_client.FOVTrace
+	// Directory of reqlog.csv and server_summary.csv. Empty writes nothing.
+	OutputDir string
+}
+
+// Client-side outcome of a simulation.
+type Result struct {
+	// Tile requests sent to the server.
+	Requests int
+	// Requests answered before their deadline.
+	OnTime int
+	// Tiles not requested because their deadline had passed (the client
+	// gives up on them without sending).
+	Skipped int
+	// The same, restricted to FoV tiles.
+	FOVTiles  int
+	FOVOnTime int
+	// Requests the server dropped because their deadline had passed.
+	ServerDrops int
+	// Bytes sent by the server.
+	BytesSent int64
+	// Virtual time from the first request to the last response.
+	Duration time.Duration
+}
+
+// OnTimeRate returns the percentage of tiles (requested or skipped) delivered
+// on time.
+func (r Result) OnTimeRate() float64 {
+	return percent(r.OnTime, r.Requests+r.Skipped)
+}
+
+// FOVOnTimeRate returns the percentage of FoV tiles delivered on time, or -1
+// without a FoV trace.
+func (r Result) FOVOnTimeRate() float64 {
+	if r.FOVTiles == 0 {
+		return -1
+	}
+	return percent(r.FOVOnTime, r.FOVTiles)
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// Run executes the simulation to the end.
+func Run(opts Options) (Result, error) {
+	cfg := opts.Config
+	if err := cfg.Validate(); err != nil {
+		return Result{}, err
+	}
+	s, err := newSimulation(opts)
+	if err != nil {
+		return Result{}, err
+	}
+	defer s.closeOutputs()
+
+	s.start()
+	s.clock.Run()
+
+	s.result.Duration = s.lastResponse.Sub(Epoch)
+	s.writeSummary()
+	return s.result, nil
+}
+
+// A request in flight, seen from both ends.
+type request struct {
+	segment, tile  int
+	priority       model.Priority
+	inFOV          bool
+	size           int
+	clientDeadline time.Time
+	serverDeadline time.Time
+	enqueuedAt     time.Time
+	answered       bool // response arrived or client timed out
+}
+
+type simulation struct {
+	clock  *Clock
+	cfg    config.Config
+	rng    *rand.Rand
+	result Result
+
+	tileSize func(segment, tile int) int
+	fovTrace *test_client.FOVTrace
+
+	// link
+	bytesPerSec float64
+	delay       time.Duration
+	// bandwidth trace, played in virtual time; the loss still applies
+	trace      *netem.BandwidthTrace
+	lossFactor float64
+
+	// client
+	segmentDuration time.Duration
+	playbackTime    []time.Time
+	nextSegment     int
+	nextTile        int
+	segmentDeadline time.Time
+	segmentStarted  bool
+	inFlight        int
+	lastResponse    time.Time
+	waitingPlayback bool
+	playbackSegment int
+	lastSegment     int
+	firstTile       int
+	lastTile        int
+	maxAhead        int
+	parallelism     int
+	hasFOVTrace     bool
+	highRatio       float64
+	mediumRatio     float64
+
+	// server
+	queue  *stream_handler.PolicyQueue[*request]
+	busy   bool
+	counts metrics.SummaryInput
+	// queues, service, backlog time and inversions, as the server's
+	acct *metrics.Accounting
+	// weighted fairness over the windows of the server's fairness.csv
+	fairness    *metrics.WeightedFairness
+	fairnessEnd time.Time // end of the current window
+	reqlog      *csvFile
+	summary     *csvFile
+}
+
+func newSimulation(opts Options) (*simulation, error) {
+	cfg := opts.Config
+	link, err := cfg.Network.LinkConfig()
+	if err != nil {
+		return nil, err
+	}
+	bandwidth := link.BandwidthMbps
+	if bandwidth <= 0 {
+		bandwidth = DefaultBandwidthMbps
+	}
+	loss := link.LossPercent
+	if link.GilbertElliott != nil {
+		ge := link.GilbertElliott
+		// stationary loss rate of the two-state chain
+		if ge.P+ge.R > 0 {
+			bad := ge.P / (ge.P + ge.R)
+			loss = bad*ge.LossBad + (1-bad)*ge.LossGood
+		}
+	}
+	if loss >= 100 {
+		return nil, fmt.Errorf("loss of %.1f%% leaves no bandwidth", loss)
+	}
+
+	s := &simulation{
+		clock:           NewClock(Epoch),
+		cfg:             cfg,
+		rng:             rand.New(rand.NewSource(cfg.Network.Seed)),
+		tileSize:        opts.TileSize,
+		fovTrace:        opts.FOVTrace,
+		bytesPerSec:     bandwidth * 1e6 / 8 * (1 - loss/100),
+		delay:           link.Delay,
+		trace:           link.BandwidthTrace,
+		lossFactor:      1 - loss/100,
+		segmentDuration: time.Second,
+		lastSegment:     cfg.Client.TotalTimeSegments,
+		firstTile:       cfg.Client.FirstTile,
+		lastTile:        cfg.Client.LastTile,
+		maxAhead:        cfg.Client.MaxBufferedSegmentsAhead,
+		parallelism:     cfg.Client.Parallelism,
+		highRatio:       cfg.Client.HighPriorityRatio,
+		mediumRatio:     cfg.Client.MediumPriorityRatio,
+	}
+	w := cfg.Server.WFQWeights
+	s.queue = stream_handler.NewPolicyQueue[*request](stream_handler.QueuePolicy(cfg.Server.Policy),
+		stream_handler.WFQWeights{High: w.High, Medium: w.Medium, Low: w.Low})
+	s.acct = metrics.NewAccounting(Epoch)
+	s.fairness = metrics.NewWeightedFairness()
+	if stream_handler.QueuePolicy(cfg.Server.Policy) == stream_handler.PolicyWFQ {
+		s.fairness.SetWeights(stream_handler.WFQWeights{High: w.High, Medium: w.Medium, Low: w.Low}.Map())
+	}
+	s.fairnessEnd = Epoch.Add(fairnessInterval)
+
+	if s.tileSize == nil {
+		s.tileSize = segmentFileSize(filepath.Join("data", "segments"))
+	}
+	if s.fovTrace == nil {
+		trace, err := test_client.LoadFOVTrace(cfg.Client.FOVTracePath, cfg.Client.FOVTraceFPS, s.segmentDuration)
+		if err != nil {
+			log.Printf("[SIM] no FoV trace (%v): priorities drawn from the configured ratios", err)
+		} else {
+			s.fovTrace = trace
+		}
+	}
+	s.hasFOVTrace = s.fovTrace != nil
+
+	if opts.OutputDir != "" {
+		if err := os.MkdirAll(opts.OutputDir, 0o755); err != nil {
+			return nil, err
+		}
+		if s.reqlog, err = createCSV(filepath.Join(opts.OutputDir, "reqlog.csv"), stream_handler.ReqlogHeader); err != nil {
+			return nil, err
+		}
+		if s.summary, err = createCSV(filepath.Join(opts.OutputDir, "server_summary.csv"), metrics.SummaryHeader); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// ----------------------------- Client -------------------------------------
+
+func (s *simulation) start() {
+	// Same schedule as PlaybackSimulator.Start: segment i plays at
+	// start + baseLatency + (i-1) * segmentDuration
+	baseLatency := time.Duration(s.cfg.Client.BaseLatencyMs) * time.Millisecond
+	s.playbackTime = make([]time.Time, s.lastSegment+1)
+	t := s.clock.Now().Add(baseLatency)
+	for i := 1; i <= s.lastSegment; i++ {
+		s.playbackTime[i] = t
+		segment := i
+		s.clock.At(t, func() { s.onPlayback(segment) })
+		t = t.Add(s.segmentDuration)
+	}
+	s.counts.Start = s.clock.Now()
+	s.nextSegment = 1
+	s.nextTile = s.firstTile
+	s.issue()
+}
+
+func (s *simulation) onPlayback(segment int) {
+	s.playbackSegment = segment
+	if s.waitingPlayback {
+		s.waitingPlayback = false
+		s.issue()
+	}
+}
+
+// Time until the segment starts playing (PlaybackSimulator.GetTimeToReceive).
+func (s *simulation) timeToReceive(segment int) time.Duration {
+	if segment <= s.playbackSegment {
+		return 0
+	}
+	d := s.playbackTime[segment].Sub(s.clock.Now())
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// Sends requests in the order of runTestIteration until it has to wait for
+// the playback (prefetch window) or for a free slot (parallelism).
+func (s *simulation) issue() {
+	for s.nextSegment <= s.lastSegment {
+		segment := s.nextSegment
+		if !s.segmentStarted {
+			if segment-s.playbackSegment > s.maxAhead {
+				s.waitingPlayback = true
+				return
+			}
+			budget := test_client.SegmentTimeBudget(s.timeToReceive(segment), s.segmentDuration, s.maxAhead)
+			s.segmentDeadline = s.clock.Now().Add(budget)
+			s.segmentStarted = true
+		}
+		if s.inFlight >= s.parallelism {
+			return
+		}
+
+		tile := s.nextTile
+		s.sendRequest(segment, tile)
+
+		s.nextTile++
+		if s.nextTile > s.lastTile {
+			s.nextTile = s.firstTile
+			s.nextSegment++
+			s.segmentStarted = false
+		}
+	}
+}
+
+func (s *simulation) sendRequest(segment, tile int) {
+	now := s.clock.Now()
+	inFOV := s.hasFOVTrace && s.fovTrace.Contains(segment, tile)
+	priority := model.LOW_PRIORITY
+	if inFOV {
+		priority = model.HIGH_PRIORITY
+	} else if !s.hasFOVTrace {
+		priority = s.randomPriority()
+	}
+	if inFOV {
+		s.result.FOVTiles++
+	}
+
+	remaining := s.segmentDeadline.Sub(now)
+	if remaining <= 0 {
+		s.result.Skipped++
+		return
+	}
+	// The request carries the timeout in ms; the server counts it from arrival
+	timeout := time.Duration(int(remaining/time.Millisecond)) * time.Millisecond
+	if timeout <= 0 {
+		timeout = time.Millisecond
+	}
+
+	r := &request{
+		segment:        segment,
+		tile:           tile,
+		priority:       priority,
+		inFOV:          inFOV,
+		clientDeadline: s.segmentDeadline,
+	}
+	s.result.Requests++
+	s.inFlight++
+	s.clock.After(s.delay, func() { s.onServerReceive(r, timeout) })
+	s.clock.At(r.clientDeadline, func() { s.onClientTimeout(r) })
+}
+
+func (s *simulation) randomPriority() model.Priority {
+	x := s.rng.Float64()
+	switch {
+	case x < s.highRatio:
+		return model.HIGH_PRIORITY
+	case x < s.highRatio+s.mediumRatio:
+		return model.MEDIUM_PRIORITY
+	default:
+		return model.LOW_PRIORITY
+	}
+}
+
+func (s *simulation) onClientTimeout(r *request) {
+	if r.answered {
+		return
+	}
+	r.answered = true
+	s.finishRequest()
+}
+
+func (s *simulation) onClientResponse(r *request) {
+	if r.answered {
+		return
+	}
+	r.answered = true
+	if !s.clock.Now().After(r.clientDeadline) {
+		s.result.OnTime++
+		if r.inFOV {
+			s.result.FOVOnTime++
+		}
+	}
+	s.finishRequest()
+}
+
+func (s *simulation) finishRequest() {
+	s.inFlight--
+	s.lastResponse = s.clock.Now()
+	s.issue()
+}
+
+// ----------------------------- Server -------------------------------------
+
+func (s *simulation) onServerReceive(r *request, timeout time.Duration) {
+	now := s.clock.Now()
+	r.enqueuedAt = now
+	r.serverDeadline = now.Add(timeout)
+	r.size = s.tileSize(r.segment, r.tile)
+
+	s.queue.Push(r.priority, r, now)
+	s.acct.Enqueue(now, r.priority)
+	s.counts.Enqueued[r.priority]++
+	if !s.busy {
+		s.serveNext()
+	}
+	s.sampleQueue()
+}
+
+// Usable bandwidth in bytes per second at a virtual time. With a trace, a
+// tile is served entirely at the bandwidth of the moment its service starts.
+func (s *simulation) rate(now time.Time) float64 {
+	if s.trace == nil {
+		return s.bytesPerSec
+	}
+	step, _ := s.trace.Position(now.Sub(Epoch))
+	return step.Mbps * 1e6 / 8 * s.lossFactor
+}
+
+// Windows of the weighted fairness, as the server's fairness.csv.
+const fairnessInterval = time.Second
+
+// Closes the fairness windows that ended by now.
+func (s *simulation) fairnessAt(now time.Time) {
+	for !now.Before(s.fairnessEnd) {
+		s.fairness.Window()
+		s.fairnessEnd = s.fairnessEnd.Add(fairnessInterval)
+	}
+}
+
+// Tells the weighted fairness which classes have queued work.
+func (s *simulation) sampleQueue() {
+	s.fairnessAt(s.clock.Now())
+	for c := model.Priority(0); c < model.Priority(model.PRIORITY_LEVEL_COUNT); c++ {
+		s.fairness.Queue(c, s.acct.Queued(c))
+	}
+}
+
+func (s *simulation) serveNext() {
+	for {
+		r, ok := s.queue.Pop()
+		if !ok {
+			s.busy = false
+			return
+		}
+		// dequeued into service, like the server, which drops in service
+		now := s.clock.Now()
+		s.acct.Dequeue(now, r.priority, nil)
+		s.sampleQueue()
+
+		// Deadline passed before service: dropped, nothing sent
+		if now.After(r.serverDeadline) {
+			s.acct.Finish(now)
+			s.counts.DroppedDeadline[r.priority]++
+			s.counts.StaleBytes += int64(r.size)
+			s.result.ServerDrops++
+			s.logRequest(r, now, now, 0, model.EXPIRED_BEFORE_SERVICE_OUTCOME)
+			continue
+		}
+		latency := &s.counts.Latency[r.priority]
+		latency.QueueDelay.Record(now.Sub(r.enqueuedAt))
+		latency.Slack.Record(r.serverDeadline.Sub(now))
+
+		// Missing file: a failure, neither a drop nor a completion
+		if r.size == 0 {
+			s.acct.Finish(now)
+			s.logRequest(r, now, now, 0, model.NOT_FOUND_OUTCOME)
+			continue
+		}
+
+		s.busy = true
+		service := time.Duration(float64(r.size) / s.rate(now) * float64(time.Second))
+		s.clock.After(service, func() {
+			end := s.clock.Now()
+			latency.ServiceTime.Record(end.Sub(now))
+			latency.ResponseTime.Record(end.Sub(r.enqueuedAt))
+			s.counts.BytesSent[r.priority] += int64(r.size)
+			s.fairnessAt(end)
+			s.fairness.Sent(r.priority, r.size)
+			s.result.BytesSent += int64(r.size)
+			s.logRequest(r, now, end, r.size, model.DeliveryOutcome(end, r.serverDeadline))
+			s.acct.Finish(end)
+			s.clock.After(s.delay, func() { s.onClientResponse(r) })
+			s.serveNext()
+		})
+		return
+	}
+}
+
+// ----------------------------- Outputs ------------------------------------
+
+// Same row as the reqlog of stream_handler, also counting the outcome for
+// the summary.
+func (s *simulation) logRequest(r *request, startedAt, end time.Time, bytes int, outcome model.Outcome) {
+	s.counts.Outcomes[outcome]++
+	if s.reqlog == nil {
+		return
+	}
+	drop := bytes <= 0 && end.After(r.serverDeadline)
+	event := "complete"
+	if drop {
+		event = "drop"
+	} else if bytes <= 0 {
+		event = "fail"
+	}
+	onTime := bytes > 0 && !end.After(r.serverDeadline)
+	s.reqlog.write([]string{
+		strconv.FormatInt(end.UnixNano(), 10),
+		event,
+		strconv.Itoa(int(r.priority)),
+		strconv.Itoa(r.segment),
+		strconv.Itoa(r.tile),
+		strconv.Itoa(bytes),
+		strconv.FormatBool(onTime),
+		strconv.FormatBool(drop),
+		strconv.FormatInt(startedAt.Sub(r.enqueuedAt).Milliseconds(), 10),
+		strconv.FormatInt(end.Sub(startedAt).Milliseconds(), 10),
+		strconv.FormatInt(end.Sub(r.enqueuedAt).Milliseconds(), 10),
+		"", // no fault injection
+		"", // nor feasibility predictions
+		"",
+		"", // nor request IDs
+		outcome.String(),
+	})
+}
+
+func (s *simulation) writeSummary() {
+	s.counts.End = s.clock.Now()
+	backlog := s.acct.Total(s.counts.End)
+	s.counts.QueuePositive, s.counts.IdleWhileQueuePositive = backlog.Backlog(), backlog.Idle
+	s.counts.Inversions = s.acct.Inversions()
+	s.fairnessAt(s.counts.End)
+	s.fairness.Window() // the last, partial window
+	s.counts.BackloggedWindows, s.counts.WeightedJain, s.counts.MinMaxRatio = s.fairness.Totals()
+	if s.summary != nil {
+		s.summary.write(metrics.SummaryRow(s.counts))
+	}
+}
+
+func (s *simulation) closeOutputs() {
+	s.reqlog.close()
+	s.summary.close()
+}
+
+// Reads tile sizes from the segment files, with a cache.
+func segmentFileSize(dir string) func(segment, tile int) int {
+	cache := map[[2]int]int{}
+	return func(segment, tile int) int {
+		key := [2]int{segment, tile}
+		if size, ok := cache[key]; ok {
+			return size
+		}
+		size := 0
+		path := filepath.Join(dir, fmt.Sprintf("video_tiled_10_dash_track%d_%d.m4s", tile, segment))
+		if st, err := os.Stat(path); err == nil {
+			size = int(st.Size())
+		}
+		cache[key] = size
+		return size
+	}
+}
@@ -0,0 +1,119 @@
+package sim_test
+
+import (
+	"encoding/csv"
+	"main/src/config"
+	"main/src/server/metrics"
+	"main/src/server/stream_handler"
+	"main/src/sim"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests if the clock runs events in time order, then in scheduling order.
+func TestClock_Order(t *testing.T) {
+	clock := sim.NewClock(sim.Epoch)
+	var order []int
+	clock.After(2*time.Second, func() { order = append(order, 3) })
+	clock.After(time.Second, func() {
+		order = append(order, 1)
+		clock.After(0, func() { order = append(order, 2) })
+	})
+	clock.Run()
+
+	assert.Equal(t, []int{1, 2, 3}, order)
+	assert.Equal(t, sim.Epoch.Add(2*time.Second), clock.Now())
+}
+
+// Small overloaded scenario: 20 tiles of 50 kB per segment on 4 Mbps, with a
+// 30% high priority share.
+func testConfig(policy string) config.Config {
+	cfg := config.Default()
+	cfg.Server.Policy = policy
+	cfg.Client.TotalTimeSegments = 10
+	cfg.Client.FirstTile = 1
+	cfg.Client.LastTile = 20
+	cfg.Client.Parallelism = 20
+	cfg.Client.FOVTracePath = "/nonexistent"
+	cfg.Client.HighPriorityRatio = 0.3
+	cfg.Network.BandwidthMbps = 4
+	cfg.Network.DelayMs = 10
+	cfg.Network.Seed = 1
+	return cfg
+}
+
+func fixedSize(segment, tile int) int { return 50000 }
+
+// Tests if strict priority serves more high priority tiles on time than FIFO.
+func TestRun_StrictPriorityFavoursHigh(t *testing.T) {
+	highOnTime := map[string]int{}
+	for _, policy := range []string{"fifo", "sp", "wfq"} {
+		dir := t.TempDir()
+		result, err := sim.Run(sim.Options{Config: testConfig(policy), TileSize: fixedSize, OutputDir: dir})
+		assert.Nil(t, err)
+		assert.Equal(t, 200, result.Requests+result.Skipped)
+		assert.True(t, result.OnTime < result.Requests, "%s should be overloaded", policy)
+
+		rows := readCSV(t, filepath.Join(dir, "reqlog.csv"))
+		assert.Equal(t, stream_handler.ReqlogHeader, rows[0])
+		outcomes := map[string]int{}
+		for _, row := range rows[1:] {
+			if row[2] == "0" && row[6] == "true" {
+				highOnTime[policy]++
+			}
+			// the ontime column and the outcome agree
+			assert.Equal(t, row[6] == "true", row[15] == "on_time")
+			outcomes[row[15]]++
+		}
+
+		summary := readCSV(t, filepath.Join(dir, "server_summary.csv"))
+		assert.Equal(t, metrics.SummaryHeader, summary[0])
+		assert.Equal(t, 2, len(summary))
+		counted := 0
+		for i, name := range summary[0] {
+			if reason := strings.TrimPrefix(name, "outcome_"); reason != name {
+				assert.Equal(t, strconv.Itoa(outcomes[reason]), summary[1][i], name)
+				counted += outcomes[reason]
+			}
+		}
+		assert.Equal(t, len(rows)-1, counted)
+	}
+	assert.True(t, highOnTime["sp"] > highOnTime["fifo"], "%v", highOnTime)
+	assert.True(t, highOnTime["wfq"] > highOnTime["fifo"], "%v", highOnTime)
+}
+
+// Tests if two runs with the same configuration give the same result.
+func TestRun_Deterministic(t *testing.T) {
+	a, err := sim.Run(sim.Options{Config: testConfig("wfq"), TileSize: fixedSize})
+	assert.Nil(t, err)
+	b, err := sim.Run(sim.Options{Config: testConfig("wfq"), TileSize: fixedSize})
+	assert.Nil(t, err)
+	assert.Equal(t, a, b)
+}
+
+// Tests if an unloaded link delivers every tile on time.
+func TestRun_Unloaded(t *testing.T) {
+	cfg := testConfig("fifo")
+	cfg.Network.BandwidthMbps = 1000
+	result, err := sim.Run(sim.Options{Config: cfg, TileSize: fixedSize})
+	assert.Nil(t, err)
+	assert.Equal(t, 200, result.Requests)
+	assert.Equal(t, 200, result.OnTime)
+	assert.Equal(t, 100.0, result.OnTimeRate())
+	assert.Equal(t, -1.0, result.FOVOnTimeRate())
+}
+
+func readCSV(t *testing.T, path string) [][]string {
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	assert.Nil(t, err)
+	return rows
+}
@@ -0,0 +1,59 @@
+package test_client
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"log"
+	"main/src/netem"
+	"os"
+	"sync"
+	"time"
+)
+
+// Records the position of the bandwidth trace played by the link, with the
+// same time base as statistics-*.csv.
+type BandwidthTraceLogger struct {
+	fileWriter *bufio.Writer
+	mutex      sync.Mutex
+	file       fs.File
+	startTime  time.Time
+}
+
+func NewBandwidthTraceLogger(path string, startTime time.Time) *BandwidthTraceLogger {
+	const header string = "time_ns,trace_time_s,sample,mbps\n"
+
+	file, err := os.Create(path)
+	if err != nil {
+		log.Panicf("Failed to open %s: %s\n", path, err)
+	}
+	fileWriter := bufio.NewWriter(file)
+
+	if _, err := fileWriter.WriteString(header); err != nil {
+		log.Panicf("Failed to write to %s: %s\n", path, err)
+	}
+
+	return &BandwidthTraceLogger{
+		fileWriter: fileWriter,
+		file:       file,
+		startTime:  startTime,
+	}
+}
+
+func (b *BandwidthTraceLogger) Log(step netem.TraceStep) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	row := fmt.Sprintf("%d,%.3f,%d,%.3f\n", time.Since(b.startTime).Nanoseconds(),
+		step.TraceTime.Seconds(), step.Sample, step.Mbps)
+	if _, err := b.fileWriter.WriteString(row); err != nil {
+		log.Panicf("Failed to write: %s\n", err)
+	}
+}
+
+func (b *BandwidthTraceLogger) Close() {
+	b.mutex.Lock()
+	b.fileWriter.Flush()
+	b.file.Close()
+	b.mutex.Unlock()
+}
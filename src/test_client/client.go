@@ -8,10 +8,13 @@ import (
 	"io"
 	"log"
 	"main/src/model"
+	"main/src/netem"
+	"net"
 	"sync"
 	"time"
 
 	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/logging"
 )
 
 type ClientOptions struct {
@@ -24,6 +27,16 @@ type ClientOptions struct {
 
 	// Port of the server
 	ServerPort int
+
+	// Classes whose responses are requested as unreliable QUIC datagrams
+	// instead of on the request stream.
+	DatagramClasses map[model.Priority]bool
+
+	// Emulated link applied to the packets sent by the client (optional)
+	Link *netem.Link
+
+	// Tracer of the connection, e.g. a tracing.QlogTracer (optional)
+	Tracer logging.Tracer
 }
 
 type requestId struct {
@@ -39,6 +52,28 @@ type Client struct {
 	waitingResponses      map[requestId]chan *model.VideoPacketResponse
 	waitingResponsesMutex sync.Mutex
 	replayBuffer          *ReplayBuffer // Adicionado o replay buffer aqui
+
+	// Responses being reassembled from datagram fragments.
+	// Guarded by waitingResponsesMutex.
+	assemblies    map[requestId]*model.DatagramAssembly
+	datagramStats DatagramStats
+
+	// Socket opened by the client itself (emulated link only)
+	packetConn net.PacketConn
+}
+
+// DatagramStats counts tiles requested with datagram delivery.
+type DatagramStats struct {
+	// Tiles requested as datagrams
+	Requested uint64
+	// Tiles for which every fragment arrived
+	Completed uint64
+	// Tiles for which at least one fragment arrived, but not all of them
+	Incomplete uint64
+	// Tiles for which no fragment arrived
+	Missing uint64
+	// Fragments received
+	Fragments uint64
 }
 
 type ReplayBuffer struct {
@@ -51,6 +86,7 @@ func NewClient(options ClientOptions) *Client {
 		Options:          options,
 		waitingResponses: make(map[requestId]chan *model.VideoPacketResponse),
 		replayBuffer:     NewReplayBuffer(), // Inicializa o replay buffer
+		assemblies:       make(map[requestId]*model.DatagramAssembly),
 	}
 }
 
@@ -86,11 +122,13 @@ func (c *Client) Connect() (err error) {
 		HandshakeIdleTimeout:  100 * time.Second, // Set the receive connection flow control window size to 20 MB
 		MaxIncomingStreams:    20000,             // Set the maximum number of incoming streams
 		MaxIncomingUniStreams: 20000,             // Set the maximum number of incoming unidirectional streams
+		EnableDatagrams:       len(c.Options.DatagramClasses) > 0,
+		Tracer:                c.Options.Tracer,
 	}
 
 	// Create new QUIC connection
 	log.Println("Connecting...")
-	c.connection, err = quic.DialAddr(url, tlsConf, config)
+	c.connection, err = c.dial(url, tlsConf, config)
 	if err != nil {
 		log.Println(err)
 		return
@@ -98,6 +136,15 @@ func (c *Client) Connect() (err error) {
 
 	log.Println("Connected")
 
+	if config.EnableDatagrams {
+		if c.connection.ConnectionState().SupportsDatagrams {
+			go c.receiveDatagrams()
+		} else {
+			log.Println("Server does not support datagrams, using streams for every class")
+			c.Options.DatagramClasses = nil
+		}
+	}
+
 	if c.Options.Pipeline {
 		c.pipelineStream, err = c.openStream()
 		if err != nil {
@@ -108,8 +155,42 @@ func (c *Client) Connect() (err error) {
 	return
 }
 
-// Send a request
-func (c *Client) Request(r model.VideoPacketRequest, timeout time.Duration) *model.VideoPacketResponse {
+// Dial the server, through the emulated link if configured.
+func (c *Client) dial(url string, tlsConf *tls.Config, config *quic.Config) (quic.Connection, error) {
+	if c.Options.Link == nil {
+		return quic.DialAddr(url, tlsConf, config)
+	}
+
+	remoteAddr, err := net.ResolveUDPAddr("udp", url)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		return nil, err
+	}
+	c.packetConn = udpConn
+	log.Printf("Emulating link: %+v", c.Options.Link.Config())
+	return quic.Dial(c.Options.Link.Wrap(udpConn), remoteAddr, url, tlsConf, config)
+}
+
+// Close the connection.
+func (c *Client) Close() {
+	if c.connection != nil {
+		_ = c.connection.CloseWithError(0, "client finished")
+	}
+	if c.packetConn != nil {
+		_ = c.packetConn.Close()
+	}
+}
+
+// Send a request. Without a response, the outcome tells whether the client
+// timed out or the connection failed; a response arrived within the timeout
+// is on time.
+func (c *Client) Request(r model.VideoPacketRequest, timeout time.Duration) (*model.VideoPacketResponse, model.Outcome) {
+	if c.Options.DatagramClasses[r.Priority] {
+		r.Delivery = model.DATAGRAM_DELIVERY
+	}
 
 	if c.pipelineStream != nil {
 		return c.requestWithStream(c.pipelineStream, r, timeout)
@@ -118,7 +199,7 @@ func (c *Client) Request(r model.VideoPacketRequest, timeout time.Duration) *mod
 		stream, err := c.openStream()
 		if err != nil {
 			log.Println("Open stream failed: ", err)
-			return nil
+			return nil, model.TRANSPORT_ERROR_OUTCOME
 		}
 		defer stream.Close()
 
@@ -129,7 +210,7 @@ func (c *Client) Request(r model.VideoPacketRequest, timeout time.Duration) *mod
 // Send a request with a stream
 func (c *Client) requestWithStream(stream quic.Stream,
 	r model.VideoPacketRequest,
-	timeout time.Duration) *model.VideoPacketResponse {
+	timeout time.Duration) (*model.VideoPacketResponse, model.Outcome) {
 	// Register request id
 
 	id := requestId{
@@ -139,13 +220,25 @@ func (c *Client) requestWithStream(stream quic.Stream,
 	responseChannel := make(chan *model.VideoPacketResponse, 1)
 	c.waitingResponsesMutex.Lock()
 	c.waitingResponses[id] = responseChannel
+	if r.Delivery == model.DATAGRAM_DELIVERY {
+		c.datagramStats.Requested++
+	}
 	c.waitingResponsesMutex.Unlock()
 
 	defer func() {
 		c.waitingResponsesMutex.Lock()
 		if ch, ok := c.waitingResponses[id]; ok && ch == responseChannel {
 			delete(c.waitingResponses, id)
+			if r.Delivery == model.DATAGRAM_DELIVERY {
+				// Timed out: a tile with any fragment missing is lost
+				if _, partial := c.assemblies[id]; partial {
+					c.datagramStats.Incomplete++
+				} else {
+					c.datagramStats.Missing++
+				}
+			}
 		}
+		delete(c.assemblies, id)
 		c.waitingResponsesMutex.Unlock()
 	}()
 
@@ -154,7 +247,7 @@ func (c *Client) requestWithStream(stream quic.Stream,
 	if err := r.Write(stream); err != nil {
 		delete(c.waitingResponses, id)
 		log.Println("Write failed: ", err)
-		return nil
+		return nil, model.TRANSPORT_ERROR_OUTCOME
 	}
 
 	// Response
@@ -165,9 +258,71 @@ func (c *Client) requestWithStream(stream quic.Stream,
 		if res != nil {
 			c.replayBuffer.AddResponse(res)
 		}
-		return res
+		return res, model.ON_TIME_OUTCOME
 	case <-time.After(timeout):
-		return nil
+		if c.connection.Context().Err() != nil {
+			return nil, model.TRANSPORT_ERROR_OUTCOME
+		}
+		return nil, model.CLIENT_TIMEOUT_OUTCOME
+	}
+}
+
+// DatagramStats returns a snapshot of the datagram delivery counters.
+func (c *Client) DatagramStats() DatagramStats {
+	c.waitingResponsesMutex.Lock()
+	defer c.waitingResponsesMutex.Unlock()
+	return c.datagramStats
+}
+
+// Receives datagram fragments, reassembles them and hands complete responses
+// to the waiting requests. Fragments for requests no longer waiting are
+// dropped.
+func (c *Client) receiveDatagrams() {
+	for {
+		message, err := c.connection.ReceiveMessage()
+		if err != nil {
+			if c.connection.Context().Err() == nil {
+				log.Println("Receive datagram failed: ", err)
+			}
+			return
+		}
+		fragment, err := model.ParseDatagramFragment(message)
+		if err != nil {
+			log.Println("Invalid datagram: ", err)
+			continue
+		}
+
+		id := requestId{
+			segment: fragment.Segment,
+			tile:    fragment.Tile,
+		}
+
+		c.waitingResponsesMutex.Lock()
+		responseChannel, ok := c.waitingResponses[id]
+		if !ok {
+			c.waitingResponsesMutex.Unlock()
+			continue
+		}
+		c.datagramStats.Fragments++
+		assembly, exists := c.assemblies[id]
+		if !exists {
+			assembly = model.NewDatagramAssembly(fragment)
+			c.assemblies[id] = assembly
+		} else {
+			assembly.Add(fragment)
+		}
+		var res *model.VideoPacketResponse
+		if assembly.Complete() {
+			res = assembly.Response()
+			delete(c.assemblies, id)
+			delete(c.waitingResponses, id)
+			c.datagramStats.Completed++
+		}
+		c.waitingResponsesMutex.Unlock()
+
+		if res != nil {
+			responseChannel <- res
+		}
 	}
 }
 
@@ -16,9 +16,11 @@ type PlaybackSimulator struct {
 	baseLatency     time.Duration
 	firstSegment    int
 	lastSegment     int
+
+	maxBufferedSegmentsAhead int
 }
 
-const maxBufferedSegmentsAhead = 3
+const defaultMaxBufferedSegmentsAhead = 3
 
 func NewPlaybackSimulator(
 	segmentDuration time.Duration,
@@ -38,6 +40,16 @@ func NewPlaybackSimulator(
 		baseLatency:     baseLatency,
 		firstSegment:    firstSegment,
 		lastSegment:     lastSegment,
+
+		maxBufferedSegmentsAhead: defaultMaxBufferedSegmentsAhead,
+	}
+}
+
+// Changes how many segments can be downloaded ahead of the playback. Must be
+// called before Start.
+func (p *PlaybackSimulator) SetMaxBufferedSegmentsAhead(segments int) {
+	if segments > 0 {
+		p.maxBufferedSegmentsAhead = segments
 	}
 }
 
@@ -79,7 +91,7 @@ func (p *PlaybackSimulator) WaitForPlaybackStart(segment int) {
 // ultrapassar a janela de segurança definida em maxBufferedSegmentsAhead.
 func (p *PlaybackSimulator) WaitUntilWithinPrefetchWindow(segment int) {
 	p.mutex.Lock()
-	for (segment - p.currentPlaybackSegment) > maxBufferedSegmentsAhead {
+	for (segment - p.currentPlaybackSegment) > p.maxBufferedSegmentsAhead {
 		p.cond.Wait()
 	}
 	p.mutex.Unlock()
@@ -125,7 +137,7 @@ func (p *PlaybackSimulator) GetBufferLevel(lastDownloadedSegment int) time.Durat
 	}
 
 	bufferLevel := bufferEndTime.Sub(playbackTime)
-	maxBuffer := time.Duration(maxBufferedSegmentsAhead) * p.segmentDuration
+	maxBuffer := time.Duration(p.maxBufferedSegmentsAhead) * p.segmentDuration
 	if bufferLevel > maxBuffer {
 		return maxBuffer
 	}
@@ -0,0 +1,116 @@
+package test_client
+
+import (
+	"fmt"
+	"log"
+	"main/src/model"
+	"main/src/netem"
+	"main/src/workload"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RunReplay re-sends the requests of a trace (see workload.Recorder) with
+// their original timing and timeouts, regardless of the responses: no ABR,
+// no playback gating and no parallelism limit. Results go to
+// statistics-replay-<RunID>.csv, with the columns of statistics-*.csv.
+func RunReplay(opts TestClientOptions, tracePath string) error {
+	records, err := workload.Load(tracePath)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("%s: empty trace", tracePath)
+	}
+
+	runID := opts.RunID
+	if runID == "" {
+		runID = strconv.Itoa(os.Getpid())
+	}
+	if opts.OutputDir != "" {
+		if err := os.MkdirAll(opts.OutputDir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	datagramClasses, err := model.ParseClassList(opts.DatagramClasses)
+	if err != nil {
+		return fmt.Errorf("datagram classes: %w", err)
+	}
+	linkConfig, err := opts.Network.LinkConfig()
+	if err != nil {
+		return fmt.Errorf("link emulation: %w", err)
+	}
+	var link *netem.Link
+	if linkConfig.Enabled() {
+		link = netem.NewLink(linkConfig)
+		defer link.Close()
+	}
+
+	client := NewClient(ClientOptions{
+		Pipeline:        opts.Pipeline,
+		ServerURL:       opts.ServerURL,
+		ServerPort:      opts.ServerPort,
+		DatagramClasses: datagramClasses,
+		Link:            link,
+		Tracer:          QlogTracer(opts.ClientConfig, "replay-"+runID),
+	})
+	if err := client.Connect(); err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer client.Close()
+
+	statisticsLogger := NewStatisticsLogger(filepath.Join(opts.OutputDir, fmt.Sprintf("statistics-replay-%s.csv", runID)))
+	defer statisticsLogger.Close()
+
+	log.Printf("Replaying %d requests from %s (%.1f s)", len(records), tracePath, records[len(records)-1].TimeMs/1000)
+
+	var mutex sync.Mutex
+	var sent, onTime [model.PRIORITY_LEVEL_COUNT]int
+
+	var wg sync.WaitGroup
+	startTime := time.Now()
+	statisticsLogger.SetStartTime(startTime)
+	for _, record := range records {
+		time.Sleep(time.Until(startTime.Add(record.At())))
+
+		wg.Add(1)
+		go func(record workload.Record) {
+			defer wg.Done()
+			request := record.Request()
+			timeout := time.Duration(request.Timeout) * time.Millisecond
+			if timeout <= 0 {
+				timeout = time.Millisecond
+			}
+
+			requestTime := time.Since(startTime)
+			response, outcome := client.Request(request, timeout)
+			responseTime := time.Since(startTime)
+			ok := response != nil
+
+			mutex.Lock()
+			sent[request.Priority]++
+			if ok {
+				onTime[request.Priority]++
+			}
+			mutex.Unlock()
+
+			statisticsLogger.Log(requestTime, request, responseTime-requestTime,
+				!ok, false, ok, 0.0, 0.0, -1.0, record.InFOV, ok, outcome)
+		}(record)
+	}
+	wg.Wait()
+
+	for class := 0; class < model.PRIORITY_LEVEL_COUNT; class++ {
+		if sent[class] == 0 {
+			continue
+		}
+		log.Printf("Replay class %d: sent=%d on_time=%d (%.2f%%)", class, sent[class], onTime[class],
+			100*float64(onTime[class])/float64(sent[class]))
+	}
+	log.Printf("Replay finished in %v", time.Since(startTime).Round(time.Millisecond))
+	return nil
+}
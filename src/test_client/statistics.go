@@ -9,16 +9,19 @@ import (
 	"os"
 	"sync"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 type StatisticsLogger struct {
 	fileWriter *bufio.Writer
 	mutex      sync.Mutex
 	file       fs.File
+	startTime  time.Time
 }
 
 func NewStatisticsLogger(path string) *StatisticsLogger {
-	const header string = "time_ns,segment,tile,priority,latency_ns,timedout,skipped,ok,tp,buffer_s,tile_missing_ratio,in_fov,on_time\n"
+	const header string = "time_ns,segment,tile,priority,latency_ns,timedout,skipped,ok,tp,buffer_s,tile_missing_ratio,in_fov,on_time,delivery,request_id,sent_unix_ns,outcome\n"
 
 	file, err := os.Create(path)
 	if err != nil {
@@ -37,13 +40,31 @@ func NewStatisticsLogger(path string) *StatisticsLogger {
 	return s
 }
 
+// SetStartTime sets the time timeFromStart counts from, which gives the
+// absolute send time (sent_unix_ns) compared with the server reqlog.
+func (s *StatisticsLogger) SetStartTime(startTime time.Time) {
+	s.mutex.Lock()
+	s.startTime = startTime
+	s.mutex.Unlock()
+}
+
 func (s *StatisticsLogger) Log(timeFromStart time.Duration,
 	r model.VideoPacketRequest, latency time.Duration, timedOut bool,
-	skipped bool, ok bool, tp float64, bufferSec float64, tileMissingRatio float64, inFOV bool, onTime bool) {
+	skipped bool, ok bool, tp float64, bufferSec float64, tileMissingRatio float64, inFOV bool, onTime bool,
+	outcome model.Outcome) {
 	s.mutex.Lock()
 
-	row := fmt.Sprintf("%d,%d,%d,%d,%d,%t,%t,%t,%f,%.2f,%.2f,%t,%t\n", timeFromStart.Nanoseconds(),
-		r.Segment, r.Tile, r.Priority, latency.Nanoseconds(), timedOut, skipped, ok, tp, bufferSec, tileMissingRatio, inFOV, onTime)
+	requestID := ""
+	if r.ID != uuid.Nil {
+		requestID = r.ID.String()
+	}
+	var sentUnixNs int64
+	if !s.startTime.IsZero() {
+		sentUnixNs = s.startTime.Add(timeFromStart).UnixNano()
+	}
+	row := fmt.Sprintf("%d,%d,%d,%d,%d,%t,%t,%t,%f,%.2f,%.2f,%t,%t,%s,%s,%d,%s\n", timeFromStart.Nanoseconds(),
+		r.Segment, r.Tile, r.Priority, latency.Nanoseconds(), timedOut, skipped, ok, tp, bufferSec, tileMissingRatio, inFOV, onTime, r.Delivery,
+		requestID, sentUnixNs, outcome)
 
 	if _, err := s.fileWriter.WriteString(row); err != nil {
 		log.Panicf("Failed to write: %s\n", err)
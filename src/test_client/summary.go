@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io/fs"
 	"log"
+	"main/src/model"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -19,7 +21,11 @@ type SummaryLogger struct {
 }
 
 func NewSummaryLogger(path string) *SummaryLogger {
-	const header string = "join_latency_ms,segment_completion_rate_percent,segment_completion_rate_fov_percent,stale_bytes_ratio_percent,deadline_miss_rate_fov_percent,deadline_miss_rate_nonfov_percent,fov_hit_rate_delivery_percent,useful_goodput_fov_kbps\n"
+	header := "join_latency_ms,segment_completion_rate_percent,segment_completion_rate_fov_percent,stale_bytes_ratio_percent,deadline_miss_rate_fov_percent,deadline_miss_rate_nonfov_percent,fov_hit_rate_delivery_percent,useful_goodput_fov_kbps,datagram_tile_loss_rate_percent"
+	for _, o := range model.ClientOutcomes {
+		header += ",outcome_" + o.String()
+	}
+	header += "\n"
 
 	file, err := os.Create(path)
 	if err != nil {
@@ -38,22 +44,30 @@ func NewSummaryLogger(path string) *SummaryLogger {
 }
 
 // LogSession grava uma linha com Join latency, Segment completion rate (%) e Stale bytes ratio (%).
-func (s *SummaryLogger) LogSession(joinLatency time.Duration, segmentCompletionRatePercent float64, fovCompletionRatePercent float64, staleBytesRatioPercent float64, deadlineMissRateFOV float64, deadlineMissRateNonFOV float64, fovHitRate float64, usefulGoodputKbps float64) {
+// datagramTileLossRate é -1 quando nenhuma classe usa entrega via datagram.
+// outcomes conta as requisições por motivo (colunas outcome_*).
+func (s *SummaryLogger) LogSession(joinLatency time.Duration, segmentCompletionRatePercent float64, fovCompletionRatePercent float64, staleBytesRatioPercent float64, deadlineMissRateFOV float64, deadlineMissRateNonFOV float64, fovHitRate float64, usefulGoodputKbps float64, datagramTileLossRate float64, outcomes [model.OUTCOME_COUNT]uint64) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	row := fmt.Sprintf("%d,%.2f,%.2f,%.2f,%.2f,%.2f,%.2f,%.2f\n", joinLatency.Milliseconds(), segmentCompletionRatePercent, fovCompletionRatePercent, staleBytesRatioPercent, deadlineMissRateFOV, deadlineMissRateNonFOV, fovHitRate, usefulGoodputKbps)
+	counts := make([]string, 0, len(model.ClientOutcomes))
+	for _, o := range model.ClientOutcomes {
+		counts = append(counts, fmt.Sprintf("%d", outcomes[o]))
+	}
+	row := fmt.Sprintf("%d,%.2f,%.2f,%.2f,%.2f,%.2f,%.2f,%.2f,%.2f,%s\n", joinLatency.Milliseconds(), segmentCompletionRatePercent, fovCompletionRatePercent, staleBytesRatioPercent, deadlineMissRateFOV, deadlineMissRateNonFOV, fovHitRate, usefulGoodputKbps, datagramTileLossRate, strings.Join(counts, ","))
 	if _, err := s.fileWriter.WriteString(row); err != nil {
 		log.Panicf("Failed to write: %s\n", err)
 	}
 }
 
-// LogJoinLatency � mantido por compatibilidade; escreve as taxas como -1.00.
+// LogJoinLatency � mantido por compatibilidade; escreve as taxas como -1.00
+// e as contagens por motivo como -1.
 func (s *SummaryLogger) LogJoinLatency(d time.Duration) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	row := fmt.Sprintf("%d,%.2f,%.2f,%.2f,%.2f,%.2f,%.2f,%.2f\n", d.Milliseconds(), -1.0, -1.0, -1.0, -1.0, -1.0, -1.0, -1.0)
+	row := fmt.Sprintf("%d,%.2f,%.2f,%.2f,%.2f,%.2f,%.2f,%.2f,%.2f%s\n", d.Milliseconds(), -1.0, -1.0, -1.0, -1.0, -1.0, -1.0, -1.0, -1.0,
+		strings.Repeat(",-1", len(model.ClientOutcomes)))
 	if _, err := s.fileWriter.WriteString(row); err != nil {
 		log.Panicf("Failed to write: %s\n", err)
 	}
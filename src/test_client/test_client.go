@@ -6,9 +6,15 @@ import (
 	"bufio"
 	"fmt"
 	"log"
+	"main/src/config"
 	"main/src/model"
+	"main/src/netem"
 	"main/src/test_client/netstats"
+	"main/src/tracing"
+	"main/src/workload"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"sync"
@@ -16,21 +22,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-)
-
-// If pipeline = true, use the same stream for all requests.
-// If pipeline = false, use one stream for each request.
-const pipeline = false
-
-// Proportion of medium priority
-const mediumPriorityRatio = 0.0
-
-// Proportion of high priority
-const highPriorityRatio = 0.3
-
-const (
-	defaultFOVTracePath = "data/user_fov.csv"
-	defaultFOVTraceFPS  = 30
+	"github.com/lucas-clemente/quic-go/logging"
 )
 
 // Aggregator for Segment Completion Rate (ALL tiles requested)
@@ -135,6 +127,28 @@ func (a *tileDeadlineMissAgg) Rates() (float64, float64) {
 	return fovRate, nonFOVRate
 }
 
+// Aggregator for request outcomes (model.Outcome).
+type outcomeAgg struct {
+	mutex  sync.Mutex
+	counts [model.OUTCOME_COUNT]uint64
+}
+
+func newOutcomeAgg() *outcomeAgg {
+	return &outcomeAgg{}
+}
+
+func (a *outcomeAgg) Add(outcome model.Outcome) {
+	a.mutex.Lock()
+	a.counts[outcome]++
+	a.mutex.Unlock()
+}
+
+func (a *outcomeAgg) Counts() [model.OUTCOME_COUNT]uint64 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.counts
+}
+
 // Aggregator for FoV hit rate per segment.
 type fovHitAgg struct {
 	mutex  sync.Mutex
@@ -519,35 +533,73 @@ func writeFOVGoodputSeries(path string, samples []fovGoodputSample) {
 	}
 }
 
-func StartTestClient(serverURL string, serverPort int, parallelism int, baseLatencyMs int) {
-	client := NewClient(ClientOptions{
-		Pipeline:   pipeline,
-		ServerURL:  serverURL,
-		ServerPort: serverPort,
-	})
+// Options of a test client run.
+type TestClientOptions struct {
+	config.ClientConfig
+	ServerPort int
+	// Emulated link of the client socket, if enabled.
+	Network config.NetworkConfig
+	// Suffix of the output file names (statistics-<RunID>.csv...).
+	// Defaults to the process ID.
+	RunID string
+}
 
-	log.Println("Base latency =", baseLatencyMs)
+// QlogTracer returns the tracer writing <name>-<odcid>.qlog in the output
+// directory, or nil if client.qlog is disabled.
+func QlogTracer(cfg config.ClientConfig, name string) logging.Tracer {
+	if !cfg.Qlog {
+		return nil
+	}
+	return tracing.NewQlogTracer(cfg.OutputDir, name)
+}
 
-	err := client.Connect()
+// RunTestClient connects to the server, streams the whole video and writes
+// the statistics files. It returns when every request has finished.
+func RunTestClient(opts TestClientOptions) error {
+	runID := opts.RunID
+	if runID == "" {
+		runID = strconv.Itoa(os.Getpid())
+	}
+	if opts.OutputDir != "" {
+		if err := os.MkdirAll(opts.OutputDir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	datagramClasses, err := model.ParseClassList(opts.DatagramClasses)
 	if err != nil {
-		log.Println("failed to connect")
-		return
+		return fmt.Errorf("datagram classes: %w", err)
 	}
 
-	segmentDuration := 1 * time.Second
-	fovPath := os.Getenv("FOV_TRACE_PATH")
-	if fovPath == "" {
-		fovPath = defaultFOVTracePath
+	linkConfig, err := opts.Network.LinkConfig()
+	if err != nil {
+		return fmt.Errorf("link emulation: %w", err)
+	}
+	var link *netem.Link
+	if linkConfig.Enabled() {
+		link = netem.NewLink(linkConfig)
+		defer link.Close()
 	}
 
-	fps := defaultFOVTraceFPS
-	if envFPS := os.Getenv("FOV_TRACE_FPS"); envFPS != "" {
-		if parsed, parseErr := strconv.Atoi(envFPS); parseErr == nil && parsed > 0 {
-			fps = parsed
-		} else {
-			log.Printf("Invalid FOV_TRACE_FPS=%q, falling back to default %d", envFPS, defaultFOVTraceFPS)
-		}
+	client := NewClient(ClientOptions{
+		Pipeline:        opts.Pipeline,
+		ServerURL:       opts.ServerURL,
+		ServerPort:      opts.ServerPort,
+		DatagramClasses: datagramClasses,
+		Link:            link,
+		Tracer:          QlogTracer(opts.ClientConfig, "client-"+runID),
+	})
+
+	log.Println("Base latency =", opts.BaseLatencyMs)
+
+	err = client.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
 	}
+	defer client.Close()
+
+	segmentDuration := 1 * time.Second
+	fovPath, fps := opts.FOVTracePath, opts.FOVTraceFPS
 
 	var fovTrace *FOVTrace
 	if trace, traceErr := LoadFOVTrace(fovPath, fps, segmentDuration); traceErr != nil {
@@ -557,33 +609,55 @@ func StartTestClient(serverURL string, serverPort int, parallelism int, baseLate
 		log.Printf("Loaded FOV trace: fps=%d, segments=%d", fps, fovTrace.MaxSegment())
 	}
 
-	statisticsPath := fmt.Sprintf("statistics-%d.csv", os.Getpid())
-	summaryPath := fmt.Sprintf("statistics-summary-%d.csv", os.Getpid())
-	fovDeliveryPath := fmt.Sprintf("fov-delivery-%d.csv", os.Getpid())
-	fovGoodputPath := fmt.Sprintf("fov-goodput-%d.csv", os.Getpid())
+	outputPath := func(format string) string {
+		return filepath.Join(opts.OutputDir, fmt.Sprintf(format, runID))
+	}
+	statisticsPath := outputPath("statistics-%s.csv")
+	summaryPath := outputPath("statistics-summary-%s.csv")
+	fovDeliveryPath := outputPath("fov-delivery-%s.csv")
+	fovGoodputPath := outputPath("fov-goodput-%s.csv")
+	bandwidthTracePath := ""
+	if linkConfig.BandwidthTrace != nil {
+		bandwidthTracePath = outputPath("bandwidth-%s.csv")
+	}
+
+	var recorder *workload.Recorder
+	if opts.RecordRequests {
+		recorder, err = workload.NewRecorder(outputPath("requests-%s.jsonl"))
+		if err != nil {
+			return err
+		}
+	}
 
 	statisticsLogger := NewStatisticsLogger(statisticsPath)
 	summaryLogger := NewSummaryLogger(summaryPath)
-	runTestIteration(client, parallelism, baseLatencyMs, statisticsLogger, summaryLogger, segmentDuration, fovTrace, fovDeliveryPath, fovGoodputPath)
+	runTestIteration(client, opts.ClientConfig, statisticsLogger, summaryLogger, recorder, segmentDuration, fovTrace, fovDeliveryPath, fovGoodputPath, bandwidthTracePath)
 	statisticsLogger.Close()
 	summaryLogger.Close()
+	return recorder.Close()
 }
 
-func runTestIteration(client *Client, parallelism int, baseLatencyMs int,
-	statisticsLogger *StatisticsLogger, summaryLogger *SummaryLogger, segmentDuration time.Duration, fovTrace *FOVTrace, fovDeliveryPath string, fovGoodputPath string) {
+func runTestIteration(client *Client, opts config.ClientConfig,
+	statisticsLogger *StatisticsLogger, summaryLogger *SummaryLogger, recorder *workload.Recorder, segmentDuration time.Duration, fovTrace *FOVTrace, fovDeliveryPath string, fovGoodputPath string, bandwidthTracePath string) {
 	var wg sync.WaitGroup
 
 	startTime := time.Now()
+	statisticsLogger.SetStartTime(startTime)
+
+	if bandwidthTracePath != "" && client.Options.Link != nil {
+		bandwidthLogger := NewBandwidthTraceLogger(bandwidthTracePath, startTime)
+		defer bandwidthLogger.Close()
+		defer client.Options.Link.OnTraceStep(bandwidthLogger.Log)()
+	}
 
-	baseLatency := time.Duration(baseLatencyMs) * time.Millisecond
+	baseLatency := time.Duration(opts.BaseLatencyMs) * time.Millisecond
+	parallelism := opts.Parallelism
 
-	const (
-		totalTimeSegments = 120
-		firstSegment      = 1
-		lastSegment       = totalTimeSegments
-		firstTile         = 100
-		lastTile          = 177
-	)
+	totalTimeSegments := opts.TotalTimeSegments
+	firstSegment := 1
+	lastSegment := totalTimeSegments
+	firstTile := opts.FirstTile
+	lastTile := opts.LastTile
 
 	playbackSimulator := NewPlaybackSimulator(
 		segmentDuration,
@@ -591,6 +665,7 @@ func runTestIteration(client *Client, parallelism int, baseLatencyMs int,
 		firstSegment,
 		lastSegment,
 	)
+	playbackSimulator.SetMaxBufferedSegmentsAhead(opts.MaxBufferedSegmentsAhead)
 
 	parallelismSemaphore := NewSemaphore(parallelism)
 
@@ -613,6 +688,7 @@ func runTestIteration(client *Client, parallelism int, baseLatencyMs int,
 	deadlineAgg := newTileDeadlineMissAgg()
 	fovHit := newFovHitAgg()
 	fovGoodput := newFovGoodputAgg(segmentDuration)
+	outcomes := newOutcomeAgg()
 
 	lastFOVSegment := 0
 	if fovTrace != nil {
@@ -639,15 +715,7 @@ func runTestIteration(client *Client, parallelism int, baseLatencyMs int,
 		log.Printf("ABR: Average Throughput = %.2f, Buffer Level = %.2f s, Selected Bitrate = %d", avgThroughput, bufferLevel.Seconds(), currentBitrate)
 
 		playbackSimulator.WaitUntilWithinPrefetchWindow(segmentID)
-		timeBudget := playbackSimulator.GetTimeToReceive(segmentID)
-		if timeBudget <= 0 {
-			timeBudget = segmentDuration
-		}
-		maxAhead := 3 * segmentDuration
-		if timeBudget > maxAhead {
-			timeBudget = maxAhead
-		}
-		timeBudget += segmentDuration
+		timeBudget := SegmentTimeBudget(playbackSimulator.GetTimeToReceive(segmentID), segmentDuration, opts.MaxBufferedSegmentsAhead)
 		segmentDeadline := time.Now().Add(timeBudget)
 
 		agg.SetRequired(segmentID, tileUniverse)
@@ -665,6 +733,12 @@ func runTestIteration(client *Client, parallelism int, baseLatencyMs int,
 			if inFOV {
 				priority = model.HIGH_PRIORITY
 				requestBitrate = currentBitrate
+			} else if fovTrace == nil {
+				// Without a FoV trace, draw the priority from the configured ratios
+				priority = randomPriority(opts.HighPriorityRatio, opts.MediumPriorityRatio)
+				if priority == model.HIGH_PRIORITY {
+					requestBitrate = currentBitrate
+				}
 			}
 
 			parallelismSemaphore.Acquire()
@@ -688,16 +762,18 @@ func runTestIteration(client *Client, parallelism int, baseLatencyMs int,
 					}
 					deadlineAgg.Add(inFOV, true)
 					fovHit.Add(segmentID, inFOV, false)
+					// not sent: the deadline passed while waiting for a slot
+					outcomes.Add(model.CANCELLED_OUTCOME)
 					if statisticsLogger != nil {
 						bufferSec := playbackSimulator.GetBufferLevel(int(lastDownloadedSegment.Load())).Seconds()
 						statisticsLogger.Log(time.Since(startTime), model.VideoPacketRequest{
 							ID:       uuid.Nil,
 							Priority: priority,
 							Bitrate:  bitrate,
-							Segment:  tileID,
-							Tile:     segmentID,
+							Segment:  segmentID,
+							Tile:     tileID,
 							Timeout:  0,
-						}, 0, true, true, false, 0.0, bufferSec, tmrValue, inFOV, false)
+						}, 0, true, true, false, 0.0, bufferSec, tmrValue, inFOV, false, model.CANCELLED_OUTCOME)
 					}
 					return
 				}
@@ -712,10 +788,13 @@ func runTestIteration(client *Client, parallelism int, baseLatencyMs int,
 					ID:       uuid.Must(uuid.New(), nil),
 					Priority: priority,
 					Bitrate:  bitrate,
-					Segment:  tileID,
-					Tile:     segmentID,
+					Segment:  segmentID,
+					Tile:     tileID,
 					Timeout:  timeoutMs,
 				}
+				if client.Options.DatagramClasses[priority] {
+					request.Delivery = model.DATAGRAM_DELIVERY
+				}
 
 				fmt.Printf("Sending request for segment %d, tile %d (priority=%d, FOV=%t)\n", segmentID, tileID, priority, inFOV)
 
@@ -725,7 +804,8 @@ func runTestIteration(client *Client, parallelism int, baseLatencyMs int,
 				collector.RecordSend(request.ID)
 
 				requestTime := time.Since(startTime)
-				response := client.Request(request, remaining)
+				recorder.Record(workload.NewRecord(requestTime, request, inFOV))
+				response, outcome := client.Request(request, remaining)
 				responseTime := time.Since(startTime)
 
 				bytesReceived := 0
@@ -747,6 +827,7 @@ func runTestIteration(client *Client, parallelism int, baseLatencyMs int,
 					if late {
 						fmt.Printf("Late response for segment %d, tile %d\n", segmentID, tileID)
 						timedOut = true
+						outcome = model.LATE_OUTCOME
 					} else {
 						fmt.Printf("Received response for segment %d, tile %d\n", segmentID, tileID)
 						timedOut = false
@@ -754,6 +835,7 @@ func runTestIteration(client *Client, parallelism int, baseLatencyMs int,
 				}
 
 				onTime := (response != nil) && (!timedOut)
+				outcomes.Add(outcome)
 				fovHit.Add(segmentID, inFOV, onTime)
 				fovGoodput.Add(responseTime, bytesReceived, inFOV, onTime)
 				deadlineAgg.Add(inFOV, !onTime)
@@ -781,7 +863,7 @@ func runTestIteration(client *Client, parallelism int, baseLatencyMs int,
 
 				if statisticsLogger != nil {
 					statisticsLogger.Log(requestTime, request,
-						responseTime-requestTime, timedOut, false, !timedOut, instaThroughput, sendBufferSec, tmrValue, inFOV, onTime)
+						responseTime-requestTime, timedOut, false, !timedOut, instaThroughput, sendBufferSec, tmrValue, inFOV, onTime, outcome)
 				}
 			}(segmentID, tileID, segmentDeadline, requestBitrate, priority, inFOV)
 		}
@@ -830,8 +912,16 @@ func runTestIteration(client *Client, parallelism int, baseLatencyMs int,
 	fovGoodputRate := fovGoodput.OverallKbps(elapsed)
 	log.Printf("Useful goodput (FoV): %.2f kbps", fovGoodputRate)
 
+	datagramLossRate := -1.0
+	if stats := client.DatagramStats(); stats.Requested > 0 {
+		lost := stats.Incomplete + stats.Missing
+		datagramLossRate = 100.0 * float64(lost) / float64(stats.Requested)
+		log.Printf("Datagram delivery: requested=%d completed=%d incomplete=%d missing=%d fragments=%d loss=%.2f%%",
+			stats.Requested, stats.Completed, stats.Incomplete, stats.Missing, stats.Fragments, datagramLossRate)
+	}
+
 	if summaryLogger != nil {
-		summaryLogger.LogSession(joinLatency, completionRate, fovCompletionRate, staleRatio, fovMissRate, nonFOVMissRate, fovHitRate, fovGoodputRate)
+		summaryLogger.LogSession(joinLatency, completionRate, fovCompletionRate, staleRatio, fovMissRate, nonFOVMissRate, fovHitRate, fovGoodputRate, datagramLossRate, outcomes.Counts())
 	}
 
 	if fovDeliveryPath != "" {
@@ -845,6 +935,33 @@ func runTestIteration(client *Client, parallelism int, baseLatencyMs int,
 	}
 }
 
+// SegmentTimeBudget returns how long the tiles of a segment may take, given
+// the time until the segment is played (0 if unknown or already late) and how
+// many segments may be buffered ahead of the playback.
+func SegmentTimeBudget(timeToReceive time.Duration, segmentDuration time.Duration, maxSegmentsAhead int) time.Duration {
+	timeBudget := timeToReceive
+	if timeBudget <= 0 {
+		timeBudget = segmentDuration
+	}
+	maxAhead := time.Duration(maxSegmentsAhead) * segmentDuration
+	if timeBudget > maxAhead {
+		timeBudget = maxAhead
+	}
+	return timeBudget + segmentDuration
+}
+
+func randomPriority(highRatio, mediumRatio float64) model.Priority {
+	x := rand.Float64()
+	switch {
+	case x < highRatio:
+		return model.HIGH_PRIORITY
+	case x < highRatio+mediumRatio:
+		return model.MEDIUM_PRIORITY
+	default:
+		return model.LOW_PRIORITY
+	}
+}
+
 // metricas de rede (vazão instantanea + media)
 // tamanho do buffer
 // identificação do tile + segmento + prioridade (fov)
@@ -0,0 +1,149 @@
+// Package tracing attaches quic-go tracers to the connections of the server
+// and of the clients.
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/logging"
+	"github.com/lucas-clemente/quic-go/qlog"
+)
+
+// QlogTracer writes the qlog of every connection to its own file,
+// <dir>/<name>-<odcid>.qlog. The original destination connection ID (odcid)
+// is chosen by the client, so it matches the qlogs of both sides.
+type QlogTracer struct {
+	logging.NullTracer
+	dir  string
+	name string
+
+	mu      sync.Mutex
+	unnamed map[uint64]*qlogFile // by tracing ID, until SetName
+}
+
+// NewQlogTracer creates the tracer of quic.Config.Tracer. With an empty name,
+// each connection is named later with SetName (the server only numbers a
+// connection once it is accepted), and its events are kept in memory until
+// then.
+func NewQlogTracer(dir string, name string) *QlogTracer {
+	return &QlogTracer{dir: dir, name: name, unnamed: make(map[uint64]*qlogFile)}
+}
+
+func (t *QlogTracer) TracerForConnection(ctx context.Context, p logging.Perspective, odcid logging.ConnectionID) logging.ConnectionTracer {
+	file := &qlogFile{dir: t.dir, odcid: fmt.Sprintf("%x", odcid.Bytes()), tracer: t}
+	if t.name != "" {
+		if err := file.open(t.name); err != nil {
+			log.Printf("[QLOG] %v", err)
+			return nil
+		}
+	} else if id, ok := ctx.Value(quic.ConnectionTracingKey).(uint64); ok {
+		file.tracingID = id
+		t.mu.Lock()
+		t.unnamed[id] = file
+		t.mu.Unlock()
+	}
+	return qlog.NewConnectionTracer(file, p, odcid)
+}
+
+// SetName names the qlog of a connection: <name>-<odcid>.qlog. ctx is the
+// connection context (quic.Connection.Context()).
+func (t *QlogTracer) SetName(ctx context.Context, name string) {
+	if t == nil {
+		return
+	}
+	id, ok := ctx.Value(quic.ConnectionTracingKey).(uint64)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	file := t.unnamed[id]
+	delete(t.unnamed, id)
+	t.mu.Unlock()
+	if file != nil {
+		if err := file.open(name); err != nil {
+			log.Printf("[QLOG] %v", err)
+		}
+	}
+}
+
+// Name of the files of the connections closed before SetName.
+const unnamedQlog = "unnamed"
+
+// Writer of one qlog. Buffers in memory until it has a name.
+type qlogFile struct {
+	dir       string
+	odcid     string
+	tracer    *QlogTracer
+	tracingID uint64
+
+	mu      sync.Mutex
+	pending bytes.Buffer
+	file    *os.File
+	w       *bufio.Writer
+	failed  bool
+}
+
+func (f *qlogFile) open(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil || f.failed {
+		return nil
+	}
+	if err := os.MkdirAll(f.dir, 0o755); err != nil {
+		f.failed = true
+		return err
+	}
+	path := filepath.Join(f.dir, fmt.Sprintf("%s-%s.qlog", name, f.odcid))
+	file, err := os.Create(path)
+	if err != nil {
+		f.failed = true
+		return err
+	}
+	f.file = file
+	f.w = bufio.NewWriter(file)
+	_, err = f.pending.WriteTo(f.w)
+	return err
+}
+
+func (f *qlogFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case f.w != nil:
+		return f.w.Write(p)
+	case f.failed:
+		return len(p), nil
+	default:
+		return f.pending.Write(p)
+	}
+}
+
+func (f *qlogFile) Close() error {
+	if f.tracer != nil {
+		f.tracer.mu.Lock()
+		delete(f.tracer.unnamed, f.tracingID)
+		f.tracer.mu.Unlock()
+	}
+	if err := f.open(unnamedQlog); err != nil {
+		log.Printf("[QLOG] %v", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.w.Flush()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
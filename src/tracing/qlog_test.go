@@ -0,0 +1,75 @@
+package tracing_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"main/src/tracing"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+// odcid of the test connections (empty: quic-go does not export a
+// constructor)
+var odcid logging.ConnectionID
+
+func connectionContext(id uint64) context.Context {
+	return context.WithValue(context.Background(), quic.ConnectionTracingKey, id)
+}
+
+// Content of the only qlog named <name>-<odcid>.qlog in dir ("" if none).
+func readQlog(t *testing.T, dir, name string) string {
+	paths, err := filepath.Glob(filepath.Join(dir, name+"-*.qlog"))
+	assert.Nil(t, err)
+	if len(paths) != 1 {
+		return ""
+	}
+	data, err := os.ReadFile(paths[0])
+	assert.Nil(t, err)
+	return string(data)
+}
+
+// Tests if a named tracer writes <name>-<odcid>.qlog
+func TestQlogTracer_Named(t *testing.T) {
+	dir := t.TempDir()
+	tracer := tracing.NewQlogTracer(dir, "client-1")
+
+	connectionTracer := tracer.TracerForConnection(connectionContext(1), logging.PerspectiveClient, odcid)
+	connectionTracer.Close()
+
+	assert.Contains(t, readQlog(t, dir, "client-1"), "qlog_version")
+}
+
+// Tests if the events before SetName end up in the named file
+func TestQlogTracer_SetName(t *testing.T) {
+	dir := t.TempDir()
+	tracer := tracing.NewQlogTracer(dir, "")
+
+	ctx := connectionContext(7)
+	connectionTracer := tracer.TracerForConnection(ctx, logging.PerspectiveServer, odcid)
+	assert.Equal(t, "", readQlog(t, dir, "conn1"))
+
+	tracer.SetName(ctx, "conn1")
+	connectionTracer.Close()
+
+	content := readQlog(t, dir, "conn1")
+	assert.Contains(t, content, "qlog_version")
+	assert.Contains(t, content, "server")
+}
+
+// Tests if a connection closed before SetName is still written
+func TestQlogTracer_Unnamed(t *testing.T) {
+	dir := t.TempDir()
+	tracer := tracing.NewQlogTracer(dir, "")
+
+	ctx := connectionContext(3)
+	tracer.TracerForConnection(ctx, logging.PerspectiveServer, odcid).Close()
+	tracer.SetName(ctx, "conn1")
+
+	assert.Contains(t, readQlog(t, dir, "unnamed"), "qlog_version")
+	assert.Equal(t, "", readQlog(t, dir, "conn1"))
+}
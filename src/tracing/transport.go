@@ -0,0 +1,299 @@
+package tracing
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/logging"
+)
+
+// Columns of transport.csv. ts has the format of queue_len.csv and
+// fairness.csv, so the series can be plotted together. packets_lost counts
+// the losses since the previous row of the connection.
+var TransportHeader = []string{
+	"ts", "connection", "odcid",
+	"srtt_ms", "rttvar_ms", "min_rtt_ms", "latest_rtt_ms",
+	"cwnd_bytes", "bytes_in_flight", "packets_in_flight",
+	"packets_lost", "packets_lost_total", "cc_state",
+}
+
+// TransportTracer samples the transport state of every connection (RTT,
+// congestion window, bytes in flight, losses) and writes it to a CSV at a
+// fixed interval, one row per connection. Connections are named with SetName,
+// as in QlogTracer.
+type TransportTracer struct {
+	logging.NullTracer
+	file   *os.File
+	w      *csv.Writer
+	ticker *time.Ticker
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu          sync.Mutex
+	connections map[uint64]*transportConnection // by tracing ID
+	untracked   uint64                          // keys of the connections without tracing ID
+}
+
+// NewTransportTracer creates csvPath and starts sampling every interval.
+// Returns nil if the file does not open. With an empty csvPath it only keeps
+// the state, for DeliveryRate.
+func NewTransportTracer(csvPath string, interval time.Duration) *TransportTracer {
+	t := &TransportTracer{connections: make(map[uint64]*transportConnection)}
+	if csvPath == "" {
+		return t
+	}
+	_ = os.MkdirAll(filepath.Dir(csvPath), 0o755)
+	file, err := os.Create(csvPath)
+	if err != nil {
+		log.Printf("[TRANSPORT] csv open %s: %v", csvPath, err)
+		return nil
+	}
+	w := csv.NewWriter(file)
+	_ = w.Write(TransportHeader)
+	w.Flush()
+	t.file = file
+	t.w = w
+	t.ticker = time.NewTicker(interval)
+	t.stop = make(chan struct{})
+	t.done = make(chan struct{})
+	go t.loop()
+	return t
+}
+
+func (t *TransportTracer) TracerForConnection(ctx context.Context, p logging.Perspective, odcid logging.ConnectionID) logging.ConnectionTracer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id, ok := ctx.Value(quic.ConnectionTracingKey).(uint64)
+	if !ok {
+		// out of the range of quic-go's tracing IDs, which count from 1
+		t.untracked++
+		id = ^t.untracked
+	}
+	connection := &transportConnection{tracer: t, id: id, odcid: fmt.Sprintf("%x", odcid.Bytes())}
+	t.connections[id] = connection
+	return connection
+}
+
+// SetName names the rows of a connection. ctx is the connection context
+// (quic.Connection.Context()).
+func (t *TransportTracer) SetName(ctx context.Context, name string) {
+	if t == nil {
+		return
+	}
+	id, ok := ctx.Value(quic.ConnectionTracingKey).(uint64)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	connection := t.connections[id]
+	t.mu.Unlock()
+	if connection != nil {
+		connection.mu.Lock()
+		connection.name = name
+		connection.mu.Unlock()
+	}
+}
+
+// DeliveryRate returns the rate estimate of a connection in bytes/s, the
+// congestion window over the smoothed RTT (0 before the first RTT sample).
+// Returns nil if the connection is unknown. ctx is the connection context.
+func (t *TransportTracer) DeliveryRate(ctx context.Context) func() float64 {
+	if t == nil {
+		return nil
+	}
+	id, ok := ctx.Value(quic.ConnectionTracingKey).(uint64)
+	if !ok {
+		return nil
+	}
+	t.mu.Lock()
+	connection := t.connections[id]
+	t.mu.Unlock()
+	if connection == nil {
+		return nil
+	}
+	return connection.deliveryRate
+}
+
+// Close writes a last sample and closes the CSV.
+func (t *TransportTracer) Close() {
+	if t == nil || t.file == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+	t.ticker.Stop()
+	t.sample(time.Now())
+	t.w.Flush()
+	_ = t.file.Close()
+}
+
+func (t *TransportTracer) loop() {
+	defer close(t.done)
+	for {
+		select {
+		case <-t.stop:
+			return
+		case now := <-t.ticker.C:
+			t.sample(now)
+		}
+	}
+}
+
+// Writes a row per connection. Closed connections get a last row and are
+// forgotten. Without a CSV, closed connections are forgotten right away.
+func (t *TransportTracer) sample(now time.Time) {
+	t.mu.Lock()
+	ids := make([]uint64, 0, len(t.connections))
+	for id := range t.connections {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	connections := make([]*transportConnection, len(ids))
+	for i, id := range ids {
+		connections[i] = t.connections[id]
+		if connections[i].isClosed() {
+			delete(t.connections, id)
+		}
+	}
+	t.mu.Unlock()
+
+	ts := now.Format(time.RFC3339Nano)
+	for _, connection := range connections {
+		if row := connection.row(ts); row != nil {
+			_ = t.w.Write(row)
+		}
+	}
+	t.w.Flush()
+}
+
+// Latest transport state of a connection.
+type transportConnection struct {
+	logging.NullConnectionTracer
+	tracer *TransportTracer
+	id     uint64 // key in tracer.connections
+	odcid  string
+
+	mu              sync.Mutex
+	name            string
+	updated         bool // UpdatedMetrics was called
+	srtt, rttvar    time.Duration
+	minRTT, lastRTT time.Duration
+	cwnd, inFlight  logging.ByteCount
+	packetsInFlight int
+	lost, lostTotal int64
+	ccState         logging.CongestionState
+	closed          bool
+}
+
+func (c *transportConnection) UpdatedMetrics(rttStats *logging.RTTStats, cwnd, bytesInFlight logging.ByteCount, packetsInFlight int) {
+	c.mu.Lock()
+	c.updated = true
+	c.srtt = rttStats.SmoothedRTT()
+	c.rttvar = rttStats.MeanDeviation()
+	c.minRTT = rttStats.MinRTT()
+	c.lastRTT = rttStats.LatestRTT()
+	c.cwnd = cwnd
+	c.inFlight = bytesInFlight
+	c.packetsInFlight = packetsInFlight
+	c.mu.Unlock()
+}
+
+func (c *transportConnection) LostPacket(logging.EncryptionLevel, logging.PacketNumber, logging.PacketLossReason) {
+	c.mu.Lock()
+	c.lost++
+	c.lostTotal++
+	c.mu.Unlock()
+}
+
+func (c *transportConnection) UpdatedCongestionState(state logging.CongestionState) {
+	c.mu.Lock()
+	c.ccState = state
+	c.mu.Unlock()
+}
+
+func (c *transportConnection) Close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	if c.tracer.file == nil {
+		c.tracer.mu.Lock()
+		delete(c.tracer.connections, c.id)
+		c.tracer.mu.Unlock()
+	}
+}
+
+func (c *transportConnection) deliveryRate() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.srtt <= 0 {
+		return 0
+	}
+	return float64(c.cwnd) / c.srtt.Seconds()
+}
+
+func (c *transportConnection) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Row of transport.csv, or nil before the first metrics update.
+func (c *transportConnection) row(ts string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.updated {
+		return nil
+	}
+	row := []string{
+		ts, c.name, c.odcid,
+		milliseconds(c.srtt), milliseconds(c.rttvar), milliseconds(c.minRTT), milliseconds(c.lastRTT),
+		strconv.FormatInt(int64(c.cwnd), 10),
+		strconv.FormatInt(int64(c.inFlight), 10),
+		strconv.Itoa(c.packetsInFlight),
+		strconv.FormatInt(c.lost, 10),
+		strconv.FormatInt(c.lostTotal, 10),
+		congestionStateName(c.ccState),
+	}
+	c.lost = 0
+	return row
+}
+
+func milliseconds(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+func congestionStateName(state logging.CongestionState) string {
+	switch state {
+	case logging.CongestionStateSlowStart:
+		return "slow_start"
+	case logging.CongestionStateCongestionAvoidance:
+		return "congestion_avoidance"
+	case logging.CongestionStateRecovery:
+		return "recovery"
+	case logging.CongestionStateApplicationLimited:
+		return "application_limited"
+	default:
+		return ""
+	}
+}
+
+// Join combines the tracers of a quic.Config (nil if there is none).
+func Join(tracers ...logging.Tracer) logging.Tracer {
+	switch len(tracers) {
+	case 0:
+		return nil
+	case 1:
+		return tracers[0]
+	default:
+		return logging.NewMultiplexedTracer(tracers...)
+	}
+}
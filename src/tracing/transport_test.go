@@ -0,0 +1,117 @@
+package tracing_test
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"main/src/tracing"
+
+	"github.com/lucas-clemente/quic-go/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func readTransportCSV(t *testing.T, path string) [][]string {
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	assert.Nil(t, err)
+	return rows
+}
+
+// Tests if the last state of a named connection is written on Close
+func TestTransportTracer_Sample(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transport.csv")
+	tracer := tracing.NewTransportTracer(path, time.Hour)
+
+	ctx := connectionContext(1)
+	connectionTracer := tracer.TracerForConnection(ctx, logging.PerspectiveServer, odcid)
+	tracer.SetName(ctx, "conn1")
+
+	rtt := &logging.RTTStats{}
+	rtt.UpdateRTT(40*time.Millisecond, 0, time.Now())
+	connectionTracer.UpdatedMetrics(rtt, 64000, 12000, 9)
+	connectionTracer.LostPacket(logging.Encryption1RTT, 5, logging.PacketLossReorderingThreshold)
+	connectionTracer.LostPacket(logging.Encryption1RTT, 6, logging.PacketLossTimeThreshold)
+	connectionTracer.UpdatedCongestionState(logging.CongestionStateRecovery)
+	tracer.Close()
+
+	rows := readTransportCSV(t, path)
+	assert.Equal(t, 2, len(rows))
+	assert.Equal(t, tracing.TransportHeader, rows[0])
+	row := map[string]string{}
+	for i, column := range rows[0] {
+		row[column] = rows[1][i]
+	}
+	assert.Equal(t, "conn1", row["connection"])
+	assert.Equal(t, "40.000", row["srtt_ms"])
+	assert.Equal(t, "64000", row["cwnd_bytes"])
+	assert.Equal(t, "12000", row["bytes_in_flight"])
+	assert.Equal(t, "9", row["packets_in_flight"])
+	assert.Equal(t, "2", row["packets_lost"])
+	assert.Equal(t, "2", row["packets_lost_total"])
+	assert.Equal(t, "recovery", row["cc_state"])
+}
+
+// Tests if losses are counted per row and closed connections are dropped
+func TestTransportTracer_Interval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transport.csv")
+	tracer := tracing.NewTransportTracer(path, 20*time.Millisecond)
+
+	connectionTracer := tracer.TracerForConnection(connectionContext(1), logging.PerspectiveServer, odcid)
+	connectionTracer.UpdatedMetrics(&logging.RTTStats{}, 1000, 0, 0)
+	connectionTracer.LostPacket(logging.Encryption1RTT, 1, logging.PacketLossTimeThreshold)
+	time.Sleep(50 * time.Millisecond)
+	connectionTracer.Close()
+	time.Sleep(50 * time.Millisecond)
+	tracer.Close()
+
+	rows := readTransportCSV(t, path)[1:]
+	assert.True(t, len(rows) >= 2)
+	lost := 0
+	for _, row := range rows {
+		if row[10] == "1" {
+			lost++
+		}
+		assert.Equal(t, "1", row[11])
+	}
+	assert.Equal(t, 1, lost)
+
+	// no rows after the one that follows Close
+	last := rows[len(rows)-1][0]
+	closedAt, err := time.Parse(time.RFC3339Nano, last)
+	assert.Nil(t, err)
+	assert.True(t, time.Since(closedAt) > 20*time.Millisecond)
+}
+
+// Tests if a tracer without a connection writes only the header
+func TestTransportTracer_Empty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transport.csv")
+	tracing.NewTransportTracer(path, time.Millisecond).Close()
+
+	assert.Equal(t, [][]string{tracing.TransportHeader}, readTransportCSV(t, path))
+}
+
+// Tests if the delivery rate is the congestion window over the smoothed RTT,
+// also without CSV
+func TestTransportTracer_DeliveryRate(t *testing.T) {
+	tracer := tracing.NewTransportTracer("", 0)
+	defer tracer.Close()
+
+	ctx := connectionContext(1)
+	assert.Nil(t, tracer.DeliveryRate(ctx))
+	connectionTracer := tracer.TracerForConnection(ctx, logging.PerspectiveServer, odcid)
+	rate := tracer.DeliveryRate(ctx)
+	assert.Equal(t, 0.0, rate())
+
+	rtt := &logging.RTTStats{}
+	rtt.UpdateRTT(50*time.Millisecond, 0, time.Now())
+	connectionTracer.UpdatedMetrics(rtt, 100000, 0, 0)
+	assert.InDelta(t, 2e6, rate(), 1e-6)
+
+	connectionTracer.Close()
+	assert.Nil(t, tracer.DeliveryRate(ctx))
+}
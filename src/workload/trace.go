@@ -0,0 +1,141 @@
+// Package workload reads and writes request traces: the requests a client
+// sent, with their timing, so the same workload can be replayed against any
+// server.
+package workload
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"main/src/model"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// One line of a JSONL trace. Segment and Tile are the request fields as
+// sent on the wire.
+type Record struct {
+	// Send time since the start of the run, in ms.
+	TimeMs    float64        `json:"t_ms"`
+	ID        string         `json:"id"`
+	Segment   int            `json:"segment"`
+	Tile      int            `json:"tile"`
+	Priority  model.Priority `json:"priority"`
+	Bitrate   model.Bitrate  `json:"bitrate"`
+	TimeoutMs int            `json:"timeout_ms"`
+	InFOV     bool           `json:"in_fov,omitempty"`
+}
+
+// NewRecord describes a request sent at the given time since the start.
+func NewRecord(at time.Duration, r model.VideoPacketRequest, inFOV bool) Record {
+	return Record{
+		TimeMs:    float64(at) / float64(time.Millisecond),
+		ID:        r.ID.String(),
+		Segment:   r.Segment,
+		Tile:      r.Tile,
+		Priority:  r.Priority,
+		Bitrate:   r.Bitrate,
+		TimeoutMs: r.Timeout,
+		InFOV:     inFOV,
+	}
+}
+
+// At returns the send time since the start.
+func (r Record) At() time.Duration {
+	return time.Duration(r.TimeMs * float64(time.Millisecond))
+}
+
+// Request rebuilds the request. An invalid or empty ID gets a new one.
+func (r Record) Request() model.VideoPacketRequest {
+	id, err := uuid.Parse(r.ID)
+	if err != nil {
+		id = uuid.New()
+	}
+	return model.VideoPacketRequest{
+		ID:       id,
+		Priority: r.Priority,
+		Bitrate:  r.Bitrate,
+		Segment:  r.Segment,
+		Tile:     r.Tile,
+		Timeout:  r.TimeoutMs,
+	}
+}
+
+// Recorder appends records to a JSONL file. Safe for concurrent use.
+type Recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+// NewRecorder creates (or truncates) the trace file.
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriter(file)
+	return &Recorder{file: file, writer: writer, encoder: json.NewEncoder(writer)}, nil
+}
+
+// Record writes one record. A nil Recorder records nothing.
+func (r *Recorder) Record(record Record) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	_ = r.encoder.Encode(record)
+	r.mu.Unlock()
+}
+
+// Close flushes and closes the file.
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.writer.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// Load reads a trace, sorted by send time. Concurrent senders may have
+// written the lines slightly out of order.
+func Load(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if record.Priority < model.HIGH_PRIORITY || record.Priority > model.LOW_PRIORITY {
+			return nil, fmt.Errorf("%s:%d: priority %d out of range (%d to %d)", path, line, record.Priority, model.HIGH_PRIORITY, model.LOW_PRIORITY)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].TimeMs < records[j].TimeMs })
+	return records, nil
+}
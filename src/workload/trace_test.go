@@ -0,0 +1,75 @@
+package workload_test
+
+import (
+	"main/src/model"
+	"main/src/workload"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests if a recorded trace is read back sorted by send time.
+func TestRecorder_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	recorder, err := workload.NewRecorder(path)
+	assert.Nil(t, err)
+
+	first := model.VideoPacketRequest{
+		ID:       uuid.New(),
+		Priority: model.HIGH_PRIORITY,
+		Bitrate:  model.HIGH_BITRATE,
+		Segment:  120,
+		Tile:     3,
+		Timeout:  1500,
+	}
+	second := first
+	second.ID = uuid.New()
+	second.Priority = model.LOW_PRIORITY
+
+	recorder.Record(workload.NewRecord(250*time.Millisecond, second, false))
+	recorder.Record(workload.NewRecord(1500*time.Microsecond, first, true))
+	assert.Nil(t, recorder.Close())
+
+	records, err := workload.Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(records))
+
+	assert.Equal(t, 1500*time.Microsecond, records[0].At())
+	assert.True(t, records[0].InFOV)
+	assert.Equal(t, first, records[0].Request())
+	assert.Equal(t, 250*time.Millisecond, records[1].At())
+	assert.Equal(t, second, records[1].Request())
+}
+
+// Tests if a malformed line is reported with its number.
+func TestLoad_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	assert.Nil(t, os.WriteFile(path, []byte("{\"t_ms\": 1}\n\n{\"t_ms\": \n"), 0o644))
+
+	_, err := workload.Load(path)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "requests.jsonl:3")
+
+	_, err = workload.Load(filepath.Join(t.TempDir(), "missing.jsonl"))
+	assert.NotNil(t, err)
+}
+
+// Tests if a priority outside HIGH..LOW is reported with its line number.
+func TestLoad_InvalidPriority(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	assert.Nil(t, os.WriteFile(path, []byte("{\"t_ms\": 1, \"priority\": 2}\n{\"t_ms\": 2, \"priority\": 3}\n"), 0o644))
+
+	_, err := workload.Load(path)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "requests.jsonl:2")
+	assert.Contains(t, err.Error(), "priority 3")
+
+	assert.Nil(t, os.WriteFile(path, []byte("{\"t_ms\": 1, \"priority\": -1}\n"), 0o644))
+	_, err = workload.Load(path)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "requests.jsonl:1")
+}